@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// cacheOptions restricts the manager's cache to the objects that the webhook reads. Only the
+// namespace defaults ConfigMaps are cached, rather than every ConfigMap in the cluster.
+func cacheOptions() cache.Options {
+	return cache.Options{
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.ConfigMap{}: {
+				Field: fields.OneTermEqualSelector("metadata.name", constants.NamespaceDefaultsConfigMap),
+			},
+		},
+	}
+}
+
+// cachedObjects returns the kinds of object that the webhook reads through the cache with the given
+// features enabled: namespaces for the trust domain and mode policies, and ConfigMaps for the
+// namespace defaults
+func cachedObjects(namespacePolicy, namespaceDefaults bool) []client.Object {
+	var objects []client.Object
+	if namespacePolicy {
+		objects = append(objects, &corev1.Namespace{})
+	}
+	if namespaceDefaults {
+		objects = append(objects, &corev1.ConfigMap{})
+	}
+	return objects
+}
+
+// registerInformers registers the informers for objects with the cache before it is started, so
+// that they start with the manager rather than on the first admission request, which would
+// otherwise block until they had synced
+func registerInformers(ctx context.Context, informers cache.Informers, objects []client.Object) ([]cache.Informer, error) {
+	var registered []cache.Informer
+	for _, obj := range objects {
+		informer, err := informers.GetInformer(ctx, obj, cache.BlockUntilSynced(false))
+		if err != nil {
+			return nil, fmt.Errorf("error registering informer for %T: %w", obj, err)
+		}
+		registered = append(registered, informer)
+	}
+	return registered, nil
+}
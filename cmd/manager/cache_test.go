@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestCacheOptions(t *testing.T) {
+	opts := cacheOptions()
+	require.Len(t, opts.ByObject, 1)
+	for obj, byObject := range opts.ByObject {
+		assert.IsType(t, &corev1.ConfigMap{}, obj)
+		require.NotNil(t, byObject.Field)
+		assert.Equal(t, "metadata.name="+constants.NamespaceDefaultsConfigMap, byObject.Field.String())
+	}
+}
+
+func TestCachedObjects(t *testing.T) {
+	tests := []struct {
+		name              string
+		namespacePolicy   bool
+		namespaceDefaults bool
+		expected          []client.Object
+	}{
+		{name: "none"},
+		{name: "namespace policy", namespacePolicy: true, expected: []client.Object{&corev1.Namespace{}}},
+		{name: "namespace defaults", namespaceDefaults: true, expected: []client.Object{&corev1.ConfigMap{}}},
+		{
+			name:              "both",
+			namespacePolicy:   true,
+			namespaceDefaults: true,
+			expected:          []client.Object{&corev1.Namespace{}, &corev1.ConfigMap{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, cachedObjects(tt.namespacePolicy, tt.namespaceDefaults))
+		})
+	}
+}
+
+func TestRegisterInformers(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	informers := &informertest.FakeInformers{Scheme: scheme}
+
+	registered, err := registerInformers(context.Background(), informers, cachedObjects(true, true))
+	require.NoError(t, err)
+	assert.Len(t, registered, 2)
+	assert.Len(t, informers.InformersByGVK, 2)
+}
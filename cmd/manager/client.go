@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/rest"
+)
+
+// The client-go defaults, which controller-runtime also applies to an unset rate limit
+const (
+	defaultClientQPS   = 20
+	defaultClientBurst = 30
+)
+
+// clientConfig sets the rate limits of the Kubernetes API client used by the manager, eg for
+// namespace lookups by the webhook
+type clientConfig struct {
+	qps   float64
+	burst int
+}
+
+func (c clientConfig) validate() error {
+	if c.qps <= 0 {
+		return fmt.Errorf("invalid client QPS %v: must be positive", c.qps)
+	}
+	if c.burst <= 0 {
+		return fmt.Errorf("invalid client burst %d: must be positive", c.burst)
+	}
+	return nil
+}
+
+// apply returns a copy of cfg with the client rate limits set
+func (c clientConfig) apply(cfg *rest.Config) *rest.Config {
+	cfg = rest.CopyConfig(cfg)
+	cfg.QPS = float32(c.qps)
+	cfg.Burst = c.burst
+	return cfg
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/rest"
+)
+
+func TestClientConfig_Validate(t *testing.T) {
+	valid := clientConfig{qps: defaultClientQPS, burst: defaultClientBurst}
+	require.NoError(t, valid.validate())
+
+	for name, cfg := range map[string]clientConfig{
+		"zero qps":       {burst: 10},
+		"negative qps":   {qps: -1, burst: 10},
+		"zero burst":     {qps: 10},
+		"negative burst": {qps: 10, burst: -1},
+	} {
+		assert.Error(t, cfg.validate(), name)
+	}
+}
+
+func TestClientConfig_Apply(t *testing.T) {
+	base := &rest.Config{Host: "https://kubernetes.default.svc", QPS: 5, Burst: 10}
+
+	cfg := clientConfig{qps: 50.5, burst: 100}.apply(base)
+
+	assert.Equal(t, float32(50.5), cfg.QPS)
+	assert.Equal(t, 100, cfg.Burst)
+	assert.Equal(t, base.Host, cfg.Host)
+
+	// The original config is unchanged
+	assert.Equal(t, float32(5), base.QPS)
+	assert.Equal(t, 10, base.Burst)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// informersSyncedCheck returns a readiness check that fails until each of the informers has
+// synced. The webhook reads namespaces and ConfigMaps through these informers, so a replica with
+// an unsynced informer would make decisions without them.
+func informersSyncedCheck(informers []cache.Informer) healthz.Checker {
+	return func(_ *http.Request) error {
+		for i, informer := range informers {
+			if !informer.HasSynced() {
+				return fmt.Errorf("informer %d of %d has not synced", i+1, len(informers))
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+func TestInformersSyncedCheck(t *testing.T) {
+	synced := controllertest.NewFakeInformer(controllertest.Synced)
+	unsynced := controllertest.NewFakeInformer()
+	// The manager serves its readiness checks under /readyz like this
+	handler := http.StripPrefix("/readyz", &healthz.Handler{Checks: map[string]healthz.Checker{
+		"ping":  healthz.Ping,
+		"cache": informersSyncedCheck([]cache.Informer{synced, unsynced}),
+	}})
+
+	readyz := func() int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusInternalServerError, readyz(), "ready before every informer has synced")
+
+	unsynced.Synced()
+	assert.Equal(t, http.StatusOK, readyz(), "not ready after every informer has synced")
+}
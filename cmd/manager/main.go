@@ -1,19 +1,25 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/utils/ptr"
 
+	constants "github.com/cofide/spiffe-enable/internal/const"
 	cofidewebhook "github.com/cofide/spiffe-enable/internal/webhook"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -27,6 +33,14 @@ import (
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
+
+	// Native sidecars (init containers with restartPolicy Always) are enabled by default from v1.29
+	minNativeSidecarVersion = utilversion.MajorMinor(1, 29)
+	// The sleep action for lifecycle hooks is enabled by default from v1.30
+	minSleepActionVersion = utilversion.MajorMinor(1, 30)
+
+	// version is set at build time with -ldflags "-X main.version=..."
+	version = "dev"
 )
 
 func init() {
@@ -35,11 +49,31 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == selfTestCommand {
+		os.Exit(selfTest())
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var skipOwnerKinds string
+	var allowedTrustDomains string
+	var allowedModes string
+	var denyNameCollisions bool
+	var requireSidecarResources bool
+	var checkAgentXDS bool
+	var namespaceDefaults bool
+	var otelEndpoint string
+	var autoInjectImagePrefixes string
+	var autoInjectServiceAccounts string
+	var autoInjectMode string
+	var maxEnvVarSize int
+	var maxAnnotationsSize int
+	var images cofidewebhook.ImageConfig
+	var serverConfig webhookServerConfig
+	var kubeClientConfig clientConfig
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
@@ -50,6 +84,67 @@ func main() {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&skipOwnerKinds, "skip-owner-kinds", "",
+		"Comma-delimited list of owner kinds (eg Job) whose pods are never injected. Injects into all pods by default.")
+	flag.StringVar(&allowedTrustDomains, "allowed-trust-domains", "",
+		"Comma-delimited list of trust domains. If set, injection is denied for pods in namespaces whose "+
+			"spiffe.cofide.io/trust-domain annotation maps them to any other trust domain.")
+	flag.StringVar(&allowedModes, "allowed-modes", "",
+		"Comma-delimited list of modes (csi, helper, proxy), or none, that may be injected by default. If set, "+
+			"injection of other modes is denied, unless re-enabled by the spiffe.cofide.io/allowed-modes "+
+			"annotation of the pod's namespace, which replaces this list. All modes are allowed by default.")
+	flag.BoolVar(&denyNameCollisions, "deny-container-name-collisions", false,
+		"If set, injection is denied for pods with a container named like an injected container. "+
+			"Such pods are injected with a warning by default.")
+	flag.BoolVar(&requireSidecarResources, "require-sidecar-resources", false,
+		"If set, injection is denied for pods that don't set the resources of each sidecar to be injected with "+
+			"the spiffe.cofide.io/helper-resources, spiffe.cofide.io/proxy-resources or spiffe.cofide.io/proxy-size "+
+			"annotations. Sidecars get default resources otherwise.")
+	flag.BoolVar(&checkAgentXDS, "check-agent-xds", false,
+		"If set, injecting the proxy checks that the agent's xDS service resolves, and warns if it doesn't. "+
+			"Injection is never denied by the check.")
+	flag.BoolVar(&namespaceDefaults, "namespace-defaults", false,
+		"If set, the spiffe.cofide.io/inject and spiffe.cofide.io/debug annotations of pods that don't set them are "+
+			"defaulted from the spiffe-enable-defaults ConfigMap of the pod's namespace. Requires permission to read ConfigMaps.")
+	flag.StringVar(&otelEndpoint, "otel-endpoint", "",
+		"The URL of an OTLP/gRPC endpoint (eg http://otel-collector:4317) to which traces of admission requests "+
+			"are exported. Tracing is disabled by default.")
+	flag.StringVar(&autoInjectImagePrefixes, "auto-inject-image-prefixes", "",
+		"Comma-delimited list of image prefixes (eg registry.example.com/). If set, pods without the "+
+			"spiffe.cofide.io/inject annotation that have a container with a matching image are injected "+
+			"with --auto-inject-mode. No pods are auto-injected by default.")
+	flag.StringVar(&autoInjectServiceAccounts, "auto-inject-service-accounts", "",
+		"Comma-delimited list of service accounts, each as namespace/name. If set, pods without the "+
+			"spiffe.cofide.io/inject annotation that use a listed service account are injected with "+
+			"--auto-inject-mode. No pods are auto-injected by default.")
+	flag.StringVar(&autoInjectMode, "auto-inject-mode", constants.InjectCSIVolume,
+		"Comma-delimited list of components injected into pods matched by --auto-inject-image-prefixes "+
+			"or --auto-inject-service-accounts.")
+	flag.IntVar(&maxEnvVarSize, "max-env-var-size", cofidewebhook.DefaultMaxEnvVarSize,
+		"The maximum size in bytes of an environment variable injected into a pod, such as a rendered sidecar config. "+
+			"Injection is denied for pods that exceed it.")
+	flag.IntVar(&maxAnnotationsSize, "max-annotations-size", cofidewebhook.DefaultMaxAnnotationsSize,
+		"The maximum total size in bytes of a mutated pod's annotations. Injection is denied for pods that exceed it.")
+	flag.StringVar(&images.Helper, "helper-image", "",
+		"The spiffe-helper sidecar image. Overrides the SPIFFE_ENABLE_HELPER_IMAGE environment variable.")
+	flag.StringVar(&images.HelperInit, "helper-init-image", "",
+		"The spiffe-helper init container image. Overrides the SPIFFE_ENABLE_HELPER_INIT_IMAGE environment variable.")
+	flag.StringVar(&images.Proxy, "proxy-image", "",
+		"The Envoy sidecar image. Overrides the SPIFFE_ENABLE_PROXY_IMAGE environment variable.")
+	flag.StringVar(&images.ProxyInit, "proxy-init-image", "",
+		"The proxy init container image, which must provide nft. Overrides the SPIFFE_ENABLE_PROXY_INIT_IMAGE environment variable.")
+	flag.StringVar(&images.DebugUI, "debug-ui-image", "",
+		"The debug UI container image. Overrides the SPIFFE_ENABLE_UI_IMAGE environment variable.")
+	flag.DurationVar(&serverConfig.readTimeout, "webhook-read-timeout", defaultWebhookReadTimeout,
+		"The maximum duration for reading an admission request.")
+	flag.DurationVar(&serverConfig.writeTimeout, "webhook-write-timeout", defaultWebhookWriteTimeout,
+		"The maximum duration for handling an admission request and writing the response.")
+	flag.IntVar(&serverConfig.maxConcurrent, "webhook-max-concurrent-handlers", 0,
+		"The maximum number of admission requests handled at once. Unlimited by default.")
+	flag.Float64Var(&kubeClientConfig.qps, "client-qps", defaultClientQPS,
+		"The maximum sustained queries per second from the manager to the Kubernetes API server.")
+	flag.IntVar(&kubeClientConfig.burst, "client-burst", defaultClientBurst,
+		"The maximum burst of queries from the manager to the Kubernetes API server.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -58,6 +153,16 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if err := serverConfig.validate(); err != nil {
+		setupLog.Error(err, "invalid webhook server configuration")
+		os.Exit(1)
+	}
+
+	if err := kubeClientConfig.validate(); err != nil {
+		setupLog.Error(err, "invalid client configuration")
+		os.Exit(1)
+	}
+
 	disableHTTP2 := func(c *tls.Config) {
 		setupLog.Info("disabling http/2")
 		c.NextProtos = []string{"http/1.1"}
@@ -72,8 +177,9 @@ func main() {
 		TLSOpts: tlsOpts,
 	})
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	mgr, err := ctrl.NewManager(kubeClientConfig.apply(ctrl.GetConfigOrDie()), ctrl.Options{
 		Scheme: scheme,
+		Cache:  cacheOptions(),
 		Metrics: metricsserver.Options{
 			BindAddress:    metricsAddr,
 			SecureServing:  secureMetrics,
@@ -90,20 +196,68 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Start the informers for the objects that the webhook reads with the manager
+	namespacePolicy := len(splitList(allowedTrustDomains)) > 0 || allowedModes != ""
+	informers, err := registerInformers(context.Background(), mgr.GetCache(),
+		cachedObjects(namespacePolicy, namespaceDefaults))
+	if err != nil {
+		setupLog.Error(err, "unable to set up informers")
+		os.Exit(1)
+	}
+
+	tracerProvider, shutdownTracing, err := newTracerProvider(context.Background(), otelEndpoint)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+
+	serverVersion := detectServerVersion(mgr.GetConfig())
+	nativeSidecars := versionSupports(serverVersion, minNativeSidecarVersion)
+	sleepAction := versionSupports(serverVersion, minSleepActionVersion)
+	if serverVersion != nil {
+		setupLog.Info("detected Kubernetes version", "version", serverVersion.String(),
+			"nativeSidecars", nativeSidecars, "sleepAction", sleepAction)
+	}
+
 	spiffeEnableHandler, err := cofidewebhook.NewSpiffeEnableWebhook(
 		mgr.GetClient(),
 		ctrl.Log.WithName("cofide-spiffe-enable"),
 		admission.NewDecoder(mgr.GetScheme()),
+		cofidewebhook.WithNativeSidecarSupport(nativeSidecars),
+		cofidewebhook.WithSleepActionSupport(sleepAction),
+		cofidewebhook.WithSkipOwnerKinds(splitList(skipOwnerKinds)),
+		cofidewebhook.WithVersion(version),
+		cofidewebhook.WithAllowedTrustDomains(splitList(allowedTrustDomains)),
+		cofidewebhook.WithAllowedModes(allowedModes),
+		cofidewebhook.WithDenyContainerNameCollisions(denyNameCollisions),
+		cofidewebhook.WithRequireSidecarResources(requireSidecarResources),
+		cofidewebhook.WithAgentXDSCheck(checkAgentXDS),
+		cofidewebhook.WithNamespaceDefaults(namespaceDefaults),
+		cofidewebhook.WithAutoInjectMode(autoInjectMode),
+		cofidewebhook.WithAutoInjectImagePrefixes(splitList(autoInjectImagePrefixes)),
+		cofidewebhook.WithAutoInjectServiceAccounts(splitList(autoInjectServiceAccounts)),
+		cofidewebhook.WithMaxEnvVarSize(maxEnvVarSize),
+		cofidewebhook.WithMaxAnnotationsSize(maxAnnotationsSize),
+		cofidewebhook.WithImages(images),
+		cofidewebhook.WithTracerProvider(tracerProvider),
 	)
 	if err != nil {
 		setupLog.Error(err, "unable to create cofide-spiffe-enable handler")
 		os.Exit(1)
 	}
 
-	mgr.GetWebhookServer().Register("/inject", &admission.Webhook{
+	if prefixes := splitList(autoInjectImagePrefixes); len(prefixes) > 0 {
+		setupLog.Info("auto-injection enabled for pods with matching images", "imagePrefixes", prefixes, "mode", autoInjectMode)
+	}
+	if serviceAccounts := splitList(autoInjectServiceAccounts); len(serviceAccounts) > 0 {
+		setupLog.Info("auto-injection enabled for pods with matching service accounts",
+			"serviceAccounts", serviceAccounts, "mode", autoInjectMode)
+	}
+
+	mgr.GetWebhookServer().Register("/inject", serverConfig.wrap(&admission.Webhook{
 		Handler:      spiffeEnableHandler,
 		RecoverPanic: ptr.To(true),
-	})
+	}))
 
 	// +kubebuilder:scaffold:builder
 
@@ -115,10 +269,66 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("config", spiffeEnableHandler.ReadyCheck); err != nil {
+		setupLog.Error(err, "unable to set up config ready check")
+		os.Exit(1)
+	}
+	// Admission requests are only routed to a replica that is serving with its certs loaded, and
+	// whose cache has synced
+	if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+		setupLog.Error(err, "unable to set up webhook ready check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("cache", informersSyncedCheck(informers)); err != nil {
+		setupLog.Error(err, "unable to set up cache ready check")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	err = mgr.Start(ctrl.SetupSignalHandler())
+	shutdownTracing()
+	if err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// detectServerVersion returns the cluster's Kubernetes version, or nil if it can't be determined
+func detectServerVersion(cfg *rest.Config) *utilversion.Version {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		setupLog.Error(err, "unable to create discovery client, assuming support for all features")
+		return nil
+	}
+
+	serverVersion, err := discoveryClient.ServerVersion()
+	if err != nil {
+		setupLog.Error(err, "unable to get server version, assuming support for all features")
+		return nil
+	}
+
+	v, err := utilversion.ParseGeneric(serverVersion.GitVersion)
+	if err != nil {
+		setupLog.Error(err, "unable to parse server version, assuming support for all features",
+			"version", serverVersion.GitVersion)
+		return nil
+	}
+	return v
+}
+
+// versionSupports checks whether the cluster's Kubernetes version is at least minVersion. If the
+// version couldn't be determined, support is assumed.
+func versionSupports(v *utilversion.Version, minVersion *utilversion.Version) bool {
+	return v == nil || v.AtLeast(minVersion)
+}
+
+// splitList splits a comma-delimited flag value, ignoring empty elements
+func splitList(value string) []string {
+	var elements []string
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	"github.com/cofide/spiffe-enable/internal/helper"
+	"github.com/cofide/spiffe-enable/internal/proxy"
+)
+
+const (
+	selfTestCommand = "selftest"
+	selfTestTimeout = 30 * time.Second
+
+	selfTestPass = "PASS"
+	selfTestFail = "FAIL"
+	selfTestSkip = "SKIP"
+
+	// spiffeHelperSelfTestTimeout bounds how long spiffe-helper is run for while it retries the
+	// Workload API, having already accepted its config
+	spiffeHelperSelfTestTimeout = 5 * time.Second
+)
+
+// spiffeHelperConfigErrors are how spiffe-helper reports a config that it failed to parse or
+// validate when it exits
+var spiffeHelperConfigErrors = []string{"failed to parse", "invalid configuration"}
+
+// spiffeHelperAcceptedConfig reports whether spiffe-helper's output shows that it got past parsing
+// and validating its config
+func spiffeHelperAcceptedConfig(output []byte) bool {
+	for _, configErr := range spiffeHelperConfigErrors {
+		if bytes.Contains(output, []byte(configErr)) {
+			return false
+		}
+	}
+	return true
+}
+
+// selfTestCheck renders a config and validates it with an external binary
+type selfTestCheck struct {
+	name string
+	// render returns the config file name and contents
+	render func(ctx context.Context) (string, []byte, error)
+	// binary is looked up in PATH and invoked with args to validate the config; the check is
+	// skipped if the binary is absent
+	binary string
+	args   func(configPath string) []string
+	// timeout, if set, bounds how long the binary is run for
+	timeout time.Duration
+	// accepted, if set, reports whether the binary accepted the config despite exiting with an
+	// error, for binaries that can't validate a config without also running it
+	accepted func(output []byte) bool
+}
+
+type selfTestResult struct {
+	name    string
+	status  string
+	message string
+}
+
+func selfTestChecks() []selfTestCheck {
+	return []selfTestCheck{
+		{
+			name: "envoy",
+			render: func(ctx context.Context) (string, []byte, error) {
+				envoy, err := proxy.NewEnvoy(ctx, proxy.EnvoyConfigParams{
+					AgentXDSService: constants.AgentXDSService,
+					AgentXDSPort:    constants.AgentXDSPort,
+				})
+				if err != nil {
+					return "", nil, err
+				}
+				return proxy.EnvoyConfigFileName, envoy.Cfg, nil
+			},
+			binary: "envoy",
+			args: func(configPath string) []string {
+				return []string{"--mode", "validate", "-c", configPath}
+			},
+		},
+		{
+			name: "spiffe-helper",
+			render: func(ctx context.Context) (string, []byte, error) {
+				spiffeHelper, err := helper.NewSPIFFEHelper(ctx, helper.SPIFFEHelperConfigParams{
+					AgentAddress: constants.SPIFFEWLSocketPath,
+					CertPath:     constants.SPIFFEEnableCertDirectory,
+				})
+				if err != nil {
+					return "", nil, err
+				}
+				return spiffeHelper.ConfigFileName(), []byte(spiffeHelper.Config), nil
+			},
+			// spiffe-helper has no validation mode, but parses and validates its config before
+			// connecting to the Workload API, so run it once without an agent and check that it
+			// failed for want of one rather than rejecting the config
+			binary: "spiffe-helper",
+			args: func(configPath string) []string {
+				return []string{"-config", configPath, "-daemon-mode=false"}
+			},
+			timeout:  spiffeHelperSelfTestTimeout,
+			accepted: spiffeHelperAcceptedConfig,
+		},
+	}
+}
+
+// runSelfTest renders each config into dir and validates it, writing a line per check to out.
+// It returns false if any check failed.
+func runSelfTest(ctx context.Context, out io.Writer, dir string, checks []selfTestCheck) bool {
+	ok := true
+	for _, check := range checks {
+		result := runSelfTestCheck(ctx, dir, check)
+		if result.status == selfTestFail {
+			ok = false
+		}
+		_, _ = fmt.Fprintf(out, "%s\t%s: %s\n", result.status, result.name, result.message)
+	}
+	return ok
+}
+
+func runSelfTestCheck(ctx context.Context, dir string, check selfTestCheck) selfTestResult {
+	result := selfTestResult{name: check.name, status: selfTestFail}
+
+	fileName, config, err := check.render(ctx)
+	if err != nil {
+		result.message = fmt.Sprintf("failed to render config: %v", err)
+		return result
+	}
+
+	configPath := filepath.Join(dir, check.name, fileName)
+	if err := os.MkdirAll(filepath.Dir(configPath), 0o755); err != nil {
+		result.message = fmt.Sprintf("failed to create config directory: %v", err)
+		return result
+	}
+	if err := os.WriteFile(configPath, config, 0o644); err != nil {
+		result.message = fmt.Sprintf("failed to write config: %v", err)
+		return result
+	}
+
+	binaryPath, err := exec.LookPath(check.binary)
+	if errors.Is(err, exec.ErrNotFound) {
+		result.status = selfTestSkip
+		result.message = fmt.Sprintf("%s binary not found in PATH, skipping validation", check.binary)
+		return result
+	}
+	if err != nil {
+		result.message = fmt.Sprintf("failed to find %s binary: %v", check.binary, err)
+		return result
+	}
+
+	cmdCtx := ctx
+	if check.timeout > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(ctx, check.timeout)
+		defer cancel()
+	}
+	output, err := exec.CommandContext(cmdCtx, binaryPath, check.args(configPath)...).CombinedOutput()
+	if err != nil && (check.accepted == nil || !check.accepted(output)) {
+		result.message = fmt.Sprintf("%s rejected config: %v: %s", check.binary, err, strings.TrimSpace(string(output)))
+		return result
+	}
+	result.status = selfTestPass
+	result.message = fmt.Sprintf("config validated by %s", binaryPath)
+	return result
+}
+
+// selfTest runs the selftest subcommand, returning the process exit code
+func selfTest() int {
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+
+	dir, err := os.MkdirTemp("", "spiffe-enable-selftest")
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to create temporary directory: %v\n", err)
+		return 1
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	if !runSelfTest(ctx, os.Stdout, dir, selfTestChecks()) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeStubBinary writes an executable shell script named name into dir
+func writeStubBinary(t *testing.T, dir, name, script string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0o755))
+}
+
+func TestRunSelfTest(t *testing.T) {
+	tests := []struct {
+		name           string
+		stubScript     string
+		expectedOK     bool
+		expectedOutput []string
+	}{
+		{
+			name:       "envoy binary absent",
+			expectedOK: true,
+			expectedOutput: []string{
+				"SKIP\tenvoy: envoy binary not found in PATH",
+				"SKIP\tspiffe-helper: spiffe-helper binary not found in PATH",
+			},
+		},
+		{
+			name: "envoy accepts config",
+			// Succeed only when invoked in validate mode with a config file that exists
+			stubScript: `[ "$1" = "--mode" ] && [ "$2" = "validate" ] && [ "$3" = "-c" ] && [ -s "$4" ]`,
+			expectedOK: true,
+			expectedOutput: []string{
+				"PASS\tenvoy: config validated by",
+				"SKIP\tspiffe-helper: spiffe-helper binary not found in PATH",
+			},
+		},
+		{
+			name:       "envoy rejects config",
+			stubScript: "echo 'invalid bootstrap'; exit 1",
+			expectedOK: false,
+			expectedOutput: []string{
+				"FAIL\tenvoy: envoy rejected config",
+				"invalid bootstrap",
+				"SKIP\tspiffe-helper: spiffe-helper binary not found in PATH",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binDir := t.TempDir()
+			if tt.stubScript != "" {
+				writeStubBinary(t, binDir, "envoy", tt.stubScript)
+			}
+			t.Setenv("PATH", binDir)
+
+			var out bytes.Buffer
+			ok := runSelfTest(context.Background(), &out, t.TempDir(), selfTestChecks())
+
+			assert.Equal(t, tt.expectedOK, ok)
+			for _, expected := range tt.expectedOutput {
+				assert.Contains(t, out.String(), expected)
+			}
+		})
+	}
+}
+
+func TestRunSelfTest_RenderedConfigWritten(t *testing.T) {
+	binDir := t.TempDir()
+	configDir := t.TempDir()
+	// Copy the config passed to the stub so that it can be inspected
+	writeStubBinary(t, binDir, "envoy", `cat "$4" > "`+filepath.Join(configDir, "validated.yaml")+`"`)
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var out bytes.Buffer
+	require.True(t, runSelfTest(context.Background(), &out, t.TempDir(), selfTestChecks()), out.String())
+
+	validated, err := os.ReadFile(filepath.Join(configDir, "validated.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(validated), "xds_cluster")
+}
+
+func TestRunSelfTest_SPIFFEHelper(t *testing.T) {
+	tests := []struct {
+		name           string
+		stubScript     string
+		expectedOK     bool
+		expectedOutput string
+	}{
+		{
+			name:           "spiffe-helper binary absent",
+			expectedOK:     true,
+			expectedOutput: "SKIP\tspiffe-helper: spiffe-helper binary not found in PATH",
+		},
+		{
+			name: "spiffe-helper accepts config",
+			// Fail for want of an agent, as spiffe-helper does once it has validated its config, if
+			// invoked once with a config file that exists
+			stubScript: `[ "$1" = "-config" ] && [ -s "$2" ] && [ "$3" = "-daemon-mode=false" ] || { echo 'failed to parse'; exit 1; }
+echo 'failed to fetch X.509 context: connection refused'; exit 1`,
+			expectedOK:     true,
+			expectedOutput: "PASS\tspiffe-helper: config validated by",
+		},
+		{
+			name:           "spiffe-helper rejects config",
+			stubScript:     `echo "Error starting spiffe-helper: failed to parse \"$2\": unknown key"; exit 1`,
+			expectedOK:     false,
+			expectedOutput: "FAIL\tspiffe-helper: spiffe-helper rejected config",
+		},
+		{
+			name:           "spiffe-helper rejects invalid config",
+			stubScript:     `echo 'Error starting spiffe-helper: invalid configuration: agent_address is required'; exit 1`,
+			expectedOK:     false,
+			expectedOutput: "FAIL\tspiffe-helper: spiffe-helper rejected config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binDir := t.TempDir()
+			if tt.stubScript != "" {
+				writeStubBinary(t, binDir, "spiffe-helper", tt.stubScript)
+			}
+			t.Setenv("PATH", binDir)
+
+			var out bytes.Buffer
+			ok := runSelfTest(context.Background(), &out, t.TempDir(), selfTestChecks()[1:])
+
+			assert.Equal(t, tt.expectedOK, ok)
+			assert.Contains(t, out.String(), tt.expectedOutput)
+		})
+	}
+}
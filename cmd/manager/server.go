@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultWebhookReadTimeout  = 10 * time.Second
+	defaultWebhookWriteTimeout = 10 * time.Second
+)
+
+// webhookServerConfig tunes how the webhook server handles admission requests. controller-runtime
+// doesn't expose its HTTP server settings, so they are applied to each request by wrapping the handler.
+type webhookServerConfig struct {
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	// maxConcurrent limits the number of requests handled at once; further requests wait for a
+	// free slot until they are cancelled. Zero means no limit.
+	maxConcurrent int
+}
+
+func (c webhookServerConfig) validate() error {
+	if c.readTimeout <= 0 {
+		return fmt.Errorf("invalid webhook read timeout %s: must be positive", c.readTimeout)
+	}
+	if c.writeTimeout <= 0 {
+		return fmt.Errorf("invalid webhook write timeout %s: must be positive", c.writeTimeout)
+	}
+	if c.maxConcurrent < 0 {
+		return fmt.Errorf("invalid webhook max concurrent handlers %d: must not be negative", c.maxConcurrent)
+	}
+	return nil
+}
+
+// wrap applies the config to handler
+func (c webhookServerConfig) wrap(handler http.Handler) http.Handler {
+	var slots chan struct{}
+	if c.maxConcurrent > 0 {
+		slots = make(chan struct{}, c.maxConcurrent)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Deadlines can't be set on all connections, eg in tests, in which case the server's defaults apply
+		rc := http.NewResponseController(w)
+		now := time.Now()
+		if err := rc.SetReadDeadline(now.Add(c.readTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := rc.SetWriteDeadline(now.Add(c.writeTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-r.Context().Done():
+				http.Error(w, "too many concurrent admission requests", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
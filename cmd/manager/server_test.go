@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deadlineRecorder records the deadlines set through an http.ResponseController
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func (d *deadlineRecorder) SetReadDeadline(deadline time.Time) error {
+	d.readDeadline = deadline
+	return nil
+}
+
+func (d *deadlineRecorder) SetWriteDeadline(deadline time.Time) error {
+	d.writeDeadline = deadline
+	return nil
+}
+
+func TestWebhookServerConfig_Validate(t *testing.T) {
+	valid := webhookServerConfig{readTimeout: time.Second, writeTimeout: time.Second}
+	require.NoError(t, valid.validate())
+
+	for name, cfg := range map[string]webhookServerConfig{
+		"zero read timeout":       {writeTimeout: time.Second},
+		"negative write timeout":  {readTimeout: time.Second, writeTimeout: -time.Second},
+		"negative max concurrent": {readTimeout: time.Second, writeTimeout: time.Second, maxConcurrent: -1},
+	} {
+		assert.Error(t, cfg.validate(), name)
+	}
+}
+
+func TestWebhookServerConfig_Wrap(t *testing.T) {
+	t.Run("sets deadlines", func(t *testing.T) {
+		cfg := webhookServerConfig{readTimeout: 5 * time.Second, writeTimeout: 20 * time.Second}
+		handler := cfg.wrap(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		rec := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+		start := time.Now()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/inject", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.WithinDuration(t, start.Add(cfg.readTimeout), rec.readDeadline, time.Second)
+		assert.WithinDuration(t, start.Add(cfg.writeTimeout), rec.writeDeadline, time.Second)
+	})
+
+	t.Run("limits concurrent handlers", func(t *testing.T) {
+		cfg := webhookServerConfig{readTimeout: time.Second, writeTimeout: time.Second, maxConcurrent: 1}
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		handler := cfg.wrap(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			close(started)
+			<-release
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		first := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handler.ServeHTTP(first, httptest.NewRequest(http.MethodPost, "/inject", nil))
+		}()
+		<-started
+
+		// The only slot is taken, so the second request waits until it is cancelled
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		second := httptest.NewRecorder()
+		handler.ServeHTTP(second, httptest.NewRequest(http.MethodPost, "/inject", nil).WithContext(ctx))
+		assert.Equal(t, http.StatusServiceUnavailable, second.Code)
+
+		close(release)
+		<-done
+		assert.Equal(t, http.StatusOK, first.Code)
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracingShutdownTimeout bounds flushing the remaining spans when the manager stops
+const tracingShutdownTimeout = 5 * time.Second
+
+// newTracerProvider returns a tracer provider that exports spans over OTLP/gRPC to endpoint, a URL
+// such as http://otel-collector:4317, along with a function that flushes and stops it. Tracing is a
+// no-op if endpoint is empty.
+func newTracerProvider(ctx context.Context, endpoint string) (trace.TracerProvider, func(), error) {
+	if endpoint == "" {
+		return noop.NewTracerProvider(), func() {}, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating OTLP trace exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("spiffe-enable"),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	shutdown := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			setupLog.Error(err, "unable to shut down tracing")
+		}
+	}
+	return provider, shutdown, nil
+}
@@ -1,12 +1,108 @@
 package constants
 
+import "time"
+
 // Pod annotations
 const (
-	InjectAnnotation        = "spiffe.cofide.io/inject"
-	DebugAnnotation         = "spiffe.cofide.io/debug"
-	EnvoyLogLevelAnnotation = "spiffe.cofide.io/envoy-log-level"
+	InjectAnnotation          = "spiffe.cofide.io/inject"
+	DebugAnnotation           = "spiffe.cofide.io/debug"
+	DebugUIExposeAnnotation   = "spiffe.cofide.io/debug-ui-expose"
+	DebugUIPortAnnotation     = "spiffe.cofide.io/debug-ui-port"
+	EnvoyLogLevelAnnotation   = "spiffe.cofide.io/envoy-log-level"
+	ExtraEnvAnnotation        = "spiffe.cofide.io/extra-env"
+	EnvFromAnnotation         = "spiffe.cofide.io/env-from"
+	SidecarModeAnnotation     = "spiffe.cofide.io/sidecar-mode"
+	SidecarPositionAnnotation = "spiffe.cofide.io/sidecar-position"
+	ProxySizeAnnotation       = "spiffe.cofide.io/proxy-size"
+	ProxyResourcesAnnotation  = "spiffe.cofide.io/proxy-resources"
+	// InjectInitContainersAnnotation also mounts the SPIFFE Workload API socket into the pod's own
+	// init containers, which don't get it by default
+	InjectInitContainersAnnotation = "spiffe.cofide.io/inject-init-containers"
+	// TargetContainersAnnotation restricts the application containers that get the SPIFFE Workload
+	// API socket to those listed, eg to leave out logging agents
+	TargetContainersAnnotation = "spiffe.cofide.io/target-containers"
+	// WorkloadSocketPathAnnotation sets the path of the SPIFFE Workload API socket within the CSI
+	// volume, eg for an agent whose socket has another name
+	WorkloadSocketPathAnnotation = "spiffe.cofide.io/workload-socket-path"
+	// EnvoyAdminPortAnnotation and EnvoyAdminAddressAnnotation move Envoy's admin interface, eg
+	// off a port that the app already binds
+	EnvoyAdminPortAnnotation    = "spiffe.cofide.io/envoy-admin-port"
+	EnvoyAdminAddressAnnotation = "spiffe.cofide.io/envoy-admin-address"
+	// ProxyDNSConfigAnnotation tunes the pod's DNS config for the proxy's DNS redirection
+	ProxyDNSConfigAnnotation = "spiffe.cofide.io/proxy-dns-config"
+	// ProxyCustomDNSAnnotation selects how DNS redirection is handled for pods with dnsPolicy None
+	ProxyCustomDNSAnnotation = "spiffe.cofide.io/proxy-custom-dns"
+	// ProxyFlavorAnnotation selects the proxy flavor, which adjusts the traffic capture rules
+	ProxyFlavorAnnotation = "spiffe.cofide.io/proxy-flavor"
+	// ExcludeOutboundPortsAnnotation is a comma-delimited list of loopback destination ports whose
+	// traffic isn't redirected to the proxy
+	ExcludeOutboundPortsAnnotation = "spiffe.cofide.io/exclude-outbound-ports"
+	// ExcludeDestCIDRsAnnotation is a comma-delimited list of destination CIDRs whose traffic isn't
+	// redirected to the proxy
+	ExcludeDestCIDRsAnnotation = "spiffe.cofide.io/exclude-dest-cidrs"
+	// ProxyIPFamilyAnnotation limits the proxy's traffic capture rules to one IP family, eg for
+	// IPv6-only nodes
+	ProxyIPFamilyAnnotation = "spiffe.cofide.io/proxy-ip-family"
+	// ProxyStartupProbeAnnotation adds a startup probe on Envoy's readiness to the app containers,
+	// with a timeout set by ProxyStartupProbeTimeoutAnnotation. The probe doesn't delay the app's
+	// process: it holds back the container's liveness and readiness probes until Envoy is ready,
+	// and the kubelet restarts the container if Envoy isn't ready within the timeout. Envoy's admin
+	// interface is only bound to loopback, so the probe targets the readiness listener on
+	// port 15021, which serves only the admin readiness endpoint.
+	ProxyStartupProbeAnnotation        = "spiffe.cofide.io/proxy-startup-probe"
+	ProxyStartupProbeTimeoutAnnotation = "spiffe.cofide.io/proxy-startup-probe-timeout"
+	// ProxyWaitForSocketAnnotation makes the proxy init container wait for the SPIFFE Workload API
+	// socket before applying the nftables rules, for up to ProxyWaitForSocketTimeoutAnnotation
+	ProxyWaitForSocketAnnotation        = "spiffe.cofide.io/proxy-wait-for-socket"
+	ProxyWaitForSocketTimeoutAnnotation = "spiffe.cofide.io/proxy-wait-for-socket-timeout"
+	// ProxyJWTAudiencesAnnotation enables validation of the JWT-SVIDs of incoming requests by the
+	// proxy, accepting the listed audiences, before forwarding them to ProxyJWTAppPortAnnotation
+	ProxyJWTAudiencesAnnotation = "spiffe.cofide.io/proxy-jwt-audiences"
+	ProxyJWTAppPortAnnotation   = "spiffe.cofide.io/proxy-jwt-app-port"
+	ProxyJWTJWKSURIAnnotation   = "spiffe.cofide.io/proxy-jwt-jwks-uri"
+	ProxyJWTForwardAnnotation   = "spiffe.cofide.io/proxy-jwt-forward"
+	// ProxyInboundPortAnnotation adds a proxy listener on this port that terminates mTLS for
+	// incoming connections, forwarding them to ProxyInboundAppPortAnnotation
+	ProxyInboundPortAnnotation    = "spiffe.cofide.io/proxy-inbound-port"
+	ProxyInboundAppPortAnnotation = "spiffe.cofide.io/proxy-inbound-app-port"
+	// ProxyUpstreamsAnnotation is a comma-delimited list of upstreams, in the form
+	// host:port=spiffe-id, that the proxy originates mTLS to, validating their SPIFFE IDs
+	ProxyUpstreamsAnnotation = "spiffe.cofide.io/proxy-upstreams"
+	// ProxyInitExtraCommandsAnnotation is an advanced, unsafe escape hatch: its value is run as
+	// shell commands, as root, in the proxy init container before the nftables rules are applied
+	ProxyInitExtraCommandsAnnotation = "spiffe.cofide.io/proxy-init-extra-commands"
+	// InitNetRawAnnotation adds the NET_RAW capability, which the nftables rules don't need, to the
+	// proxy init container
+	InitNetRawAnnotation = "spiffe.cofide.io/init-net-raw"
+	// ConfigVolumeMemoryAnnotation backs the injected proxy and helper config volumes with memory,
+	// like the cert volume, so that the rendered config isn't written to the node's disk
+	ConfigVolumeMemoryAnnotation = "spiffe.cofide.io/config-volume-memory"
+	// InitImagePullPolicyAnnotation sets the image pull policy of the injected init containers,
+	// independently of the sidecars
+	InitImagePullPolicyAnnotation = "spiffe.cofide.io/init-image-pull-policy"
+	// ImagePullSecretsAnnotation lists secrets, by name, to add to the pod's image pull secrets for
+	// pulling the images of the injected containers from a private registry
+	ImagePullSecretsAnnotation = "spiffe.cofide.io/image-pull-secrets"
+
+	// DryRunAnnotation makes the webhook log the mutations it would make to the pod without making
+	// them, to preview injection
+	DryRunAnnotation = "spiffe.cofide.io/dry-run"
+
+	// TrustDomainAnnotation is set on a namespace to map it to the trust domain of its workloads
+	TrustDomainAnnotation = "spiffe.cofide.io/trust-domain"
+	// AllowedModesAnnotation is set on a namespace to override the modes that may be injected into
+	// its pods, when the mode policy is enabled
+	AllowedModesAnnotation = "spiffe.cofide.io/allowed-modes"
+
+	// Audit annotations, set by the webhook on pods that it mutates
+	InjectedAtAnnotation = "spiffe.cofide.io/injected-at"
+	InjectedByAnnotation = "spiffe.cofide.io/injected-by"
 )
 
+// NamespaceDefaultsConfigMap is the name of the ConfigMap in a namespace that sets default
+// annotations for its pods, when namespace defaults are enabled
+const NamespaceDefaultsConfigMap = "spiffe-enable-defaults"
+
 // Components that can be injected
 const (
 	InjectAnnotationHelper = "helper"
@@ -14,6 +110,30 @@ const (
 	InjectCSIVolume        = "csi"
 )
 
+// Sidecar modes
+const (
+	// SidecarModeNative injects sidecars as init containers with restartPolicy Always
+	SidecarModeNative = "native"
+	// SidecarModeRegular injects sidecars as regular application containers
+	SidecarModeRegular = "regular"
+)
+
+// Handling of DNS redirection for pods with their own DNS config (dnsPolicy None)
+const (
+	// ProxyCustomDNSWarn redirects DNS requests to the proxy, with a warning
+	ProxyCustomDNSWarn = "warn"
+	// ProxyCustomDNSSkip leaves DNS requests to go to the pod's nameservers directly
+	ProxyCustomDNSSkip = "skip"
+)
+
+// Sidecar positions, for sidecars injected as regular containers
+const (
+	// SidecarPositionFirst places sidecars before the existing containers
+	SidecarPositionFirst = "first"
+	// SidecarPositionLast places sidecars after the existing containers
+	SidecarPositionLast = "last"
+)
+
 // SPIFFE Workload API
 const (
 	SPIFFEWLVolume        = "spiffe-workload-api"
@@ -27,6 +147,13 @@ const (
 const (
 	AgentXDSPort    = 18001
 	AgentXDSService = "cofide-agent-xds.cofide.svc.cluster.local"
+
+	// The xDS authentication token is read from EnvVarXDSTokenFile if set, otherwise EnvVarXDSToken,
+	// and sent in the header named by EnvVarXDSTokenHeader
+	EnvVarXDSToken        = "SPIFFE_ENABLE_XDS_TOKEN"
+	EnvVarXDSTokenFile    = "SPIFFE_ENABLE_XDS_TOKEN_FILE"
+	EnvVarXDSTokenHeader  = "SPIFFE_ENABLE_XDS_TOKEN_HEADER"
+	DefaultXDSTokenHeader = "authorization"
 )
 
 // SPIFFE Enable
@@ -35,10 +162,37 @@ const (
 	SPIFFEEnableCertDirectory  = "/spiffe-enable"
 )
 
+// Webhook configuration
+const (
+	DefaultRenderTimeout = 2 * time.Second
+	EnvVarRenderTimeout  = "SPIFFE_ENABLE_RENDER_TIMEOUT"
+	// The proxy init container applies nftables rules, so its image needs nft; the helper
+	// init container only writes config files, so any image with a shell will do
+	EnvVarProxyInitImage  = "SPIFFE_ENABLE_PROXY_INIT_IMAGE"
+	EnvVarHelperInitImage = "SPIFFE_ENABLE_HELPER_INIT_IMAGE"
+	// EnvVarProxyImage and EnvVarHelperImage override the sidecar images, eg to pull them from a mirror
+	EnvVarProxyImage  = "SPIFFE_ENABLE_PROXY_IMAGE"
+	EnvVarHelperImage = "SPIFFE_ENABLE_HELPER_IMAGE"
+	// EnvVarInitFallbackImage is used for the helper init container if its image isn't set, in place
+	// of the default image, eg a public image such as PublicInitFallbackImage for clusters that can't
+	// pull the default. It isn't used for the proxy init container, which needs nft.
+	EnvVarInitFallbackImage = "SPIFFE_ENABLE_INIT_FALLBACK_IMAGE"
+	// EnvVarJWKSURI is the default JWKS URI of the trust domain's JWT bundle, used to validate
+	// JWT-SVIDs if the pod doesn't set its own
+	EnvVarJWKSURI = "SPIFFE_ENABLE_JWKS_URI"
+	// EnvVarEnvoyBaseConfigFile is the path of an operator-provided Envoy bootstrap config, in JSON
+	// or YAML, that the generated config is merged into
+	EnvVarEnvoyBaseConfigFile = "SPIFFE_ENABLE_ENVOY_BASE_CONFIG_FILE"
+	// PublicInitFallbackImage is a public image with a shell, suitable for the helper init container
+	PublicInitFallbackImage = "docker.io/library/busybox:1.37"
+)
+
 // Debug UI constants
 const (
 	DebugUIContainerName = "spiffe-enable-ui"
-	DebugUIPort          = 8000
+	DebugUIPort          = 8080
 	DefaultDebugUIImage  = "ghcr.io/cofide/spiffe-enable-ui:v0.3.0"
 	EnvVarUIImage        = "SPIFFE_ENABLE_UI_IMAGE"
+	// EnvVarUIPort is set on the debug UI container to move it off DebugUIPort
+	EnvVarUIPort = "SPIFFE_ENABLE_UI_PORT"
 )
@@ -1,19 +1,29 @@
 package helper
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	constants "github.com/cofide/spiffe-enable/internal/const"
+	"github.com/cofide/spiffe-enable/internal/render"
 	"github.com/cofide/spiffe-enable/internal/workload"
 	"github.com/hashicorp/hcl/v2/hclwrite"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
 
 	"github.com/hashicorp/hcl/v2/gohcl"
 )
 
-// Images
+// Default images, which can be overridden with SPIFFEHelperConfigParams
 var (
 	SPIFFEHelperImage = "ghcr.io/spiffe/spiffe-helper:0.10.1"
 	InitHelperImage   = "ghcr.io/cofide/spiffe-enable-init:v0.3.0"
@@ -21,111 +31,453 @@ var (
 
 // Constants
 const (
-	SPIFFEHelperIncIntermediateAnnotation = "spiffe.cofide.io/spiffe-helper-include-intermediate-bundle"
-	SPIFFEHelperConfigVolumeName          = "spiffe-helper-config"
-	SPIFFEHelperSidecarContainerName      = "spiffe-helper"
-	SPIFFEHelperConfigContentEnvVar       = "SPIFFE_HELPER_CONFIG"
-	SPIFFEHelperConfigMountPath           = "/etc/spiffe-helper"
-	SPIFFEHelperConfigFileName            = "config.conf"
-	SPIFFEHelperInitContainerName         = "inject-spiffe-helper-config"
-	SPIFFEHelperHealthCheckReadinessPath  = "/ready"
-	SPIFFEHelperHealthCheckLivenessPath   = "/live"
-	SPIFFEHelperHealthCheckPort           = 8081
+	SPIFFEHelperIncIntermediateAnnotation  = "spiffe.cofide.io/spiffe-helper-include-intermediate-bundle"
+	SPIFFEHelperConfigFormatAnnotation     = "spiffe.cofide.io/helper-config-format"
+	SPIFFEHelperCertDirModeAnnotation      = "spiffe.cofide.io/helper-cert-dir-mode"
+	SPIFFEHelperCertFileModeAnnotation     = "spiffe.cofide.io/helper-cert-file-mode"
+	SPIFFEHelperKeyFileModeAnnotation      = "spiffe.cofide.io/helper-key-file-mode"
+	SPIFFEHelperCertSymlinksAnnotation     = "spiffe.cofide.io/helper-cert-symlinks"
+	SPIFFEHelperCertPathsAnnotation        = "spiffe.cofide.io/helper-cert-paths"
+	SPIFFEHelperHealthChecksAnnotation     = "spiffe.cofide.io/helper-health-checks"
+	SPIFFEHelperPreStopSleepAnnotation     = "spiffe.cofide.io/helper-pre-stop-sleep"
+	SPIFFEHelperCABundlePathAnnotation     = "spiffe.cofide.io/helper-ca-bundle-path"
+	SPIFFEHelperResourcesAnnotation        = "spiffe.cofide.io/helper-resources"
+	SPIFFEHelperJWTAudiencesAnnotation     = "spiffe.cofide.io/helper-jwt-audiences"
+	SPIFFEHelperIncFederatedAnnotation     = "spiffe.cofide.io/helper-include-federated-domains"
+	SPIFFEHelperSVIDFileAnnotation         = "spiffe.cofide.io/helper-svid-file-name"
+	SPIFFEHelperSVIDKeyFileAnnotation      = "spiffe.cofide.io/helper-svid-key-file-name"
+	SPIFFEHelperSVIDBundleFileAnnotation   = "spiffe.cofide.io/helper-svid-bundle-file-name"
+	SPIFFEHelperCmdAnnotation              = "spiffe.cofide.io/helper-cmd"
+	SPIFFEHelperCmdArgsAnnotation          = "spiffe.cofide.io/helper-cmd-args"
+	SPIFFEHelperRenewSignalAnnotation      = "spiffe.cofide.io/helper-renew-signal"
+	SPIFFEHelperWaitForCertAnnotation      = "spiffe.cofide.io/wait-for-cert"
+	SPIFFEHelperConfigVolumeName           = "spiffe-helper-config"
+	SPIFFEHelperSidecarContainerName       = "spiffe-helper"
+	SPIFFEHelperConfigContentEnvVar        = "SPIFFE_HELPER_CONFIG"
+	SPIFFEHelperConfigMountPath            = "/etc/spiffe-helper"
+	SPIFFEHelperConfigFileName             = "config.conf"
+	SPIFFEHelperJSONConfigFileName         = "config.json"
+	SPIFFEHelperInitContainerName          = "inject-spiffe-helper-config"
+	SPIFFEHelperWaitContainerName          = "wait-for-spiffe-helper-cert"
+	SPIFFEHelperCertPublisherContainerName = "spiffe-helper-cert-publisher"
+	SPIFFEHelperHealthCheckReadinessPath   = "/ready"
+	SPIFFEHelperHealthCheckLivenessPath    = "/live"
+	SPIFFEHelperHealthCheckPort            = 8081
+	SPIFFEHelperSVIDFileName               = "tls.crt"
+	SPIFFEHelperSVIDKeyFileName            = "tls.key"
+	SPIFFEHelperSVIDBundleFileName         = "ca.pem"
+	SPIFFEHelperJWTSVIDFileName            = "jwt_svid.token"
+	// SPIFFEHelperCertStagingDir is the directory, relative to the cert directory, that spiffe-helper
+	// writes to when cert symlinks are enabled
+	SPIFFEHelperCertStagingDir = "..staging"
+	// SPIFFEHelperCertDataDir is the symlink, relative to the cert directory, to the latest version
+	// of the files when cert symlinks are enabled
+	SPIFFEHelperCertDataDir = "..data"
+	// SPIFFEHelperCertPublishInterval is how often, in seconds, the cert publisher checks for files
+	// written by spiffe-helper
+	SPIFFEHelperCertPublishInterval = 1
 )
 
+// Default permissions for the cert directory and the files written by spiffe-helper. The
+// private key is only readable by its owner, so that other containers sharing the cert
+// volume can't read it unless they run as the same user.
+const (
+	DefaultCertDirMode  = 0o755
+	DefaultCertFileMode = 0o644
+	DefaultKeyFileMode  = 0o600
+)
+
+// DefaultSidecarResources are the spiffe-helper sidecar's resources if none are set. Only requests
+// are set, so that the sidecar isn't BestEffort without risking it being throttled or OOM-killed.
+var DefaultSidecarResources = corev1.ResourceRequirements{
+	Requests: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("10m"),
+		corev1.ResourceMemory: resource.MustParse("32Mi"),
+	},
+}
+
+// Config formats. spiffe-helper parses its config with the HCL v1 decoder, which accepts the legacy
+// HCL syntax and JSON, but not YAML or HCL v2, so JSON is the structured format offered. The
+// decoder detects the syntax from the contents rather than the file name.
+const (
+	SPIFFEHelperConfigFormatHCL  = "hcl"
+	SPIFFEHelperConfigFormatJSON = "json"
+)
+
+// SPIFFEHelperConfigFormats are the supported config formats
+var SPIFFEHelperConfigFormats = []string{SPIFFEHelperConfigFormatHCL, SPIFFEHelperConfigFormatJSON}
+
+var fileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// RenewSignals are the signals that spiffe-helper can send to the process it runs when the certs
+// are renewed. SIGKILL and SIGSTOP are left out, as they can't be handled, so would kill or freeze
+// the process on every renewal.
+var RenewSignals = []string{
+	"SIGHUP", "SIGINT", "SIGQUIT", "SIGUSR1", "SIGUSR2", "SIGTERM", "SIGCONT", "SIGWINCH",
+}
+
 // Structs from github.com/spiffe/spiffe-helper/cmd/spiffe-helper/config
 // Copied for now as the upstream structs are designed for decoding, not encoding to HCL (our case case)
 type SPIFFEHelperConfig struct {
-	AddIntermediatesToBundle bool                     `hcl:"add_intermediates_to_bundle"`
-	AgentAddress             string                   `hcl:"agent_address"`
-	Cmd                      string                   `hcl:"cmd"`
-	CmdArgs                  string                   `hcl:"cmd_args"`
-	PIDFilename              string                   `hcl:"pid_file_name"`
-	CertDir                  string                   `hcl:"cert_dir"`
-	CertFileMode             int                      `hcl:"cert_file_mode"`
-	KeyFileMode              int                      `hcl:"key_file_mode"`
-	JWTBundleFileMode        int                      `hcl:"jwt_bundle_file_mode"`
-	JWTSVIDFileMode          int                      `hcl:"jwt_svid_file_mode"`
-	IncludeFederatedDomains  bool                     `hcl:"include_federated_domains"`
-	RenewSignal              string                   `hcl:"renew_signal"`
-	DaemonMode               *bool                    `hcl:"daemon_mode"`
-	HealthCheck              SPIFFEHelperHealthConfig `hcl:"health_checks,block"`
-	Hint                     string                   `hcl:"hint"`
+	AddIntermediatesToBundle bool                      `hcl:"add_intermediates_to_bundle" json:"add_intermediates_to_bundle"`
+	AgentAddress             string                    `hcl:"agent_address" json:"agent_address"`
+	Cmd                      string                    `hcl:"cmd" json:"cmd"`
+	CmdArgs                  string                    `hcl:"cmd_args" json:"cmd_args"`
+	PIDFilename              string                    `hcl:"pid_file_name" json:"pid_file_name"`
+	CertDir                  string                    `hcl:"cert_dir" json:"cert_dir"`
+	CertFileMode             int                       `hcl:"cert_file_mode" json:"cert_file_mode"`
+	KeyFileMode              int                       `hcl:"key_file_mode" json:"key_file_mode"`
+	JWTBundleFileMode        int                       `hcl:"jwt_bundle_file_mode" json:"jwt_bundle_file_mode"`
+	JWTSVIDFileMode          int                       `hcl:"jwt_svid_file_mode" json:"jwt_svid_file_mode"`
+	IncludeFederatedDomains  bool                      `hcl:"include_federated_domains" json:"include_federated_domains"`
+	RenewSignal              string                    `hcl:"renew_signal" json:"renew_signal"`
+	DaemonMode               *bool                     `hcl:"daemon_mode" json:"daemon_mode"`
+	HealthCheck              *SPIFFEHelperHealthConfig `hcl:"health_checks,block" json:"health_checks,omitempty"`
+	Hint                     string                    `hcl:"hint" json:"hint"`
 
 	// x509 configuration
-	SVIDFilename       string `hcl:"svid_file_name"`
-	SVIDKeyFilename    string `hcl:"svid_key_file_name"`
-	SVIDBundleFilename string `hcl:"svid_bundle_file_name"`
+	SVIDFilename       string `hcl:"svid_file_name" json:"svid_file_name"`
+	SVIDKeyFilename    string `hcl:"svid_key_file_name" json:"svid_key_file_name"`
+	SVIDBundleFilename string `hcl:"svid_bundle_file_name" json:"svid_bundle_file_name"`
 
 	// JWT configuration
-	JWTSVIDs          []SPIFFEHelperJWTConfig `hcl:"jwt_svids,block"`
-	JWTBundleFilename string                  `hcl:"jwt_bundle_file_name"`
+	JWTSVIDs          []SPIFFEHelperJWTConfig `hcl:"jwt_svids,block" json:"jwt_svids,omitempty"`
+	JWTBundleFilename string                  `hcl:"jwt_bundle_file_name" json:"jwt_bundle_file_name"`
 }
 
 type SPIFFEHelperJWTConfig struct {
-	JWTAudience       string   `hcl:"jwt_audience"`
-	JWTExtraAudiences []string `hcl:"jwt_extra_audiences"`
-	JWTSVIDFilename   string   `hcl:"jwt_svid_file_name"`
+	JWTAudience       string   `hcl:"jwt_audience" json:"jwt_audience"`
+	JWTExtraAudiences []string `hcl:"jwt_extra_audiences" json:"jwt_extra_audiences"`
+	JWTSVIDFilename   string   `hcl:"jwt_svid_file_name" json:"jwt_svid_file_name"`
 }
 
 type SPIFFEHelperHealthConfig struct {
-	ListenerEnabled bool   `hcl:"listener_enabled"`
-	BindPort        int    `hcl:"bind_port"`
-	LivenessPath    string `hcl:"liveness_path"`
-	ReadinessPath   string `hcl:"readiness_path"`
+	ListenerEnabled bool   `hcl:"listener_enabled" json:"listener_enabled"`
+	BindPort        int    `hcl:"bind_port" json:"bind_port"`
+	LivenessPath    string `hcl:"liveness_path" json:"liveness_path"`
+	ReadinessPath   string `hcl:"readiness_path" json:"readiness_path"`
 }
 
 type SPIFFEHelperConfigParams struct {
 	AgentAddress              string
 	CertPath                  string
 	IncludeIntermediateBundle bool
+	// ConfigFormat is the format the config is rendered in; defaults to HCL
+	ConfigFormat string
+	// CertDirMode, CertFileMode and KeyFileMode are the permissions of the cert directory and
+	// the files written to it; zero values select the defaults
+	CertDirMode  int
+	CertFileMode int
+	KeyFileMode  int
+	// CertSymlinks exposes the files at stable symlinks in the cert directory, which are swapped to
+	// each new version of the files atomically, rather than writing them there directly; see
+	// GetCertPublisherContainer
+	CertSymlinks bool
+	// Image is the spiffe-helper sidecar image; defaults to SPIFFEHelperImage
+	Image string
+	// InitImage is the image for the init container, which only needs a shell
+	InitImage string
+	// InitImagePullPolicy is the pull policy of the init container, independent of the sidecar's;
+	// defaults to IfNotPresent
+	InitImagePullPolicy corev1.PullPolicy
+	// Resources are the sidecar's resource requests and limits; see GetSidecarResources
+	Resources corev1.ResourceRequirements
+	// ExcludeFederatedDomains stops spiffe-helper from adding the bundles of federated trust domains
+	// to the written trust bundle, eg for single trust domain deployments. They are included by
+	// default.
+	ExcludeFederatedDomains bool
+	// DisableHealthChecks omits the health check listener, which older spiffe-helper versions
+	// don't support, along with the sidecar probes that depend on it
+	DisableHealthChecks bool
+	// ConfigVolumeMemory backs the config volume with memory (tmpfs) rather than the node's disk
+	ConfigVolumeMemory bool
+	// PreStopSleep, if set, adds a preStop hook that delays the termination of the sidecar, so that
+	// a sidecar injected as a regular container outlives the app's graceful shutdown. It only
+	// delays the SIGTERM sent to spiffe-helper, which watches for certs until then. The
+	// spiffe-helper image has no shell, so the hook uses the sleep action, which requires
+	// Kubernetes v1.30+. It is rounded up to whole seconds.
+	PreStopSleep time.Duration
+	// JWTAudiences are the audiences of the JWT-SVIDs that spiffe-helper fetches, one per audience,
+	// and writes to the cert directory; see JWTSVIDFileName. No JWT-SVIDs are fetched if empty.
+	JWTAudiences []string
+	// SVIDFileName, SVIDKeyFileName and SVIDBundleFileName are the names of the files in the cert
+	// directory that the X.509-SVID, its private key and the trust bundle are written to; empty
+	// values select SPIFFEHelperSVIDFileName, SPIFFEHelperSVIDKeyFileName and
+	// SPIFFEHelperSVIDBundleFileName. See ValidateFileNames.
+	SVIDFileName       string
+	SVIDKeyFileName    string
+	SVIDBundleFileName string
+	// Cmd is a command that spiffe-helper runs in its container, with the arguments CmdArgs, and
+	// sends RenewSignal to when the certs are renewed. See ValidateCmd.
+	Cmd         string
+	CmdArgs     string
+	RenewSignal string
+	// CertVolumeName is the volume mounted at the cert directory, which spiffe-helper writes to;
+	// defaults to the emptyDir volume constants.SPIFFEEnableCertVolumeName
+	CertVolumeName string
 }
 
-func NewSPIFFEHelper(params SPIFFEHelperConfigParams) (*SPIFFEHelper, error) {
+// ParseFileMode parses an octal file mode, eg 0600 or 600
+func ParseFileMode(value string) (int, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode == 0 || mode > 0o777 {
+		return 0, fmt.Errorf("invalid file mode %q: must be an octal value between 0001 and 0777", value)
+	}
+	return int(mode), nil
+}
+
+// ParseJWTAudiences parses a comma-delimited list of JWT-SVID audiences, which must be non-empty
+// and distinct
+func ParseJWTAudiences(value string) ([]string, error) {
+	var audiences []string
+	for _, audience := range strings.Split(value, ",") {
+		audience = strings.TrimSpace(audience)
+		if audience == "" {
+			return nil, fmt.Errorf("invalid JWT audiences %q: audiences must not be empty", value)
+		}
+		if slices.Contains(audiences, audience) {
+			return nil, fmt.Errorf("invalid JWT audiences %q: duplicate audience %q", value, audience)
+		}
+		audiences = append(audiences, audience)
+	}
+	return audiences, nil
+}
+
+// ValidateFileNames checks the names of the files written to the cert directory. Each must be a
+// plain file name of letters, digits, '.', '_' and '-', as the names are used in the init
+// container's commands, and they must be distinct. Names starting with .. are reserved for the data
+// directory used with cert symlinks.
+func (p *SPIFFEHelperConfigParams) ValidateFileNames() error {
+	var fileNames []string
+	for _, fileName := range []struct {
+		value, fallback string
+	}{
+		{p.SVIDFileName, SPIFFEHelperSVIDFileName},
+		{p.SVIDKeyFileName, SPIFFEHelperSVIDKeyFileName},
+		{p.SVIDBundleFileName, SPIFFEHelperSVIDBundleFileName},
+	} {
+		name := fileName.value
+		if name == "" {
+			name = fileName.fallback
+		}
+		if !fileNameRegexp.MatchString(name) || name == "." || strings.HasPrefix(name, "..") {
+			return fmt.Errorf("invalid file name %q: must consist of letters, digits, '.', '_' and '-', and not start with ..", name)
+		}
+		fileNames = append(fileNames, name)
+	}
+	for i := range p.JWTAudiences {
+		fileNames = append(fileNames, JWTSVIDFileName(i, len(p.JWTAudiences)))
+	}
+
+	for i, name := range fileNames {
+		if slices.Contains(fileNames[:i], name) {
+			return fmt.Errorf("invalid file name %q: used for more than one file", name)
+		}
+	}
+	return nil
+}
+
+// ValidateCmd checks the command run by spiffe-helper. The arguments require a command, and the
+// renew signal must be one of RenewSignals.
+func (p *SPIFFEHelperConfigParams) ValidateCmd() error {
+	if p.CmdArgs != "" && p.Cmd == "" {
+		return fmt.Errorf("the command arguments require a command")
+	}
+	if p.RenewSignal != "" && !slices.Contains(RenewSignals, p.RenewSignal) {
+		return fmt.Errorf("invalid renew signal %q: allowed signals are %v", p.RenewSignal, RenewSignals)
+	}
+	return nil
+}
+
+// JWTSVIDFileName returns the name of the file that the JWT-SVID for the audience at index is
+// written to: SPIFFEHelperJWTSVIDFileName for a single audience, or a numbered file, eg
+// jwt_svid_2.token for the second, if there are several
+func JWTSVIDFileName(index, count int) string {
+	if count == 1 {
+		return SPIFFEHelperJWTSVIDFileName
+	}
+	ext := filepath.Ext(SPIFFEHelperJWTSVIDFileName)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(SPIFFEHelperJWTSVIDFileName, ext), index+1, ext)
+}
+
+// GetSidecarResources returns the resources for the spiffe-helper sidecar, parsed from explicit
+// resources in either of the formats accepted by workload.ParseResources, or
+// DefaultSidecarResources if none are set
+func GetSidecarResources(explicit string) (corev1.ResourceRequirements, error) {
+	if explicit == "" {
+		return *DefaultSidecarResources.DeepCopy(), nil
+	}
+	resources, err := workload.ParseResources(explicit)
+	if err != nil {
+		return corev1.ResourceRequirements{}, fmt.Errorf("invalid spiffe-helper resources: %w", err)
+	}
+	return resources, nil
+}
+
+// NewSPIFFEHelper renders the spiffe-helper config for params, failing if rendering doesn't complete
+// by the time ctx is done
+func NewSPIFFEHelper(ctx context.Context, params SPIFFEHelperConfigParams) (*SPIFFEHelper, error) {
 	if params.AgentAddress == "" || params.CertPath == "" {
 		return nil, fmt.Errorf("missing spiffe-helper configuration parameters")
 	}
 
+	if err := params.ValidateFileNames(); err != nil {
+		return nil, err
+	}
+	if err := params.ValidateCmd(); err != nil {
+		return nil, err
+	}
+
+	params.setDefaults()
+
+	certDir := params.CertPath
+	if params.CertSymlinks {
+		certDir = filepath.Join(params.CertPath, SPIFFEHelperCertStagingDir)
+	}
+
 	spiffeHelperCfg := &SPIFFEHelperConfig{
-		CertDir:                  params.CertPath,
+		CertDir:                  certDir,
+		CertFileMode:             params.CertFileMode,
+		KeyFileMode:              params.KeyFileMode,
 		DaemonMode:               BoolPtr(true),
-		IncludeFederatedDomains:  true,
+		IncludeFederatedDomains:  !params.ExcludeFederatedDomains,
 		AgentAddress:             params.AgentAddress,
+		Cmd:                      params.Cmd,
+		CmdArgs:                  params.CmdArgs,
+		RenewSignal:              params.RenewSignal,
 		AddIntermediatesToBundle: params.IncludeIntermediateBundle,
-		SVIDFilename:             "tls.crt",
-		SVIDKeyFilename:          "tls.key",
-		SVIDBundleFilename:       "ca.pem",
-		HealthCheck: SPIFFEHelperHealthConfig{
+		SVIDFilename:             params.SVIDFileName,
+		SVIDKeyFilename:          params.SVIDKeyFileName,
+		SVIDBundleFilename:       params.SVIDBundleFileName,
+	}
+	certFiles := []string{params.SVIDFileName, params.SVIDKeyFileName, params.SVIDBundleFileName}
+	for i, audience := range params.JWTAudiences {
+		fileName := JWTSVIDFileName(i, len(params.JWTAudiences))
+		// The extra audiences are set, if empty, as spiffe-helper's HCL parser doesn't support null
+		spiffeHelperCfg.JWTSVIDs = append(spiffeHelperCfg.JWTSVIDs, SPIFFEHelperJWTConfig{
+			JWTAudience:       audience,
+			JWTExtraAudiences: []string{},
+			JWTSVIDFilename:   fileName,
+		})
+		certFiles = append(certFiles, fileName)
+	}
+	if len(params.JWTAudiences) > 0 {
+		// JWT-SVIDs are bearer tokens, so are as sensitive as the private key
+		spiffeHelperCfg.JWTSVIDFileMode = params.KeyFileMode
+	}
+	if !params.DisableHealthChecks {
+		spiffeHelperCfg.HealthCheck = &SPIFFEHelperHealthConfig{
 			ListenerEnabled: true,
-		},
+		}
+	}
+
+	spiffeHelper := &SPIFFEHelper{
+		certDir:      params.CertPath,
+		certVolume:   params.CertVolumeName,
+		certFile:     params.SVIDFileName,
+		bundleFile:   params.SVIDBundleFileName,
+		certDirMode:  params.CertDirMode,
+		certSymlinks: params.CertSymlinks,
+		certFiles:    certFiles,
+		image:        params.Image,
+		initImage:    params.InitImage,
+		initPull:     params.InitImagePullPolicy,
+		resources:    params.Resources,
+		healthChecks: !params.DisableHealthChecks,
+		configMemory: params.ConfigVolumeMemory,
+		preStopSleep: params.PreStopSleep,
 	}
 
-	// Marshal to an HCL-formatted string
-	hclFile := hclwrite.NewEmptyFile()
-	gohcl.EncodeIntoBody(spiffeHelperCfg, hclFile.Body())
-	hclBytes := hclFile.Bytes()
-	hclString := string(hclBytes)
+	var config []byte
+	spiffeHelper.configFile = SPIFFEHelperConfigFileName
+	switch params.ConfigFormat {
+	case "", SPIFFEHelperConfigFormatHCL:
+		// Marshal to an HCL-formatted string
+		hclFile := hclwrite.NewEmptyFile()
+		gohcl.EncodeIntoBody(spiffeHelperCfg, hclFile.Body())
+		config = hclFile.Bytes()
+
+	case SPIFFEHelperConfigFormatJSON:
+		jsonBytes, err := json.MarshalIndent(spiffeHelperCfg, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling spiffe-helper config to JSON: %w", err)
+		}
+		config = jsonBytes
+		spiffeHelper.configFile = SPIFFEHelperJSONConfigFileName
+
+	default:
+		return nil, fmt.Errorf("unsupported spiffe-helper config format %q", params.ConfigFormat)
+	}
+
+	// Bound the rendered config in the same way as the other injected configs
+	w := render.NewWriter(ctx)
+	if _, err := w.Write(config); err != nil {
+		return nil, fmt.Errorf("rendering of spiffe-helper config did not complete: %w", err)
+	}
+	spiffeHelper.Config = w.String()
+	return spiffeHelper, nil
+}
 
-	return &SPIFFEHelper{Config: hclString}, nil
+func (p *SPIFFEHelperConfigParams) setDefaults() {
+	if p.CertDirMode == 0 {
+		p.CertDirMode = DefaultCertDirMode
+	}
+	if p.CertFileMode == 0 {
+		p.CertFileMode = DefaultCertFileMode
+	}
+	if p.KeyFileMode == 0 {
+		p.KeyFileMode = DefaultKeyFileMode
+	}
+	if p.Image == "" {
+		p.Image = SPIFFEHelperImage
+	}
+	if p.InitImage == "" {
+		p.InitImage = InitHelperImage
+	}
+	if p.InitImagePullPolicy == "" {
+		p.InitImagePullPolicy = corev1.PullIfNotPresent
+	}
+	if p.SVIDFileName == "" {
+		p.SVIDFileName = SPIFFEHelperSVIDFileName
+	}
+	if p.SVIDKeyFileName == "" {
+		p.SVIDKeyFileName = SPIFFEHelperSVIDKeyFileName
+	}
+	if p.SVIDBundleFileName == "" {
+		p.SVIDBundleFileName = SPIFFEHelperSVIDBundleFileName
+	}
+	if p.CertVolumeName == "" {
+		p.CertVolumeName = constants.SPIFFEEnableCertVolumeName
+	}
 }
 
 func (h *SPIFFEHelper) GetConfigVolume() corev1.Volume {
+	emptyDir := &corev1.EmptyDirVolumeSource{}
+	if h.configMemory {
+		emptyDir.Medium = corev1.StorageMediumMemory
+	}
 	return corev1.Volume{
 		Name:         SPIFFEHelperConfigVolumeName,
-		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		VolumeSource: corev1.VolumeSource{EmptyDir: emptyDir},
 	}
 }
 
-func (h *SPIFFEHelper) GetSidecarContainer() corev1.Container {
-	// Required in order for this sidecar to be native
-	var restartPolicyAlways = corev1.ContainerRestartPolicyAlways
+// GetSidecarContainer returns the spiffe-helper sidecar container. A native sidecar
+// must be injected as an init container; otherwise it is a regular container.
+func (h *SPIFFEHelper) GetSidecarContainer(native bool) corev1.Container {
+	var restartPolicy *corev1.ContainerRestartPolicy
+	if native {
+		// Required in order for this sidecar to be native
+		restartPolicy = ptr.To(corev1.ContainerRestartPolicyAlways)
+	}
 
-	return corev1.Container{
+	container := corev1.Container{
 		Name:            SPIFFEHelperSidecarContainerName,
-		Image:           SPIFFEHelperImage,
+		Image:           h.image,
 		ImagePullPolicy: corev1.PullIfNotPresent,
-		RestartPolicy:   &restartPolicyAlways,
-		Args:            []string{"-config", filepath.Join(SPIFFEHelperConfigMountPath, SPIFFEHelperConfigFileName)},
+		RestartPolicy:   restartPolicy,
+		Args:            []string{"-config", filepath.Join(SPIFFEHelperConfigMountPath, h.configFile)},
+		Resources:       *h.resources.DeepCopy(),
 		StartupProbe: &corev1.Probe{
 			ProbeHandler: corev1.ProbeHandler{
 				HTTPGet: &corev1.HTTPGetAction{
@@ -175,28 +527,80 @@ func (h *SPIFFEHelper) GetSidecarContainer() corev1.Container {
 				ReadOnly:  true,
 			},
 			{
-				Name:      constants.SPIFFEEnableCertVolumeName,
+				Name:      h.certVolume,
 				MountPath: constants.SPIFFEEnableCertDirectory,
 			},
 			workload.GetSPIFFEVolumeMount(),
 		},
 	}
+
+	// The probes use the health check listener. The spiffe-helper image has no shell for exec
+	// probes, so without the listener the sidecar is not probed.
+	if !h.healthChecks {
+		container.StartupProbe = nil
+		container.LivenessProbe = nil
+		container.ReadinessProbe = nil
+	}
+
+	if h.preStopSleep > 0 {
+		container.Lifecycle = &corev1.Lifecycle{
+			PreStop: &corev1.LifecycleHandler{
+				Sleep: &corev1.SleepAction{Seconds: int64((h.preStopSleep + time.Second - 1) / time.Second)},
+			},
+		}
+	}
+
+	return container
+}
+
+// GetCABundleVolumeMount returns a read-only mount of the trust bundle written by spiffe-helper
+// (ca.pem by default) as a single file at mountPath, eg in an application's CA directory. The mount uses a
+// subPath, which is bound when the container starts and doesn't follow the file being replaced, so
+// a rotated bundle is only seen after the container restarts.
+func (h *SPIFFEHelper) GetCABundleVolumeMount(mountPath string) corev1.VolumeMount {
+	subPath := h.bundleFile
+	if h.certSymlinks {
+		// Mount the file that spiffe-helper writes, as the published versions are removed once
+		// they are replaced
+		subPath = filepath.Join(SPIFFEHelperCertStagingDir, h.bundleFile)
+	}
+	return corev1.VolumeMount{
+		Name:      h.certVolume,
+		MountPath: mountPath,
+		SubPath:   subPath,
+		ReadOnly:  true,
+	}
 }
 
 func (h *SPIFFEHelper) GetInitContainer() corev1.Container {
-	configFilePath := filepath.Join(SPIFFEHelperConfigMountPath, SPIFFEHelperConfigFileName)
+	configFilePath := filepath.Join(SPIFFEHelperConfigMountPath, h.configFile)
 	writeCmd := fmt.Sprintf("mkdir -p %s && printf %%s \"$${%s}\" > %s && echo -e \"\\n=== SPIFFE Helper Config ===\" && cat %s && echo -e \"\\n===========================\"",
 		filepath.Dir(configFilePath),
 		SPIFFEHelperConfigContentEnvVar,
 		configFilePath,
 		configFilePath)
 
+	// Restrict access to the cert directory; the file permissions are set by spiffe-helper
+	certCmd := fmt.Sprintf("chmod %o %s", h.certDirMode, h.certDir)
+
+	// With cert symlinks, spiffe-helper writes into a staging directory, and each file in the cert
+	// directory is a symlink through the ..data symlink, which the cert publisher points at each
+	// new version; see GetCertPublisherContainer
+	if h.certSymlinks {
+		stagingDir := filepath.Join(h.certDir, SPIFFEHelperCertStagingDir)
+		certCmd = fmt.Sprintf("%s && mkdir -p %s && chmod %o %s", certCmd, stagingDir, h.certDirMode, stagingDir)
+		for _, file := range h.certFiles {
+			certCmd = fmt.Sprintf("%s && ln -sfn %s %s",
+				certCmd, filepath.Join(SPIFFEHelperCertDataDir, file), filepath.Join(h.certDir, file))
+		}
+	}
+
 	return corev1.Container{
 		Name:            SPIFFEHelperInitContainerName,
-		Image:           InitHelperImage,
-		ImagePullPolicy: corev1.PullIfNotPresent,
+		Image:           h.initImage,
+		ImagePullPolicy: h.initPull,
 		Command:         []string{"/bin/sh", "-c"},
-		Args:            []string{writeCmd},
+		Args:            []string{fmt.Sprintf("%s && %s", writeCmd, certCmd)},
 		Env: []corev1.EnvVar{{
 			Name:  SPIFFEHelperConfigContentEnvVar,
 			Value: h.Config,
@@ -206,7 +610,89 @@ func (h *SPIFFEHelper) GetInitContainer() corev1.Container {
 				Name: SPIFFEHelperConfigVolumeName, MountPath: filepath.Dir(configFilePath),
 			},
 			{
-				Name: constants.SPIFFEEnableCertVolumeName, MountPath: constants.SPIFFEEnableCertDirectory,
+				Name: h.certVolume, MountPath: constants.SPIFFEEnableCertDirectory,
+			},
+		},
+	}
+}
+
+// GetWaitForCertContainer returns an init container that blocks until spiffe-helper has written
+// the first X.509-SVID to the cert directory, so that the application containers don't start
+// without it. It must follow the spiffe-helper native sidecar in the pod's init containers, as
+// init containers before the sidecar run before it starts.
+func (h *SPIFFEHelper) GetWaitForCertContainer() corev1.Container {
+	certFilePath := filepath.Join(h.certDir, h.certFile)
+	waitCmd := fmt.Sprintf("until [ -f %s ]; do echo \"Waiting for %s...\"; sleep 1; done && echo \"%s is ready.\"",
+		certFilePath, certFilePath, certFilePath)
+
+	return corev1.Container{
+		Name:            SPIFFEHelperWaitContainerName,
+		Image:           h.initImage,
+		ImagePullPolicy: h.initPull,
+		Command:         []string{"/bin/sh", "-c"},
+		Args:            []string{waitCmd},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name: h.certVolume, MountPath: constants.SPIFFEEnableCertDirectory, ReadOnly: true,
+			},
+		},
+	}
+}
+
+// GetCertPublisherContainer returns the sidecar that publishes the files written by spiffe-helper
+// when cert symlinks are enabled, using the same layout as the kubelet does for ConfigMap volumes.
+// spiffe-helper rewrites the files in its staging directory in place, one at a time, so they are
+// only published once they have been unchanged for a whole interval, ie once spiffe-helper has
+// finished writing them. Each version is copied to its own timestamped directory, and the ..data
+// symlink is then replaced with one to the new directory by a rename, which is atomic. An
+// application that resolves the stable symlinks in the cert directory therefore sees either the
+// previous version of every file or the new one, never a new cert with an old key. The previous
+// version's directory is removed once it has been replaced.
+//
+// The publisher copies the private key, so must run as a user that can read the files written by
+// spiffe-helper. It is a native sidecar if spiffe-helper is.
+func (h *SPIFFEHelper) GetCertPublisherContainer(native bool) corev1.Container {
+	staged := make([]string, 0, len(h.certFiles))
+	for _, file := range h.certFiles {
+		staged = append(staged, filepath.Join(SPIFFEHelperCertStagingDir, file))
+	}
+	stagedFiles := strings.Join(staged, " ")
+	versionTmp := SPIFFEHelperCertDataDir + "_tmp"
+
+	publishCmd := strings.Join([]string{
+		fmt.Sprintf("cd %s || exit 1", h.certDir),
+		`previous=""; published=""; version=0`,
+		"while true; do",
+		fmt.Sprintf(`  if current=$(cksum %s 2>/dev/null) && [ "$current" = "$previous" ] && [ "$current" != "$published" ]; then`, stagedFiles),
+		"    version=$((version + 1))",
+		`    dir="..$(date +%Y_%m_%d_%H_%M_%S).$version"`,
+		fmt.Sprintf(`    if mkdir -m %o "$dir" && cp -p %s "$dir"/ && ln -sfn "$dir" %s && mv -fT %s %s; then`,
+			h.certDirMode, stagedFiles, versionTmp, versionTmp, SPIFFEHelperCertDataDir),
+		`      published="$current"; echo "Published $dir"`,
+		`      for old in ..[0-9]*; do [ "$old" = "$dir" ] || rm -rf "$old"; done`,
+		"    fi",
+		"  fi",
+		`  previous="$current"`,
+		fmt.Sprintf("  sleep %d", SPIFFEHelperCertPublishInterval),
+		"done",
+	}, "\n")
+
+	var restartPolicy *corev1.ContainerRestartPolicy
+	if native {
+		restartPolicy = ptr.To(corev1.ContainerRestartPolicyAlways)
+	}
+
+	return corev1.Container{
+		Name:            SPIFFEHelperCertPublisherContainerName,
+		Image:           h.initImage,
+		ImagePullPolicy: h.initPull,
+		RestartPolicy:   restartPolicy,
+		Command:         []string{"/bin/sh", "-c"},
+		Args:            []string{publishCmd},
+		Resources:       *DefaultSidecarResources.DeepCopy(),
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name: h.certVolume, MountPath: constants.SPIFFEEnableCertDirectory,
 			},
 		},
 	}
@@ -214,6 +700,27 @@ func (h *SPIFFEHelper) GetInitContainer() corev1.Container {
 
 type SPIFFEHelper struct {
 	Config string
+	// configFile is the name of the config file, which matches its format
+	configFile   string
+	certDir      string
+	certVolume   string
+	certFile     string
+	bundleFile   string
+	certDirMode  int
+	certSymlinks bool
+	certFiles    []string
+	image        string
+	initImage    string
+	initPull     corev1.PullPolicy
+	resources    corev1.ResourceRequirements
+	healthChecks bool
+	configMemory bool
+	preStopSleep time.Duration
+}
+
+// ConfigFileName returns the name of the config file, which depends on the config format
+func (h *SPIFFEHelper) ConfigFileName() string {
+	return h.configFile
 }
 
 func BoolPtr(b bool) *bool {
@@ -1,11 +1,20 @@
 package helper
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
 	"testing"
+	"time"
 
+	constants "github.com/cofide/spiffe-enable/internal/const"
 	"github.com/hashicorp/hcl/v2/hclsimple"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
 )
 
 func TestNewSPIFFEHelper(t *testing.T) {
@@ -32,6 +41,77 @@ func TestNewSPIFFEHelper(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name: "without federated domains",
+			params: SPIFFEHelperConfigParams{
+				AgentAddress:            "/tmp/agent.sock",
+				CertPath:                "/mnt/certs",
+				ExcludeFederatedDomains: true,
+			},
+			expectError: false,
+		},
+		{
+			name: "with custom file names",
+			params: SPIFFEHelperConfigParams{
+				AgentAddress:       "/tmp/agent.sock",
+				CertPath:           "/mnt/certs",
+				SVIDFileName:       "server.crt",
+				SVIDKeyFileName:    "server.key",
+				SVIDBundleFileName: "ca-bundle.pem",
+			},
+			expectError: false,
+		},
+		{
+			name: "file name with a path",
+			params: SPIFFEHelperConfigParams{
+				AgentAddress: "/tmp/agent.sock",
+				CertPath:     "/mnt/certs",
+				SVIDFileName: "../server.crt",
+			},
+			expectError:               true,
+			expectedErrorMsgSubstring: "invalid file name",
+		},
+		{
+			name: "file name reserved for the data directory",
+			params: SPIFFEHelperConfigParams{
+				AgentAddress:    "/tmp/agent.sock",
+				CertPath:        "/mnt/certs",
+				SVIDKeyFileName: SPIFFEHelperCertDataDir,
+			},
+			expectError:               true,
+			expectedErrorMsgSubstring: "invalid file name",
+		},
+		{
+			name: "file name with shell characters",
+			params: SPIFFEHelperConfigParams{
+				AgentAddress:       "/tmp/agent.sock",
+				CertPath:           "/mnt/certs",
+				SVIDBundleFileName: "ca.pem;reboot",
+			},
+			expectError:               true,
+			expectedErrorMsgSubstring: "invalid file name",
+		},
+		{
+			name: "duplicate file names",
+			params: SPIFFEHelperConfigParams{
+				AgentAddress:    "/tmp/agent.sock",
+				CertPath:        "/mnt/certs",
+				SVIDKeyFileName: SPIFFEHelperSVIDFileName,
+			},
+			expectError:               true,
+			expectedErrorMsgSubstring: "used for more than one file",
+		},
+		{
+			name: "file name of a JWT-SVID",
+			params: SPIFFEHelperConfigParams{
+				AgentAddress: "/tmp/agent.sock",
+				CertPath:     "/mnt/certs",
+				SVIDFileName: SPIFFEHelperJWTSVIDFileName,
+				JWTAudiences: []string{"example"},
+			},
+			expectError:               true,
+			expectedErrorMsgSubstring: "used for more than one file",
+		},
 		{
 			name: "empty params", // Check defaults or expected behavior for empty strings
 			params: SPIFFEHelperConfigParams{
@@ -44,7 +124,7 @@ func TestNewSPIFFEHelper(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			helper, err := NewSPIFFEHelper(tt.params)
+			helper, err := NewSPIFFEHelper(context.Background(), tt.params)
 
 			if tt.expectError {
 				require.Error(t, err)
@@ -56,7 +136,15 @@ func TestNewSPIFFEHelper(t *testing.T) {
 
 			require.NoError(t, err)
 			require.NotNil(t, helper)
-			require.NotEmpty(t, helper.Config)
+
+			// Check the rendered config itself, as well as the decoded values below
+			assert.Regexp(t, `(?m)^agent_address\s+= "`+regexp.QuoteMeta(tt.params.AgentAddress)+`"$`, helper.Config)
+			intermediatesPattern := `(?m)^add_intermediates_to_bundle\s+= true$`
+			if tt.params.IncludeIntermediateBundle {
+				assert.Regexp(t, intermediatesPattern, helper.Config)
+			} else {
+				assert.NotRegexp(t, intermediatesPattern, helper.Config)
+			}
 
 			// Parse the generated HCL string back into the SPIFFEHelperConfig struct
 			var decodedCfg SPIFFEHelperConfig
@@ -72,13 +160,520 @@ func TestNewSPIFFEHelper(t *testing.T) {
 			// --- Assertions for default values set by NewSPIFFEHelper ---
 			require.NotNil(t, decodedCfg.DaemonMode)
 			assert.True(t, *decodedCfg.DaemonMode)
-			assert.True(t, decodedCfg.IncludeFederatedDomains)
+			assert.Equal(t, !tt.params.ExcludeFederatedDomains, decodedCfg.IncludeFederatedDomains)
+			assert.Contains(t, helper.Config,
+				fmt.Sprintf("include_federated_domains   = %t", !tt.params.ExcludeFederatedDomains))
 
-			assert.Equal(t, "tls.crt", decodedCfg.SVIDFilename)
-			assert.Equal(t, "tls.key", decodedCfg.SVIDKeyFilename)
-			assert.Equal(t, "ca.pem", decodedCfg.SVIDBundleFilename)
+			// The file names fall back to the defaults if unset
+			expectedFileNames := []string{"tls.crt", "tls.key", "ca.pem"}
+			for i, fileName := range []string{tt.params.SVIDFileName, tt.params.SVIDKeyFileName, tt.params.SVIDBundleFileName} {
+				if fileName != "" {
+					expectedFileNames[i] = fileName
+				}
+			}
+			assert.Equal(t, expectedFileNames,
+				[]string{decodedCfg.SVIDFilename, decodedCfg.SVIDKeyFilename, decodedCfg.SVIDBundleFilename})
 
+			require.NotNil(t, decodedCfg.HealthCheck)
 			assert.True(t, decodedCfg.HealthCheck.ListenerEnabled)
+
+			assert.Equal(t, DefaultCertFileMode, decodedCfg.CertFileMode)
+			assert.Equal(t, DefaultKeyFileMode, decodedCfg.KeyFileMode)
+		})
+	}
+}
+
+func TestNewSPIFFEHelper_ConfigFormats(t *testing.T) {
+	params := SPIFFEHelperConfigParams{
+		AgentAddress:              "/tmp/agent.sock",
+		CertPath:                  "/mnt/certs",
+		IncludeIntermediateBundle: true,
+	}
+
+	decoded := make(map[string]SPIFFEHelperConfig)
+	for _, format := range []string{"", SPIFFEHelperConfigFormatHCL, SPIFFEHelperConfigFormatJSON} {
+		params.ConfigFormat = format
+		helper, err := NewSPIFFEHelper(context.Background(), params)
+		require.NoError(t, err)
+
+		// The HCL decoder selects the syntax based on the file extension
+		filename := "config.hcl"
+		expectedFileName := SPIFFEHelperConfigFileName
+		if format == SPIFFEHelperConfigFormatJSON {
+			filename = "config.json"
+			expectedFileName = SPIFFEHelperJSONConfigFileName
+			assert.True(t, json.Valid([]byte(helper.Config)), "config is not valid JSON: %s", helper.Config)
+		}
+
+		// The config file is named for its format, both where it is written and where it is read
+		configFilePath := filepath.Join(SPIFFEHelperConfigMountPath, expectedFileName)
+		assert.Equal(t, expectedFileName, helper.ConfigFileName())
+		assert.Equal(t, []string{"-config", configFilePath}, helper.GetSidecarContainer(true).Args)
+		assert.Contains(t, helper.GetInitContainer().Args[0], "> "+configFilePath)
+
+		var decodedCfg SPIFFEHelperConfig
+		err = hclsimple.Decode(filename, []byte(helper.Config), nil, &decodedCfg)
+		require.NoError(t, err, "Failed to decode generated %q config: %s", format, helper.Config)
+		decoded[format] = decodedCfg
+	}
+
+	// The default format is HCL, and both formats describe the same config
+	assert.Equal(t, decoded[SPIFFEHelperConfigFormatHCL], decoded[""])
+	assert.Equal(t, decoded[SPIFFEHelperConfigFormatHCL], decoded[SPIFFEHelperConfigFormatJSON])
+	assert.Equal(t, "/tmp/agent.sock", decoded[SPIFFEHelperConfigFormatJSON].AgentAddress)
+	assert.True(t, decoded[SPIFFEHelperConfigFormatJSON].AddIntermediatesToBundle)
+
+	params.ConfigFormat = "yaml"
+	_, err := NewSPIFFEHelper(context.Background(), params)
+	require.Error(t, err)
+}
+
+func TestNewSPIFFEHelper_RenderTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewSPIFFEHelper(ctx, SPIFFEHelperConfigParams{
+		AgentAddress: "/tmp/agent.sock",
+		CertPath:     "/mnt/certs",
+	})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSPIFFEHelper_GetInitContainer_Permissions(t *testing.T) {
+	tests := []struct {
+		name             string
+		params           SPIFFEHelperConfigParams
+		expectedChmod    string
+		expectedCertMode int
+		expectedKeyMode  int
+	}{
+		{
+			name: "default permissions",
+			params: SPIFFEHelperConfigParams{
+				AgentAddress: "/tmp/agent.sock",
+				CertPath:     "/mnt/certs",
+			},
+			expectedChmod:    "chmod 755 /mnt/certs",
+			expectedCertMode: 0o644,
+			expectedKeyMode:  0o600,
+		},
+		{
+			name: "custom permissions",
+			params: SPIFFEHelperConfigParams{
+				AgentAddress: "/tmp/agent.sock",
+				CertPath:     "/mnt/certs",
+				CertDirMode:  0o700,
+				CertFileMode: 0o640,
+				KeyFileMode:  0o400,
+			},
+			expectedChmod:    "chmod 700 /mnt/certs",
+			expectedCertMode: 0o640,
+			expectedKeyMode:  0o400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper, err := NewSPIFFEHelper(context.Background(), tt.params)
+			require.NoError(t, err)
+
+			initContainer := helper.GetInitContainer()
+			require.Len(t, initContainer.Args, 1)
+			assert.Contains(t, initContainer.Args[0], tt.expectedChmod)
+
+			var decodedCfg SPIFFEHelperConfig
+			require.NoError(t, hclsimple.Decode("config.hcl", []byte(helper.Config), nil, &decodedCfg))
+			assert.Equal(t, tt.expectedCertMode, decodedCfg.CertFileMode)
+			assert.Equal(t, tt.expectedKeyMode, decodedCfg.KeyFileMode)
 		})
 	}
 }
+
+func TestNewSPIFFEHelper_Cmd(t *testing.T) {
+	tests := []struct {
+		name        string
+		cmd         string
+		cmdArgs     string
+		renewSignal string
+		expectError bool
+	}{
+		{name: "default"},
+		{name: "SIGHUP reload", cmd: "/usr/bin/reloader", cmdArgs: "--pid-file /run/app.pid", renewSignal: "SIGHUP"},
+		{name: "command without signal", cmd: "/usr/bin/reloader"},
+		{name: "arguments without command", cmdArgs: "--verbose", expectError: true},
+		{name: "unknown signal", cmd: "/usr/bin/reloader", renewSignal: "SIGFOO", expectError: true},
+		{name: "signal number", cmd: "/usr/bin/reloader", renewSignal: "1", expectError: true},
+		{name: "lowercase signal", cmd: "/usr/bin/reloader", renewSignal: "sighup", expectError: true},
+		{name: "SIGKILL", cmd: "/usr/bin/reloader", renewSignal: "SIGKILL", expectError: true},
+		{name: "SIGSTOP", cmd: "/usr/bin/reloader", renewSignal: "SIGSTOP", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper, err := NewSPIFFEHelper(context.Background(), SPIFFEHelperConfigParams{
+				AgentAddress: "/tmp/agent.sock",
+				CertPath:     "/mnt/certs",
+				Cmd:          tt.cmd,
+				CmdArgs:      tt.cmdArgs,
+				RenewSignal:  tt.renewSignal,
+			})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var decodedCfg SPIFFEHelperConfig
+			require.NoError(t, hclsimple.Decode("config.hcl", []byte(helper.Config), nil, &decodedCfg))
+			assert.Equal(t, tt.cmd, decodedCfg.Cmd)
+			assert.Equal(t, tt.cmdArgs, decodedCfg.CmdArgs)
+			assert.Equal(t, tt.renewSignal, decodedCfg.RenewSignal)
+		})
+	}
+}
+
+func TestParseFileMode(t *testing.T) {
+	for value, expected := range map[string]int{"0600": 0o600, "600": 0o600, "0755": 0o755, "777": 0o777} {
+		mode, err := ParseFileMode(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, mode, value)
+	}
+
+	for _, value := range []string{"", "0", "0800", "1777", "rw-------", "-600"} {
+		_, err := ParseFileMode(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestNewSPIFFEHelper_JWTAudiences(t *testing.T) {
+	tests := []struct {
+		name      string
+		audiences []string
+		expected  []SPIFFEHelperJWTConfig
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:      "one",
+			audiences: []string{"api"},
+			expected:  []SPIFFEHelperJWTConfig{{JWTAudience: "api", JWTExtraAudiences: []string{}, JWTSVIDFilename: "jwt_svid.token"}},
+		},
+		{
+			name:      "multiple",
+			audiences: []string{"api", "spiffe://example.org/db"},
+			expected: []SPIFFEHelperJWTConfig{
+				{JWTAudience: "api", JWTExtraAudiences: []string{}, JWTSVIDFilename: "jwt_svid_1.token"},
+				{JWTAudience: "spiffe://example.org/db", JWTExtraAudiences: []string{}, JWTSVIDFilename: "jwt_svid_2.token"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, format := range SPIFFEHelperConfigFormats {
+				helper, err := NewSPIFFEHelper(context.Background(), SPIFFEHelperConfigParams{
+					AgentAddress: "/tmp/agent.sock",
+					CertPath:     "/mnt/certs",
+					ConfigFormat: format,
+					KeyFileMode:  0o640,
+					CertSymlinks: true,
+					JWTAudiences: tt.audiences,
+				})
+				require.NoError(t, err)
+
+				var decoded SPIFFEHelperConfig
+				require.NoError(t, hclsimple.Decode("config."+format, []byte(helper.Config), nil, &decoded))
+				assert.Equal(t, tt.expected, decoded.JWTSVIDs, format)
+
+				initArgs := helper.GetInitContainer().Args[0]
+				if len(tt.expected) == 0 {
+					assert.NotContains(t, helper.Config, "jwt_svids", format)
+					assert.NotContains(t, initArgs, "jwt_svid")
+					continue
+				}
+
+				// The JWT-SVIDs have the private key's permissions, and are symlinked like the certs
+				assert.Equal(t, 0o640, decoded.JWTSVIDFileMode, format)
+				for _, jwtSVID := range tt.expected {
+					assert.Contains(t, initArgs, fmt.Sprintf("ln -sfn ..data/%[1]s /mnt/certs/%[1]s", jwtSVID.JWTSVIDFilename))
+				}
+			}
+		})
+	}
+}
+
+func TestParseJWTAudiences(t *testing.T) {
+	audiences, err := ParseJWTAudiences(" api, spiffe://example.org/db ")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api", "spiffe://example.org/db"}, audiences)
+
+	for _, value := range []string{"", "api,", "api,,db", "api,api"} {
+		_, err := ParseJWTAudiences(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestGetSidecarResources(t *testing.T) {
+	resources, err := GetSidecarResources("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultSidecarResources, resources)
+
+	resources, err = GetSidecarResources("cpu=20m,limits.memory=64Mi")
+	require.NoError(t, err)
+	assert.Equal(t, "20m", resources.Requests.Cpu().String())
+	assert.Equal(t, "64Mi", resources.Limits.Memory().String())
+
+	resources, err = GetSidecarResources(`{"requests":{"memory":"16Mi"}}`)
+	require.NoError(t, err)
+	assert.Equal(t, "16Mi", resources.Requests.Memory().String())
+
+	for _, value := range []string{"cpu", "cpu=fast", "gpu=1", `{"requests":`} {
+		_, err := GetSidecarResources(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestSPIFFEHelper_GetSidecarContainer_Resources(t *testing.T) {
+	resources, err := GetSidecarResources("memory=32Mi,limits.memory=64Mi")
+	require.NoError(t, err)
+
+	h, err := NewSPIFFEHelper(context.Background(), SPIFFEHelperConfigParams{
+		AgentAddress: constants.SPIFFEWLSocketPath,
+		CertPath:     constants.SPIFFEEnableCertDirectory,
+		Resources:    resources,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, resources, h.GetSidecarContainer(false).Resources)
+}
+
+func TestSPIFFEHelper_GetInitContainer_CertSymlinks(t *testing.T) {
+	params := SPIFFEHelperConfigParams{
+		AgentAddress: "/tmp/agent.sock",
+		CertPath:     "/mnt/certs",
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		helper, err := NewSPIFFEHelper(context.Background(), params)
+		require.NoError(t, err)
+
+		initContainer := helper.GetInitContainer()
+		require.Len(t, initContainer.Args, 1)
+		assert.NotContains(t, initContainer.Args[0], "ln -sfn")
+
+		var decodedCfg SPIFFEHelperConfig
+		require.NoError(t, hclsimple.Decode("config.hcl", []byte(helper.Config), nil, &decodedCfg))
+		assert.Equal(t, "/mnt/certs", decodedCfg.CertDir)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		params := params
+		params.CertSymlinks = true
+		helper, err := NewSPIFFEHelper(context.Background(), params)
+		require.NoError(t, err)
+
+		initContainer := helper.GetInitContainer()
+		require.Len(t, initContainer.Args, 1)
+		assert.Contains(t, initContainer.Args[0], "mkdir -p /mnt/certs/..staging && chmod 755 /mnt/certs/..staging")
+		assert.Contains(t, initContainer.Args[0], "ln -sfn ..data/tls.crt /mnt/certs/tls.crt")
+		assert.Contains(t, initContainer.Args[0], "ln -sfn ..data/tls.key /mnt/certs/tls.key")
+		assert.Contains(t, initContainer.Args[0], "ln -sfn ..data/ca.pem /mnt/certs/ca.pem")
+
+		var decodedCfg SPIFFEHelperConfig
+		require.NoError(t, hclsimple.Decode("config.hcl", []byte(helper.Config), nil, &decodedCfg))
+		assert.Equal(t, "/mnt/certs/..staging", decodedCfg.CertDir)
+	})
+}
+
+func TestSPIFFEHelper_GetCertPublisherContainer(t *testing.T) {
+	helper, err := NewSPIFFEHelper(context.Background(), SPIFFEHelperConfigParams{
+		AgentAddress: "/tmp/agent.sock",
+		CertPath:     constants.SPIFFEEnableCertDirectory,
+		CertDirMode:  0o750,
+		CertSymlinks: true,
+		JWTAudiences: []string{"api"},
+	})
+	require.NoError(t, err)
+
+	for _, native := range []bool{false, true} {
+		t.Run(fmt.Sprintf("native=%t", native), func(t *testing.T) {
+			container := helper.GetCertPublisherContainer(native)
+			assert.Equal(t, SPIFFEHelperCertPublisherContainerName, container.Name)
+			assert.Equal(t, InitHelperImage, container.Image)
+			if native {
+				assert.Equal(t, ptr.To(corev1.ContainerRestartPolicyAlways), container.RestartPolicy)
+			} else {
+				assert.Nil(t, container.RestartPolicy)
+			}
+			assert.Equal(t, []corev1.VolumeMount{{
+				Name:      constants.SPIFFEEnableCertVolumeName,
+				MountPath: constants.SPIFFEEnableCertDirectory,
+			}}, container.VolumeMounts)
+
+			// Each stable set of files is copied to a new version directory, which ..data is then
+			// atomically repointed at
+			require.Len(t, container.Args, 1)
+			script := container.Args[0]
+			stagedFiles := "..staging/tls.crt ..staging/tls.key ..staging/ca.pem ..staging/jwt_svid.token"
+			assert.Contains(t, script, "cd "+constants.SPIFFEEnableCertDirectory)
+			assert.Contains(t, script, `current=$(cksum `+stagedFiles+` 2>/dev/null) && [ "$current" = "$previous" ]`)
+			assert.Contains(t, script, `mkdir -m 750 "$dir" && cp -p `+stagedFiles+` "$dir"/`)
+			assert.Contains(t, script, `ln -sfn "$dir" ..data_tmp && mv -fT ..data_tmp ..data`)
+		})
+	}
+}
+
+func TestNewSPIFFEHelper_HealthChecks(t *testing.T) {
+	tests := []struct {
+		name                string
+		disableHealthChecks bool
+	}{
+		{name: "current spiffe-helper"},
+		{name: "older spiffe-helper without health checks", disableHealthChecks: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper, err := NewSPIFFEHelper(context.Background(), SPIFFEHelperConfigParams{
+				AgentAddress:        "/tmp/agent.sock",
+				CertPath:            "/mnt/certs",
+				DisableHealthChecks: tt.disableHealthChecks,
+			})
+			require.NoError(t, err)
+
+			var decodedCfg SPIFFEHelperConfig
+			require.NoError(t, hclsimple.Decode("config.hcl", []byte(helper.Config), nil, &decodedCfg))
+
+			sidecar := helper.GetSidecarContainer(true)
+
+			if tt.disableHealthChecks {
+				assert.NotContains(t, helper.Config, "health_checks")
+				assert.Nil(t, decodedCfg.HealthCheck)
+				assert.Nil(t, sidecar.StartupProbe)
+				assert.Nil(t, sidecar.LivenessProbe)
+				assert.Nil(t, sidecar.ReadinessProbe)
+				return
+			}
+
+			require.NotNil(t, decodedCfg.HealthCheck)
+			assert.True(t, decodedCfg.HealthCheck.ListenerEnabled)
+			for _, probe := range []*corev1.Probe{sidecar.StartupProbe, sidecar.LivenessProbe, sidecar.ReadinessProbe} {
+				require.NotNil(t, probe)
+				require.NotNil(t, probe.HTTPGet)
+				assert.Equal(t, SPIFFEHelperHealthCheckPort, probe.HTTPGet.Port.IntValue())
+			}
+		})
+	}
+}
+
+func TestSPIFFEHelper_GetConfigVolume(t *testing.T) {
+	for _, memory := range []bool{false, true} {
+		t.Run(fmt.Sprintf("memory=%t", memory), func(t *testing.T) {
+			helper, err := NewSPIFFEHelper(context.Background(), SPIFFEHelperConfigParams{
+				AgentAddress:       "/tmp/agent.sock",
+				CertPath:           "/mnt/certs",
+				ConfigVolumeMemory: memory,
+			})
+			require.NoError(t, err)
+
+			volume := helper.GetConfigVolume()
+			assert.Equal(t, SPIFFEHelperConfigVolumeName, volume.Name)
+			require.NotNil(t, volume.EmptyDir)
+			if memory {
+				assert.Equal(t, corev1.StorageMediumMemory, volume.EmptyDir.Medium)
+			} else {
+				assert.Equal(t, corev1.StorageMediumDefault, volume.EmptyDir.Medium)
+			}
+		})
+	}
+}
+
+func TestSPIFFEHelper_GetSidecarContainer_PreStopSleep(t *testing.T) {
+	tests := []struct {
+		name            string
+		preStopSleep    time.Duration
+		expectedSeconds int64
+	}{
+		{name: "disabled"},
+		{name: "whole seconds", preStopSleep: 10 * time.Second, expectedSeconds: 10},
+		{name: "rounded up", preStopSleep: 1500 * time.Millisecond, expectedSeconds: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper, err := NewSPIFFEHelper(context.Background(), SPIFFEHelperConfigParams{
+				AgentAddress: "/tmp/agent.sock",
+				CertPath:     "/mnt/certs",
+				PreStopSleep: tt.preStopSleep,
+			})
+			require.NoError(t, err)
+
+			for _, native := range []bool{false, true} {
+				sidecar := helper.GetSidecarContainer(native)
+				if tt.preStopSleep == 0 {
+					assert.Nil(t, sidecar.Lifecycle)
+					continue
+				}
+				require.NotNil(t, sidecar.Lifecycle)
+				require.NotNil(t, sidecar.Lifecycle.PreStop)
+				require.NotNil(t, sidecar.Lifecycle.PreStop.Sleep)
+				assert.Equal(t, tt.expectedSeconds, sidecar.Lifecycle.PreStop.Sleep.Seconds)
+			}
+		})
+	}
+}
+
+func TestSPIFFEHelper_GetCABundleVolumeMount(t *testing.T) {
+	for _, symlinks := range []bool{false, true} {
+		t.Run(fmt.Sprintf("symlinks=%t", symlinks), func(t *testing.T) {
+			helper, err := NewSPIFFEHelper(context.Background(), SPIFFEHelperConfigParams{
+				AgentAddress: "/tmp/agent.sock",
+				CertPath:     "/mnt/certs",
+				CertSymlinks: symlinks,
+			})
+			require.NoError(t, err)
+
+			expectedSubPath := "ca.pem"
+			if symlinks {
+				expectedSubPath = "..staging/ca.pem"
+			}
+			assert.Equal(t, corev1.VolumeMount{
+				Name:      constants.SPIFFEEnableCertVolumeName,
+				MountPath: "/etc/ssl/certs/spiffe-ca.pem",
+				SubPath:   expectedSubPath,
+				ReadOnly:  true,
+			}, helper.GetCABundleVolumeMount("/etc/ssl/certs/spiffe-ca.pem"))
+		})
+	}
+
+	t.Run("custom bundle file name", func(t *testing.T) {
+		helper, err := NewSPIFFEHelper(context.Background(), SPIFFEHelperConfigParams{
+			AgentAddress:       "/tmp/agent.sock",
+			CertPath:           "/mnt/certs",
+			SVIDBundleFileName: "ca-bundle.pem",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "ca-bundle.pem", helper.GetCABundleVolumeMount("/etc/ssl/certs/spiffe-ca.pem").SubPath)
+	})
+}
+
+func TestSPIFFEHelper_GetWaitForCertContainer(t *testing.T) {
+	helper, err := NewSPIFFEHelper(context.Background(), SPIFFEHelperConfigParams{
+		AgentAddress: "/tmp/agent.sock",
+		CertPath:     constants.SPIFFEEnableCertDirectory,
+		SVIDFileName: "svid.pem",
+	})
+	require.NoError(t, err)
+
+	container := helper.GetWaitForCertContainer()
+	assert.Equal(t, SPIFFEHelperWaitContainerName, container.Name)
+	assert.Equal(t, InitHelperImage, container.Image)
+	require.Len(t, container.Args, 1)
+	assert.Contains(t, container.Args[0], "until [ -f "+constants.SPIFFEEnableCertDirectory+"/svid.pem ]")
+	assert.Equal(t, []corev1.VolumeMount{{
+		Name:      constants.SPIFFEEnableCertVolumeName,
+		MountPath: constants.SPIFFEEnableCertDirectory,
+		ReadOnly:  true,
+	}}, container.VolumeMounts)
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"sigs.k8s.io/yaml"
+)
+
+// namedListKeys are the keys of bootstrap lists whose entries are identified by their name, so
+// that base and generated entries are merged by name rather than the list being replaced
+var namedListKeys = map[string]bool{
+	"clusters":             true,
+	"listeners":            true,
+	"bootstrap_extensions": true,
+}
+
+// mergeBaseConfig merges the generated bootstrap config into an operator-provided base config in
+// JSON or YAML. Objects are merged recursively, with the generated values taking precedence, so
+// that the node, admin and xDS settings are always those generated. Clusters, listeners and
+// bootstrap extensions are merged by name, keeping the base entries that aren't generated; other
+// lists are replaced by the generated ones.
+func mergeBaseConfig(base []byte, generated map[string]interface{}) (map[string]interface{}, error) {
+	baseJSON, err := yaml.YAMLToJSON(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base config: %w", err)
+	}
+	var baseCfg map[string]interface{}
+	if err := json.Unmarshal(baseJSON, &baseCfg); err != nil || baseCfg == nil {
+		return nil, fmt.Errorf("invalid base config: must be an object")
+	}
+
+	// Round-trip the generated config so that both use the same types
+	generatedJSON, err := json.Marshal(generated)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling proxy config to JSON: %w", err)
+	}
+	var generatedCfg map[string]interface{}
+	if err := json.Unmarshal(generatedJSON, &generatedCfg); err != nil {
+		return nil, fmt.Errorf("error unmarshalling proxy config: %w", err)
+	}
+
+	merged := mergeObjects(baseCfg, generatedCfg)
+	if err := validateBootstrap(merged); err != nil {
+		return nil, fmt.Errorf("invalid merged config: %w", err)
+	}
+	return merged, nil
+}
+
+func mergeObjects(base, generated map[string]interface{}) map[string]interface{} {
+	for key, value := range generated {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			if baseValue, ok := base[key].(map[string]interface{}); ok {
+				base[key] = mergeObjects(baseValue, value)
+				continue
+			}
+		case []interface{}:
+			if baseValue, ok := base[key].([]interface{}); ok && namedListKeys[key] {
+				base[key] = mergeNamedLists(baseValue, value)
+				continue
+			}
+		}
+		base[key] = value
+	}
+	return base
+}
+
+// mergeNamedLists returns the base entries, with those named like a generated entry replaced by
+// it, followed by the remaining generated entries
+func mergeNamedLists(base, generated []interface{}) []interface{} {
+	generatedByName := make(map[string]interface{}, len(generated))
+	for _, entry := range generated {
+		if name := entryName(entry); name != "" {
+			generatedByName[name] = entry
+		}
+	}
+
+	merged := make([]interface{}, 0, len(base)+len(generated))
+	replaced := make(map[string]bool)
+	for _, entry := range base {
+		name := entryName(entry)
+		if generatedEntry, ok := generatedByName[name]; ok {
+			merged = append(merged, generatedEntry)
+			replaced[name] = true
+			continue
+		}
+		merged = append(merged, entry)
+	}
+	for _, entry := range generated {
+		if !replaced[entryName(entry)] {
+			merged = append(merged, entry)
+		}
+	}
+	return merged
+}
+
+func entryName(entry interface{}) string {
+	object, _ := entry.(map[string]interface{})
+	name, _ := object["name"].(string)
+	return name
+}
+
+// validateBootstrap checks that a bootstrap config has the node identity and the ADS config
+// and cluster that connect Envoy to the agent
+func validateBootstrap(cfg map[string]interface{}) error {
+	node, _ := cfg["node"].(map[string]interface{})
+	if id, _ := node["id"].(string); id == "" {
+		return fmt.Errorf("missing node.id")
+	}
+	if cluster, _ := node["cluster"].(string); cluster == "" {
+		return fmt.Errorf("missing node.cluster")
+	}
+
+	dynamicResources, _ := cfg["dynamic_resources"].(map[string]interface{})
+	if _, ok := dynamicResources["ads_config"].(map[string]interface{}); !ok {
+		return fmt.Errorf("missing dynamic_resources.ads_config")
+	}
+
+	staticResources, _ := cfg["static_resources"].(map[string]interface{})
+	clusters, _ := staticResources["clusters"].([]interface{})
+	for _, cluster := range clusters {
+		if entryName(cluster) == valueXDSCluster {
+			return nil
+		}
+	}
+	return fmt.Errorf("missing static_resources.clusters entry %s", valueXDSCluster)
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEnvoy_BaseConfig(t *testing.T) {
+	baseYAML := `
+node:
+  id: base-node
+  locality:
+    zone: zone-a
+admin:
+  access_log_path: /dev/null
+overload_manager:
+  refresh_interval: 0.25s
+static_resources:
+  clusters:
+  - name: telemetry
+    type: STRICT_DNS
+  - name: xds_cluster
+    type: STRICT_DNS
+`
+
+	envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{
+		NodeID:          "node",
+		ClusterName:     "cluster",
+		AgentXDSService: "agent.example.org",
+		AgentXDSPort:    18001,
+		BaseConfig:      []byte(baseYAML),
+	})
+	require.NoError(t, err)
+
+	var cfg map[string]interface{}
+	require.NoError(t, json.Unmarshal(envoy.Cfg, &cfg))
+
+	// The generated node settings take precedence, keeping the base's other settings
+	assert.Equal(t, map[string]interface{}{
+		"id":       "node",
+		"cluster":  "cluster",
+		"locality": map[string]interface{}{"zone": "zone-a"},
+	}, cfg["node"])
+
+	admin := cfg["admin"].(map[string]interface{})
+	assert.Equal(t, "/dev/null", admin["access_log_path"])
+	assert.Contains(t, admin, "address")
+
+	// Top-level settings that aren't generated are kept
+	assert.Equal(t, map[string]interface{}{"refresh_interval": "0.25s"}, cfg["overload_manager"])
+
+	// The generated xDS settings are merged in
+	dynamicResources := cfg["dynamic_resources"].(map[string]interface{})
+	assert.Contains(t, dynamicResources, "ads_config")
+
+	// Base clusters are kept in order, with the generated xDS cluster replacing the base's
+	clusters := cfg["static_resources"].(map[string]interface{})["clusters"].([]interface{})
+	var names []string
+	for _, cluster := range clusters {
+		names = append(names, cluster.(map[string]interface{})["name"].(string))
+	}
+	require.GreaterOrEqual(t, len(names), 2)
+	assert.Equal(t, []string{"telemetry", valueXDSCluster}, names[:2])
+	xdsCluster := clusters[1].(map[string]interface{})
+	assert.NotEqual(t, "STRICT_DNS", xdsCluster["type"])
+	assert.Contains(t, string(envoy.Cfg), "agent.example.org")
+}
+
+func TestNewEnvoy_BaseConfigJSON(t *testing.T) {
+	envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{
+		BaseConfig: []byte(`{"layered_runtime": {"layers": [{"name": "static", "static_layer": {}}]}}`),
+	})
+	require.NoError(t, err)
+
+	var cfg map[string]interface{}
+	require.NoError(t, json.Unmarshal(envoy.Cfg, &cfg))
+	assert.Contains(t, cfg, "layered_runtime")
+	assert.Contains(t, cfg, "dynamic_resources")
+}
+
+func TestNewEnvoy_InvalidBaseConfig(t *testing.T) {
+	for name, base := range map[string]string{
+		"invalid YAML":  "node: [",
+		"not an object": "- node",
+		"null":          "null",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewEnvoy(context.Background(), EnvoyConfigParams{BaseConfig: []byte(base)})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid base config")
+		})
+	}
+}
+
+func TestValidateBootstrap(t *testing.T) {
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"node":              map[string]interface{}{"id": "node", "cluster": "cluster"},
+			"dynamic_resources": map[string]interface{}{"ads_config": map[string]interface{}{}},
+			"static_resources": map[string]interface{}{
+				"clusters": []interface{}{map[string]interface{}{"name": valueXDSCluster}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		modify        func(cfg map[string]interface{})
+		expectedError string
+	}{
+		{
+			name:   "valid",
+			modify: func(map[string]interface{}) {},
+		},
+		{
+			name:          "missing node id",
+			modify:        func(cfg map[string]interface{}) { delete(cfg["node"].(map[string]interface{}), "id") },
+			expectedError: "missing node.id",
+		},
+		{
+			name:          "missing ADS config",
+			modify:        func(cfg map[string]interface{}) { cfg["dynamic_resources"] = "ads" },
+			expectedError: "missing dynamic_resources.ads_config",
+		},
+		{
+			name: "missing xDS cluster",
+			modify: func(cfg map[string]interface{}) {
+				cfg["static_resources"] = map[string]interface{}{"clusters": []interface{}{}}
+			},
+			expectedError: "missing static_resources.clusters entry xds_cluster",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(cfg)
+			err := validateBootstrap(cfg)
+			if tt.expectedError == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.expectedError)
+		})
+	}
+}
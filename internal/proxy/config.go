@@ -1,20 +1,29 @@
 package proxy
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"math"
+	"net"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	constants "github.com/cofide/spiffe-enable/internal/const"
 	"github.com/cofide/spiffe-enable/internal/helper"
+	"github.com/cofide/spiffe-enable/internal/render"
 	"github.com/cofide/spiffe-enable/internal/workload"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/ptr"
 )
 
-// Envoy-specific constants
+// Default Envoy image, which can be overridden with EnvoyConfigParams
 var (
 	IstioImage = "docker.io/istio/proxyv2:1.26.4"
 )
@@ -29,18 +38,48 @@ const (
 	EnvoyPort                    = 10000
 	EnvoyUID                     = 1337
 	DNSProxyPort                 = 15053
+	// EnvoyReadinessPort serves the readiness endpoint of Envoy's admin interface to probes, as the
+	// admin interface itself is only bound to loopback
+	EnvoyReadinessPort = 15021
+	EnvoyReadinessPath = "/ready"
+	// DefaultAdminAddress and DefaultAdminPort are where Envoy's admin interface listens by default
+	DefaultAdminAddress = "127.0.0.1"
+	DefaultAdminPort    = 9901
+	// EnvoyDrainSeconds is how long the sidecar's preStop hook waits after draining Envoy's
+	// listeners, so that the app's in-flight connections finish before Envoy is stopped
+	EnvoyDrainSeconds = 5
 )
 
 const (
-	keyAddress      = "address"
-	keyClusterName  = "cluster_name"
-	valueXDSCluster = "xds_cluster"
+	keyAddress              = "address"
+	keyClusterName          = "cluster_name"
+	valueXDSCluster         = "xds_cluster"
+	valueOriginalDstCluster = "original_dst_cluster"
+	valueDNSResolverCluster = "dns_resolver_cluster"
+	valueAdminCluster       = "envoy_admin"
+	valueSDSCluster         = "sds-grpc"
 )
 
 type NftablesParams struct {
 	EnvoyUID     int
 	EnvoyPort    int
+	AdminPort    int
 	DNSProxyPort int
+	DNSRedirect  bool
+	// DNSRedirectTCP also redirects TCP DNS requests, which the DNS proxy only handles if it has
+	// resolvers to forward them to
+	DNSRedirectTCP bool
+	ExtraCommands  string
+	// ExcludePorts are loopback destination ports that aren't redirected to Envoy
+	ExcludePorts []int
+	// ExcludeDestinationCIDRs are destination CIDRs whose traffic isn't redirected to Envoy
+	ExcludeDestinationCIDRs []string
+	// SocketWaitPath, if set, is a socket that the script waits for, for up to
+	// SocketWaitTimeoutSeconds, before applying the rules
+	SocketWaitPath           string
+	SocketWaitTimeoutSeconds int
+	// IPFamily limits the rules to one of IPFamilies; both families are redirected if empty
+	IPFamily string
 }
 
 const nftablesSetupScript = `
@@ -48,9 +87,24 @@ if ! command -v nft &> /dev/null; then
     echo "nftables (nft) is not installed"
     exit 1
 fi
+{{- if .SocketWaitPath}}
 
-# These nftables rules intercept DNS requests (UDP+TCP)
-# and redirect to a DNS proxy provided by Envoy
+# Wait for the SPIFFE Workload API socket, so that Envoy doesn't start before its identity
+# source is available
+waited=0
+until [ -S {{.SocketWaitPath}} ]; do
+    if [ "$waited" -ge {{.SocketWaitTimeoutSeconds}} ]; then
+        echo "Timed out after {{.SocketWaitTimeoutSeconds}}s waiting for the SPIFFE Workload API socket {{.SocketWaitPath}}"
+        exit 1
+    fi
+    sleep 1
+    waited=$((waited + 1))
+done
+echo "SPIFFE Workload API socket {{.SocketWaitPath}} is ready."
+{{- end}}
+
+# These nftables rules intercept DNS requests (UDP, and TCP if the
+# DNS proxy has resolvers) and redirect to a DNS proxy provided by Envoy
 cat <<EOF > /tmp/dns_redirect.nft
 table inet envoy_proxy {
 	chain envoy_output {
@@ -58,22 +112,50 @@ table inet envoy_proxy {
 
         # Skip Envoy's own traffic
         meta skuid == {{.EnvoyUID}} return
+{{- if .ExcludeDestinationCIDRs}}
+
+        # Skip traffic to excluded destinations
+{{- range .ExcludeDestinationCIDRs}}
+        {{nftFamily .}} daddr {{.}} return
+{{- end}}
+{{- end}}
+{{- if .DNSRedirect}}
 
         # DNS redirection
-        udp dport 53 counter redirect to :{{.DNSProxyPort}} comment "DNS UDP to Envoy"
-        tcp dport 53 counter redirect to :{{.DNSProxyPort}} comment "DNS TCP to Envoy"
+        {{.FamilyMatch}}udp dport 53 counter redirect to :{{.DNSProxyPort}} comment "DNS UDP to Envoy"
+{{- if .DNSRedirectTCP}}
+        {{.FamilyMatch}}tcp dport 53 counter redirect to :{{.DNSProxyPort}} comment "DNS TCP to Envoy"
+{{- end}}
+{{- end}}
 
         # Skip traffic already going to Envoy port
         tcp dport {{.EnvoyPort}} return
-        tcp dport 9901 return
+        tcp dport {{.AdminPort}} return
+{{- if .ExcludePorts}}
+
+        # Skip traffic to excluded ports
+{{- range .ExcludePorts}}
+        tcp dport {{.}} return
+{{- end}}
+{{- end}}
 
         # Redirect loopback TCP traffic (using tcp dport range to match all TCP)
+{{- if .IPv4}}
         ip daddr 127.0.0.1/8 tcp dport 1-65535 counter redirect to :{{.EnvoyPort}} comment "Loopback IPv4 to Envoy"
+{{- end}}
+{{- if .IPv6}}
         ip6 daddr ::1/128 tcp dport 1-65535 counter redirect to :{{.EnvoyPort}} comment "Loopback IPv6 to Envoy"
+{{- end}}
     }
 }
 EOF
 
+{{- if .ExtraCommands}}
+
+# Extra commands supplied by the pod, eg to load nft modules
+{{.ExtraCommands}}
+{{- end}}
+
 # Apply the nftables rules from the created file
 nft -f /tmp/dns_redirect.nft
 echo "nftables DNS redirection rules applied."
@@ -89,31 +171,610 @@ type EnvoyConfigParams struct {
 	AdminPort       uint32
 	AgentXDSService string
 	AgentXDSPort    uint32
+	// XDSInitialMetadata are headers sent on the xDS gRPC stream, eg an authentication token
+	XDSInitialMetadata []XDSHeader
+	// CircuitBreakers, if set, are applied to the static clusters
+	CircuitBreakers *CircuitBreakers
+	// Image is the Envoy sidecar image; defaults to IstioImage
+	Image string
+	// InitImage is the image for the init container, which must provide a shell and nft
+	InitImage string
+	// InitImagePullPolicy is the pull policy of the init container, independent of the sidecar's;
+	// defaults to IfNotPresent
+	InitImagePullPolicy corev1.PullPolicy
+	// Resources are the sidecar's resource requests and limits; see GetSidecarResources
+	Resources corev1.ResourceRequirements
+	// XDSHealthCheck, if set, enables active gRPC health checking of the xDS cluster. It is off
+	// by default as the xDS stream already detects a lost connection to the agent.
+	XDSHealthCheck *HealthCheck
+	// XDSKeepalive configures HTTP/2 keepalive pings on the xDS connection, so that a connection
+	// silently dropped by an intermediate load balancer is detected and re-established. Zero
+	// durations use the defaults.
+	XDSKeepalive Keepalive
+	// InitialFetchTimeout bounds how long Envoy waits for the initial clusters and listeners from
+	// the agent before starting without them, so a slow agent can't block startup indefinitely
+	InitialFetchTimeout time.Duration
+	// OriginalDst adds a static listener on the Envoy port that recovers the original destination
+	// of connections redirected by the nftables rules, and forwards them to it, so transparent
+	// interception works without the control plane pushing a listener. The control plane must
+	// then not push a listener on the same port.
+	OriginalDst bool
+	// UpstreamProtocol is the protocol used to forward connections to their original destination.
+	// It defaults to UpstreamProtocolTCP, and requires OriginalDst.
+	UpstreamProtocol string
+	// InitExtraCommands are shell commands run in the init container before the nftables rules are
+	// applied. This is an escape hatch for unusual node environments: the commands run as root with
+	// NET_ADMIN, so they must come from a trusted source.
+	InitExtraCommands string
+	// InitNetRaw adds the NET_RAW capability to the init container. The nftables rules only need
+	// NET_ADMIN, but extra commands may need NET_RAW, which PodSecurity's restricted and baseline
+	// profiles forbid.
+	InitNetRaw bool
+	// DNSProxy configures the listeners on the DNS proxy port that the nftables rules redirect DNS
+	// requests to. Unless DisableDNSRedirect is set, it defaults to a DNS proxy that forwards to the
+	// pod's nameservers, so that redirected requests are always answered.
+	DNSProxy *DNSProxy
+	// DisableDNSRedirect omits the nftables rules that redirect the pod's DNS requests to Envoy, eg
+	// for pods with their own DNS config. It can't be combined with DNSProxy.
+	DisableDNSRedirect bool
+	// JWTAuthn, if set, adds a listener that validates the JWT-SVIDs of incoming requests before
+	// forwarding them to the app
+	JWTAuthn *JWTAuthn
+	// ReadinessListener adds a listener on EnvoyReadinessPort that serves only the readiness
+	// endpoint of the admin interface, so that it can be probed by the kubelet
+	ReadinessListener bool
+	// StatsMatcher, if set, limits the stats that Envoy creates, reducing its memory use. By
+	// default, Envoy creates all stats.
+	StatsMatcher *StatsMatcher
+	// BootstrapExtensions are raw entries for the bootstrap's bootstrap_extensions list, eg for
+	// extensions built into a custom Envoy image. Each must have a name, and is otherwise passed
+	// through to Envoy unchecked.
+	BootstrapExtensions []map[string]interface{}
+	// BaseConfig, if set, is an operator-provided bootstrap config in JSON or YAML that the
+	// generated config is merged into, so that operators keep their baseline settings. The
+	// generated settings take precedence; see mergeBaseConfig.
+	BaseConfig []byte
+	// ConfigVolumeName is the name of the emptyDir volume holding the Envoy config. It defaults to
+	// EnvoyConfigVolumeName, and can be changed to avoid a volume of that name in the pod.
+	ConfigVolumeName string
+	// ConfigVolumeMemory backs the config volume with memory (tmpfs) rather than the node's disk
+	ConfigVolumeMemory bool
+	// Flavor adjusts the nftables rules to the proxy distribution; one of ProxyFlavors, defaulting
+	// to ProxyFlavorEnvoy
+	Flavor string
+	// ExcludeOutboundPorts are loopback destination ports that aren't redirected to Envoy, eg for
+	// a local metrics scraper or a sidecar that doesn't use SPIFFE. They add to those excluded by
+	// the flavor.
+	ExcludeOutboundPorts []int
+	// ExcludeDestinationCIDRs are destination CIDRs whose traffic, including DNS requests, isn't
+	// redirected to Envoy
+	ExcludeDestinationCIDRs []string
+	// IPFamily limits the nftables rules to one of IPFamilies, eg for IPv6-only nodes. It defaults
+	// to IPFamilyDual.
+	IPFamily string
+	// SocketWaitTimeout, if set, makes the init container wait up to this long for the SPIFFE
+	// Workload API socket before applying the nftables rules, failing if it doesn't appear. The
+	// init container then mounts the CSI volume. It is rounded up to whole seconds.
+	SocketWaitTimeout time.Duration
+	// WorkloadSocketPath is the path of the SPIFFE Workload API socket, which Envoy fetches its
+	// SVID and bundle from over SDS; defaults to constants.SPIFFEWLSocketPath
+	WorkloadSocketPath string
+	// InboundPort, if set, adds a listener on this port that terminates mTLS for incoming
+	// connections with the pod's X.509-SVID, requiring clients to present an SVID from the trust
+	// domain, and forwards the decrypted connections to ApplicationPort on loopback
+	InboundPort     uint32
+	ApplicationPort uint32
+	// Upstreams adds a cluster for each upstream that originates mTLS with the pod's X.509-SVID,
+	// validating the upstream's SPIFFE ID
+	Upstreams []Upstream
+}
+
+// DNSProxy configures Envoy's DNS proxy
+type DNSProxy struct {
+	// Resolvers are the IP addresses of the upstream DNS servers. If empty, the pod's resolv.conf
+	// is used for UDP requests, and TCP requests are not handled, so are left to go to the pod's
+	// nameservers directly.
+	Resolvers []string
+}
+
+func (d *DNSProxy) validate() error {
+	for _, resolver := range d.Resolvers {
+		if net.ParseIP(resolver) == nil {
+			return fmt.Errorf("invalid DNS resolver %q: must be an IP address", resolver)
+		}
+	}
+	return nil
+}
+
+// listeners returns a UDP listener with Envoy's DNS filter and, if there are resolvers, a TCP
+// listener that forwards to them, as the DNS filter only handles UDP
+func (d *DNSProxy) listeners() []interface{} {
+	clientConfig := map[string]interface{}{
+		"resolver_timeout":    "5s",
+		"max_pending_lookups": 256,
+	}
+	if len(d.Resolvers) > 0 {
+		resolvers := make([]interface{}, 0, len(d.Resolvers))
+		for _, resolver := range d.Resolvers {
+			resolvers = append(resolvers, map[string]interface{}{
+				"socket_address": map[string]interface{}{keyAddress: resolver, "port_value": 53},
+			})
+		}
+		clientConfig["typed_dns_resolver_config"] = map[string]interface{}{
+			"name": "envoy.network.dns_resolver.cares",
+			"typed_config": map[string]interface{}{
+				"@type":     "type.googleapis.com/envoy.extensions.network.dns_resolver.cares.v3.CaresDnsResolverConfig",
+				"resolvers": resolvers,
+			},
+		}
+	}
+
+	listeners := []interface{}{
+		map[string]interface{}{
+			"name":                "dns_udp_listener",
+			keyAddress:            dnsProxyAddress("UDP"),
+			"udp_listener_config": map[string]interface{}{},
+			"listener_filters": []interface{}{
+				map[string]interface{}{
+					"name": "envoy.filters.udp.dns_filter",
+					"typed_config": map[string]interface{}{
+						"@type":         "type.googleapis.com/envoy.extensions.filters.udp.dns_filter.v3.DnsFilterConfig",
+						"stat_prefix":   "dns",
+						"client_config": clientConfig,
+						"server_config": map[string]interface{}{"inline_dns_table": map[string]interface{}{}},
+					},
+				},
+			},
+		},
+	}
+
+	if len(d.Resolvers) > 0 {
+		listeners = append(listeners, map[string]interface{}{
+			"name":     "dns_tcp_listener",
+			keyAddress: dnsProxyAddress("TCP"),
+			"filter_chains": []interface{}{
+				map[string]interface{}{
+					"filters": []interface{}{
+						map[string]interface{}{
+							"name": "envoy.filters.network.tcp_proxy",
+							"typed_config": map[string]interface{}{
+								"@type":       "type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy",
+								"stat_prefix": "dns_tcp",
+								"cluster":     valueDNSResolverCluster,
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	return listeners
+}
+
+// cluster returns the cluster of upstream resolvers for TCP DNS requests, or nil if there are none
+func (d *DNSProxy) cluster() map[string]interface{} {
+	if len(d.Resolvers) == 0 {
+		return nil
+	}
+
+	endpoints := make([]interface{}, 0, len(d.Resolvers))
+	for _, resolver := range d.Resolvers {
+		endpoints = append(endpoints, map[string]interface{}{
+			"endpoint": map[string]interface{}{
+				keyAddress: map[string]interface{}{
+					"socket_address": map[string]interface{}{keyAddress: resolver, "port_value": 53},
+				},
+			},
+		})
+	}
+
+	return map[string]interface{}{
+		"name":            valueDNSResolverCluster,
+		"type":            "STATIC",
+		"connect_timeout": "5s",
+		"load_assignment": map[string]interface{}{
+			keyClusterName: valueDNSResolverCluster,
+			"endpoints": []interface{}{
+				map[string]interface{}{"lb_endpoints": endpoints},
+			},
+		},
+	}
+}
+
+// dnsProxyAddress returns the address of the DNS proxy port for protocol, on both the IPv4 and
+// IPv6 loopback addresses that DNS requests are redirected from
+func dnsProxyAddress(protocol string) map[string]interface{} {
+	return map[string]interface{}{
+		"socket_address": map[string]interface{}{
+			"protocol":    protocol,
+			keyAddress:    "::",
+			"port_value":  DNSProxyPort,
+			"ipv4_compat": true,
+		},
+	}
+}
+
+// Upstream protocols for the original destination cluster
+const (
+	UpstreamProtocolAuto = "auto"
+	UpstreamProtocolH1   = "h1"
+	UpstreamProtocolH2   = "h2"
+	UpstreamProtocolTCP  = "tcp"
+)
+
+var UpstreamProtocols = []string{UpstreamProtocolAuto, UpstreamProtocolH1, UpstreamProtocolH2, UpstreamProtocolTCP}
+
+// DefaultInitialFetchTimeout matches Envoy's own default
+const DefaultInitialFetchTimeout = 15 * time.Second
+
+// Defaults for an active health check
+const (
+	DefaultHealthCheckInterval = 10 * time.Second
+	DefaultHealthCheckTimeout  = 1 * time.Second
+)
+
+// HealthCheck configures an active gRPC health check on a cluster. Zero durations use the defaults.
+type HealthCheck struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+func (h *HealthCheck) setDefaults() {
+	if h.Interval == 0 {
+		h.Interval = DefaultHealthCheckInterval
+	}
+	if h.Timeout == 0 {
+		h.Timeout = DefaultHealthCheckTimeout
+	}
+}
+
+func (h *HealthCheck) validate() error {
+	if h.Interval < 0 || h.Timeout < 0 {
+		return fmt.Errorf("invalid health check: interval and timeout must not be negative")
+	}
+	if h.Timeout > h.Interval {
+		return fmt.Errorf("invalid health check: timeout %s must not exceed interval %s", h.Timeout, h.Interval)
+	}
+	return nil
+}
+
+func (h *HealthCheck) build() []interface{} {
+	return []interface{}{
+		map[string]interface{}{
+			"interval":            envoyDuration(h.Interval),
+			"timeout":             envoyDuration(h.Timeout),
+			"unhealthy_threshold": 3,
+			"healthy_threshold":   1,
+			"grpc_health_check":   map[string]interface{}{},
+		},
+	}
+}
+
+// Defaults for HTTP/2 connection keepalive
+const (
+	DefaultKeepaliveInterval = 30 * time.Second
+	DefaultKeepaliveTimeout  = 5 * time.Second
+)
+
+// Keepalive configures HTTP/2 keepalive pings on a connection. Zero durations use the defaults.
+type Keepalive struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+func (k *Keepalive) setDefaults() {
+	if k.Interval == 0 {
+		k.Interval = DefaultKeepaliveInterval
+	}
+	if k.Timeout == 0 {
+		k.Timeout = DefaultKeepaliveTimeout
+	}
+}
+
+func (k *Keepalive) validate() error {
+	if k.Interval < 0 || k.Timeout < 0 {
+		return fmt.Errorf("invalid keepalive: interval and timeout must not be negative")
+	}
+	return nil
+}
+
+func (k *Keepalive) build() map[string]interface{} {
+	return map[string]interface{}{
+		"interval": envoyDuration(k.Interval),
+		"timeout":  envoyDuration(k.Timeout),
+	}
+}
+
+// envoyDuration formats d as a protobuf JSON duration, eg 1.5s
+func envoyDuration(d time.Duration) string {
+	return fmt.Sprintf("%ss", strconv.FormatFloat(d.Seconds(), 'f', -1, 64))
+}
+
+// StatsMatcher selects the stats that Envoy creates by name prefix, eg "cluster.xds_cluster.".
+// Either the included or the excluded prefixes may be set, but not both.
+type StatsMatcher struct {
+	InclusionPrefixes []string
+	ExclusionPrefixes []string
+}
+
+func (m *StatsMatcher) validate() error {
+	if (len(m.InclusionPrefixes) == 0) == (len(m.ExclusionPrefixes) == 0) {
+		return fmt.Errorf("invalid stats matcher: exactly one of the inclusion and exclusion prefixes must be set")
+	}
+	for _, prefix := range slices.Concat(m.InclusionPrefixes, m.ExclusionPrefixes) {
+		if prefix == "" {
+			return fmt.Errorf("invalid stats matcher: prefixes must not be empty")
+		}
+	}
+	return nil
+}
+
+func (m *StatsMatcher) build() map[string]interface{} {
+	key, prefixes := "inclusion_list", m.InclusionPrefixes
+	if len(m.ExclusionPrefixes) > 0 {
+		key, prefixes = "exclusion_list", m.ExclusionPrefixes
+	}
+
+	patterns := make([]interface{}, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		patterns = append(patterns, map[string]interface{}{"prefix": prefix})
+	}
+	return map[string]interface{}{
+		"stats_matcher": map[string]interface{}{
+			key: map[string]interface{}{"patterns": patterns},
+		},
+	}
+}
+
+// CircuitBreakers are the circuit breaker thresholds for a cluster. Zero values are
+// omitted, leaving Envoy's defaults in place.
+type CircuitBreakers struct {
+	MaxConnections     int
+	MaxPendingRequests int
+	MaxRequests        int
+	MaxRetries         int
+}
+
+func (c *CircuitBreakers) validate() error {
+	thresholds := map[string]int{
+		"max connections":      c.MaxConnections,
+		"max pending requests": c.MaxPendingRequests,
+		"max requests":         c.MaxRequests,
+		"max retries":          c.MaxRetries,
+	}
+
+	set := false
+	for name, threshold := range thresholds {
+		if threshold < 0 || threshold > math.MaxUint32 {
+			return fmt.Errorf("invalid circuit breaker %s threshold %d: must be between 0 and %d", name, threshold, uint32(math.MaxUint32))
+		}
+		if threshold > 0 {
+			set = true
+		}
+	}
+
+	if !set {
+		return fmt.Errorf("invalid circuit breakers: at least one threshold must be set")
+	}
+	return nil
+}
+
+func (c *CircuitBreakers) build() map[string]interface{} {
+	thresholds := map[string]interface{}{
+		"priority": "DEFAULT",
+	}
+	for key, threshold := range map[string]int{
+		"max_connections":      c.MaxConnections,
+		"max_pending_requests": c.MaxPendingRequests,
+		"max_requests":         c.MaxRequests,
+		"max_retries":          c.MaxRetries,
+	} {
+		if threshold > 0 {
+			thresholds[key] = threshold
+		}
+	}
+
+	return map[string]interface{}{
+		"thresholds": []interface{}{thresholds},
+	}
+}
+
+// XDSHeader is a header sent as initial metadata on the xDS gRPC stream
+type XDSHeader struct {
+	Key   string
+	Value string
+}
+
+// headerKeyRegex matches valid gRPC metadata keys
+var headerKeyRegex = regexp.MustCompile(`^[0-9a-z_.-]+$`)
+
+// ValidateXDSHeaderKey checks that key is a valid gRPC metadata key that may be set as xDS initial metadata
+func ValidateXDSHeaderKey(key string) error {
+	if !headerKeyRegex.MatchString(key) {
+		return fmt.Errorf("invalid xDS header %q: must consist of lowercase letters, digits, '_', '-' and '.'", key)
+	}
+	if strings.HasPrefix(key, "grpc-") {
+		return fmt.Errorf("invalid xDS header %q: the grpc- prefix is reserved", key)
+	}
+	if strings.HasSuffix(key, "-bin") {
+		return fmt.Errorf("invalid xDS header %q: binary headers are not supported", key)
+	}
+	return nil
 }
 
 type Envoy struct {
-	InitScript string
-	Cfg        []byte
+	InitScript         string
+	Cfg                []byte
+	image              string
+	initImage          string
+	initPullPolicy     corev1.PullPolicy
+	resources          corev1.ResourceRequirements
+	configVolumeName   string
+	configVolumeMemory bool
+	socketWait         bool
+	initNetRaw         bool
+	adminAddress       string
+	adminPort          uint32
 }
 
-func NewEnvoy(params EnvoyConfigParams) (*Envoy, error) {
+// NewEnvoy renders the Envoy bootstrap config and nftables init script. Rendering
+// is bounded by ctx, so callers should supply a context with a deadline.
+func NewEnvoy(ctx context.Context, params EnvoyConfigParams) (*Envoy, error) {
 	params.setDefaults()
 
+	for _, header := range params.XDSInitialMetadata {
+		if err := ValidateXDSHeaderKey(header.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	if params.InitialFetchTimeout < 0 {
+		return nil, fmt.Errorf("invalid initial fetch timeout %s: must not be negative", params.InitialFetchTimeout)
+	}
+
+	if params.DNSProxy != nil {
+		if err := params.DNSProxy.validate(); err != nil {
+			return nil, err
+		}
+		if params.DisableDNSRedirect {
+			return nil, fmt.Errorf("the DNS proxy requires DNS redirection")
+		}
+	}
+
+	if !slices.Contains(ProxyFlavors, params.Flavor) {
+		return nil, fmt.Errorf("invalid proxy flavor %q: must be one of %v", params.Flavor, ProxyFlavors)
+	}
+
+	if net.ParseIP(params.AdminAddress) == nil {
+		return nil, fmt.Errorf("invalid admin address %q: must be an IP address", params.AdminAddress)
+	}
+	if params.AdminPort > 65535 {
+		return nil, fmt.Errorf("invalid admin port %d: must be between 1 and 65535", params.AdminPort)
+	}
+	for _, port := range []uint32{EnvoyPort, DNSProxyPort, EnvoyReadinessPort} {
+		if params.AdminPort == port {
+			return nil, fmt.Errorf("invalid admin port %d: already used by the proxy", params.AdminPort)
+		}
+	}
+
+	if err := params.ValidateInbound(); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateUpstreams(params.Upstreams); err != nil {
+		return nil, err
+	}
+
+	if err := workload.ValidateSocketPath(params.WorkloadSocketPath); err != nil {
+		return nil, err
+	}
+
+	for _, port := range params.ExcludeOutboundPorts {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid excluded outbound port %d: must be between 1 and 65535", port)
+		}
+	}
+
+	if !slices.Contains(IPFamilies, params.IPFamily) {
+		return nil, fmt.Errorf("invalid IP family %q: must be one of %v", params.IPFamily, IPFamilies)
+	}
+
+	excludeDestinationCIDRs := make([]string, 0, len(params.ExcludeDestinationCIDRs))
+	for _, cidr := range params.ExcludeDestinationCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid excluded destination CIDR %q: %w", cidr, err)
+		}
+		// Traffic of the other family isn't redirected, so needs no exclusions
+		if family := nftFamily(ipNet.String()); (family == "ip" && params.IPFamily == IPFamilyIPv6) ||
+			(family == "ip6" && params.IPFamily == IPFamilyIPv4) {
+			continue
+		}
+		excludeDestinationCIDRs = append(excludeDestinationCIDRs, ipNet.String())
+	}
+
+	if params.SocketWaitTimeout < 0 {
+		return nil, fmt.Errorf("invalid socket wait timeout %s: must not be negative", params.SocketWaitTimeout)
+	}
+
+	if !slices.Contains(UpstreamProtocols, params.UpstreamProtocol) {
+		return nil, fmt.Errorf("invalid upstream protocol %q: must be one of %v", params.UpstreamProtocol, UpstreamProtocols)
+	}
+	if params.UpstreamProtocol != UpstreamProtocolTCP && !params.OriginalDst {
+		return nil, fmt.Errorf("upstream protocol %q requires the original destination listener", params.UpstreamProtocol)
+	}
+
+	if params.JWTAuthn != nil {
+		if err := params.JWTAuthn.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if params.StatsMatcher != nil {
+		if err := params.StatsMatcher.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, extension := range params.BootstrapExtensions {
+		if name, _ := extension["name"].(string); name == "" {
+			return nil, fmt.Errorf("invalid bootstrap extension %d: must have a name", i)
+		}
+	}
+
+	if params.CircuitBreakers != nil {
+		if err := params.CircuitBreakers.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := params.XDSKeepalive.validate(); err != nil {
+		return nil, err
+	}
+
+	if params.XDSHealthCheck != nil {
+		healthCheck := *params.XDSHealthCheck
+		healthCheck.setDefaults()
+		if err := healthCheck.validate(); err != nil {
+			return nil, err
+		}
+		params.XDSHealthCheck = &healthCheck
+	}
+
 	cfg := params.build()
+	if len(params.BaseConfig) > 0 {
+		merged, err := mergeBaseConfig(params.BaseConfig, cfg)
+		if err != nil {
+			return nil, err
+		}
+		cfg = merged
+	}
 
 	nftTablesParams := NftablesParams{
-		EnvoyUID:     EnvoyUID,
-		EnvoyPort:    EnvoyPort,
-		DNSProxyPort: DNSProxyPort,
+		EnvoyUID:                EnvoyUID,
+		EnvoyPort:               EnvoyPort,
+		AdminPort:               int(params.AdminPort),
+		DNSProxyPort:            DNSProxyPort,
+		DNSRedirect:             !params.DisableDNSRedirect,
+		DNSRedirectTCP:          params.DNSProxy != nil && len(params.DNSProxy.Resolvers) > 0,
+		ExtraCommands:           params.InitExtraCommands,
+		ExcludePorts:            excludedPorts(params.Flavor, params.ExcludeOutboundPorts),
+		ExcludeDestinationCIDRs: excludeDestinationCIDRs,
+		IPFamily:                params.IPFamily,
+	}
+	if params.SocketWaitTimeout > 0 {
+		nftTablesParams.SocketWaitPath = params.WorkloadSocketPath
+		nftTablesParams.SocketWaitTimeoutSeconds = int((params.SocketWaitTimeout + time.Second - 1) / time.Second)
 	}
 
-	tmpl, err := template.New("initScript").Parse(nftablesSetupScript)
+	tmpl, err := parseInitScriptTemplate(nftablesSetupScript)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse nftables init script template: %w", err)
+		return nil, err
 	}
 
-	var renderedScript bytes.Buffer
-	if err := tmpl.Execute(&renderedScript, nftTablesParams); err != nil {
+	renderedScript, err := render.Template(ctx, tmpl, nftTablesParams)
+	if err != nil {
 		return nil, fmt.Errorf("failed to render nftables init script template with params: %w", err)
 	}
 
@@ -122,13 +783,53 @@ func NewEnvoy(params EnvoyConfigParams) (*Envoy, error) {
 		return nil, fmt.Errorf("error marshalling proxy config to JSON: %w", err)
 	}
 
-	return &Envoy{InitScript: renderedScript.String(), Cfg: envoyConfigJSON}, nil
+	return &Envoy{
+		InitScript:         renderedScript,
+		Cfg:                envoyConfigJSON,
+		image:              params.Image,
+		initImage:          params.InitImage,
+		initPullPolicy:     params.InitImagePullPolicy,
+		resources:          params.Resources,
+		configVolumeName:   params.ConfigVolumeName,
+		configVolumeMemory: params.ConfigVolumeMemory,
+		socketWait:         params.SocketWaitTimeout > 0,
+		initNetRaw:         params.InitNetRaw,
+		adminAddress:       params.AdminAddress,
+		adminPort:          params.AdminPort,
+	}, nil
+}
+
+// ValidateTemplates checks that the templates rendered for each injection parse, so that a broken
+// template can be reported before any pods are mutated
+func ValidateTemplates() error {
+	_, err := parseInitScriptTemplate(nftablesSetupScript)
+	return err
+}
+
+func parseInitScriptTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("initScript").Funcs(template.FuncMap{"nftFamily": nftFamily}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse nftables init script template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// nftFamily returns the nftables address family of a CIDR
+func nftFamily(cidr string) string {
+	if strings.Contains(cidr, ":") {
+		return "ip6"
+	}
+	return "ip"
 }
 
 func (e *Envoy) GetConfigVolume() corev1.Volume {
+	emptyDir := &corev1.EmptyDirVolumeSource{}
+	if e.configVolumeMemory {
+		emptyDir.Medium = corev1.StorageMediumMemory
+	}
 	return corev1.Volume{
-		Name:         EnvoyConfigVolumeName,
-		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		Name:         e.configVolumeName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: emptyDir},
 	}
 }
 
@@ -143,18 +844,29 @@ func (e *Envoy) GetInitContainer() corev1.Container {
 
 	cmd := fmt.Sprintf("set -e; %s && %s", envoyConfigCmd, e.InitScript)
 
+	volumeMounts := []corev1.VolumeMount{{Name: e.configVolumeName, MountPath: filepath.Dir(configFilePath)}}
+	if e.socketWait {
+		// The socket is waited for in the CSI volume
+		volumeMounts = append(volumeMounts, workload.GetSPIFFEVolumeMount())
+	}
+
+	// Applying the nftables rules only needs NET_ADMIN
+	capabilities := []corev1.Capability{"NET_ADMIN"}
+	if e.initNetRaw {
+		capabilities = append(capabilities, "NET_RAW")
+	}
+
 	return corev1.Container{
 		Name:            EnvoyConfigInitContainerName,
-		Image:           helper.InitHelperImage,
-		ImagePullPolicy: corev1.PullIfNotPresent,
+		Image:           e.initImage,
+		ImagePullPolicy: e.initPullPolicy,
 		Command:         []string{"/bin/sh", "-c"},
 		Args:            []string{cmd},
 		Env:             []corev1.EnvVar{{Name: EnvoyConfigContentEnvVar, Value: string(e.Cfg)}},
-		VolumeMounts:    []corev1.VolumeMount{{Name: EnvoyConfigVolumeName, MountPath: filepath.Dir(configFilePath)}},
+		VolumeMounts:    volumeMounts,
 		SecurityContext: &corev1.SecurityContext{
 			Capabilities: &corev1.Capabilities{
-				Add: []corev1.Capability{"NET_ADMIN", "NET_RAW"}, // # Additional capabilities required to apply nftables rules
-
+				Add: capabilities,
 			},
 			RunAsUser:    ptr.To(int64(0)), // # Run as root in order to apply nftables rules
 			RunAsNonRoot: ptr.To(false),
@@ -162,17 +874,43 @@ func (e *Envoy) GetInitContainer() corev1.Container {
 	}
 }
 
-func (e *Envoy) GetSidecarContainer(logLevel string) corev1.Container {
+// GetStartupProbe returns a startup probe that succeeds once Envoy is ready, allowing up to
+// timeout for it to become so. It requires the readiness listener.
+func GetStartupProbe(timeout time.Duration) *corev1.Probe {
+	const period = time.Second
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: EnvoyReadinessPath,
+				Port: intstr.FromInt32(EnvoyReadinessPort),
+			},
+		},
+		PeriodSeconds:    int32(period.Seconds()),
+		FailureThreshold: int32(max(1, (timeout+period-1)/period)),
+	}
+}
+
+// GetSidecarContainer returns the Envoy sidecar container. A native sidecar must be
+// injected as an init container, after the config init container; otherwise it is a
+// regular container.
+func (e *Envoy) GetSidecarContainer(logLevel string, native bool) corev1.Container {
 	configFilePath := filepath.Join(EnvoyConfigMountPath, EnvoyConfigFileName)
 
+	var restartPolicy *corev1.ContainerRestartPolicy
+	if native {
+		restartPolicy = ptr.To(corev1.ContainerRestartPolicyAlways)
+	}
+
 	return corev1.Container{
 		Name:            EnvoySidecarContainerName,
-		Image:           IstioImage,
+		Image:           e.image,
 		ImagePullPolicy: corev1.PullIfNotPresent,
+		RestartPolicy:   restartPolicy,
 		Command:         []string{"envoy"},
 		Args:            []string{"-c", configFilePath, "-l", logLevel},
+		Resources:       *e.resources.DeepCopy(),
 		VolumeMounts: []corev1.VolumeMount{
-			{Name: EnvoyConfigVolumeName, MountPath: EnvoyConfigMountPath},
+			{Name: e.configVolumeName, MountPath: EnvoyConfigMountPath},
 			workload.GetSPIFFEVolumeMount(),
 		},
 		SecurityContext: &corev1.SecurityContext{
@@ -188,6 +926,29 @@ func (e *Envoy) GetSidecarContainer(logLevel string) corev1.Container {
 				ContainerPort: EnvoyPort,
 			},
 		},
+		Lifecycle: &corev1.Lifecycle{PreStop: e.drainHandler()},
+	}
+}
+
+// drainHandler returns a handler that gracefully drains Envoy's listeners with the admin
+// interface, then waits for EnvoyDrainSeconds, so that connections are closed cleanly rather than
+// reset when the pod terminates. It runs curl in the sidecar, which the Istio proxy image provides;
+// if curl is missing, the hook fails and Envoy is stopped straight away, as before.
+func (e *Envoy) drainHandler() *corev1.LifecycleHandler {
+	host := e.adminAddress
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		// An admin interface on all addresses is reached over loopback
+		host = "127.0.0.1"
+		if ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	drainURL := fmt.Sprintf("http://%s/drain_listeners?graceful", net.JoinHostPort(host, strconv.FormatUint(uint64(e.adminPort), 10)))
+
+	return &corev1.LifecycleHandler{
+		Exec: &corev1.ExecAction{
+			Command: []string{"/bin/sh", "-c", fmt.Sprintf("curl -sf -X POST '%s'; sleep %d", drainURL, EnvoyDrainSeconds)},
+		},
 	}
 }
 
@@ -199,15 +960,46 @@ func (p *EnvoyConfigParams) setDefaults() {
 		p.ClusterName = "cluster"
 	}
 	if p.AdminAddress == "" {
-		p.AdminAddress = "127.0.0.1"
+		p.AdminAddress = DefaultAdminAddress
 	}
 	if p.AdminPort == 0 {
-		p.AdminPort = 9901
+		p.AdminPort = DefaultAdminPort
+	}
+	if p.Image == "" {
+		p.Image = IstioImage
+	}
+	if p.InitImage == "" {
+		p.InitImage = helper.InitHelperImage
+	}
+	if p.InitImagePullPolicy == "" {
+		p.InitImagePullPolicy = corev1.PullIfNotPresent
+	}
+	if p.InitialFetchTimeout == 0 {
+		p.InitialFetchTimeout = DefaultInitialFetchTimeout
+	}
+	if p.Flavor == "" {
+		p.Flavor = ProxyFlavorEnvoy
+	}
+	if p.UpstreamProtocol == "" {
+		p.UpstreamProtocol = UpstreamProtocolTCP
+	}
+	if p.IPFamily == "" {
+		p.IPFamily = IPFamilyDual
+	}
+	if p.DNSProxy == nil && !p.DisableDNSRedirect {
+		p.DNSProxy = &DNSProxy{}
+	}
+	p.XDSKeepalive.setDefaults()
+	if p.ConfigVolumeName == "" {
+		p.ConfigVolumeName = EnvoyConfigVolumeName
+	}
+	if p.WorkloadSocketPath == "" {
+		p.WorkloadSocketPath = constants.SPIFFEWLSocketPath
 	}
 }
 
 func (p *EnvoyConfigParams) build() map[string]interface{} {
-	return map[string]interface{}{
+	cfg := map[string]interface{}{
 		"node": map[string]interface{}{
 			"id":      p.NodeID,
 			"cluster": p.ClusterName,
@@ -222,74 +1014,367 @@ func (p *EnvoyConfigParams) build() map[string]interface{} {
 		},
 		"dynamic_resources": map[string]interface{}{
 			"ads_config": map[string]interface{}{
-				"api_type":              "GRPC",
-				"transport_api_version": "V3",
-				"grpc_services": []interface{}{
-					map[string]interface{}{
-						"envoy_grpc": map[string]interface{}{
-							keyClusterName: valueXDSCluster,
-						},
-					},
-				},
+				"api_type":                       "GRPC",
+				"transport_api_version":          "V3",
+				"grpc_services":                  []interface{}{p.xdsGRPCService()},
 				"set_node_on_first_message_only": true,
 			},
+			// The initial fetch timeout is a property of each resource's config source; the
+			// ADS config itself has no such field
 			"cds_config": map[string]interface{}{
-				"resource_api_version": "V3",
-				"ads":                  map[string]interface{}{},
+				"resource_api_version":  "V3",
+				"ads":                   map[string]interface{}{},
+				"initial_fetch_timeout": envoyDuration(p.InitialFetchTimeout),
 			},
 			"lds_config": map[string]interface{}{
-				"resource_api_version": "V3",
-				"ads":                  map[string]interface{}{},
+				"resource_api_version":  "V3",
+				"ads":                   map[string]interface{}{},
+				"initial_fetch_timeout": envoyDuration(p.InitialFetchTimeout),
+			},
+		},
+		"static_resources": p.staticResources(),
+	}
+
+	if p.StatsMatcher != nil {
+		cfg["stats_config"] = p.StatsMatcher.build()
+	}
+
+	if len(p.BootstrapExtensions) > 0 {
+		extensions := make([]interface{}, 0, len(p.BootstrapExtensions))
+		for _, extension := range p.BootstrapExtensions {
+			extensions = append(extensions, extension)
+		}
+		cfg["bootstrap_extensions"] = extensions
+	}
+
+	return cfg
+}
+
+func (p *EnvoyConfigParams) staticResources() map[string]interface{} {
+	staticResources := map[string]interface{}{
+		"clusters": p.staticClusters(),
+	}
+	var listeners []interface{}
+	if p.OriginalDst {
+		listeners = append(listeners, p.originalDstListener())
+	}
+	if p.DNSProxy != nil {
+		listeners = append(listeners, p.DNSProxy.listeners()...)
+	}
+	if p.JWTAuthn != nil {
+		listeners = append(listeners, p.JWTAuthn.listener())
+	}
+	if p.ReadinessListener {
+		listeners = append(listeners, p.readinessListener())
+	}
+	if p.InboundPort != 0 {
+		listeners = append(listeners, p.inboundListener())
+	}
+	if len(listeners) > 0 {
+		staticResources["listeners"] = listeners
+	}
+	return staticResources
+}
+
+func (p *EnvoyConfigParams) staticClusters() []interface{} {
+	clusters := []map[string]interface{}{p.xdsCluster(), p.sdsCluster()}
+	if p.OriginalDst {
+		clusters = append(clusters, p.originalDstCluster())
+	}
+	if p.DNSProxy != nil {
+		if cluster := p.DNSProxy.cluster(); cluster != nil {
+			clusters = append(clusters, cluster)
+		}
+	}
+	if p.JWTAuthn != nil {
+		clusters = append(clusters, p.JWTAuthn.clusters()...)
+	}
+	if p.ReadinessListener {
+		clusters = append(clusters, p.adminCluster())
+	}
+	if p.InboundPort != 0 {
+		clusters = append(clusters, p.inboundCluster())
+	}
+	for _, upstream := range p.Upstreams {
+		clusters = append(clusters, upstream.cluster())
+	}
+
+	staticClusters := make([]interface{}, 0, len(clusters))
+	for _, cluster := range clusters {
+		if p.CircuitBreakers != nil {
+			cluster["circuit_breakers"] = p.CircuitBreakers.build()
+		}
+		staticClusters = append(staticClusters, cluster)
+	}
+	return staticClusters
+}
+
+func (p *EnvoyConfigParams) xdsCluster() map[string]interface{} {
+	cluster := map[string]interface{}{
+		"name":            valueXDSCluster,
+		"type":            "LOGICAL_DNS",
+		"connect_timeout": "5s",
+		"typed_extension_protocol_options": map[string]interface{}{
+			"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": map[string]interface{}{
+				"@type": "type.googleapis.com/envoy.extensions.upstreams.http.v3.HttpProtocolOptions",
+				"explicit_http_config": map[string]interface{}{
+					"http2_protocol_options": map[string]interface{}{
+						"connection_keepalive": p.XDSKeepalive.build(),
+					},
+				},
 			},
 		},
-		"static_resources": map[string]interface{}{
-			"clusters": []interface{}{
+		"load_assignment": map[string]interface{}{
+			keyClusterName: valueXDSCluster,
+			"endpoints": []interface{}{
 				map[string]interface{}{
-					"name":            valueXDSCluster,
-					"type":            "LOGICAL_DNS",
-					"connect_timeout": "5s",
-					"typed_extension_protocol_options": map[string]interface{}{
-						"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": map[string]interface{}{
-							"@type": "type.googleapis.com/envoy.extensions.upstreams.http.v3.HttpProtocolOptions",
-							"explicit_http_config": map[string]interface{}{
-								"http2_protocol_options": map[string]interface{}{},
+					"lb_endpoints": []interface{}{
+						map[string]interface{}{
+							"endpoint": map[string]interface{}{
+								keyAddress: map[string]interface{}{
+									"socket_address": map[string]interface{}{
+										keyAddress:   p.AgentXDSService,
+										"port_value": p.AgentXDSPort,
+									},
+								},
 							},
 						},
 					},
-					"load_assignment": map[string]interface{}{
-						keyClusterName: valueXDSCluster,
-						"endpoints": []interface{}{
+				},
+			},
+		},
+	}
+
+	if p.XDSHealthCheck != nil {
+		cluster["health_checks"] = p.XDSHealthCheck.build()
+	}
+
+	return cluster
+}
+
+func (p *EnvoyConfigParams) xdsGRPCService() map[string]interface{} {
+	grpcService := map[string]interface{}{
+		"envoy_grpc": map[string]interface{}{
+			keyClusterName: valueXDSCluster,
+		},
+	}
+
+	if len(p.XDSInitialMetadata) > 0 {
+		initialMetadata := make([]interface{}, 0, len(p.XDSInitialMetadata))
+		for _, header := range p.XDSInitialMetadata {
+			initialMetadata = append(initialMetadata, map[string]interface{}{
+				"key":   header.Key,
+				"value": header.Value,
+			})
+		}
+		grpcService["initial_metadata"] = initialMetadata
+	}
+
+	return grpcService
+}
+
+// originalDstListener returns a listener for connections redirected to Envoy by the nftables
+// rules, which recovers their original destination and proxies them to the original_dst cluster
+func (p *EnvoyConfigParams) originalDstListener() map[string]interface{} {
+	return map[string]interface{}{
+		"name": "original_dst_listener",
+		keyAddress: map[string]interface{}{
+			"socket_address": map[string]interface{}{
+				// Connections are redirected from both the IPv4 and IPv6 loopback addresses
+				keyAddress:    "::",
+				"port_value":  EnvoyPort,
+				"ipv4_compat": true,
+			},
+		},
+		"listener_filters": []interface{}{
+			map[string]interface{}{
+				"name": "envoy.filters.listener.original_dst",
+				"typed_config": map[string]interface{}{
+					"@type": "type.googleapis.com/envoy.extensions.filters.listener.original_dst.v3.OriginalDst",
+				},
+			},
+		},
+		"filter_chains": []interface{}{
+			map[string]interface{}{
+				"filters": []interface{}{p.originalDstFilter()},
+			},
+		},
+	}
+}
+
+// originalDstFilter returns the network filter that forwards connections to the original_dst
+// cluster: a TCP proxy, or an HTTP connection manager for the HTTP upstream protocols
+func (p *EnvoyConfigParams) originalDstFilter() map[string]interface{} {
+	if p.UpstreamProtocol == UpstreamProtocolTCP {
+		return map[string]interface{}{
+			"name": "envoy.filters.network.tcp_proxy",
+			"typed_config": map[string]interface{}{
+				"@type":       "type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy",
+				"stat_prefix": "original_dst",
+				"cluster":     valueOriginalDstCluster,
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"name": "envoy.filters.network.http_connection_manager",
+		"typed_config": map[string]interface{}{
+			"@type":       "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+			"stat_prefix": "original_dst",
+			"codec_type":  "AUTO",
+			"route_config": map[string]interface{}{
+				"name": "original_dst",
+				"virtual_hosts": []interface{}{
+					map[string]interface{}{
+						"name":    "original_dst",
+						"domains": []interface{}{"*"},
+						"routes": []interface{}{
 							map[string]interface{}{
-								"lb_endpoints": []interface{}{
+								"match": map[string]interface{}{"prefix": "/"},
+								"route": map[string]interface{}{"cluster": valueOriginalDstCluster},
+							},
+						},
+					},
+				},
+			},
+			"http_filters": []interface{}{
+				map[string]interface{}{
+					"name": "envoy.filters.http.router",
+					"typed_config": map[string]interface{}{
+						"@type": "type.googleapis.com/envoy.extensions.filters.http.router.v3.Router",
+					},
+				},
+			},
+		},
+	}
+}
+
+// readinessListener returns a listener that forwards requests for the readiness path to the admin
+// interface, and rejects any others
+func (p *EnvoyConfigParams) readinessListener() map[string]interface{} {
+	return map[string]interface{}{
+		"name": "readiness_listener",
+		keyAddress: map[string]interface{}{
+			"socket_address": map[string]interface{}{
+				keyAddress:    "::",
+				"port_value":  EnvoyReadinessPort,
+				"ipv4_compat": true,
+			},
+		},
+		"filter_chains": []interface{}{
+			map[string]interface{}{
+				"filters": []interface{}{
+					map[string]interface{}{
+						"name": "envoy.filters.network.http_connection_manager",
+						"typed_config": map[string]interface{}{
+							"@type":       "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+							"stat_prefix": "readiness",
+							"codec_type":  "AUTO",
+							"route_config": map[string]interface{}{
+								"name": "readiness",
+								"virtual_hosts": []interface{}{
 									map[string]interface{}{
-										"endpoint": map[string]interface{}{
-											keyAddress: map[string]interface{}{
-												"socket_address": map[string]interface{}{
-													keyAddress:   p.AgentXDSService,
-													"port_value": p.AgentXDSPort,
-												},
+										"name":    "readiness",
+										"domains": []interface{}{"*"},
+										"routes": []interface{}{
+											map[string]interface{}{
+												"match": map[string]interface{}{"path": EnvoyReadinessPath},
+												"route": map[string]interface{}{"cluster": valueAdminCluster},
 											},
 										},
 									},
 								},
 							},
+							"http_filters": []interface{}{
+								map[string]interface{}{
+									"name": "envoy.filters.http.router",
+									"typed_config": map[string]interface{}{
+										"@type": "type.googleapis.com/envoy.extensions.filters.http.router.v3.Router",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// adminCluster returns a cluster that connects to the admin interface
+func (p *EnvoyConfigParams) adminCluster() map[string]interface{} {
+	return map[string]interface{}{
+		"name":            valueAdminCluster,
+		"type":            "STATIC",
+		"connect_timeout": "1s",
+		"load_assignment": map[string]interface{}{
+			keyClusterName: valueAdminCluster,
+			"endpoints": []interface{}{
+				map[string]interface{}{
+					"lb_endpoints": []interface{}{
+						map[string]interface{}{
+							"endpoint": map[string]interface{}{
+								keyAddress: map[string]interface{}{
+									"socket_address": map[string]interface{}{
+										keyAddress:   p.AdminAddress,
+										"port_value": p.AdminPort,
+									},
+								},
+							},
 						},
 					},
 				},
-				getSDSCluster(),
 			},
 		},
 	}
 }
 
-func getSDSCluster() map[string]interface{} {
+// originalDstCluster returns a cluster that connects to the original destination of the downstream connection
+func (p *EnvoyConfigParams) originalDstCluster() map[string]interface{} {
+	cluster := map[string]interface{}{
+		"name":            valueOriginalDstCluster,
+		"type":            "ORIGINAL_DST",
+		"lb_policy":       "CLUSTER_PROVIDED",
+		"connect_timeout": "5s",
+	}
+
+	var httpConfig map[string]interface{}
+	switch p.UpstreamProtocol {
+	case UpstreamProtocolH1:
+		httpConfig = map[string]interface{}{
+			"explicit_http_config": map[string]interface{}{"http_protocol_options": map[string]interface{}{}},
+		}
+	case UpstreamProtocolH2:
+		httpConfig = map[string]interface{}{
+			"explicit_http_config": map[string]interface{}{"http2_protocol_options": map[string]interface{}{}},
+		}
+	case UpstreamProtocolAuto:
+		// HTTP/2 is only selected when negotiated with ALPN, otherwise HTTP/1.1 is used
+		httpConfig = map[string]interface{}{
+			"auto_config": map[string]interface{}{
+				"http_protocol_options":  map[string]interface{}{},
+				"http2_protocol_options": map[string]interface{}{},
+			},
+		}
+	}
+	if httpConfig != nil {
+		httpConfig["@type"] = "type.googleapis.com/envoy.extensions.upstreams.http.v3.HttpProtocolOptions"
+		cluster["typed_extension_protocol_options"] = map[string]interface{}{
+			"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": httpConfig,
+		}
+	}
+
+	return cluster
+}
+
+func (p *EnvoyConfigParams) sdsCluster() map[string]interface{} {
 	return map[string]interface{}{
-		"name":                   "sds-grpc",
+		"name":                   valueSDSCluster,
 		"connect_timeout":        "5s",
 		"type":                   "STATIC",
 		"http2_protocol_options": map[string]interface{}{},
 		"load_assignment": map[string]interface{}{
-			keyClusterName: "sds-grpc",
+			keyClusterName: valueSDSCluster,
 			"endpoints": []interface{}{
 				map[string]interface{}{
 					"lb_endpoints": []interface{}{
@@ -297,7 +1382,7 @@ func getSDSCluster() map[string]interface{} {
 							"endpoint": map[string]interface{}{
 								keyAddress: map[string]interface{}{
 									"pipe": map[string]interface{}{
-										"path": constants.SPIFFEWLSocketPath,
+										"path": p.WorkloadSocketPath,
 									},
 								},
 							},
@@ -0,0 +1,1274 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	"github.com/cofide/spiffe-enable/internal/workload"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// xdsGRPCServiceFromConfig decodes a rendered Envoy config and returns the ADS gRPC service
+func xdsGRPCServiceFromConfig(t *testing.T, cfg []byte) map[string]interface{} {
+	t.Helper()
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(cfg, &decoded))
+
+	dynamicResources, ok := decoded["dynamic_resources"].(map[string]interface{})
+	require.True(t, ok)
+	adsConfig, ok := dynamicResources["ads_config"].(map[string]interface{})
+	require.True(t, ok)
+	grpcServices, ok := adsConfig["grpc_services"].([]interface{})
+	require.True(t, ok)
+	require.Len(t, grpcServices, 1)
+	grpcService, ok := grpcServices[0].(map[string]interface{})
+	require.True(t, ok)
+
+	return grpcService
+}
+
+func TestNewEnvoy_XDSInitialMetadata(t *testing.T) {
+	tests := []struct {
+		name             string
+		headers          []XDSHeader
+		expectedMetadata []interface{}
+		expectError      bool
+	}{
+		{
+			name: "no headers",
+		},
+		{
+			name: "token and custom headers",
+			headers: []XDSHeader{
+				{Key: "authorization", Value: "Bearer token"},
+				{Key: "x-cofide-cluster", Value: "cluster-1"},
+			},
+			expectedMetadata: []interface{}{
+				map[string]interface{}{"key": "authorization", "value": "Bearer token"},
+				map[string]interface{}{"key": "x-cofide-cluster", "value": "cluster-1"},
+			},
+		},
+		{
+			name:        "uppercase header",
+			headers:     []XDSHeader{{Key: "Authorization", Value: "token"}},
+			expectError: true,
+		},
+		{
+			name:        "invalid character",
+			headers:     []XDSHeader{{Key: "x token", Value: "token"}},
+			expectError: true,
+		},
+		{
+			name:        "reserved prefix",
+			headers:     []XDSHeader{{Key: "grpc-timeout", Value: "1s"}},
+			expectError: true,
+		},
+		{
+			name:        "binary header",
+			headers:     []XDSHeader{{Key: "token-bin", Value: "token"}},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{
+				AgentXDSService:    "xds.example.org",
+				AgentXDSPort:       18001,
+				XDSInitialMetadata: tt.headers,
+			})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			grpcService := xdsGRPCServiceFromConfig(t, envoy.Cfg)
+			assert.Equal(t, map[string]interface{}{keyClusterName: valueXDSCluster}, grpcService["envoy_grpc"])
+			if tt.expectedMetadata == nil {
+				assert.NotContains(t, grpcService, "initial_metadata")
+				return
+			}
+			assert.Equal(t, tt.expectedMetadata, grpcService["initial_metadata"])
+		})
+	}
+}
+
+func TestNewEnvoy_CircuitBreakers(t *testing.T) {
+	tests := []struct {
+		name               string
+		circuitBreakers    *CircuitBreakers
+		expectedThresholds map[string]interface{}
+		expectError        bool
+	}{
+		{
+			name: "omitted by default",
+		},
+		{
+			name: "all thresholds",
+			circuitBreakers: &CircuitBreakers{
+				MaxConnections:     100,
+				MaxPendingRequests: 50,
+				MaxRequests:        200,
+				MaxRetries:         3,
+			},
+			expectedThresholds: map[string]interface{}{
+				"priority":             "DEFAULT",
+				"max_connections":      float64(100),
+				"max_pending_requests": float64(50),
+				"max_requests":         float64(200),
+				"max_retries":          float64(3),
+			},
+		},
+		{
+			name:            "some thresholds",
+			circuitBreakers: &CircuitBreakers{MaxConnections: 10},
+			expectedThresholds: map[string]interface{}{
+				"priority":        "DEFAULT",
+				"max_connections": float64(10),
+			},
+		},
+		{
+			name:            "no thresholds",
+			circuitBreakers: &CircuitBreakers{},
+			expectError:     true,
+		},
+		{
+			name:            "negative threshold",
+			circuitBreakers: &CircuitBreakers{MaxConnections: 10, MaxRetries: -1},
+			expectError:     true,
+		},
+		{
+			name:            "threshold too large",
+			circuitBreakers: &CircuitBreakers{MaxRequests: 1 << 32},
+			expectError:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{
+				AgentXDSService: "xds.example.org",
+				AgentXDSPort:    18001,
+				CircuitBreakers: tt.circuitBreakers,
+			})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var decoded struct {
+				StaticResources struct {
+					Clusters []map[string]interface{} `json:"clusters"`
+				} `json:"static_resources"`
+			}
+			require.NoError(t, json.Unmarshal(envoy.Cfg, &decoded))
+			require.Len(t, decoded.StaticResources.Clusters, 2) // xds + sds
+
+			for _, cluster := range decoded.StaticResources.Clusters {
+				if tt.expectedThresholds == nil {
+					assert.NotContains(t, cluster, "circuit_breakers", "cluster %s", cluster["name"])
+					continue
+				}
+				assert.Equal(t, map[string]interface{}{
+					"thresholds": []interface{}{tt.expectedThresholds},
+				}, cluster["circuit_breakers"], "cluster %s", cluster["name"])
+			}
+		})
+	}
+}
+
+func TestNewEnvoy_XDSHealthCheck(t *testing.T) {
+	tests := []struct {
+		name                string
+		healthCheck         *HealthCheck
+		expectedHealthCheck map[string]interface{}
+		expectError         bool
+	}{
+		{
+			name: "omitted by default",
+		},
+		{
+			name:        "defaults",
+			healthCheck: &HealthCheck{},
+			expectedHealthCheck: map[string]interface{}{
+				"interval":            "10s",
+				"timeout":             "1s",
+				"unhealthy_threshold": float64(3),
+				"healthy_threshold":   float64(1),
+				"grpc_health_check":   map[string]interface{}{},
+			},
+		},
+		{
+			name:        "custom interval and timeout",
+			healthCheck: &HealthCheck{Interval: 30 * time.Second, Timeout: 1500 * time.Millisecond},
+			expectedHealthCheck: map[string]interface{}{
+				"interval":            "30s",
+				"timeout":             "1.5s",
+				"unhealthy_threshold": float64(3),
+				"healthy_threshold":   float64(1),
+				"grpc_health_check":   map[string]interface{}{},
+			},
+		},
+		{
+			name:        "timeout exceeds interval",
+			healthCheck: &HealthCheck{Interval: time.Second, Timeout: 2 * time.Second},
+			expectError: true,
+		},
+		{
+			name:        "negative interval",
+			healthCheck: &HealthCheck{Interval: -time.Second},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{
+				AgentXDSService: "xds.example.org",
+				AgentXDSPort:    18001,
+				XDSHealthCheck:  tt.healthCheck,
+			})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var decoded struct {
+				StaticResources struct {
+					Clusters []map[string]interface{} `json:"clusters"`
+				} `json:"static_resources"`
+			}
+			require.NoError(t, json.Unmarshal(envoy.Cfg, &decoded))
+
+			for _, cluster := range decoded.StaticResources.Clusters {
+				if cluster["name"] != valueXDSCluster || tt.expectedHealthCheck == nil {
+					assert.NotContains(t, cluster, "health_checks", "cluster %s", cluster["name"])
+					continue
+				}
+				assert.Equal(t, []interface{}{tt.expectedHealthCheck}, cluster["health_checks"])
+			}
+		})
+	}
+}
+
+func TestNewEnvoy_InitialFetchTimeout(t *testing.T) {
+	tests := []struct {
+		name            string
+		timeout         time.Duration
+		expectedTimeout string
+		expectError     bool
+	}{
+		{
+			name:            "default",
+			expectedTimeout: "15s",
+		},
+		{
+			name:            "custom",
+			timeout:         5 * time.Second,
+			expectedTimeout: "5s",
+		},
+		{
+			name:        "negative",
+			timeout:     -time.Second,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{
+				AgentXDSService:     "xds.example.org",
+				AgentXDSPort:        18001,
+				InitialFetchTimeout: tt.timeout,
+			})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var decoded struct {
+				DynamicResources map[string]map[string]interface{} `json:"dynamic_resources"`
+			}
+			require.NoError(t, json.Unmarshal(envoy.Cfg, &decoded))
+
+			for _, source := range []string{"cds_config", "lds_config"} {
+				assert.Equal(t, tt.expectedTimeout, decoded.DynamicResources[source]["initial_fetch_timeout"], source)
+			}
+			assert.NotContains(t, decoded.DynamicResources["ads_config"], "initial_fetch_timeout")
+		})
+	}
+}
+
+func TestNewEnvoy_OriginalDst(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%t", enabled), func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{
+				AgentXDSService: "xds.example.org",
+				AgentXDSPort:    18001,
+				OriginalDst:     enabled,
+				// Leave out the DNS proxy listeners
+				DisableDNSRedirect: true,
+			})
+			require.NoError(t, err)
+
+			var decoded struct {
+				StaticResources struct {
+					Listeners []struct {
+						Address struct {
+							SocketAddress map[string]interface{} `json:"socket_address"`
+						} `json:"address"`
+						ListenerFilters []struct {
+							Name string `json:"name"`
+						} `json:"listener_filters"`
+						FilterChains []struct {
+							Filters []struct {
+								TypedConfig map[string]interface{} `json:"typed_config"`
+							} `json:"filters"`
+						} `json:"filter_chains"`
+					} `json:"listeners"`
+					Clusters []map[string]interface{} `json:"clusters"`
+				} `json:"static_resources"`
+			}
+			require.NoError(t, json.Unmarshal(envoy.Cfg, &decoded))
+
+			var originalDstCluster map[string]interface{}
+			for _, cluster := range decoded.StaticResources.Clusters {
+				if cluster["name"] == valueOriginalDstCluster {
+					originalDstCluster = cluster
+				}
+			}
+
+			if !enabled {
+				assert.Empty(t, decoded.StaticResources.Listeners)
+				assert.Nil(t, originalDstCluster)
+				return
+			}
+
+			require.Len(t, decoded.StaticResources.Listeners, 1)
+			listener := decoded.StaticResources.Listeners[0]
+			assert.Equal(t, float64(EnvoyPort), listener.Address.SocketAddress["port_value"])
+			require.Len(t, listener.ListenerFilters, 1)
+			assert.Equal(t, "envoy.filters.listener.original_dst", listener.ListenerFilters[0].Name)
+			require.Len(t, listener.FilterChains, 1)
+			require.Len(t, listener.FilterChains[0].Filters, 1)
+			assert.Equal(t, valueOriginalDstCluster, listener.FilterChains[0].Filters[0].TypedConfig["cluster"])
+
+			require.NotNil(t, originalDstCluster)
+			assert.Equal(t, "ORIGINAL_DST", originalDstCluster["type"])
+			assert.Equal(t, "CLUSTER_PROVIDED", originalDstCluster["lb_policy"])
+		})
+	}
+}
+
+func TestNewEnvoy_UpstreamProtocol(t *testing.T) {
+	tests := []struct {
+		name                 string
+		protocol             string
+		originalDst          bool
+		expectedFilter       string
+		expectedHTTPProtocol map[string]interface{}
+		expectError          bool
+	}{
+		{
+			name:           "tcp by default",
+			originalDst:    true,
+			expectedFilter: "envoy.filters.network.tcp_proxy",
+		},
+		{
+			name:           "tcp",
+			protocol:       UpstreamProtocolTCP,
+			originalDst:    true,
+			expectedFilter: "envoy.filters.network.tcp_proxy",
+		},
+		{
+			name:           "h1",
+			protocol:       UpstreamProtocolH1,
+			originalDst:    true,
+			expectedFilter: "envoy.filters.network.http_connection_manager",
+			expectedHTTPProtocol: map[string]interface{}{
+				"explicit_http_config": map[string]interface{}{"http_protocol_options": map[string]interface{}{}},
+			},
+		},
+		{
+			name:           "h2",
+			protocol:       UpstreamProtocolH2,
+			originalDst:    true,
+			expectedFilter: "envoy.filters.network.http_connection_manager",
+			expectedHTTPProtocol: map[string]interface{}{
+				"explicit_http_config": map[string]interface{}{"http2_protocol_options": map[string]interface{}{}},
+			},
+		},
+		{
+			name:           "auto",
+			protocol:       UpstreamProtocolAuto,
+			originalDst:    true,
+			expectedFilter: "envoy.filters.network.http_connection_manager",
+			expectedHTTPProtocol: map[string]interface{}{
+				"auto_config": map[string]interface{}{
+					"http_protocol_options":  map[string]interface{}{},
+					"http2_protocol_options": map[string]interface{}{},
+				},
+			},
+		},
+		{
+			name:        "invalid protocol",
+			protocol:    "h3",
+			originalDst: true,
+			expectError: true,
+		},
+		{
+			name:        "requires original destination listener",
+			protocol:    UpstreamProtocolH2,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{
+				AgentXDSService:  "xds.example.org",
+				AgentXDSPort:     18001,
+				OriginalDst:      tt.originalDst,
+				UpstreamProtocol: tt.protocol,
+				// Leave out the DNS proxy listeners
+				DisableDNSRedirect: true,
+			})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var decoded struct {
+				StaticResources struct {
+					Listeners []struct {
+						FilterChains []struct {
+							Filters []struct {
+								Name string `json:"name"`
+							} `json:"filters"`
+						} `json:"filter_chains"`
+					} `json:"listeners"`
+					Clusters []map[string]interface{} `json:"clusters"`
+				} `json:"static_resources"`
+			}
+			require.NoError(t, json.Unmarshal(envoy.Cfg, &decoded))
+
+			require.Len(t, decoded.StaticResources.Listeners, 1)
+			require.Len(t, decoded.StaticResources.Listeners[0].FilterChains, 1)
+			filters := decoded.StaticResources.Listeners[0].FilterChains[0].Filters
+			require.Len(t, filters, 1)
+			assert.Equal(t, tt.expectedFilter, filters[0].Name)
+
+			var originalDstCluster map[string]interface{}
+			for _, cluster := range decoded.StaticResources.Clusters {
+				if cluster["name"] == valueOriginalDstCluster {
+					originalDstCluster = cluster
+				}
+			}
+			require.NotNil(t, originalDstCluster)
+
+			if tt.expectedHTTPProtocol == nil {
+				assert.NotContains(t, originalDstCluster, "typed_extension_protocol_options")
+				return
+			}
+			tt.expectedHTTPProtocol["@type"] = "type.googleapis.com/envoy.extensions.upstreams.http.v3.HttpProtocolOptions"
+			assert.Equal(t, map[string]interface{}{
+				"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": tt.expectedHTTPProtocol,
+			}, originalDstCluster["typed_extension_protocol_options"])
+		})
+	}
+}
+
+func TestNewEnvoy_InitExtraCommands(t *testing.T) {
+	t.Run("omitted by default", func(t *testing.T) {
+		envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{})
+		require.NoError(t, err)
+		assert.NotContains(t, envoy.InitScript, "Extra commands")
+	})
+
+	t.Run("run before the nftables rules are applied", func(t *testing.T) {
+		extraCommands := "modprobe nf_nat\nmodprobe nf_tables"
+		envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{InitExtraCommands: extraCommands})
+		require.NoError(t, err)
+
+		extraIdx := strings.Index(envoy.InitScript, extraCommands)
+		require.NotEqual(t, -1, extraIdx)
+		nftCheckIdx := strings.Index(envoy.InitScript, "command -v nft")
+		nftApplyIdx := strings.Index(envoy.InitScript, "nft -f /tmp/dns_redirect.nft")
+		require.NotEqual(t, -1, nftCheckIdx)
+		require.NotEqual(t, -1, nftApplyIdx)
+
+		assert.Greater(t, extraIdx, nftCheckIdx)
+		assert.Less(t, extraIdx, nftApplyIdx)
+
+		// The commands must not be inside the heredoc that writes the nftables rules
+		assert.Greater(t, extraIdx, strings.Index(envoy.InitScript, "\nEOF\n"))
+	})
+}
+
+func TestNewEnvoy_DisableDNSRedirect(t *testing.T) {
+	t.Run("redirected by default", func(t *testing.T) {
+		envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{})
+		require.NoError(t, err)
+		assert.Contains(t, envoy.InitScript, fmt.Sprintf("udp dport 53 counter redirect to :%d", DNSProxyPort))
+		// Without resolvers the DNS proxy doesn't handle TCP, so TCP requests go to the nameservers
+		assert.NotContains(t, envoy.InitScript, "tcp dport 53")
+	})
+
+	t.Run("TCP redirected with resolvers", func(t *testing.T) {
+		envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{
+			DNSProxy: &DNSProxy{Resolvers: []string{"10.96.0.10"}},
+		})
+		require.NoError(t, err)
+		assert.Contains(t, envoy.InitScript, fmt.Sprintf("udp dport 53 counter redirect to :%d", DNSProxyPort))
+		assert.Contains(t, envoy.InitScript, fmt.Sprintf("tcp dport 53 counter redirect to :%d", DNSProxyPort))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{DisableDNSRedirect: true})
+		require.NoError(t, err)
+		assert.NotContains(t, envoy.InitScript, "dport 53")
+		// Loopback TCP traffic is still redirected
+		assert.Contains(t, envoy.InitScript, fmt.Sprintf("counter redirect to :%d", EnvoyPort))
+	})
+
+	t.Run("DNS proxy requires redirection", func(t *testing.T) {
+		_, err := NewEnvoy(context.Background(), EnvoyConfigParams{DisableDNSRedirect: true, DNSProxy: &DNSProxy{}})
+		require.Error(t, err)
+	})
+}
+
+func TestNewEnvoy_DNSProxy(t *testing.T) {
+	tests := []struct {
+		name               string
+		dnsProxy           *DNSProxy
+		disableDNSRedirect bool
+		expectedListeners  []string
+		expectedResolvers  []interface{}
+		expectError        bool
+	}{
+		{
+			name:              "system resolvers by default",
+			expectedListeners: []string{"dns_udp_listener"},
+		},
+		{
+			name:               "omitted without DNS redirection",
+			disableDNSRedirect: true,
+		},
+		{
+			name:              "system resolvers",
+			dnsProxy:          &DNSProxy{},
+			expectedListeners: []string{"dns_udp_listener"},
+		},
+		{
+			name:              "configured resolvers",
+			dnsProxy:          &DNSProxy{Resolvers: []string{"10.96.0.10", "fd00::a"}},
+			expectedListeners: []string{"dns_udp_listener", "dns_tcp_listener"},
+			expectedResolvers: []interface{}{
+				map[string]interface{}{"socket_address": map[string]interface{}{"address": "10.96.0.10", "port_value": float64(53)}},
+				map[string]interface{}{"socket_address": map[string]interface{}{"address": "fd00::a", "port_value": float64(53)}},
+			},
+		},
+		{
+			name:        "invalid resolver",
+			dnsProxy:    &DNSProxy{Resolvers: []string{"kube-dns"}},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{
+				AgentXDSService:    "xds.example.org",
+				AgentXDSPort:       18001,
+				DNSProxy:           tt.dnsProxy,
+				DisableDNSRedirect: tt.disableDNSRedirect,
+			})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var decoded struct {
+				StaticResources struct {
+					Listeners []map[string]interface{} `json:"listeners"`
+					Clusters  []map[string]interface{} `json:"clusters"`
+				} `json:"static_resources"`
+			}
+			require.NoError(t, json.Unmarshal(envoy.Cfg, &decoded))
+
+			var names []string
+			for _, listener := range decoded.StaticResources.Listeners {
+				names = append(names, listener["name"].(string))
+				socketAddress := listener["address"].(map[string]interface{})["socket_address"].(map[string]interface{})
+				assert.Equal(t, float64(DNSProxyPort), socketAddress["port_value"])
+			}
+			assert.Equal(t, tt.expectedListeners, names)
+			if tt.disableDNSRedirect {
+				return
+			}
+
+			udpListener := decoded.StaticResources.Listeners[0]
+			socketAddress := udpListener["address"].(map[string]interface{})["socket_address"].(map[string]interface{})
+			assert.Equal(t, "UDP", socketAddress["protocol"])
+			listenerFilters := udpListener["listener_filters"].([]interface{})
+			require.Len(t, listenerFilters, 1)
+			dnsFilter := listenerFilters[0].(map[string]interface{})
+			assert.Equal(t, "envoy.filters.udp.dns_filter", dnsFilter["name"])
+
+			clientConfig := dnsFilter["typed_config"].(map[string]interface{})["client_config"].(map[string]interface{})
+			var resolverCluster map[string]interface{}
+			for _, cluster := range decoded.StaticResources.Clusters {
+				if cluster["name"] == valueDNSResolverCluster {
+					resolverCluster = cluster
+				}
+			}
+
+			if tt.expectedResolvers == nil {
+				assert.NotContains(t, clientConfig, "typed_dns_resolver_config")
+				assert.Nil(t, resolverCluster)
+				return
+			}
+			resolverConfig := clientConfig["typed_dns_resolver_config"].(map[string]interface{})["typed_config"].(map[string]interface{})
+			assert.Equal(t, tt.expectedResolvers, resolverConfig["resolvers"])
+			require.NotNil(t, resolverCluster)
+			assert.Equal(t, "STATIC", resolverCluster["type"])
+		})
+	}
+}
+
+func TestNewEnvoy_ConfigVolumeName(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   EnvoyConfigParams
+		expected string
+	}{
+		{
+			name:     "default",
+			expected: EnvoyConfigVolumeName,
+		},
+		{
+			name:     "custom",
+			params:   EnvoyConfigParams{ConfigVolumeName: "envoy-config-1"},
+			expected: "envoy-config-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), tt.params)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expected, envoy.GetConfigVolume().Name)
+			assert.Equal(t, tt.expected, envoy.GetInitContainer().VolumeMounts[0].Name)
+			assert.Equal(t, tt.expected, envoy.GetSidecarContainer("info", false).VolumeMounts[0].Name)
+		})
+	}
+}
+
+func TestNewEnvoy_XDSKeepalive(t *testing.T) {
+	tests := []struct {
+		name              string
+		keepalive         Keepalive
+		expectedKeepalive map[string]interface{}
+		expectError       bool
+	}{
+		{
+			name:              "defaults",
+			expectedKeepalive: map[string]interface{}{"interval": "30s", "timeout": "5s"},
+		},
+		{
+			name:              "custom interval and timeout",
+			keepalive:         Keepalive{Interval: 2 * time.Minute, Timeout: 2500 * time.Millisecond},
+			expectedKeepalive: map[string]interface{}{"interval": "120s", "timeout": "2.5s"},
+		},
+		{
+			name:              "custom interval only",
+			keepalive:         Keepalive{Interval: 10 * time.Second},
+			expectedKeepalive: map[string]interface{}{"interval": "10s", "timeout": "5s"},
+		},
+		{
+			name:        "negative timeout",
+			keepalive:   Keepalive{Timeout: -time.Second},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{
+				AgentXDSService: "xds.example.org",
+				AgentXDSPort:    18001,
+				XDSKeepalive:    tt.keepalive,
+			})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var decoded struct {
+				StaticResources struct {
+					Clusters []struct {
+						Name                          string `json:"name"`
+						TypedExtensionProtocolOptions map[string]struct {
+							ExplicitHTTPConfig struct {
+								HTTP2ProtocolOptions map[string]interface{} `json:"http2_protocol_options"`
+							} `json:"explicit_http_config"`
+						} `json:"typed_extension_protocol_options"`
+					} `json:"clusters"`
+				} `json:"static_resources"`
+			}
+			require.NoError(t, json.Unmarshal(envoy.Cfg, &decoded))
+
+			var found bool
+			for _, cluster := range decoded.StaticResources.Clusters {
+				if cluster.Name != valueXDSCluster {
+					continue
+				}
+				found = true
+				options := cluster.TypedExtensionProtocolOptions["envoy.extensions.upstreams.http.v3.HttpProtocolOptions"]
+				assert.Equal(t, tt.expectedKeepalive, options.ExplicitHTTPConfig.HTTP2ProtocolOptions["connection_keepalive"])
+			}
+			assert.True(t, found, "xDS cluster not found")
+		})
+	}
+}
+
+func TestNewEnvoy_StatsMatcher(t *testing.T) {
+	tests := []struct {
+		name          string
+		statsMatcher  *StatsMatcher
+		expectedStats map[string]interface{}
+		expectError   bool
+	}{
+		{
+			name: "omitted by default",
+		},
+		{
+			name:         "inclusion list",
+			statsMatcher: &StatsMatcher{InclusionPrefixes: []string{"cluster.xds_cluster.", "server."}},
+			expectedStats: map[string]interface{}{
+				"stats_matcher": map[string]interface{}{
+					"inclusion_list": map[string]interface{}{
+						"patterns": []interface{}{
+							map[string]interface{}{"prefix": "cluster.xds_cluster."},
+							map[string]interface{}{"prefix": "server."},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:         "exclusion list",
+			statsMatcher: &StatsMatcher{ExclusionPrefixes: []string{"http."}},
+			expectedStats: map[string]interface{}{
+				"stats_matcher": map[string]interface{}{
+					"exclusion_list": map[string]interface{}{
+						"patterns": []interface{}{
+							map[string]interface{}{"prefix": "http."},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "inclusion and exclusion lists",
+			statsMatcher: &StatsMatcher{
+				InclusionPrefixes: []string{"server."},
+				ExclusionPrefixes: []string{"http."},
+			},
+			expectError: true,
+		},
+		{
+			name:         "no prefixes",
+			statsMatcher: &StatsMatcher{},
+			expectError:  true,
+		},
+		{
+			name:         "empty prefix",
+			statsMatcher: &StatsMatcher{InclusionPrefixes: []string{""}},
+			expectError:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{StatsMatcher: tt.statsMatcher})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var decoded map[string]interface{}
+			require.NoError(t, json.Unmarshal(envoy.Cfg, &decoded))
+			if tt.expectedStats == nil {
+				assert.NotContains(t, decoded, "stats_config")
+				return
+			}
+			assert.Equal(t, tt.expectedStats, decoded["stats_config"])
+		})
+	}
+}
+
+func TestNewEnvoy_ReadinessListener(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%t", enabled), func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{ReadinessListener: enabled})
+			require.NoError(t, err)
+
+			var decoded struct {
+				StaticResources struct {
+					Listeners []map[string]interface{} `json:"listeners"`
+					Clusters  []map[string]interface{} `json:"clusters"`
+				} `json:"static_resources"`
+			}
+			require.NoError(t, json.Unmarshal(envoy.Cfg, &decoded))
+
+			var listener, cluster map[string]interface{}
+			for _, l := range decoded.StaticResources.Listeners {
+				if l["name"] == "readiness_listener" {
+					listener = l
+				}
+			}
+			for _, c := range decoded.StaticResources.Clusters {
+				if c["name"] == valueAdminCluster {
+					cluster = c
+				}
+			}
+			if !enabled {
+				assert.Nil(t, listener)
+				assert.Nil(t, cluster)
+				return
+			}
+
+			require.NotNil(t, listener)
+			require.NotNil(t, cluster)
+			listenerJSON, err := json.Marshal(listener)
+			require.NoError(t, err)
+			assert.Contains(t, string(listenerJSON), fmt.Sprintf(`"port_value":%d`, EnvoyReadinessPort))
+			assert.Contains(t, string(listenerJSON), `"match":{"path":"/ready"}`)
+			assert.Contains(t, string(listenerJSON), `"route":{"cluster":"envoy_admin"}`)
+
+			clusterJSON, err := json.Marshal(cluster)
+			require.NoError(t, err)
+			assert.Contains(t, string(clusterJSON), `"address":"127.0.0.1","port_value":9901`)
+		})
+	}
+}
+
+func TestGetStartupProbe(t *testing.T) {
+	tests := []struct {
+		timeout                  time.Duration
+		expectedFailureThreshold int32
+	}{
+		{timeout: time.Minute, expectedFailureThreshold: 60},
+		{timeout: 1500 * time.Millisecond, expectedFailureThreshold: 2},
+		{timeout: time.Millisecond, expectedFailureThreshold: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.timeout.String(), func(t *testing.T) {
+			probe := GetStartupProbe(tt.timeout)
+			require.NotNil(t, probe.HTTPGet)
+			assert.Equal(t, EnvoyReadinessPath, probe.HTTPGet.Path)
+			assert.Equal(t, int32(EnvoyReadinessPort), probe.HTTPGet.Port.IntVal)
+			assert.Equal(t, int32(1), probe.PeriodSeconds)
+			assert.Equal(t, tt.expectedFailureThreshold, probe.FailureThreshold)
+		})
+	}
+}
+
+func TestNewEnvoy_BootstrapExtensions(t *testing.T) {
+	extension := map[string]interface{}{
+		"name": "envoy.bootstrap.custom",
+		"typed_config": map[string]interface{}{
+			"@type": "type.googleapis.com/example.Custom",
+			"key":   "value",
+		},
+	}
+
+	tests := []struct {
+		name               string
+		extensions         []map[string]interface{}
+		expectedExtensions []interface{}
+		expectError        bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:               "extension",
+			extensions:         []map[string]interface{}{extension},
+			expectedExtensions: []interface{}{extension},
+		},
+		{
+			name:        "missing name",
+			extensions:  []map[string]interface{}{extension, {"typed_config": map[string]interface{}{}}},
+			expectError: true,
+		},
+		{
+			name:        "non-string name",
+			extensions:  []map[string]interface{}{{"name": 1}},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{BootstrapExtensions: tt.extensions})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var decoded map[string]interface{}
+			require.NoError(t, json.Unmarshal(envoy.Cfg, &decoded))
+			if tt.expectedExtensions == nil {
+				assert.NotContains(t, decoded, "bootstrap_extensions")
+				return
+			}
+			assert.Equal(t, tt.expectedExtensions, decoded["bootstrap_extensions"])
+		})
+	}
+}
+
+func TestNewEnvoy_ConfigVolumeMemory(t *testing.T) {
+	for _, memory := range []bool{false, true} {
+		t.Run(fmt.Sprintf("memory=%t", memory), func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{ConfigVolumeMemory: memory})
+			require.NoError(t, err)
+
+			volume := envoy.GetConfigVolume()
+			require.NotNil(t, volume.EmptyDir)
+			if memory {
+				assert.Equal(t, corev1.StorageMediumMemory, volume.EmptyDir.Medium)
+			} else {
+				assert.Equal(t, corev1.StorageMediumDefault, volume.EmptyDir.Medium)
+			}
+		})
+	}
+}
+
+func TestNewEnvoy_SocketWait(t *testing.T) {
+	tests := []struct {
+		name            string
+		timeout         time.Duration
+		expectedSeconds int
+		expectError     bool
+	}{
+		{name: "disabled"},
+		{name: "enabled", timeout: 30 * time.Second, expectedSeconds: 30},
+		{name: "rounded up", timeout: 1500 * time.Millisecond, expectedSeconds: 2},
+		{name: "negative", timeout: -time.Second, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{SocketWaitTimeout: tt.timeout})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			initContainer := envoy.GetInitContainer()
+			var mountsCSI bool
+			for _, vm := range initContainer.VolumeMounts {
+				if vm == workload.GetSPIFFEVolumeMount() {
+					mountsCSI = true
+				}
+			}
+
+			if tt.expectedSeconds == 0 {
+				assert.NotContains(t, envoy.InitScript, "until [ -S")
+				assert.False(t, mountsCSI)
+				return
+			}
+
+			assert.Contains(t, envoy.InitScript, "until [ -S "+constants.SPIFFEWLSocketPath+" ]; do")
+			assert.Contains(t, envoy.InitScript, fmt.Sprintf(`if [ "$waited" -ge %d ]; then`, tt.expectedSeconds))
+			// The socket is waited for before the rules are applied
+			assert.Less(t, strings.Index(envoy.InitScript, "until [ -S"), strings.Index(envoy.InitScript, "nft -f"))
+			assert.True(t, mountsCSI)
+		})
+	}
+}
+
+func TestNewEnvoy_InitNetRaw(t *testing.T) {
+	for _, tt := range []struct {
+		netRaw       bool
+		capabilities []corev1.Capability
+	}{
+		{netRaw: false, capabilities: []corev1.Capability{"NET_ADMIN"}},
+		{netRaw: true, capabilities: []corev1.Capability{"NET_ADMIN", "NET_RAW"}},
+	} {
+		t.Run(fmt.Sprintf("netRaw=%t", tt.netRaw), func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{InitNetRaw: tt.netRaw})
+			require.NoError(t, err)
+
+			securityContext := envoy.GetInitContainer().SecurityContext
+			require.NotNil(t, securityContext)
+			require.NotNil(t, securityContext.Capabilities)
+			assert.Equal(t, tt.capabilities, securityContext.Capabilities.Add)
+		})
+	}
+}
+
+func TestNewEnvoy_WorkloadSocketPath(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{SocketWaitTimeout: time.Minute})
+		require.NoError(t, err)
+		assert.Contains(t, string(envoy.Cfg), `"path": "`+constants.SPIFFEWLSocketPath+`"`)
+		assert.Contains(t, envoy.InitScript, "until [ -S "+constants.SPIFFEWLSocketPath+" ]; do")
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		socketPath := constants.SPIFFEWLMountPath + "/agent.sock"
+		envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{
+			SocketWaitTimeout:  time.Minute,
+			WorkloadSocketPath: socketPath,
+		})
+		require.NoError(t, err)
+		assert.Contains(t, string(envoy.Cfg), `"path": "`+socketPath+`"`)
+		assert.NotContains(t, string(envoy.Cfg), constants.SPIFFEWLSocketPath)
+		assert.Contains(t, envoy.InitScript, "until [ -S "+socketPath+" ]; do")
+	})
+
+	for _, socketPath := range []string{
+		"agent.sock",
+		"/run/agent.sock",
+		constants.SPIFFEWLMountPath,
+		constants.SPIFFEWLMountPath + "/../agent.sock",
+		constants.SPIFFEWLMountPath + "/$(id).sock",
+	} {
+		t.Run(socketPath, func(t *testing.T) {
+			_, err := NewEnvoy(context.Background(), EnvoyConfigParams{WorkloadSocketPath: socketPath})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestNewEnvoy_Admin(t *testing.T) {
+	tests := []struct {
+		name            string
+		address         string
+		port            uint32
+		expectedAddress string
+		expectedPort    uint32
+		expectedError   string
+	}{
+		{name: "default", expectedAddress: DefaultAdminAddress, expectedPort: DefaultAdminPort},
+		{name: "custom", address: "::1", port: 19901, expectedAddress: "::1", expectedPort: 19901},
+		{name: "invalid address", address: "localhost", expectedError: "invalid admin address"},
+		{name: "port out of range", port: 65536, expectedError: "must be between 1 and 65535"},
+		{name: "port used by the proxy", port: EnvoyPort, expectedError: "already used by the proxy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{AdminAddress: tt.address, AdminPort: tt.port})
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+
+			var decoded struct {
+				Admin struct {
+					Address struct {
+						SocketAddress struct {
+							Address   string `json:"address"`
+							PortValue uint32 `json:"port_value"`
+						} `json:"socket_address"`
+					} `json:"address"`
+				} `json:"admin"`
+			}
+			require.NoError(t, json.Unmarshal(envoy.Cfg, &decoded))
+			assert.Equal(t, tt.expectedAddress, decoded.Admin.Address.SocketAddress.Address)
+			assert.Equal(t, tt.expectedPort, decoded.Admin.Address.SocketAddress.PortValue)
+
+			// Traffic to the admin interface isn't redirected to Envoy
+			assert.Contains(t, envoy.InitScript, fmt.Sprintf("tcp dport %d return", tt.expectedPort))
+			if tt.expectedPort != DefaultAdminPort {
+				assert.NotContains(t, envoy.InitScript, fmt.Sprintf("tcp dport %d return", DefaultAdminPort))
+			}
+		})
+	}
+}
+
+func TestEnvoy_GetSidecarContainer_PreStop(t *testing.T) {
+	tests := []struct {
+		name        string
+		address     string
+		port        uint32
+		expectedURL string
+	}{
+		{name: "default", expectedURL: "http://127.0.0.1:9901/drain_listeners?graceful"},
+		{name: "IPv6", address: "::1", port: 19901, expectedURL: "http://[::1]:19901/drain_listeners?graceful"},
+		{name: "all addresses", address: "0.0.0.0", port: 19901, expectedURL: "http://127.0.0.1:19901/drain_listeners?graceful"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{AdminAddress: tt.address, AdminPort: tt.port})
+			require.NoError(t, err)
+
+			container := envoy.GetSidecarContainer("info", false)
+			require.NotNil(t, container.Lifecycle)
+			require.NotNil(t, container.Lifecycle.PreStop)
+			require.NotNil(t, container.Lifecycle.PreStop.Exec)
+			assert.Equal(t, []string{
+				"/bin/sh", "-c",
+				fmt.Sprintf("curl -sf -X POST '%s'; sleep %d", tt.expectedURL, EnvoyDrainSeconds),
+			}, container.Lifecycle.PreStop.Exec.Command)
+		})
+	}
+}
+
+func TestNewEnvoy_ExcludeDestinationCIDRs(t *testing.T) {
+	tests := []struct {
+		name          string
+		cidrs         []string
+		expectedRules []string
+		expectError   bool
+	}{
+		{name: "none"},
+		{
+			name:          "IPv4 and IPv6",
+			cidrs:         []string{"10.96.0.0/12", "fd00::/8"},
+			expectedRules: []string{"ip daddr 10.96.0.0/12 return", "ip6 daddr fd00::/8 return"},
+		},
+		{
+			name:          "normalised",
+			cidrs:         []string{"10.96.0.1/12"},
+			expectedRules: []string{"ip daddr 10.96.0.0/12 return"},
+		},
+		{name: "address", cidrs: []string{"10.96.0.1"}, expectError: true},
+		{name: "invalid", cidrs: []string{"cluster"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{ExcludeDestinationCIDRs: tt.cidrs})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			if len(tt.expectedRules) == 0 {
+				assert.NotContains(t, envoy.InitScript, "Skip traffic to excluded destinations")
+				return
+			}
+
+			// The excluded destinations are skipped before any traffic is redirected
+			dnsRedirect := strings.Index(envoy.InitScript, "DNS UDP to Envoy")
+			for _, rule := range tt.expectedRules {
+				assert.Contains(t, envoy.InitScript, rule)
+				assert.Less(t, strings.Index(envoy.InitScript, rule), dnsRedirect)
+			}
+		})
+	}
+}
+
+func TestNewEnvoy_IPFamily(t *testing.T) {
+	const (
+		ipv4Loopback = `ip daddr 127.0.0.1/8 tcp dport 1-65535 counter redirect to :10000`
+		ipv6Loopback = `ip6 daddr ::1/128 tcp dport 1-65535 counter redirect to :10000`
+	)
+	tests := []struct {
+		name            string
+		family          string
+		expectedRules   []string
+		unexpectedRules []string
+		expectError     bool
+	}{
+		{
+			name:   "default",
+			family: "",
+			expectedRules: []string{
+				ipv4Loopback,
+				ipv6Loopback,
+				"        udp dport 53 counter redirect to :15053",
+				"ip daddr 10.96.0.0/12 return",
+				"ip6 daddr fd00::/8 return",
+			},
+			unexpectedRules: []string{"meta nfproto"},
+		},
+		{
+			name:   "dual",
+			family: IPFamilyDual,
+			expectedRules: []string{
+				ipv4Loopback,
+				ipv6Loopback,
+				"        udp dport 53 counter redirect to :15053",
+				"ip daddr 10.96.0.0/12 return",
+				"ip6 daddr fd00::/8 return",
+			},
+			unexpectedRules: []string{"meta nfproto"},
+		},
+		{
+			name:   "IPv4",
+			family: IPFamilyIPv4,
+			expectedRules: []string{
+				ipv4Loopback,
+				"meta nfproto ipv4 udp dport 53 counter redirect to :15053",
+				"meta nfproto ipv4 tcp dport 53 counter redirect to :15053",
+				"ip daddr 10.96.0.0/12 return",
+			},
+			unexpectedRules: []string{ipv6Loopback, "ip6 daddr", "ipv6"},
+		},
+		{
+			name:   "IPv6",
+			family: IPFamilyIPv6,
+			expectedRules: []string{
+				ipv6Loopback,
+				"meta nfproto ipv6 udp dport 53 counter redirect to :15053",
+				"meta nfproto ipv6 tcp dport 53 counter redirect to :15053",
+				"ip6 daddr fd00::/8 return",
+			},
+			unexpectedRules: []string{ipv4Loopback, "ip daddr", "ipv4"},
+		},
+		{name: "invalid", family: "ipv5", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{
+				IPFamily:                tt.family,
+				ExcludeDestinationCIDRs: []string{"10.96.0.0/12", "fd00::/8"},
+				// Resolvers so that TCP DNS requests are also redirected
+				DNSProxy: &DNSProxy{Resolvers: []string{"10.96.0.10"}},
+			})
+			if tt.expectError {
+				require.ErrorContains(t, err, "invalid IP family")
+				return
+			}
+			require.NoError(t, err)
+
+			for _, rule := range tt.expectedRules {
+				assert.Contains(t, envoy.InitScript, rule)
+			}
+			for _, rule := range tt.unexpectedRules {
+				assert.NotContains(t, envoy.InitScript, rule)
+			}
+		})
+	}
+}
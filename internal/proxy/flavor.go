@@ -0,0 +1,37 @@
+package proxy
+
+import "slices"
+
+// Proxy flavors, which adjust the traffic capture rules to the proxy distribution
+const (
+	// ProxyFlavorEnvoy is the default: all loopback TCP traffic other than to Envoy itself is
+	// redirected to Envoy
+	ProxyFlavorEnvoy = "envoy"
+	// ProxyFlavorIstio is for Istio's proxy, whose agent and Envoy listen on well-known ports that
+	// apps and tooling in the pod connect to directly, so traffic to those ports isn't redirected
+	ProxyFlavorIstio = "istio"
+)
+
+// ProxyFlavors are the supported proxy flavors
+var ProxyFlavors = []string{ProxyFlavorEnvoy, ProxyFlavorIstio}
+
+// istioExcludedPorts are the ports of Istio's proxy that are excluded from redirection, as Istio's
+// own init logic excludes them: the Envoy admin (15000), outbound (15001) and inbound (15006)
+// capture ports, the agent's merged metrics and health ports (15020, 15021) and Envoy's
+// Prometheus stats (15090)
+var istioExcludedPorts = []int{15000, 15001, 15006, 15020, 15021, 15090}
+
+// excludedPorts returns the loopback destination ports that the flavor excludes from redirection,
+// followed by the extra excluded ports that the flavor doesn't already exclude
+func excludedPorts(flavor string, extra []int) []int {
+	var ports []int
+	if flavor == ProxyFlavorIstio {
+		ports = slices.Clone(istioExcludedPorts)
+	}
+	for _, port := range extra {
+		if !slices.Contains(ports, port) {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEnvoy_Flavor(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		flavor        string
+		expectExclude bool
+		expectError   bool
+	}{
+		{name: "default"},
+		{name: "envoy", flavor: ProxyFlavorEnvoy},
+		{name: "istio", flavor: ProxyFlavorIstio, expectExclude: true},
+		{name: "invalid", flavor: "linkerd", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{Flavor: tt.flavor})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			// Both flavors redirect the remaining loopback traffic to Envoy
+			assert.Contains(t, envoy.InitScript, fmt.Sprintf("counter redirect to :%d", EnvoyPort))
+			if !tt.expectExclude {
+				assert.NotContains(t, envoy.InitScript, "Skip traffic to excluded ports")
+				return
+			}
+
+			// The excluded ports are skipped before the loopback traffic is redirected
+			for _, port := range istioExcludedPorts {
+				rule := fmt.Sprintf("tcp dport %d return", port)
+				assert.Contains(t, envoy.InitScript, rule)
+				assert.Less(t, strings.Index(envoy.InitScript, rule), strings.Index(envoy.InitScript, "Loopback IPv4 to Envoy"))
+			}
+		})
+	}
+}
+
+func TestNewEnvoy_ExcludeOutboundPorts(t *testing.T) {
+	tests := []struct {
+		name          string
+		flavor        string
+		ports         []int
+		expectedPorts []int
+		expectError   bool
+	}{
+		{name: "ports", ports: []int{9090, 8125}, expectedPorts: []int{9090, 8125}},
+		{name: "with flavor", flavor: ProxyFlavorIstio, ports: []int{15020, 9090}, expectedPorts: []int{15000, 15001, 15006, 15020, 15021, 15090, 9090}},
+		{name: "zero", ports: []int{0}, expectError: true},
+		{name: "too large", ports: []int{65536}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{Flavor: tt.flavor, ExcludeOutboundPorts: tt.ports})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			// There is one return rule per excluded port, before the loopback traffic is redirected
+			redirect := strings.Index(envoy.InitScript, "Loopback IPv4 to Envoy")
+			for _, port := range tt.expectedPorts {
+				rule := fmt.Sprintf("tcp dport %d return", port)
+				assert.Equal(t, 1, strings.Count(envoy.InitScript, rule), rule)
+				assert.Less(t, strings.Index(envoy.InitScript, rule), redirect)
+			}
+		})
+	}
+}
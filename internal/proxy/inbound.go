@@ -0,0 +1,116 @@
+package proxy
+
+import "fmt"
+
+const (
+	valueInboundCluster = "inbound_app_cluster"
+	// The names of the pod's X.509-SVID and its trust domain's bundle in the agent's SDS API
+	valueSDSDefaultSVID   = "default"
+	valueSDSDefaultBundle = "ROOTCA"
+)
+
+// ValidateInbound checks the inbound mTLS ports. They must be set together, and the inbound port
+// must not be one already used by the proxy.
+func (p *EnvoyConfigParams) ValidateInbound() error {
+	if p.InboundPort == 0 {
+		if p.ApplicationPort != 0 {
+			return fmt.Errorf("the application port requires the inbound port")
+		}
+		return nil
+	}
+
+	if p.InboundPort > 65535 {
+		return fmt.Errorf("invalid inbound port %d: must be between 1 and 65535", p.InboundPort)
+	}
+	adminPort := p.AdminPort
+	if adminPort == 0 {
+		adminPort = DefaultAdminPort
+	}
+	for _, port := range []uint32{EnvoyPort, DNSProxyPort, EnvoyReadinessPort, EnvoyJWTAuthnPort, adminPort} {
+		if p.InboundPort == port {
+			return fmt.Errorf("invalid inbound port %d: already used by the proxy", p.InboundPort)
+		}
+	}
+	if p.ApplicationPort == 0 || p.ApplicationPort > 65535 {
+		return fmt.Errorf("invalid application port %d: must be between 1 and 65535", p.ApplicationPort)
+	}
+	if p.ApplicationPort == p.InboundPort {
+		return fmt.Errorf("invalid application port %d: must not be the inbound port", p.ApplicationPort)
+	}
+	return nil
+}
+
+// inboundListener returns a listener that terminates mTLS with the pod's X.509-SVID, requiring
+// clients to present an SVID from the trust domain, and forwards the decrypted connections to the
+// app. The SVID and bundle are fetched over SDS from the agent's Workload API socket.
+func (p *EnvoyConfigParams) inboundListener() map[string]interface{} {
+	return map[string]interface{}{
+		"name": "inbound_listener",
+		keyAddress: map[string]interface{}{
+			"socket_address": map[string]interface{}{
+				keyAddress:    "::",
+				"port_value":  p.InboundPort,
+				"ipv4_compat": true,
+			},
+		},
+		"filter_chains": []interface{}{
+			map[string]interface{}{
+				"transport_socket": map[string]interface{}{
+					"name": "envoy.transport_sockets.tls",
+					"typed_config": map[string]interface{}{
+						"@type":                      "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.DownstreamTlsContext",
+						"require_client_certificate": true,
+						"common_tls_context": map[string]interface{}{
+							"tls_certificate_sds_secret_configs": []interface{}{
+								sdsSecretConfig(valueSDSDefaultSVID),
+							},
+							"validation_context_sds_secret_config": sdsSecretConfig(valueSDSDefaultBundle),
+						},
+					},
+				},
+				"filters": []interface{}{
+					map[string]interface{}{
+						"name": "envoy.filters.network.tcp_proxy",
+						"typed_config": map[string]interface{}{
+							"@type":       "type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy",
+							"stat_prefix": "inbound",
+							"cluster":     valueInboundCluster,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// inboundCluster returns a cluster that connects to the app on loopback
+func (p *EnvoyConfigParams) inboundCluster() map[string]interface{} {
+	return map[string]interface{}{
+		"name":            valueInboundCluster,
+		"type":            "STATIC",
+		"connect_timeout": "1s",
+		"load_assignment": map[string]interface{}{
+			keyClusterName: valueInboundCluster,
+			"endpoints":    []interface{}{lbEndpoint("127.0.0.1", p.ApplicationPort)},
+		},
+	}
+}
+
+// sdsSecretConfig returns the config of a secret fetched from the SDS cluster
+func sdsSecretConfig(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"name": name,
+		"sds_config": map[string]interface{}{
+			"resource_api_version": "V3",
+			"api_config_source": map[string]interface{}{
+				"api_type":              "GRPC",
+				"transport_api_version": "V3",
+				"grpc_services": []interface{}{
+					map[string]interface{}{
+						"envoy_grpc": map[string]interface{}{keyClusterName: valueSDSCluster},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEnvoy_Inbound(t *testing.T) {
+	t.Run("listener and cluster", func(t *testing.T) {
+		envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{InboundPort: 15443, ApplicationPort: 8080})
+		require.NoError(t, err)
+
+		var cfg struct {
+			StaticResources struct {
+				Listeners []map[string]interface{} `json:"listeners"`
+				Clusters  []map[string]interface{} `json:"clusters"`
+			} `json:"static_resources"`
+		}
+		require.NoError(t, json.Unmarshal(envoy.Cfg, &cfg))
+
+		var listener map[string]interface{}
+		for _, l := range cfg.StaticResources.Listeners {
+			if l["name"] == "inbound_listener" {
+				listener = l
+			}
+		}
+		require.NotNil(t, listener)
+		socketAddress := listener["address"].(map[string]interface{})["socket_address"].(map[string]interface{})
+		assert.Equal(t, float64(15443), socketAddress["port_value"])
+
+		// The filter chain terminates mTLS with the SVID and bundle from SDS
+		filterChain := listener["filter_chains"].([]interface{})[0].(map[string]interface{})
+		transportSocket := filterChain["transport_socket"].(map[string]interface{})
+		assert.Equal(t, "envoy.transport_sockets.tls", transportSocket["name"])
+		tlsContext := transportSocket["typed_config"].(map[string]interface{})
+		assert.Equal(t, "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.DownstreamTlsContext", tlsContext["@type"])
+		assert.Equal(t, true, tlsContext["require_client_certificate"])
+		commonTLSContext := tlsContext["common_tls_context"].(map[string]interface{})
+		certificate := commonTLSContext["tls_certificate_sds_secret_configs"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, valueSDSDefaultSVID, certificate["name"])
+		assert.Contains(t, string(mustMarshal(t, certificate)), valueSDSCluster)
+		validation := commonTLSContext["validation_context_sds_secret_config"].(map[string]interface{})
+		assert.Equal(t, valueSDSDefaultBundle, validation["name"])
+
+		// The decrypted connections are forwarded to the app
+		filter := filterChain["filters"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, valueInboundCluster, filter["typed_config"].(map[string]interface{})["cluster"])
+
+		var cluster map[string]interface{}
+		for _, c := range cfg.StaticResources.Clusters {
+			if c["name"] == valueInboundCluster {
+				cluster = c
+			}
+		}
+		require.NotNil(t, cluster)
+		assert.Contains(t, string(mustMarshal(t, cluster)), `"port_value":8080`)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{})
+		require.NoError(t, err)
+		assert.NotContains(t, string(envoy.Cfg), "inbound_listener")
+		assert.NotContains(t, string(envoy.Cfg), valueInboundCluster)
+	})
+
+	for name, params := range map[string]EnvoyConfigParams{
+		"application port without inbound port": {ApplicationPort: 8080},
+		"no application port":                   {InboundPort: 15443},
+		"inbound port too large":                {InboundPort: 65536, ApplicationPort: 8080},
+		"application port too large":            {InboundPort: 15443, ApplicationPort: 65536},
+		"same ports":                            {InboundPort: 8080, ApplicationPort: 8080},
+		"inbound port used by the proxy":        {InboundPort: EnvoyPort, ApplicationPort: 8080},
+		"inbound port used by the admin":        {InboundPort: 9000, ApplicationPort: 8080, AdminPort: 9000},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewEnvoy(context.Background(), params)
+			require.Error(t, err)
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return data
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import "fmt"
+
+// IP families of the nftables rules, for nodes that only have IPv4 or IPv6 networking
+const (
+	// IPFamilyDual is the default: the rules redirect both IPv4 and IPv6 traffic
+	IPFamilyDual = "dual"
+	IPFamilyIPv4 = "ipv4"
+	IPFamilyIPv6 = "ipv6"
+)
+
+// IPFamilies are the supported IP families
+var IPFamilies = []string{IPFamilyDual, IPFamilyIPv4, IPFamilyIPv6}
+
+// IPv4 reports whether the rules redirect IPv4 traffic
+func (p NftablesParams) IPv4() bool {
+	return p.IPFamily != IPFamilyIPv6
+}
+
+// IPv6 reports whether the rules redirect IPv6 traffic
+func (p NftablesParams) IPv6() bool {
+	return p.IPFamily != IPFamilyIPv4
+}
+
+// FamilyMatch returns an expression that restricts a family-agnostic rule, such as the DNS
+// redirects, to the IP family; it is empty for both families
+func (p NftablesParams) FamilyMatch() string {
+	switch p.IPFamily {
+	case IPFamilyIPv4, IPFamilyIPv6:
+		return fmt.Sprintf("meta nfproto %s ", p.IPFamily)
+	default:
+		return ""
+	}
+}
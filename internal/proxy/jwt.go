@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// EnvoyJWTAuthnPort is the port of the listener that validates the JWT-SVIDs of incoming requests
+const EnvoyJWTAuthnPort = 15008
+
+const (
+	valueJWKSCluster     = "jwks_cluster"
+	valueJWTAppCluster   = "jwt_app_cluster"
+	valueJWTProviderName = "spiffe"
+)
+
+// JWTAuthn configures a listener on EnvoyJWTAuthnPort that validates the JWT-SVID in the
+// Authorization header of incoming requests, and forwards valid requests to the app
+type JWTAuthn struct {
+	// Audiences are the accepted audiences; a JWT-SVID must have at least one of them
+	Audiences []string
+	// JWKSURI is the HTTPS URI of the trust domain's JWT bundle in JWKS format, eg served by the
+	// SPIRE OIDC discovery provider
+	JWKSURI string
+	// AppPort is the port on loopback that validated requests are forwarded to
+	AppPort uint32
+	// Forward keeps the JWT-SVID in the forwarded requests; by default it is removed
+	Forward bool
+}
+
+// Validate checks that the JWT authentication config is complete
+func (j *JWTAuthn) Validate() error {
+	if len(j.Audiences) == 0 {
+		return fmt.Errorf("invalid JWT authentication: at least one audience must be set")
+	}
+	for _, audience := range j.Audiences {
+		if audience == "" {
+			return fmt.Errorf("invalid JWT authentication: audiences must not be empty")
+		}
+	}
+
+	jwksURI, err := url.Parse(j.JWKSURI)
+	if err != nil || jwksURI.Scheme != "https" || jwksURI.Hostname() == "" {
+		return fmt.Errorf("invalid JWKS URI %q: must be an https URI", j.JWKSURI)
+	}
+	if _, err := jwksPort(jwksURI); err != nil {
+		return fmt.Errorf("invalid JWKS URI %q: %w", j.JWKSURI, err)
+	}
+
+	if j.AppPort == 0 || j.AppPort > 65535 {
+		return fmt.Errorf("invalid JWT authentication app port %d: must be between 1 and 65535", j.AppPort)
+	}
+	if j.AppPort == EnvoyJWTAuthnPort {
+		return fmt.Errorf("invalid JWT authentication app port %d: must not be the JWT authentication port", j.AppPort)
+	}
+	return nil
+}
+
+// listener returns the listener that validates JWT-SVIDs with the jwt_authn filter
+func (j *JWTAuthn) listener() map[string]interface{} {
+	audiences := make([]interface{}, 0, len(j.Audiences))
+	for _, audience := range j.Audiences {
+		audiences = append(audiences, audience)
+	}
+
+	return map[string]interface{}{
+		"name": "jwt_authn_listener",
+		keyAddress: map[string]interface{}{
+			"socket_address": map[string]interface{}{
+				keyAddress:    "::",
+				"port_value":  EnvoyJWTAuthnPort,
+				"ipv4_compat": true,
+			},
+		},
+		"filter_chains": []interface{}{
+			map[string]interface{}{
+				"filters": []interface{}{
+					map[string]interface{}{
+						"name": "envoy.filters.network.http_connection_manager",
+						"typed_config": map[string]interface{}{
+							"@type":       "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+							"stat_prefix": "jwt_authn",
+							"codec_type":  "AUTO",
+							"route_config": map[string]interface{}{
+								"name": "jwt_authn",
+								"virtual_hosts": []interface{}{
+									map[string]interface{}{
+										"name":    "jwt_authn",
+										"domains": []interface{}{"*"},
+										"routes": []interface{}{
+											map[string]interface{}{
+												"match": map[string]interface{}{"prefix": "/"},
+												"route": map[string]interface{}{"cluster": valueJWTAppCluster},
+											},
+										},
+									},
+								},
+							},
+							"http_filters": []interface{}{
+								map[string]interface{}{
+									"name": "envoy.filters.http.jwt_authn",
+									"typed_config": map[string]interface{}{
+										"@type": "type.googleapis.com/envoy.extensions.filters.http.jwt_authn.v3.JwtAuthentication",
+										// JWT-SVIDs have no fixed issuer, so only the signature, expiry and
+										// audience are checked
+										"providers": map[string]interface{}{
+											valueJWTProviderName: map[string]interface{}{
+												"audiences": audiences,
+												"forward":   j.Forward,
+												"remote_jwks": map[string]interface{}{
+													"http_uri": map[string]interface{}{
+														"uri":     j.JWKSURI,
+														"cluster": valueJWKSCluster,
+														"timeout": "5s",
+													},
+													"cache_duration": "300s",
+												},
+											},
+										},
+										"rules": []interface{}{
+											map[string]interface{}{
+												"match":    map[string]interface{}{"prefix": "/"},
+												"requires": map[string]interface{}{"provider_name": valueJWTProviderName},
+											},
+										},
+									},
+								},
+								map[string]interface{}{
+									"name": "envoy.filters.http.router",
+									"typed_config": map[string]interface{}{
+										"@type": "type.googleapis.com/envoy.extensions.filters.http.router.v3.Router",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// clusters returns the clusters for the JWKS URI and the app. The URI must have been validated.
+func (j *JWTAuthn) clusters() []map[string]interface{} {
+	jwksURI, _ := url.Parse(j.JWKSURI)
+	host := jwksURI.Hostname()
+	port, _ := jwksPort(jwksURI)
+
+	jwksCluster := map[string]interface{}{
+		"name":            valueJWKSCluster,
+		"type":            "LOGICAL_DNS",
+		"connect_timeout": "5s",
+		"load_assignment": map[string]interface{}{
+			keyClusterName: valueJWKSCluster,
+			"endpoints":    []interface{}{lbEndpoint(host, port)},
+		},
+		"transport_socket": map[string]interface{}{
+			"name": "envoy.transport_sockets.tls",
+			"typed_config": map[string]interface{}{
+				"@type": "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext",
+				"sni":   host,
+			},
+		},
+	}
+
+	appCluster := map[string]interface{}{
+		"name":            valueJWTAppCluster,
+		"type":            "STATIC",
+		"connect_timeout": "1s",
+		"load_assignment": map[string]interface{}{
+			keyClusterName: valueJWTAppCluster,
+			"endpoints":    []interface{}{lbEndpoint("127.0.0.1", j.AppPort)},
+		},
+	}
+
+	return []map[string]interface{}{jwksCluster, appCluster}
+}
+
+// jwksPort returns the port of the JWKS URI, which defaults to the HTTPS port
+func jwksPort(jwksURI *url.URL) (uint32, error) {
+	if jwksURI.Port() == "" {
+		return 443, nil
+	}
+	port, err := strconv.ParseUint(jwksURI.Port(), 10, 16)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("port must be between 1 and 65535")
+	}
+	return uint32(port), nil
+}
+
+// lbEndpoint returns a locality of endpoints with the single address host:port
+func lbEndpoint(host string, port uint32) map[string]interface{} {
+	return map[string]interface{}{
+		"lb_endpoints": []interface{}{
+			map[string]interface{}{
+				"endpoint": map[string]interface{}{
+					keyAddress: map[string]interface{}{
+						"socket_address": map[string]interface{}{
+							keyAddress:   host,
+							"port_value": port,
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEnvoy_JWTAuthn(t *testing.T) {
+	tests := []struct {
+		name             string
+		jwtAuthn         *JWTAuthn
+		expectedProvider map[string]interface{}
+		expectedJWKSHost string
+		expectedJWKSPort float64
+		expectError      bool
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name: "audiences and JWKS URI",
+			jwtAuthn: &JWTAuthn{
+				Audiences: []string{"spiffe://example.org/api", "api"},
+				JWKSURI:   "https://oidc.example.org/keys",
+				AppPort:   8080,
+			},
+			expectedProvider: map[string]interface{}{
+				"audiences": []interface{}{"spiffe://example.org/api", "api"},
+				"forward":   false,
+				"remote_jwks": map[string]interface{}{
+					"http_uri": map[string]interface{}{
+						"uri":     "https://oidc.example.org/keys",
+						"cluster": valueJWKSCluster,
+						"timeout": "5s",
+					},
+					"cache_duration": "300s",
+				},
+			},
+			expectedJWKSHost: "oidc.example.org",
+			expectedJWKSPort: 443,
+		},
+		{
+			name: "forward and JWKS port",
+			jwtAuthn: &JWTAuthn{
+				Audiences: []string{"api"},
+				JWKSURI:   "https://oidc.example.org:8443/keys",
+				AppPort:   8080,
+				Forward:   true,
+			},
+			expectedProvider: map[string]interface{}{
+				"audiences": []interface{}{"api"},
+				"forward":   true,
+				"remote_jwks": map[string]interface{}{
+					"http_uri": map[string]interface{}{
+						"uri":     "https://oidc.example.org:8443/keys",
+						"cluster": valueJWKSCluster,
+						"timeout": "5s",
+					},
+					"cache_duration": "300s",
+				},
+			},
+			expectedJWKSHost: "oidc.example.org",
+			expectedJWKSPort: 8443,
+		},
+		{
+			name:        "no audiences",
+			jwtAuthn:    &JWTAuthn{JWKSURI: "https://oidc.example.org/keys", AppPort: 8080},
+			expectError: true,
+		},
+		{
+			name:        "empty audience",
+			jwtAuthn:    &JWTAuthn{Audiences: []string{""}, JWKSURI: "https://oidc.example.org/keys", AppPort: 8080},
+			expectError: true,
+		},
+		{
+			name:        "http JWKS URI",
+			jwtAuthn:    &JWTAuthn{Audiences: []string{"api"}, JWKSURI: "http://oidc.example.org/keys", AppPort: 8080},
+			expectError: true,
+		},
+		{
+			name:        "invalid JWKS port",
+			jwtAuthn:    &JWTAuthn{Audiences: []string{"api"}, JWKSURI: "https://oidc.example.org:99999/keys", AppPort: 8080},
+			expectError: true,
+		},
+		{
+			name:        "no app port",
+			jwtAuthn:    &JWTAuthn{Audiences: []string{"api"}, JWKSURI: "https://oidc.example.org/keys"},
+			expectError: true,
+		},
+		{
+			name:        "app port is the JWT authentication port",
+			jwtAuthn:    &JWTAuthn{Audiences: []string{"api"}, JWKSURI: "https://oidc.example.org/keys", AppPort: EnvoyJWTAuthnPort},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{JWTAuthn: tt.jwtAuthn})
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var decoded struct {
+				StaticResources struct {
+					Listeners []map[string]interface{} `json:"listeners"`
+					Clusters  []map[string]interface{} `json:"clusters"`
+				} `json:"static_resources"`
+			}
+			require.NoError(t, json.Unmarshal(envoy.Cfg, &decoded))
+
+			var listener map[string]interface{}
+			for _, l := range decoded.StaticResources.Listeners {
+				if l["name"] == "jwt_authn_listener" {
+					listener = l
+				}
+			}
+			clusters := make(map[string]map[string]interface{})
+			for _, c := range decoded.StaticResources.Clusters {
+				clusters[c["name"].(string)] = c
+			}
+
+			if tt.jwtAuthn == nil {
+				assert.Nil(t, listener)
+				assert.NotContains(t, clusters, valueJWKSCluster)
+				assert.NotContains(t, clusters, valueJWTAppCluster)
+				return
+			}
+
+			require.NotNil(t, listener)
+			hcm := listener["filter_chains"].([]interface{})[0].(map[string]interface{})["filters"].([]interface{})[0].(map[string]interface{})["typed_config"].(map[string]interface{})
+			jwtFilter := hcm["http_filters"].([]interface{})[0].(map[string]interface{})
+			assert.Equal(t, "envoy.filters.http.jwt_authn", jwtFilter["name"])
+			providers := jwtFilter["typed_config"].(map[string]interface{})["providers"].(map[string]interface{})
+			assert.Equal(t, tt.expectedProvider, providers[valueJWTProviderName])
+
+			require.Contains(t, clusters, valueJWKSCluster)
+			jwksCluster, err := json.Marshal(clusters[valueJWKSCluster])
+			require.NoError(t, err)
+			var jwks struct {
+				LoadAssignment struct {
+					Endpoints []struct {
+						LBEndpoints []struct {
+							Endpoint struct {
+								Address struct {
+									SocketAddress struct {
+										Address   string  `json:"address"`
+										PortValue float64 `json:"port_value"`
+									} `json:"socket_address"`
+								} `json:"address"`
+							} `json:"endpoint"`
+						} `json:"lb_endpoints"`
+					} `json:"endpoints"`
+				} `json:"load_assignment"`
+				TransportSocket struct {
+					TypedConfig struct {
+						SNI string `json:"sni"`
+					} `json:"typed_config"`
+				} `json:"transport_socket"`
+			}
+			require.NoError(t, json.Unmarshal(jwksCluster, &jwks))
+			socketAddress := jwks.LoadAssignment.Endpoints[0].LBEndpoints[0].Endpoint.Address.SocketAddress
+			assert.Equal(t, tt.expectedJWKSHost, socketAddress.Address)
+			assert.Equal(t, tt.expectedJWKSPort, socketAddress.PortValue)
+			assert.Equal(t, tt.expectedJWKSHost, jwks.TransportSocket.TypedConfig.SNI)
+
+			require.Contains(t, clusters, valueJWTAppCluster)
+			appCluster, err := json.Marshal(clusters[valueJWTAppCluster])
+			require.NoError(t, err)
+			assert.Contains(t, string(appCluster), `"address":"127.0.0.1","port_value":8080`)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cofide/spiffe-enable/internal/workload"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Proxy size profiles, selected with the proxy-size annotation
+const (
+	ProxySizeSmall  = "small"
+	ProxySizeMedium = "medium"
+	ProxySizeLarge  = "large"
+)
+
+// ProxySizeProfiles are the preset resource requests and limits for the Envoy sidecar
+var ProxySizeProfiles = map[string]corev1.ResourceRequirements{
+	ProxySizeSmall:  newResourceRequirements("50m", "64Mi", "200m", "128Mi"),
+	ProxySizeMedium: newResourceRequirements("100m", "128Mi", "500m", "256Mi"),
+	ProxySizeLarge:  newResourceRequirements("250m", "256Mi", "1", "512Mi"),
+}
+
+func newResourceRequirements(cpuRequest, memoryRequest, cpuLimit, memoryLimit string) corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpuRequest),
+			corev1.ResourceMemory: resource.MustParse(memoryRequest),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpuLimit),
+			corev1.ResourceMemory: resource.MustParse(memoryLimit),
+		},
+	}
+}
+
+// DefaultSidecarResources are the Envoy sidecar's resources if neither a size profile nor explicit
+// resources are set. Only requests are set, so that the sidecar isn't BestEffort, and so first to
+// be evicted, without risking it being throttled or OOM-killed by a limit.
+var DefaultSidecarResources = corev1.ResourceRequirements{
+	Requests: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("50m"),
+		corev1.ResourceMemory: resource.MustParse("64Mi"),
+	},
+}
+
+// GetSidecarResources returns the resources for the Envoy sidecar. Explicit resources, in either
+// of the formats accepted by workload.ParseResources, take precedence over a size profile. If
+// neither is set, DefaultSidecarResources are returned.
+func GetSidecarResources(size, explicit string) (corev1.ResourceRequirements, error) {
+	if explicit != "" {
+		resources, err := workload.ParseResources(explicit)
+		if err != nil {
+			return corev1.ResourceRequirements{}, fmt.Errorf("invalid proxy resources: %w", err)
+		}
+		return resources, nil
+	}
+
+	if size == "" {
+		return *DefaultSidecarResources.DeepCopy(), nil
+	}
+
+	resources, ok := ProxySizeProfiles[size]
+	if !ok {
+		sizes := make([]string, 0, len(ProxySizeProfiles))
+		for name := range ProxySizeProfiles {
+			sizes = append(sizes, name)
+		}
+		sort.Strings(sizes)
+		return corev1.ResourceRequirements{}, fmt.Errorf("invalid proxy size %q: must be one of %v", size, sizes)
+	}
+	return *resources.DeepCopy(), nil
+}
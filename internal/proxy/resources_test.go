@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestGetSidecarResources(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     string
+		explicit string
+		expected corev1.ResourceRequirements
+		wantErr  bool
+	}{
+		{
+			name:     "default",
+			expected: DefaultSidecarResources,
+		},
+		{
+			name:     "small",
+			size:     ProxySizeSmall,
+			expected: newResourceRequirements("50m", "64Mi", "200m", "128Mi"),
+		},
+		{
+			name:     "medium",
+			size:     ProxySizeMedium,
+			expected: newResourceRequirements("100m", "128Mi", "500m", "256Mi"),
+		},
+		{
+			name:     "large",
+			size:     ProxySizeLarge,
+			expected: newResourceRequirements("250m", "256Mi", "1", "512Mi"),
+		},
+		{
+			name:     "explicit resources override size",
+			size:     ProxySizeLarge,
+			explicit: `{"requests":{"cpu":"10m"}}`,
+			expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10m")},
+			},
+		},
+		{
+			name:     "explicit resources as a list",
+			explicit: "cpu=100m,memory=64Mi,limits.memory=128Mi",
+			expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("100m"),
+					corev1.ResourceMemory: resource.MustParse("64Mi"),
+				},
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+			},
+		},
+		{
+			name:     "explicit empty resources",
+			explicit: "{}",
+		},
+		{
+			name:    "unknown size",
+			size:    "huge",
+			wantErr: true,
+		},
+		{
+			name:     "invalid explicit resources",
+			explicit: `{"requests":`,
+			wantErr:  true,
+		},
+		{
+			name:     "unsupported resource",
+			explicit: "ephemeral-storage=1Gi",
+			wantErr:  true,
+		},
+		{
+			name:     "invalid quantity",
+			explicit: "cpu=lots",
+			wantErr:  true,
+		},
+		{
+			name:     "missing quantity",
+			explicit: "cpu",
+			wantErr:  true,
+		},
+		{
+			name:     "duplicate resource",
+			explicit: "memory=64Mi,requests.memory=128Mi",
+			wantErr:  true,
+		},
+		{
+			name:     "request exceeds limit",
+			explicit: "memory=256Mi,limits.memory=128Mi",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resources, err := GetSidecarResources(tt.size, tt.explicit)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, equalResourceLists(tt.expected.Requests, resources.Requests), "requests: %v", resources.Requests)
+			assert.True(t, equalResourceLists(tt.expected.Limits, resources.Limits), "limits: %v", resources.Limits)
+		})
+	}
+}
+
+func equalResourceLists(expected, actual corev1.ResourceList) bool {
+	if len(expected) != len(actual) {
+		return false
+	}
+	for name, quantity := range expected {
+		actualQuantity, ok := actual[name]
+		if !ok || actualQuantity.Cmp(quantity) != 0 {
+			return false
+		}
+	}
+	return true
+}
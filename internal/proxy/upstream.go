@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+// Upstream is a service that Envoy originates mTLS to with the pod's X.509-SVID, accepting only
+// a server that presents the expected SPIFFE ID
+type Upstream struct {
+	Host     string
+	Port     uint32
+	SPIFFEID string
+}
+
+// ParseUpstreams parses a comma-delimited list of upstreams, each in the form
+// host:port=spiffe://trust-domain/path
+func ParseUpstreams(value string) ([]Upstream, error) {
+	var upstreams []Upstream
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		address, id, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid upstream %q: must be in the form host:port=spiffe-id", entry)
+		}
+		host, portValue, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream %q: %w", entry, err)
+		}
+		port, err := strconv.ParseUint(portValue, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream %q: port must be a number", entry)
+		}
+		upstreams = append(upstreams, Upstream{Host: host, Port: uint32(port), SPIFFEID: id})
+	}
+	return upstreams, nil
+}
+
+// ValidateUpstreams checks that each upstream has a host, a port and a valid SPIFFE ID, and that
+// no address is listed twice
+func ValidateUpstreams(upstreams []Upstream) error {
+	seen := make(map[string]bool, len(upstreams))
+	for _, upstream := range upstreams {
+		if upstream.Host == "" {
+			return fmt.Errorf("invalid upstream: must have a host")
+		}
+		if upstream.Port == 0 || upstream.Port > 65535 {
+			return fmt.Errorf("invalid upstream port %d: must be between 1 and 65535", upstream.Port)
+		}
+		if _, err := spiffeid.FromString(upstream.SPIFFEID); err != nil {
+			return fmt.Errorf("invalid SPIFFE ID %q of upstream %s: %w", upstream.SPIFFEID, upstream.address(), err)
+		}
+		if seen[upstream.address()] {
+			return fmt.Errorf("duplicate upstream %s", upstream.address())
+		}
+		seen[upstream.address()] = true
+	}
+	return nil
+}
+
+func (u *Upstream) address() string {
+	return net.JoinHostPort(u.Host, strconv.FormatUint(uint64(u.Port), 10))
+}
+
+// ClusterName returns the name of the upstream's cluster, by which listeners pushed by the control
+// plane can route to it
+func (u *Upstream) ClusterName() string {
+	return fmt.Sprintf("upstream_%s_%d", u.Host, u.Port)
+}
+
+// cluster returns a cluster that connects to the upstream over mTLS, presenting the pod's
+// X.509-SVID and validating that the server's SVID has the upstream's SPIFFE ID against the trust
+// domain's bundle. The SVID and bundle are fetched over SDS from the agent's Workload API socket.
+func (u *Upstream) cluster() map[string]interface{} {
+	tlsContext := map[string]interface{}{
+		"@type": "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext",
+		"common_tls_context": map[string]interface{}{
+			"tls_certificate_sds_secret_configs": []interface{}{
+				sdsSecretConfig(valueSDSDefaultSVID),
+			},
+			"combined_validation_context": map[string]interface{}{
+				"default_validation_context": map[string]interface{}{
+					"match_typed_subject_alt_names": []interface{}{
+						map[string]interface{}{
+							"san_type": "URI",
+							"matcher":  map[string]interface{}{"exact": u.SPIFFEID},
+						},
+					},
+				},
+				"validation_context_sds_secret_config": sdsSecretConfig(valueSDSDefaultBundle),
+			},
+		},
+	}
+	// SNI is only sent for host names
+	if net.ParseIP(u.Host) == nil {
+		tlsContext["sni"] = u.Host
+	}
+
+	return map[string]interface{}{
+		"name":            u.ClusterName(),
+		"type":            "LOGICAL_DNS",
+		"connect_timeout": "5s",
+		"load_assignment": map[string]interface{}{
+			keyClusterName: u.ClusterName(),
+			"endpoints":    []interface{}{lbEndpoint(u.Host, u.Port)},
+		},
+		"transport_socket": map[string]interface{}{
+			"name":         "envoy.transport_sockets.tls",
+			"typed_config": tlsContext,
+		},
+	}
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEnvoy_Upstreams(t *testing.T) {
+	envoy, err := NewEnvoy(context.Background(), EnvoyConfigParams{
+		Upstreams: []Upstream{
+			{Host: "payments.prod.svc", Port: 8443, SPIFFEID: "spiffe://example.org/ns/prod/sa/payments"},
+			{Host: "10.0.0.1", Port: 443, SPIFFEID: "spiffe://example.org/ledger"},
+		},
+	})
+	require.NoError(t, err)
+
+	var cfg struct {
+		StaticResources struct {
+			Clusters []map[string]interface{} `json:"clusters"`
+		} `json:"static_resources"`
+	}
+	require.NoError(t, json.Unmarshal(envoy.Cfg, &cfg))
+
+	clusters := map[string]map[string]interface{}{}
+	for _, c := range cfg.StaticResources.Clusters {
+		clusters[c["name"].(string)] = c
+	}
+
+	cluster := clusters["upstream_payments.prod.svc_8443"]
+	require.NotNil(t, cluster)
+	assert.Contains(t, string(mustMarshal(t, cluster)), `"address":"payments.prod.svc","port_value":8443`)
+
+	// The cluster originates mTLS with the SVID from SDS, and validates the upstream's SPIFFE ID
+	// against the bundle from SDS
+	transportSocket := cluster["transport_socket"].(map[string]interface{})
+	assert.Equal(t, "envoy.transport_sockets.tls", transportSocket["name"])
+	tlsContext := transportSocket["typed_config"].(map[string]interface{})
+	assert.Equal(t, "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext", tlsContext["@type"])
+	assert.Equal(t, "payments.prod.svc", tlsContext["sni"])
+	commonTLSContext := tlsContext["common_tls_context"].(map[string]interface{})
+	certificate := commonTLSContext["tls_certificate_sds_secret_configs"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, valueSDSDefaultSVID, certificate["name"])
+	validationContext := commonTLSContext["combined_validation_context"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"match_typed_subject_alt_names": []interface{}{
+			map[string]interface{}{
+				"san_type": "URI",
+				"matcher":  map[string]interface{}{"exact": "spiffe://example.org/ns/prod/sa/payments"},
+			},
+		},
+	}, validationContext["default_validation_context"])
+	bundle := validationContext["validation_context_sds_secret_config"].(map[string]interface{})
+	assert.Equal(t, valueSDSDefaultBundle, bundle["name"])
+	assert.Contains(t, string(mustMarshal(t, bundle)), valueSDSCluster)
+
+	// No SNI is sent for an IP address
+	cluster = clusters["upstream_10.0.0.1_443"]
+	require.NotNil(t, cluster)
+	tlsContext = cluster["transport_socket"].(map[string]interface{})["typed_config"].(map[string]interface{})
+	assert.NotContains(t, tlsContext, "sni")
+}
+
+func TestParseUpstreams(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected []Upstream
+		wantErr  string
+	}{
+		{
+			name:  "valid",
+			value: "payments:8443=spiffe://example.org/payments, [::1]:443=spiffe://example.org/ledger",
+			expected: []Upstream{
+				{Host: "payments", Port: 8443, SPIFFEID: "spiffe://example.org/payments"},
+				{Host: "::1", Port: 443, SPIFFEID: "spiffe://example.org/ledger"},
+			},
+		},
+		{name: "missing SPIFFE ID", value: "payments:8443", wantErr: "must be in the form host:port=spiffe-id"},
+		{name: "missing port", value: "payments=spiffe://example.org/payments", wantErr: "missing port"},
+		{name: "invalid port", value: "payments:https=spiffe://example.org/payments", wantErr: "port must be a number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreams, err := ParseUpstreams(tt.value)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, upstreams)
+		})
+	}
+}
+
+func TestValidateUpstreams(t *testing.T) {
+	tests := []struct {
+		name      string
+		upstreams []Upstream
+		wantErr   string
+	}{
+		{name: "none"},
+		{
+			name:      "missing host",
+			upstreams: []Upstream{{Port: 8443, SPIFFEID: "spiffe://example.org/payments"}},
+			wantErr:   "must have a host",
+		},
+		{
+			name:      "invalid port",
+			upstreams: []Upstream{{Host: "payments", SPIFFEID: "spiffe://example.org/payments"}},
+			wantErr:   "invalid upstream port 0",
+		},
+		{
+			name:      "invalid SPIFFE ID",
+			upstreams: []Upstream{{Host: "payments", Port: 8443, SPIFFEID: "https://example.org/payments"}},
+			wantErr:   `invalid SPIFFE ID "https://example.org/payments"`,
+		},
+		{
+			name: "duplicate",
+			upstreams: []Upstream{
+				{Host: "payments", Port: 8443, SPIFFEID: "spiffe://example.org/payments"},
+				{Host: "payments", Port: 8443, SPIFFEID: "spiffe://example.org/other"},
+			},
+			wantErr: "duplicate upstream payments:8443",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUpstreams(tt.upstreams)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
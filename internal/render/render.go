@@ -0,0 +1,58 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"text/template"
+)
+
+// MaxSize is the largest config that can be rendered. Rendered configs are written to the pod
+// spec, so are far smaller than this unless rendering has gone wrong.
+const MaxSize = 1 << 20
+
+// ErrTooLarge is returned when a rendered config would be larger than MaxSize
+var ErrTooLarge = fmt.Errorf("rendered config is larger than %d bytes", MaxSize)
+
+// Writer buffers a rendered config, failing any write once ctx is done or once the config would
+// be larger than MaxSize, so that rendering stops rather than running on or growing unbounded
+type Writer struct {
+	ctx context.Context
+	buf bytes.Buffer
+}
+
+// NewWriter returns a Writer bound by ctx
+func NewWriter(ctx context.Context) *Writer {
+	return &Writer{ctx: ctx}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if w.buf.Len()+len(p) > MaxSize {
+		return 0, ErrTooLarge
+	}
+	return w.buf.Write(p)
+}
+
+// String returns the config rendered so far
+func (w *Writer) String() string {
+	return w.buf.String()
+}
+
+// Template executes tmpl with data into a Writer bound by ctx, returning an error if rendering
+// has not completed by the time ctx is done or would be larger than MaxSize. Rendering is checked
+// as it writes, so a template function that blocks without writing isn't interrupted; the
+// templates rendered on injection only call functions that return immediately.
+func Template(ctx context.Context, tmpl *template.Template, data any) (string, error) {
+	w := NewWriter(ctx)
+	if err := tmpl.Execute(w, data); err != nil {
+		if errors.Is(err, ErrTooLarge) || ctx.Err() != nil {
+			return "", fmt.Errorf("rendering of template %q did not complete: %w", tmpl.Name(), err)
+		}
+		return "", err
+	}
+	return w.String(), nil
+}
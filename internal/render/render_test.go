@@ -0,0 +1,75 @@
+package render
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplate(t *testing.T) {
+	t.Run("renders within budget", func(t *testing.T) {
+		tmpl := template.Must(template.New("fast").Parse("port={{.}}"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		rendered, err := Template(ctx, tmpl, 10000)
+		require.NoError(t, err)
+		assert.Equal(t, "port=10000", rendered)
+	})
+
+	t.Run("times out on slow render", func(t *testing.T) {
+		tmpl := template.Must(template.New("slow").Funcs(template.FuncMap{
+			"slow": func() string {
+				time.Sleep(time.Millisecond)
+				return "x"
+			},
+		}).Parse("{{range .}}{{slow}}{{end}}"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		// Far more iterations than can complete within the timeout
+		start := time.Now()
+		_, err := Template(ctx, tmpl, make([]struct{}, 100000))
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Contains(t, err.Error(), `"slow"`)
+		assert.Less(t, time.Since(start), time.Second, "rendering continued after the timeout")
+	})
+
+	t.Run("fails on oversized render", func(t *testing.T) {
+		tmpl := template.Must(template.New("large").Parse("{{range .}}{{.}}{{end}}"))
+
+		_, err := Template(context.Background(), tmpl, []string{strings.Repeat("x", MaxSize), "x"})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrTooLarge)
+		assert.Contains(t, err.Error(), `"large"`)
+	})
+
+	t.Run("returns execution errors", func(t *testing.T) {
+		tmpl := template.Must(template.New("broken").Parse("{{.Missing}}"))
+
+		_, err := Template(context.Background(), tmpl, struct{}{})
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "did not complete")
+	})
+}
+
+func TestWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := NewWriter(ctx)
+
+	_, err := w.Write([]byte("config"))
+	require.NoError(t, err)
+
+	cancel()
+	_, err = w.Write([]byte(" more"))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "config", w.String())
+}
@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// agentXDSCheckTTL is how long the result of resolving the agent's xDS service is cached, so
+	// that injections don't each query DNS
+	agentXDSCheckTTL = 30 * time.Second
+	// agentXDSCheckTimeout bounds the resolution, so that a slow resolver doesn't delay injection
+	agentXDSCheckTimeout = 2 * time.Second
+)
+
+// WithAgentXDSCheck sets whether injecting the proxy checks that the agent's xDS service resolves,
+// adding a warning if it doesn't. The webhook doesn't need the agent to inject pods, so the check
+// never denies injection, but it surfaces a misconfigured or missing agent early.
+func WithAgentXDSCheck(enabled bool) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.agentXDSCheck.enabled = enabled
+	}
+}
+
+// agentXDSCheck caches the result of resolving the agent's xDS service
+type agentXDSCheck struct {
+	enabled    bool
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	mu        sync.Mutex
+	host      string
+	checkedAt time.Time
+	err       error
+}
+
+func newAgentXDSCheck() *agentXDSCheck {
+	return &agentXDSCheck{lookupHost: net.DefaultResolver.LookupHost}
+}
+
+// checkAgentXDS warns if the agent's xDS service doesn't resolve, reusing a recent result for the
+// same service
+func (a *spiffeEnableWebhook) checkAgentXDS(ctx context.Context, host string, warnings *admissionWarnings) {
+	check := a.agentXDSCheck
+	if !check.enabled {
+		return
+	}
+
+	check.mu.Lock()
+	defer check.mu.Unlock()
+
+	now := a.now()
+	if check.host != host || check.checkedAt.IsZero() || now.Sub(check.checkedAt) >= agentXDSCheckTTL {
+		lookupCtx, cancel := context.WithTimeout(ctx, agentXDSCheckTimeout)
+		_, err := check.lookupHost(lookupCtx, host)
+		cancel()
+		check.host, check.checkedAt, check.err = host, now, err
+	}
+
+	if check.err != nil {
+		warnings.add("the agent's xDS service %s doesn't resolve, so the proxy may not receive its config: %v", host, check.err)
+	}
+}
@@ -0,0 +1,55 @@
+package webhook
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// parseBoolAnnotation parses an annotation that must be true or false, returning defaultValue if
+// it isn't set. The value is parsed strictly, as a mistyped value such as "yes" would otherwise
+// silently leave the option at its default.
+func parseBoolAnnotation(annotations map[string]string, name string, defaultValue bool) (bool, error) {
+	value, ok := annotations[name]
+	if !ok {
+		return defaultValue, nil
+	}
+	switch value {
+	case annotationValueTrue:
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid %s annotation: %q. Allowed values are: [true false]", name, value)
+	}
+}
+
+// parsePositiveDurationAnnotation parses an annotation that must be a positive duration, eg 30s,
+// returning defaultValue if it isn't set
+func parsePositiveDurationAnnotation(annotations map[string]string, name string, defaultValue time.Duration) (time.Duration, error) {
+	value, ok := annotations[name]
+	if !ok {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("must be positive")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation: %w", name, err)
+	}
+	return d, nil
+}
+
+// parsePortAnnotation parses an annotation that must be a port number, returning 0 if it isn't set
+func parsePortAnnotation(annotations map[string]string, name string) (uint16, error) {
+	value, ok := annotations[name]
+	if !ok {
+		return 0, nil
+	}
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("invalid %s annotation: %q. Must be a port between 1 and 65535", name, value)
+	}
+	return uint16(port), nil
+}
@@ -0,0 +1,77 @@
+package webhook
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// WithAutoInjectMode sets the components, a comma-delimited list as in the inject annotation, that
+// are injected into pods matched for auto-injection. Defaults to csi.
+func WithAutoInjectMode(mode string) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.autoInjectMode = mode
+	}
+}
+
+// WithAutoInjectImagePrefixes auto-injects pods without the inject annotation that have a container
+// whose image starts with one of the given prefixes, eg an internal registry
+func WithAutoInjectImagePrefixes(imagePrefixes []string) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.autoInjectImagePrefixes = imagePrefixes
+	}
+}
+
+// WithAutoInjectServiceAccounts auto-injects pods without the inject annotation that use one of the
+// given service accounts, each in the form namespace/name
+func WithAutoInjectServiceAccounts(serviceAccounts []string) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.autoInjectServiceAccounts = serviceAccounts
+	}
+}
+
+// validateAutoInject checks the auto-inject mode and service accounts, if auto-injection is enabled
+func (a *spiffeEnableWebhook) validateAutoInject() error {
+	if len(a.autoInjectImagePrefixes) == 0 && len(a.autoInjectServiceAccounts) == 0 {
+		return nil
+	}
+
+	modes, _, invalidModes := parseInjectModes(a.autoInjectMode)
+	if len(invalidModes) > 0 || len(modes) == 0 {
+		return fmt.Errorf("invalid auto-inject mode %q: must be a comma-delimited list of %v",
+			a.autoInjectMode, injectModeOrder)
+	}
+
+	for _, serviceAccount := range a.autoInjectServiceAccounts {
+		namespace, name, ok := strings.Cut(serviceAccount, "/")
+		if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("invalid auto-inject service account %q: must be in the form namespace/name", serviceAccount)
+		}
+	}
+	return nil
+}
+
+// autoInjectReason returns why the pod, in namespace, matches for auto-injection, or an empty
+// string if it doesn't
+func (a *spiffeEnableWebhook) autoInjectReason(pod *corev1.Pod, namespace string) string {
+	for _, container := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+		for _, prefix := range a.autoInjectImagePrefixes {
+			if strings.HasPrefix(container.Image, prefix) {
+				return fmt.Sprintf("image %s has an auto-inject prefix", container.Image)
+			}
+		}
+	}
+
+	// Pods that don't set a service account use the namespace's default one
+	serviceAccountName := pod.Spec.ServiceAccountName
+	if serviceAccountName == "" {
+		serviceAccountName = "default"
+	}
+	serviceAccount := namespace + "/" + serviceAccountName
+	if slices.Contains(a.autoInjectServiceAccounts, serviceAccount) {
+		return fmt.Sprintf("service account %s is auto-injected", serviceAccount)
+	}
+	return ""
+}
@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	"github.com/cofide/spiffe-enable/internal/helper"
+	"github.com/cofide/spiffe-enable/internal/proxy"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// WithDenyContainerNameCollisions sets whether injection is denied, rather than allowed with a
+// warning, for pods with a user container named like one of the containers the webhook injects
+func WithDenyContainerNameCollisions(deny bool) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.denyNameCollisions = deny
+	}
+}
+
+// injectedContainerNames returns the names of the containers injected for the requested
+// components, in a stable order
+func injectedContainerNames(injectValue string, debug bool) []string {
+	var names []string
+	if debug {
+		names = append(names, constants.DebugUIContainerName)
+	}
+	for _, mode := range strings.Split(injectValue, ",") {
+		switch strings.TrimSpace(mode) {
+		case constants.InjectAnnotationProxy:
+			names = append(names, proxy.EnvoySidecarContainerName, proxy.EnvoyConfigInitContainerName)
+		case constants.InjectAnnotationHelper:
+			names = append(names, helper.SPIFFEHelperSidecarContainerName, helper.SPIFFEHelperInitContainerName)
+		}
+	}
+	return names
+}
+
+// injectedInitContainerNames are the names of the init containers, including native sidecars,
+// that the webhook may inject
+var injectedInitContainerNames = []string{
+	proxy.EnvoySidecarContainerName,
+	proxy.EnvoyConfigInitContainerName,
+	helper.SPIFFEHelperSidecarContainerName,
+	helper.SPIFFEHelperInitContainerName,
+	helper.SPIFFEHelperCertPublisherContainerName,
+}
+
+// findContainerNameCollisions returns the names that are already used by containers that the
+// webhook didn't inject. Injection would otherwise be silently skipped for those containers, as
+// they look like they have already been injected. A pod carrying the injected-by annotation has
+// already been mutated by the webhook (eg on reinvocation), so its containers are not collisions.
+func findContainerNameCollisions(pod *corev1.Pod, names []string) []string {
+	if _, ok := pod.Annotations[constants.InjectedByAnnotation]; ok {
+		return nil
+	}
+
+	var collisions []string
+	for _, name := range names {
+		if sidecarExists(pod, name) && !slices.Contains(collisions, name) {
+			collisions = append(collisions, name)
+		}
+	}
+	return collisions
+}
+
+// checkContainerNameCollisions returns a reason to deny injection if the pod has containers
+// colliding with those to be injected and collisions are denied; otherwise, collisions are
+// added as a warning
+func (a *spiffeEnableWebhook) checkContainerNameCollisions(pod *corev1.Pod, names []string, warnings *admissionWarnings) string {
+	collisions := findContainerNameCollisions(pod, names)
+	if len(collisions) == 0 {
+		return ""
+	}
+
+	message := fmt.Sprintf("pod has existing container(s) %v with the same name as injected containers, "+
+		"so they will not be injected; rename the container(s) to enable injection", collisions)
+	if a.denyNameCollisions {
+		return message
+	}
+	warnings.add("%s", message)
+	return ""
+}
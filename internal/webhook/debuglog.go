@@ -0,0 +1,73 @@
+package webhook
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// debugLogLevel is the verbosity at which the mutated pod is logged, eg with --zap-log-level=debug
+const debugLogLevel = 1
+
+// redactedValue replaces sensitive values in the logged pod
+const redactedValue = "REDACTED"
+
+// sensitiveEnvVarRegex matches the names of environment variables whose values are redacted
+var sensitiveEnvVarRegex = regexp.MustCompile(`(?i)token|secret|password|passwd|credential|key`)
+
+// logMutatedPod logs the mutated pod at debug level, with sensitive values redacted (see
+// redactedPodJSON). Nothing is marshaled unless debug logging is enabled.
+func logMutatedPod(logger logr.Logger, pod *corev1.Pod, sensitiveValues []string) {
+	debugLogger := logger.V(debugLogLevel)
+	if !debugLogger.Enabled() {
+		return
+	}
+
+	podJSON, err := redactedPodJSON(pod, sensitiveValues)
+	if err != nil {
+		logger.Error(err, "Failed to marshal mutated pod for debug logging")
+		return
+	}
+	debugLogger.Info("Mutated pod", "pod", string(podJSON))
+}
+
+// redactedPodJSON marshals the pod with the values of sensitive environment variables, the extra
+// environment annotation and the given sensitive values (eg the xDS token, which is embedded in
+// the proxy config) redacted
+func redactedPodJSON(pod *corev1.Pod, sensitiveValues []string) ([]byte, error) {
+	redacted := pod.DeepCopy()
+	for _, containers := range [][]corev1.Container{redacted.Spec.InitContainers, redacted.Spec.Containers} {
+		for i := range containers {
+			for j := range containers[i].Env {
+				if containers[i].Env[j].Value != "" && sensitiveEnvVarRegex.MatchString(containers[i].Env[j].Name) {
+					containers[i].Env[j].Value = redactedValue
+				}
+			}
+		}
+	}
+	if _, ok := redacted.Annotations[constants.ExtraEnvAnnotation]; ok {
+		redacted.Annotations[constants.ExtraEnvAnnotation] = redactedValue
+	}
+
+	podJSON, err := json.Marshal(redacted)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sensitive values may also be embedded in rendered configs, which are JSON-escaped in the pod
+	podString := string(podJSON)
+	for _, value := range sensitiveValues {
+		if value == "" {
+			continue
+		}
+		podString = strings.ReplaceAll(podString, value, redactedValue)
+		if escaped, err := json.Marshal(value); err == nil {
+			podString = strings.ReplaceAll(podString, strings.Trim(string(escaped), `"`), redactedValue)
+		}
+	}
+	return []byte(podString), nil
+}
@@ -0,0 +1,44 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// dryRunPatch returns the JSON patch that would have mutated the pod, computed between the
+// redacted original and mutated pods so that the patch can be logged
+func dryRunPatch(originalPod, pod *corev1.Pod, sensitiveValues []string) (string, error) {
+	originalJSON, err := redactedPodJSON(originalPod, sensitiveValues)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal original pod: %w", err)
+	}
+	mutatedJSON, err := redactedPodJSON(pod, sensitiveValues)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal mutated pod: %w", err)
+	}
+
+	resp := admission.PatchResponseFromRaw(originalJSON, mutatedJSON)
+	if !resp.Allowed {
+		return "", fmt.Errorf("failed to compute patch: %s", resp.Result.Message)
+	}
+	patchJSON, err := json.Marshal(resp.Patches)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal patch: %w", err)
+	}
+	return string(patchJSON), nil
+}
+
+// logDryRun logs the patch that would have mutated a pod in dry-run mode, at info level so that
+// the injection can be previewed without debug logging
+func logDryRun(logger logr.Logger, originalPod, pod *corev1.Pod, sensitiveValues []string) {
+	patch, err := dryRunPatch(originalPod, pod, sensitiveValues)
+	if err != nil {
+		logger.Error(err, "Failed to compute dry-run patch")
+		return
+	}
+	logger.Info("Dry run, pod not mutated", "patch", patch)
+}
@@ -0,0 +1,82 @@
+package webhook
+
+import (
+	"fmt"
+	"regexp"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	"github.com/cofide/spiffe-enable/internal/helper"
+	"github.com/cofide/spiffe-enable/internal/proxy"
+)
+
+// imageReferenceRegex matches image references of the form [registry/]repository[:tag][@digest],
+// following the grammar of the container image reference format
+var imageReferenceRegex = regexp.MustCompile(
+	`^(?:(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*|\[[0-9a-fA-F:]+\])(?::[0-9]+)?/)?` +
+		`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+		`(?::[\w][\w.-]{0,127})?` +
+		`(?:@[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,})?$`)
+
+// ImageConfig holds the images of the injected containers, eg to pull them from a mirror in an
+// air-gapped environment
+type ImageConfig struct {
+	Helper     string
+	HelperInit string
+	Proxy      string
+	ProxyInit  string
+	DebugUI    string
+}
+
+// WithImages overrides the images of the injected containers. Empty fields keep the images set by
+// the environment variables, or the defaults.
+func WithImages(images ImageConfig) Option {
+	return func(w *spiffeEnableWebhook) {
+		for _, image := range []struct {
+			target *string
+			value  string
+		}{
+			{&w.images.Helper, images.Helper},
+			{&w.images.HelperInit, images.HelperInit},
+			{&w.images.Proxy, images.Proxy},
+			{&w.images.ProxyInit, images.ProxyInit},
+			{&w.images.DebugUI, images.DebugUI},
+		} {
+			if image.value != "" {
+				*image.target = image.value
+			}
+		}
+	}
+}
+
+// imageConfigFromEnv returns the images set by the environment variables, or the defaults
+func imageConfigFromEnv() ImageConfig {
+	return ImageConfig{
+		Helper: getEnvWithDefault(constants.EnvVarHelperImage, helper.SPIFFEHelperImage),
+		// The helper init container only needs a shell, so it can fall back to a different (eg
+		// public) image if its own isn't set; the proxy init container needs nft, so has no fallback
+		HelperInit: getEnvWithDefault(constants.EnvVarHelperInitImage,
+			getEnvWithDefault(constants.EnvVarInitFallbackImage, helper.InitHelperImage)),
+		Proxy:     getEnvWithDefault(constants.EnvVarProxyImage, proxy.IstioImage),
+		ProxyInit: getEnvWithDefault(constants.EnvVarProxyInitImage, helper.InitHelperImage),
+		DebugUI:   getEnvWithDefault(constants.EnvVarUIImage, constants.DefaultDebugUIImage),
+	}
+}
+
+// validate checks that each image is a valid image reference
+func (c ImageConfig) validate() error {
+	for _, image := range []struct {
+		name  string
+		value string
+	}{
+		{"spiffe-helper", c.Helper},
+		{"spiffe-helper init", c.HelperInit},
+		{"proxy", c.Proxy},
+		{"proxy init", c.ProxyInit},
+		{"debug UI", c.DebugUI},
+	} {
+		if !imageReferenceRegex.MatchString(image.value) {
+			return fmt.Errorf("invalid %s image %q: must be a valid image reference", image.name, image.value)
+		}
+	}
+	return nil
+}
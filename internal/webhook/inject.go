@@ -0,0 +1,234 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	"github.com/cofide/spiffe-enable/internal/helper"
+	"github.com/cofide/spiffe-enable/internal/workload"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// invalidPodError is returned for a pod with invalid annotations, which is rejected with a
+// 400 Bad Request rather than a 500 Internal Server Error
+type invalidPodError struct {
+	error
+}
+
+func (e invalidPodError) Unwrap() error {
+	return e.error
+}
+
+// errorResponse returns the response rejecting a pod due to err
+func errorResponse(err error) admission.Response {
+	var invalid invalidPodError
+	if errors.As(err, &invalid) {
+		return admission.Errored(http.StatusBadRequest, invalid.error)
+	}
+	return admission.Errored(http.StatusInternalServerError, err)
+}
+
+// injection is the state of the mutation of a pod that is shared by the injected components. It
+// is parsed from the pod's annotations before any of them are injected.
+type injection struct {
+	pod *corev1.Pod
+	// originalPod is the pod before mutation, whose containers are the application containers
+	originalPod *corev1.Pod
+	logger      logr.Logger
+	warnings    *admissionWarnings
+	// sensitiveValues are redacted from the debug log of the mutated pod
+	sensitiveValues []string
+
+	wlAPI          workloadAPIInjection
+	placer         *sidecarPlacer
+	sidecarMode    string
+	initPullPolicy corev1.PullPolicy
+	// configVolumeMemory backs the injected config volumes with memory
+	configVolumeMemory bool
+	// certVolume is the volume written by spiffe-helper, which may be one the application already
+	// mounts at the cert directory
+	certVolume string
+	// certPaths are the paths that the certs are mounted at in each application container that
+	// requested them
+	certPaths map[string]string
+	// caBundlePath, if set, is the path that the trust bundle is mounted at in every application
+	// container
+	caBundlePath     string
+	imagePullSecrets []string
+}
+
+// reject logs err as the reason that the pod is rejected, and returns it as an invalidPodError
+func (inj *injection) reject(err error, reason string, keysAndValues ...any) error {
+	inj.logger.Error(err, "Pod rejected due to "+reason, keysAndValues...)
+	return invalidPodError{err}
+}
+
+// newInjection parses the annotations that apply to all of the injected components. Only the
+// application containers are named in them, so this is done before any components are injected.
+func (a *spiffeEnableWebhook) newInjection(
+	pod, originalPod *corev1.Pod, logger logr.Logger, warnings *admissionWarnings,
+) (*injection, error) {
+	inj := &injection{
+		pod:         pod,
+		originalPod: originalPod,
+		logger:      logger,
+		warnings:    warnings,
+		wlAPI:       workloadAPIInjection{socketPath: constants.SPIFFEWLSocketPath},
+	}
+
+	// Check which of the pod's own containers get the Workload API socket
+	var err error
+	if value, ok := pod.Annotations[constants.TargetContainersAnnotation]; ok {
+		inj.wlAPI.skipContainers, err = parseTargetContainers(value, pod.Spec.Containers)
+		if err != nil {
+			return nil, inj.reject(err, "invalid target containers", "targetContainers", value)
+		}
+	}
+
+	// Check whether the pod's own init containers should also get the Workload API socket. The value
+	// is parsed strictly, as many init containers don't need it.
+	inj.wlAPI.includeInitContainers, err = parseBoolAnnotation(pod.Annotations, constants.InjectInitContainersAnnotation, false)
+	if err != nil {
+		return nil, inj.reject(err, "invalid init container injection option")
+	}
+
+	// Check for the path of the Workload API socket within the CSI volume, which is given to the
+	// containers and the injected components in place of the default
+	if value, ok := pod.Annotations[constants.WorkloadSocketPathAnnotation]; ok {
+		if err := workload.ValidateSocketPath(value); err != nil {
+			err = fmt.Errorf("invalid %s annotation: %w", constants.WorkloadSocketPathAnnotation, err)
+			return nil, inj.reject(err, "invalid Workload API socket path")
+		}
+		inj.wlAPI.socketPath = value
+	}
+
+	// Reuse a volume that the application already mounts at the cert directory, so that it shares
+	// the directory with spiffe-helper rather than conflicting with the certs volume
+	inj.certVolume = findCertVolume(pod.Spec.Containers)
+
+	// Check for per-container cert paths
+	if value, ok := pod.Annotations[helper.SPIFFEHelperCertPathsAnnotation]; ok {
+		inj.certPaths, err = parseCertPaths(value, pod.Spec.Containers, inj.certVolume)
+		if err != nil {
+			return nil, inj.reject(err, "invalid cert paths", "certPaths", value)
+		}
+	}
+
+	// Check for a CA bundle path, at which the trust bundle is mounted into every application
+	// container
+	if value, ok := pod.Annotations[helper.SPIFFEHelperCABundlePathAnnotation]; ok {
+		inj.caBundlePath, err = parseCABundlePath(value, pod.Spec.Containers, inj.certPaths, inj.certVolume)
+		if err != nil {
+			return nil, inj.reject(err, "invalid CA bundle path", "caBundlePath", value)
+		}
+	}
+
+	// Check for a sidecar mode annotation, which applies to all injected sidecars
+	inj.sidecarMode = pod.Annotations[constants.SidecarModeAnnotation]
+	switch inj.sidecarMode {
+	case "", constants.SidecarModeRegular:
+	case constants.SidecarModeNative:
+		if !a.nativeSidecarsSupported {
+			err := fmt.Errorf("sidecar mode %q is not supported by this cluster", inj.sidecarMode)
+			return nil, inj.reject(err, "unsupported sidecar mode")
+		}
+	default:
+		err := fmt.Errorf(
+			"invalid sidecar mode: %s. Allowed modes are: %v",
+			inj.sidecarMode,
+			[]string{constants.SidecarModeNative, constants.SidecarModeRegular},
+		)
+		return nil, inj.reject(err, "invalid sidecar mode")
+	}
+
+	// Check for a sidecar position annotation, which applies to sidecars injected as regular containers
+	inj.placer = &sidecarPlacer{position: pod.Annotations[constants.SidecarPositionAnnotation]}
+	switch inj.placer.position {
+	case "", constants.SidecarPositionLast, constants.SidecarPositionFirst:
+	default:
+		err := fmt.Errorf(
+			"invalid sidecar position: %s. Allowed positions are: %v",
+			inj.placer.position,
+			[]string{constants.SidecarPositionFirst, constants.SidecarPositionLast},
+		)
+		return nil, inj.reject(err, "invalid sidecar position")
+	}
+
+	// Check for an init image pull policy annotation, which applies to all injected init containers
+	inj.initPullPolicy = corev1.PullPolicy(pod.Annotations[constants.InitImagePullPolicyAnnotation])
+	switch inj.initPullPolicy {
+	case "", corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+	default:
+		err := fmt.Errorf(
+			"invalid init image pull policy: %s. Allowed policies are: %v",
+			inj.initPullPolicy,
+			[]corev1.PullPolicy{corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever},
+		)
+		return nil, inj.reject(err, "invalid init image pull policy")
+	}
+
+	// Check whether the config volumes of the injected components are backed by memory
+	inj.configVolumeMemory, err = parseBoolAnnotation(pod.Annotations, constants.ConfigVolumeMemoryAnnotation, false)
+	if err != nil {
+		return nil, inj.reject(err, "invalid config volume medium option")
+	}
+
+	// Check for image pull secrets for the injected containers' images
+	if value, ok := pod.Annotations[constants.ImagePullSecretsAnnotation]; ok {
+		inj.imagePullSecrets, err = parseImagePullSecrets(value)
+		if err != nil {
+			return nil, inj.reject(err, "invalid image pull secrets", "imagePullSecrets", value)
+		}
+	}
+
+	return inj, nil
+}
+
+// applyAppEnv adds the extra environment variables, and the ConfigMaps and Secrets to populate
+// the environment from, to the application containers that get the Workload API socket
+func (inj *injection) applyAppEnv() error {
+	if value, ok := inj.pod.Annotations[constants.ExtraEnvAnnotation]; ok {
+		extraEnv, err := parseExtraEnv(value)
+		if err != nil {
+			return inj.reject(err, "invalid extra environment variables", "extraEnv", value)
+		}
+
+		for i := range inj.pod.Spec.Containers {
+			if slices.Contains(inj.wlAPI.skipContainers, inj.pod.Spec.Containers[i].Name) {
+				continue
+			}
+			for _, envVar := range extraEnv {
+				ensureEnvVar(&inj.pod.Spec.Containers[i], envVar)
+			}
+		}
+	}
+
+	if value, ok := inj.pod.Annotations[constants.EnvFromAnnotation]; ok {
+		envFrom, err := parseEnvFrom(value)
+		if err != nil {
+			return inj.reject(err, "invalid environment sources", "envFrom", value)
+		}
+
+		for i := range inj.pod.Spec.Containers {
+			if slices.Contains(inj.wlAPI.skipContainers, inj.pod.Spec.Containers[i].Name) {
+				continue
+			}
+			for _, source := range envFrom {
+				ensureEnvFrom(&inj.pod.Spec.Containers[i], source)
+			}
+		}
+	}
+
+	return nil
+}
+
+// isAppContainer returns whether the named container is one of the application containers, ie
+// those in the pod before injection
+func (inj *injection) isAppContainer(name string) bool {
+	return workload.ContainerExists(inj.originalPod.Spec.Containers, name)
+}
@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"strconv"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	"github.com/cofide/spiffe-enable/internal/workload"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// injectDebugUI injects the debug UI as a sidecar, along with the CSI volume that it reads the
+// workload's SVIDs through
+func (a *spiffeEnableWebhook) injectDebugUI(inj *injection) error {
+	pod := inj.pod
+
+	// Ensure the CSI volume is injected and mounted to containers
+	ensureCSIVolumeAndMount(pod, inj.wlAPI, inj.logger)
+
+	if workload.ContainerExists(pod.Spec.Containers, constants.DebugUIContainerName) {
+		return nil
+	}
+
+	inj.logger.Info("Adding SPIFFE Enable debug UI container", "containerName", constants.DebugUIContainerName)
+	// The UI reads the SVIDs from the Workload API. It is added after the app containers have been
+	// given the socket, and regardless of the target containers, so gets it here.
+	debugSidecar := corev1.Container{
+		Name:            constants.DebugUIContainerName,
+		Image:           a.images.DebugUI,
+		ImagePullPolicy: corev1.PullAlways,
+		Env:             []corev1.EnvVar{workload.GetSPIFFEEnvVarForSocket(inj.wlAPI.socketPath)},
+		VolumeMounts:    []corev1.VolumeMount{workload.GetSPIFFEVolumeMount()},
+	}
+
+	// The UI can be moved off its default port, eg if the app already binds it
+	debugUIPort := int32(constants.DebugUIPort)
+	port, err := parsePortAnnotation(pod.Annotations, constants.DebugUIPortAnnotation)
+	if err != nil {
+		return inj.reject(err, "invalid debug UI port")
+	}
+	if port != 0 {
+		debugUIPort = int32(port)
+		debugSidecar.Env = append(debugSidecar.Env,
+			corev1.EnvVar{Name: constants.EnvVarUIPort, Value: strconv.FormatUint(uint64(port), 10)})
+	}
+
+	// The UI port is declared unless disabled; the UI remains reachable via port-forward. The value
+	// is parsed strictly, as a mistyped value would otherwise silently expose the port.
+	expose, err := parseBoolAnnotation(pod.Annotations, constants.DebugUIExposeAnnotation, true)
+	if err != nil {
+		return inj.reject(err, "invalid debug UI expose option")
+	}
+	if expose {
+		debugSidecar.Ports = []corev1.ContainerPort{
+			{
+				ContainerPort: debugUIPort,
+			},
+		}
+	}
+	inj.placer.add(pod, debugSidecar)
+	return nil
+}
@@ -0,0 +1,276 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	"github.com/cofide/spiffe-enable/internal/helper"
+	"github.com/cofide/spiffe-enable/internal/workload"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// injectHelper injects spiffe-helper as a sidecar, with an init container that writes its config,
+// and mounts the certs that it writes into the application containers that request them
+func (a *spiffeEnableWebhook) injectHelper(ctx context.Context, inj *injection) error {
+	pod := inj.pod
+	logger := inj.logger
+
+	// Ensure the CSI volume is injected and mounted to containers
+	ensureCSIVolumeAndMount(pod, inj.wlAPI, logger)
+
+	logger.Info("Applying 'helper' mode mutations")
+
+	incIntermediateBundle, err := parseBoolAnnotation(pod.Annotations, helper.SPIFFEHelperIncIntermediateAnnotation, false)
+	if err != nil {
+		return inj.reject(err, "invalid spiffe-helper include intermediate bundle option")
+	}
+
+	// The bundles of federated trust domains are included by default
+	incFederatedDomains, err := parseBoolAnnotation(pod.Annotations, helper.SPIFFEHelperIncFederatedAnnotation, true)
+	if err != nil {
+		return inj.reject(err, "invalid spiffe-helper include federated domains option")
+	}
+
+	configFormat := pod.Annotations[helper.SPIFFEHelperConfigFormatAnnotation]
+	if configFormat != "" && !slices.Contains(helper.SPIFFEHelperConfigFormats, configFormat) {
+		err := fmt.Errorf(
+			"invalid spiffe-helper config format: %s. Allowed formats are: %v",
+			configFormat,
+			helper.SPIFFEHelperConfigFormats,
+		)
+		return inj.reject(err, "invalid spiffe-helper config format")
+	}
+
+	// Check for annotations overriding the default cert permissions
+	fileModes := make(map[string]int)
+	for _, annotation := range []string{
+		helper.SPIFFEHelperCertDirModeAnnotation,
+		helper.SPIFFEHelperCertFileModeAnnotation,
+		helper.SPIFFEHelperKeyFileModeAnnotation,
+	} {
+		value, ok := pod.Annotations[annotation]
+		if !ok {
+			continue
+		}
+		mode, err := helper.ParseFileMode(value)
+		if err != nil {
+			err = fmt.Errorf("invalid %s annotation: %w", annotation, err)
+			return inj.reject(err, "invalid spiffe-helper file mode")
+		}
+		fileModes[annotation] = mode
+	}
+
+	// Optionally publish the certs atomically behind a ..data symlink
+	certSymlinks, err := parseBoolAnnotation(pod.Annotations, helper.SPIFFEHelperCertSymlinksAnnotation, false)
+	if err != nil {
+		return inj.reject(err, "invalid spiffe-helper cert symlinks option")
+	}
+
+	// Check whether to wait for the first X.509-SVID before starting the application containers
+	waitForCert, err := parseBoolAnnotation(pod.Annotations, helper.SPIFFEHelperWaitForCertAnnotation, false)
+	if err != nil {
+		return inj.reject(err, "invalid spiffe-helper wait for cert option")
+	}
+
+	// The health check listener is enabled by default. The value is parsed strictly, as disabling
+	// it is what keeps older spiffe-helper versions, which reject the config, from crashing.
+	healthChecks, err := parseBoolAnnotation(pod.Annotations, helper.SPIFFEHelperHealthChecksAnnotation, true)
+	if err != nil {
+		return inj.reject(err, "invalid spiffe-helper health checks option")
+	}
+
+	preStopSleep, err := a.parsePreStopSleep(inj)
+	if err != nil {
+		return err
+	}
+
+	// Check for the audiences of JWT-SVIDs to fetch
+	var jwtAudiences []string
+	if value, ok := pod.Annotations[helper.SPIFFEHelperJWTAudiencesAnnotation]; ok {
+		jwtAudiences, err = helper.ParseJWTAudiences(value)
+		if err != nil {
+			err = fmt.Errorf("invalid %s annotation: %w", helper.SPIFFEHelperJWTAudiencesAnnotation, err)
+			return inj.reject(err, "invalid spiffe-helper JWT audiences")
+		}
+	}
+
+	// Resolve the sidecar resources from an explicit annotation or the defaults
+	resources, err := helper.GetSidecarResources(pod.Annotations[helper.SPIFFEHelperResourcesAnnotation])
+	if err != nil {
+		return inj.reject(err, "invalid spiffe-helper resources")
+	}
+
+	// Generate the spiffe-helper configuration
+	configParams := helper.SPIFFEHelperConfigParams{
+		AgentAddress:              inj.wlAPI.socketPath,
+		CertPath:                  constants.SPIFFEEnableCertDirectory,
+		IncludeIntermediateBundle: incIntermediateBundle,
+		ExcludeFederatedDomains:   !incFederatedDomains,
+		ConfigFormat:              configFormat,
+		CertDirMode:               fileModes[helper.SPIFFEHelperCertDirModeAnnotation],
+		CertFileMode:              fileModes[helper.SPIFFEHelperCertFileModeAnnotation],
+		KeyFileMode:               fileModes[helper.SPIFFEHelperKeyFileModeAnnotation],
+		CertSymlinks:              certSymlinks,
+		Image:                     a.images.Helper,
+		InitImage:                 a.images.HelperInit,
+		InitImagePullPolicy:       inj.initPullPolicy,
+		Resources:                 resources,
+		DisableHealthChecks:       !healthChecks,
+		ConfigVolumeMemory:        inj.configVolumeMemory,
+		PreStopSleep:              preStopSleep,
+		JWTAudiences:              jwtAudiences,
+		SVIDFileName:              pod.Annotations[helper.SPIFFEHelperSVIDFileAnnotation],
+		SVIDKeyFileName:           pod.Annotations[helper.SPIFFEHelperSVIDKeyFileAnnotation],
+		SVIDBundleFileName:        pod.Annotations[helper.SPIFFEHelperSVIDBundleFileAnnotation],
+		Cmd:                       pod.Annotations[helper.SPIFFEHelperCmdAnnotation],
+		CmdArgs:                   pod.Annotations[helper.SPIFFEHelperCmdArgsAnnotation],
+		RenewSignal:               pod.Annotations[helper.SPIFFEHelperRenewSignalAnnotation],
+		CertVolumeName:            inj.certVolume,
+	}
+
+	// Check the names of the cert files, which can be set by annotation
+	if err := configParams.ValidateFileNames(); err != nil {
+		return inj.reject(err, "invalid spiffe-helper file names")
+	}
+
+	// Check the command run by spiffe-helper, and the signal sent to it on renewal
+	if err := configParams.ValidateCmd(); err != nil {
+		return inj.reject(err, "invalid spiffe-helper command")
+	}
+	if configParams.RenewSignal != "" && configParams.Cmd == "" {
+		inj.warnings.add("annotation %s has no effect without %s, as spiffe-helper only signals the command it runs",
+			helper.SPIFFEHelperRenewSignalAnnotation, helper.SPIFFEHelperCmdAnnotation)
+	}
+
+	// Bound config rendering as for the proxy config
+	renderCtx, renderCancel := context.WithTimeout(ctx, a.renderTimeout)
+	renderCtx, renderSpan := a.tracer.Start(renderCtx, spanGenerateConfig,
+		trace.WithAttributes(attribute.String(attributeMode, constants.InjectAnnotationHelper)))
+	spiffeHelper, err := helper.NewSPIFFEHelper(renderCtx, configParams)
+	endSpan(renderSpan, err)
+	renderCancel()
+	if err != nil {
+		logger.Error(err, "Error creating spiffe-helper config")
+		return fmt.Errorf("error creating spiffe-helper config: %w", err)
+	}
+
+	// Add an emptyDir volume for the SPIFFE Helper configuration if it doesn't already exist
+	if !workload.VolumeExists(pod, helper.SPIFFEHelperConfigVolumeName) {
+		logger.Info("Adding spiffe-helper config volume", "volumeName", helper.SPIFFEHelperConfigVolumeName)
+		pod.Spec.Volumes = append(pod.Spec.Volumes, spiffeHelper.GetConfigVolume())
+	}
+
+	// Add an emptyDir volume for the certs managed by SPIFFE Helper, unless an existing volume is
+	// reused
+	if !workload.VolumeExists(pod, inj.certVolume) {
+		logger.Info("Adding spiffe-helper certs volume", "volumeName", inj.certVolume)
+		pod.Spec.Volumes = append(pod.Spec.Volumes, getCertsVolume(inj.certVolume))
+	}
+
+	// Mount the certs into application containers that have requested them. Each container can use
+	// its own path, as the mounts all share the one volume written by spiffe-helper.
+	for i := range pod.Spec.Containers {
+		if certPath, ok := inj.certPaths[pod.Spec.Containers[i].Name]; ok {
+			ensureVolumeMount(&pod.Spec.Containers[i], corev1.VolumeMount{
+				Name:      inj.certVolume,
+				MountPath: certPath,
+				ReadOnly:  true,
+			}, logger)
+		}
+		if inj.caBundlePath != "" && inj.isAppContainer(pod.Spec.Containers[i].Name) {
+			ensureVolumeMount(&pod.Spec.Containers[i], spiffeHelper.GetCABundleVolumeMount(inj.caBundlePath), logger)
+		}
+	}
+
+	if !sidecarExists(pod, helper.SPIFFEHelperSidecarContainerName) {
+		// spiffe-helper is injected as a native sidecar unless regular is requested
+		native := a.useNativeSidecar(inj.sidecarMode, true)
+		sidecar := spiffeHelper.GetSidecarContainer(native)
+		if native {
+			logger.Info("Adding spiffe-helper sidecar container", "initContainerName", helper.SPIFFEHelperSidecarContainerName)
+			pod.Spec.InitContainers = append([]corev1.Container{sidecar}, pod.Spec.InitContainers...)
+		} else {
+			logger.Info("Adding spiffe-helper sidecar container", "containerName", helper.SPIFFEHelperSidecarContainerName)
+			inj.placer.add(pod, sidecar)
+		}
+
+		// With cert symlinks, the files written by spiffe-helper are published by a sidecar of the
+		// same kind, which runs after it
+		if configParams.CertSymlinks {
+			publisher := spiffeHelper.GetCertPublisherContainer(native)
+			if native {
+				logger.Info("Adding spiffe-helper cert publisher container", "initContainerName", helper.SPIFFEHelperCertPublisherContainerName)
+				pod.Spec.InitContainers = slices.Insert(pod.Spec.InitContainers, 1, publisher)
+			} else {
+				logger.Info("Adding spiffe-helper cert publisher container", "containerName", helper.SPIFFEHelperCertPublisherContainerName)
+				inj.placer.add(pod, publisher)
+			}
+		}
+	}
+
+	// Optionally hold the application containers back until the first X.509-SVID has been written,
+	// with an init container that runs once the native sidecar has started
+	if waitForCert && !workload.InitContainerExists(pod, helper.SPIFFEHelperWaitContainerName) {
+		sidecarIndex := slices.IndexFunc(pod.Spec.InitContainers, func(c corev1.Container) bool {
+			return c.Name == helper.SPIFFEHelperSidecarContainerName
+		})
+		// With cert symlinks, the cert is only in the cert directory once it has been published, so
+		// the wait follows the publisher
+		if publisherIndex := slices.IndexFunc(pod.Spec.InitContainers, func(c corev1.Container) bool {
+			return c.Name == helper.SPIFFEHelperCertPublisherContainerName
+		}); sidecarIndex >= 0 && publisherIndex >= 0 {
+			sidecarIndex = publisherIndex
+		}
+		if sidecarIndex >= 0 {
+			logger.Info("Adding init container to wait for the spiffe-helper cert", "initContainerName", helper.SPIFFEHelperWaitContainerName)
+			pod.Spec.InitContainers = slices.Insert(pod.Spec.InitContainers, sidecarIndex+1, spiffeHelper.GetWaitForCertContainer())
+		} else {
+			// Init containers complete before regular containers start, so waiting for a regular
+			// spiffe-helper sidecar would block the pod forever
+			inj.warnings.add("%s is ignored as %s is not a native sidecar",
+				helper.SPIFFEHelperWaitForCertAnnotation, helper.SPIFFEHelperSidecarContainerName)
+		}
+	}
+
+	if !workload.InitContainerExists(pod, helper.SPIFFEHelperInitContainerName) {
+		logger.Info("Adding init container to inject spiffe-helper config", "initContainerName", helper.SPIFFEHelperInitContainerName)
+		pod.Spec.InitContainers = append([]corev1.Container{spiffeHelper.GetInitContainer()}, pod.Spec.InitContainers...)
+	}
+
+	return nil
+}
+
+// parsePreStopSleep parses the optional delay to the spiffe-helper sidecar's termination, which is
+// applied with a preStop hook. The kubelet kills the container at the end of the pod's grace
+// period, whatever the hook.
+func (a *spiffeEnableWebhook) parsePreStopSleep(inj *injection) (time.Duration, error) {
+	pod := inj.pod
+	preStopSleep, err := parsePositiveDurationAnnotation(pod.Annotations, helper.SPIFFEHelperPreStopSleepAnnotation, 0)
+	if err != nil {
+		return 0, inj.reject(err, "invalid spiffe-helper preStop sleep")
+	}
+	if preStopSleep == 0 {
+		return 0, nil
+	}
+
+	if !a.sleepActionSupported {
+		err := fmt.Errorf("invalid %s annotation: the sleep action for preStop hooks requires Kubernetes v1.30+",
+			helper.SPIFFEHelperPreStopSleepAnnotation)
+		return 0, inj.reject(err, "invalid spiffe-helper preStop sleep")
+	}
+
+	gracePeriod := corev1.DefaultTerminationGracePeriodSeconds * time.Second
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		gracePeriod = time.Duration(*pod.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+	if preStopSleep >= gracePeriod {
+		inj.warnings.add("annotation %s (%s) is not shorter than the pod's termination grace period (%s), so the %s container will be killed during its preStop hook",
+			helper.SPIFFEHelperPreStopSleepAnnotation, preStopSleep, gracePeriod, helper.SPIFFEHelperSidecarContainerName)
+	}
+	return preStopSleep, nil
+}
@@ -0,0 +1,304 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	"github.com/cofide/spiffe-enable/internal/proxy"
+	"github.com/cofide/spiffe-enable/internal/workload"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// injectProxy injects the Envoy proxy as a sidecar, with an init container that writes its config
+// and redirects the pod's traffic to it
+func (a *spiffeEnableWebhook) injectProxy(ctx context.Context, inj *injection) error {
+	pod := inj.pod
+	logger := inj.logger
+
+	// Ensure the CSI volume is injected and mounted to containers
+	ensureCSIVolumeAndMount(pod, inj.wlAPI, logger)
+
+	// Resolve the sidecar resources from an explicit annotation or a size profile
+	resources, err := proxy.GetSidecarResources(
+		pod.Annotations[constants.ProxySizeAnnotation], pod.Annotations[constants.ProxyResourcesAnnotation])
+	if err != nil {
+		return inj.reject(err, "invalid proxy resources")
+	}
+
+	initExtraCommands, hasInitExtraCommands := pod.Annotations[constants.ProxyInitExtraCommandsAnnotation]
+	if hasInitExtraCommands {
+		initExtraCommands = strings.TrimSpace(initExtraCommands)
+		if initExtraCommands == "" {
+			err := fmt.Errorf("invalid %s annotation: must not be empty", constants.ProxyInitExtraCommandsAnnotation)
+			return inj.reject(err, "empty proxy init extra commands")
+		}
+		inj.warnings.add("annotation %s runs custom commands as root in the %s init container",
+			constants.ProxyInitExtraCommandsAnnotation, proxy.EnvoyConfigInitContainerName)
+	}
+
+	// The init container only needs NET_RAW for extra commands that use it, so it is added on request
+	initNetRaw, err := parseBoolAnnotation(pod.Annotations, constants.InitNetRawAnnotation, false)
+	if err != nil {
+		return inj.reject(err, "invalid proxy init NET_RAW option")
+	}
+
+	// Pick a name for the config volume that doesn't collide with the pod's own volumes, unless the
+	// Envoy containers (and so their volume) have already been injected
+	configVolumeName := proxy.EnvoyConfigVolumeName
+	if !sidecarExists(pod, proxy.EnvoySidecarContainerName) &&
+		!workload.InitContainerExists(pod, proxy.EnvoyConfigInitContainerName) {
+		configVolumeName = workload.UniqueVolumeName(pod, proxy.EnvoyConfigVolumeName)
+	}
+
+	// Optionally hold back the app containers' liveness and readiness probes until Envoy is ready
+	startupProbe, err := parseBoolAnnotation(pod.Annotations, constants.ProxyStartupProbeAnnotation, false)
+	if err != nil {
+		return inj.reject(err, "invalid proxy startup probe option")
+	}
+	startupProbeTimeout, err := parsePositiveDurationAnnotation(pod.Annotations,
+		constants.ProxyStartupProbeTimeoutAnnotation, defaultProxyStartupProbeTimeout)
+	if err != nil {
+		return inj.reject(err, "invalid proxy startup probe timeout")
+	}
+
+	// Optionally wait for the Workload API socket before starting Envoy
+	waitForSocket, err := parseBoolAnnotation(pod.Annotations, constants.ProxyWaitForSocketAnnotation, false)
+	if err != nil {
+		return inj.reject(err, "invalid proxy socket wait option")
+	}
+	var socketWaitTimeout time.Duration
+	if waitForSocket {
+		socketWaitTimeout, err = parsePositiveDurationAnnotation(pod.Annotations,
+			constants.ProxyWaitForSocketTimeoutAnnotation, defaultProxySocketWaitTimeout)
+		if err != nil {
+			return inj.reject(err, "invalid proxy socket wait timeout")
+		}
+	}
+
+	// Check for a custom admin interface port and address
+	adminPort, err := parsePortAnnotation(pod.Annotations, constants.EnvoyAdminPortAnnotation)
+	if err != nil {
+		return inj.reject(err, "invalid Envoy admin port")
+	}
+	if slices.Contains([]uint16{proxy.EnvoyPort, proxy.DNSProxyPort, proxy.EnvoyReadinessPort}, adminPort) {
+		err := fmt.Errorf("invalid %s annotation: port %d is already used by the proxy",
+			constants.EnvoyAdminPortAnnotation, adminPort)
+		return inj.reject(err, "invalid Envoy admin port")
+	}
+	adminAddress, hasAdminAddress := pod.Annotations[constants.EnvoyAdminAddressAnnotation]
+	if hasAdminAddress && net.ParseIP(adminAddress) == nil {
+		err := fmt.Errorf("invalid %s annotation: %q. Must be an IP address",
+			constants.EnvoyAdminAddressAnnotation, adminAddress)
+		return inj.reject(err, "invalid Envoy admin address")
+	}
+
+	// Check for a proxy flavor, which adjusts the nftables rules
+	proxyFlavor := pod.Annotations[constants.ProxyFlavorAnnotation]
+	if proxyFlavor != "" && !slices.Contains(proxy.ProxyFlavors, proxyFlavor) {
+		err := fmt.Errorf(
+			"invalid %s annotation: %s. Allowed values are: %v",
+			constants.ProxyFlavorAnnotation,
+			proxyFlavor,
+			proxy.ProxyFlavors,
+		)
+		return inj.reject(err, "invalid proxy flavor")
+	}
+
+	ipFamily := pod.Annotations[constants.ProxyIPFamilyAnnotation]
+	if ipFamily != "" && !slices.Contains(proxy.IPFamilies, ipFamily) {
+		err := fmt.Errorf(
+			"invalid %s annotation: %s. Allowed values are: %v",
+			constants.ProxyIPFamilyAnnotation,
+			ipFamily,
+			proxy.IPFamilies,
+		)
+		return inj.reject(err, "invalid proxy IP family")
+	}
+
+	// Check for outbound ports whose traffic isn't redirected to the proxy
+	var excludeOutboundPorts []int
+	if value, ok := pod.Annotations[constants.ExcludeOutboundPortsAnnotation]; ok {
+		excludeOutboundPorts, err = parseExcludeOutboundPorts(value)
+		if err != nil {
+			return inj.reject(err, "invalid excluded outbound ports")
+		}
+	}
+	var excludeDestCIDRs []string
+	if value, ok := pod.Annotations[constants.ExcludeDestCIDRsAnnotation]; ok {
+		excludeDestCIDRs, err = parseExcludeDestCIDRs(value)
+		if err != nil {
+			return inj.reject(err, "invalid excluded destination CIDRs")
+		}
+	}
+
+	// DNS requests are redirected to the proxy, which forwards those it can't answer to the pod's
+	// nameservers. For a pod with its own DNS config this layers the redirection on top of it, so
+	// the pod can choose to be warned or to skip the redirection.
+	disableDNSRedirect := false
+	customDNS := pod.Annotations[constants.ProxyCustomDNSAnnotation]
+	switch customDNS {
+	case "", constants.ProxyCustomDNSWarn, constants.ProxyCustomDNSSkip:
+	default:
+		err := fmt.Errorf(
+			"invalid %s annotation: %s. Allowed values are: %v",
+			constants.ProxyCustomDNSAnnotation,
+			customDNS,
+			[]string{constants.ProxyCustomDNSWarn, constants.ProxyCustomDNSSkip},
+		)
+		return inj.reject(err, "invalid custom DNS handling")
+	}
+	if pod.Spec.DNSPolicy == corev1.DNSNone {
+		if customDNS == constants.ProxyCustomDNSSkip {
+			logger.Info("Skipping proxy DNS redirection for pod with custom DNS config")
+			disableDNSRedirect = true
+		} else {
+			inj.warnings.add("pod has dnsPolicy %s, but its DNS requests are redirected to the %s component; set %s: %s to leave them unchanged",
+				corev1.DNSNone, constants.InjectAnnotationProxy, constants.ProxyCustomDNSAnnotation, constants.ProxyCustomDNSSkip)
+		}
+	}
+
+	// Optionally set the pod's ndots DNS option, so that qualified names are answered by the proxy
+	// without a series of failed lookups
+	dnsConfig, err := parseBoolAnnotation(pod.Annotations, constants.ProxyDNSConfigAnnotation, false)
+	if err != nil {
+		return inj.reject(err, "invalid proxy DNS config option")
+	}
+
+	// Optionally validate the JWT-SVIDs of incoming requests
+	jwtAuthn, err := a.getJWTAuthn(pod)
+	if err != nil {
+		return inj.reject(err, "invalid JWT authentication config")
+	}
+
+	xdsInitialMetadata, err := a.getXDSInitialMetadata()
+	if err != nil {
+		logger.Error(err, "Error reading xDS authentication token")
+		return fmt.Errorf("error creating proxy config: %w", err)
+	}
+	for _, header := range xdsInitialMetadata {
+		inj.sensitiveValues = append(inj.sensitiveValues, header.Value)
+	}
+
+	// Generate the Envoy configuration
+	configParams := proxy.EnvoyConfigParams{
+		NodeID:                  "node",
+		ClusterName:             "cluster",
+		AdminAddress:            adminAddress,
+		AdminPort:               uint32(adminPort),
+		AgentXDSService:         constants.AgentXDSService,
+		AgentXDSPort:            constants.AgentXDSPort,
+		XDSInitialMetadata:      xdsInitialMetadata,
+		Image:                   a.images.Proxy,
+		InitImage:               a.images.ProxyInit,
+		InitImagePullPolicy:     inj.initPullPolicy,
+		Resources:               resources,
+		InitExtraCommands:       initExtraCommands,
+		InitNetRaw:              initNetRaw,
+		DisableDNSRedirect:      disableDNSRedirect,
+		ConfigVolumeName:        configVolumeName,
+		JWTAuthn:                jwtAuthn,
+		ReadinessListener:       startupProbe,
+		BaseConfig:              a.envoyBaseConfig,
+		Flavor:                  proxyFlavor,
+		ExcludeOutboundPorts:    excludeOutboundPorts,
+		ExcludeDestinationCIDRs: excludeDestCIDRs,
+		IPFamily:                ipFamily,
+		SocketWaitTimeout:       socketWaitTimeout,
+		ConfigVolumeMemory:      inj.configVolumeMemory,
+		WorkloadSocketPath:      inj.wlAPI.socketPath,
+	}
+
+	// Optionally terminate mTLS for incoming connections
+	if err := setInboundPorts(pod, &configParams); err != nil {
+		return inj.reject(err, "invalid inbound mTLS config")
+	}
+
+	// Optionally originate mTLS to upstreams with their expected SPIFFE IDs
+	if value, ok := pod.Annotations[constants.ProxyUpstreamsAnnotation]; ok {
+		configParams.Upstreams, err = proxy.ParseUpstreams(value)
+		if err == nil {
+			err = proxy.ValidateUpstreams(configParams.Upstreams)
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid %s annotation: %w", constants.ProxyUpstreamsAnnotation, err)
+			return inj.reject(err, "invalid proxy upstreams")
+		}
+	}
+
+	a.checkAgentXDS(ctx, configParams.AgentXDSService, inj.warnings)
+
+	// Bound config rendering so a pathological render can't block the API server
+	renderCtx, renderCancel := context.WithTimeout(ctx, a.renderTimeout)
+	renderCtx, renderSpan := a.tracer.Start(renderCtx, spanGenerateConfig,
+		trace.WithAttributes(attribute.String(attributeMode, constants.InjectAnnotationProxy)))
+	envoy, err := proxy.NewEnvoy(renderCtx, configParams)
+	endSpan(renderSpan, err)
+	renderCancel()
+	if err != nil {
+		logger.Error(err, "Error creating proxy config")
+		return fmt.Errorf("error creating proxy config: %w", err)
+	}
+
+	// Add an emptyDir volume for the Envoy proxy configuration if it doesn't already exist
+	if !workload.VolumeExists(pod, configVolumeName) {
+		logger.Info("Adding Envoy config volume", "volumeName", configVolumeName)
+		pod.Spec.Volumes = append(pod.Spec.Volumes, envoy.GetConfigVolume())
+	}
+
+	// Add the Envoy container as a sidecar
+	if !sidecarExists(pod, proxy.EnvoySidecarContainerName) {
+		// Check for a log level annotation
+		logLevel := pod.Annotations[constants.EnvoyLogLevelAnnotation]
+		if logLevel == "" {
+			logLevel = "info"
+		}
+
+		// Envoy is injected as a regular sidecar unless native is requested
+		native := a.useNativeSidecar(inj.sidecarMode, false)
+		sidecar := envoy.GetSidecarContainer(logLevel, native)
+		if native {
+			// Native sidecars start in order, so this must precede the other init containers and be
+			// preceded by the config init container, which is prepended below
+			logger.Info("Adding Envoy proxy native sidecar container", "initContainerName", proxy.EnvoySidecarContainerName)
+			pod.Spec.InitContainers = append([]corev1.Container{sidecar}, pod.Spec.InitContainers...)
+		} else {
+			logger.Info("Adding Envoy proxy sidecar container", "containerName", proxy.EnvoySidecarContainerName)
+			inj.placer.add(pod, sidecar)
+		}
+	}
+
+	// Add an init container to write out the Envoy config to a file
+	if !workload.InitContainerExists(pod, proxy.EnvoyConfigInitContainerName) {
+		logger.Info("Adding init container to inject Envoy config", "initContainerName", proxy.EnvoyConfigInitContainerName)
+		pod.Spec.InitContainers = append([]corev1.Container{envoy.GetInitContainer()}, pod.Spec.InitContainers...)
+	}
+
+	// The probe is added to the app containers. A startup probe already set on a container is kept.
+	if startupProbe {
+		for i := range pod.Spec.Containers {
+			container := &pod.Spec.Containers[i]
+			if !inj.isAppContainer(container.Name) {
+				continue
+			}
+			if container.StartupProbe != nil {
+				inj.warnings.add("container %s already has a startup probe, so it won't wait for the proxy to be ready", container.Name)
+				continue
+			}
+			logger.Info("Adding proxy startup probe to container", "containerName", container.Name)
+			container.StartupProbe = proxy.GetStartupProbe(startupProbeTimeout)
+		}
+	}
+
+	if dnsConfig {
+		ensureProxyDNSConfig(pod, logger)
+	}
+
+	return nil
+}
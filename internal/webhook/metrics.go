@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Reasons for which admission requests are skipped, ie allowed without injection
+const (
+	skipReasonNotPod       = "not_pod"
+	skipReasonNotCreate    = "not_create"
+	skipReasonOwnerKind    = "owner_kind"
+	skipReasonMirrorPod    = "mirror_pod"
+	skipReasonDryRun       = "dry_run"
+	skipReasonNotRequested = "not_requested"
+)
+
+// Reasons for which pods are denied. Pods rejected by other branches are recorded by the
+// response code, as invalid_request or error, to keep the reasons to a fixed set.
+const (
+	denyReasonHostNetwork        = "host_network"
+	denyReasonTrustDomain        = "trust_domain"
+	denyReasonModePolicy         = "mode_policy"
+	denyReasonSidecarResources   = "sidecar_resources"
+	denyReasonContainerCollision = "container_name_collision"
+	denyReasonInvalidRequest     = "invalid_request"
+	denyReasonError              = "error"
+)
+
+// injectModeDebug is the mode label for pods injected with the debug UI
+const injectModeDebug = "debug"
+
+// Admission outcome metrics, registered with the controller-runtime registry so that they are
+// served on the manager's metrics endpoint
+var (
+	injectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spiffe_enable_injections_total",
+		Help: "Number of pods mutated by the webhook, by injected component",
+	}, []string{"mode"})
+	skippedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spiffe_enable_skipped_total",
+		Help: "Number of admission requests allowed without injection, by reason",
+	}, []string{"reason"})
+	deniedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spiffe_enable_denied_total",
+		Help: "Number of pods denied or rejected by the webhook, by reason",
+	}, []string{"reason"})
+	handleDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "spiffe_enable_handle_duration_seconds",
+		Help:    "Latency of handling admission requests, in seconds",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(injectionsTotal, skippedTotal, deniedTotal, handleDuration)
+}
+
+// admissionOutcome records the outcome of an admission request for the metrics
+type admissionOutcome struct {
+	dryRun        bool
+	skipReason    string
+	denyReason    string
+	injectedModes []string
+}
+
+// Results of admission requests, as recorded on their spans
+const (
+	resultInjected = "injected"
+	resultSkipped  = "skipped"
+	resultDenied   = "denied"
+)
+
+// classify returns the result of the response to an admission request and, for a request that was
+// skipped or denied, the reason
+func (o *admissionOutcome) classify(resp admission.Response) (result, reason string) {
+	switch {
+	case o.dryRun:
+		return resultSkipped, skipReasonDryRun
+	case !resp.Allowed:
+		reason := o.denyReason
+		if reason == "" {
+			reason = denyReasonError
+			if resp.Result != nil && resp.Result.Code == http.StatusBadRequest {
+				reason = denyReasonInvalidRequest
+			}
+		}
+		return resultDenied, reason
+	case o.skipReason != "":
+		return resultSkipped, o.skipReason
+	case len(o.injectedModes) == 0:
+		return resultSkipped, skipReasonNotRequested
+	default:
+		return resultInjected, ""
+	}
+}
+
+// record updates the metrics for the response to an admission request, handled in duration
+func (o *admissionOutcome) record(resp admission.Response, duration time.Duration) {
+	handleDuration.Observe(duration.Seconds())
+
+	switch result, reason := o.classify(resp); result {
+	case resultDenied:
+		deniedTotal.WithLabelValues(reason).Inc()
+	case resultSkipped:
+		skippedTotal.WithLabelValues(reason).Inc()
+	default:
+		for _, mode := range o.injectedModes {
+			injectionsTotal.WithLabelValues(mode).Inc()
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// allowedModesNone allows no modes, in place of a list of modes
+const allowedModesNone = "none"
+
+// WithAllowedModes sets the modes, a comma-delimited list as in the inject annotation or none, that
+// may be injected by default, enabling the mode policy. The allowed-modes annotation on a namespace
+// replaces the default for pods in that namespace, so that namespaces can opt back in to modes that
+// are disabled by default, or opt out of modes that are enabled. No policy applies if empty.
+func WithAllowedModes(modes string) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.allowedModes = modes
+	}
+}
+
+// parseAllowedModes parses a list of allowed modes
+func parseAllowedModes(value string) ([]string, error) {
+	if strings.TrimSpace(value) == allowedModesNone {
+		return []string{}, nil
+	}
+	modes, _, invalidModes := parseInjectModes(value)
+	if len(invalidModes) > 0 || len(modes) == 0 {
+		return nil, fmt.Errorf("invalid allowed modes %q: must be %s or a comma-delimited list of %v",
+			value, allowedModesNone, injectModeOrder)
+	}
+	return modes, nil
+}
+
+// validateModePolicy checks the default allowed modes, if the mode policy is enabled
+func (a *spiffeEnableWebhook) validateModePolicy() error {
+	if a.allowedModes == "" {
+		return nil
+	}
+	_, err := parseAllowedModes(a.allowedModes)
+	return err
+}
+
+// checkModePolicy returns a reason to deny injection if any of the modes isn't allowed in namespace.
+// The allowed modes are those of the namespace's allowed-modes annotation if it has one, and
+// otherwise the controller default. A namespace with an invalid annotation is denied all modes.
+func (a *spiffeEnableWebhook) checkModePolicy(ctx context.Context, namespace string, modes []string) (string, error) {
+	if a.allowedModes == "" {
+		return "", nil
+	}
+
+	ns := &corev1.Namespace{}
+	if err := a.Client.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		return "", fmt.Errorf("error getting namespace %s: %w", namespace, err)
+	}
+
+	allowedModes, err := parseAllowedModes(a.allowedModes)
+	if err != nil {
+		return "", err
+	}
+	source := "the controller default"
+	if value, ok := ns.Annotations[constants.AllowedModesAnnotation]; ok {
+		allowedModes, err = parseAllowedModes(value)
+		if err != nil {
+			return fmt.Sprintf("namespace %s has an invalid %s annotation: %v", namespace, constants.AllowedModesAnnotation, err), nil
+		}
+		source = fmt.Sprintf("the %s annotation of namespace %s", constants.AllowedModesAnnotation, namespace)
+	}
+
+	var forbiddenModes []string
+	for _, mode := range modes {
+		if !slices.Contains(allowedModes, mode) {
+			forbiddenModes = append(forbiddenModes, mode)
+		}
+	}
+	if len(forbiddenModes) > 0 {
+		return fmt.Sprintf("mode(s) %v are not allowed in namespace %s, which allows %v as set by %s",
+			forbiddenModes, namespace, allowedModes, source), nil
+	}
+	return "", nil
+}
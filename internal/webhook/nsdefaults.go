@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// namespaceDefaultKeys maps the keys of a namespace's defaults ConfigMap to the pod annotations
+// whose default they set
+var namespaceDefaultKeys = map[string]string{
+	"inject": constants.InjectAnnotation,
+	"debug":  constants.DebugAnnotation,
+}
+
+// WithNamespaceDefaults sets whether the webhook looks up default annotations for the pods in a
+// namespace from its NamespaceDefaultsConfigMap, so that they needn't be set on every pod template.
+// The lookup requires the webhook to be able to read ConfigMaps, so it is disabled by default. The
+// ConfigMaps are read through the client's cache, which should be restricted to those named
+// NamespaceDefaultsConfigMap rather than hold every ConfigMap in the cluster.
+func WithNamespaceDefaults(enabled bool) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.namespaceDefaults = enabled
+	}
+}
+
+// applyNamespaceDefaults sets the annotations that a pod doesn't already have from the defaults
+// ConfigMap of its namespace, if it has one, returning the annotations that were set. Explicit pod
+// annotations, including empty ones, take precedence over the defaults.
+func (a *spiffeEnableWebhook) applyNamespaceDefaults(ctx context.Context, namespace string, pod *corev1.Pod,
+	warnings *admissionWarnings) ([]string, error) {
+	if !a.namespaceDefaults {
+		return nil, nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	key := client.ObjectKey{Namespace: namespace, Name: constants.NamespaceDefaultsConfigMap}
+	if err := a.Client.Get(ctx, key, cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting ConfigMap %s: %w", key, err)
+	}
+
+	// The keys are sorted so that the warnings are in a stable order
+	var applied []string
+	for _, key := range slices.Sorted(maps.Keys(cm.Data)) {
+		value := cm.Data[key]
+		annotation, ok := namespaceDefaultKeys[key]
+		if !ok {
+			warnings.add("ConfigMap %s/%s has unknown key %q, which is ignored",
+				namespace, constants.NamespaceDefaultsConfigMap, key)
+			continue
+		}
+		if _, ok := pod.Annotations[annotation]; ok {
+			continue
+		}
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[annotation] = value
+		applied = append(applied, annotation)
+	}
+	slices.Sort(applied)
+	return applied, nil
+}
@@ -0,0 +1,19 @@
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ReadyCheck is a readiness check (a healthz.Checker) that fails while the templates rendered on
+// injection don't parse or the configured images aren't valid references, so that a misconfigured
+// controller isn't sent admission requests and never mutates pods with a bad config
+func (a *spiffeEnableWebhook) ReadyCheck(_ *http.Request) error {
+	if err := a.validateTemplates(); err != nil {
+		return fmt.Errorf("invalid templates: %w", err)
+	}
+	if err := a.images.validate(); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	"github.com/cofide/spiffe-enable/internal/helper"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// WithRequireSidecarResources sets whether injection is denied for pods that don't set the
+// resources of each sidecar to be injected with its annotations, rather than relying on the
+// defaults
+func WithRequireSidecarResources(require bool) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.requireSidecarResources = require
+	}
+}
+
+// checkSidecarResources returns a reason to deny injection if sidecar resources are required and
+// the pod doesn't set them for each of the sidecars of the components to be injected
+func (a *spiffeEnableWebhook) checkSidecarResources(pod *corev1.Pod, modes []string) string {
+	if !a.requireSidecarResources {
+		return ""
+	}
+
+	var missing []string
+	for _, sidecar := range []struct {
+		mode        string
+		annotations []string
+	}{
+		{constants.InjectAnnotationHelper, []string{helper.SPIFFEHelperResourcesAnnotation}},
+		{constants.InjectAnnotationProxy, []string{constants.ProxyResourcesAnnotation, constants.ProxySizeAnnotation}},
+	} {
+		if !slices.Contains(modes, sidecar.mode) {
+			continue
+		}
+		if !slices.ContainsFunc(sidecar.annotations, func(annotation string) bool {
+			_, ok := pod.Annotations[annotation]
+			return ok
+		}) {
+			missing = append(missing, fmt.Sprintf("the %s component requires the %s annotation",
+				sidecar.mode, strings.Join(sidecar.annotations, " or ")))
+		}
+	}
+
+	if len(missing) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("sidecar resources must be set: %s", strings.Join(missing, "; "))
+}
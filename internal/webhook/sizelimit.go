@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// DefaultMaxEnvVarSize is the default limit on the size of an injected environment variable.
+	// Linux limits each string in a process's environment to 128KiB (including the name, the '='
+	// and the terminating NUL), so containers with a larger variable fail to start.
+	DefaultMaxEnvVarSize = 128 * 1024
+	// DefaultMaxAnnotationsSize is the default limit on the total size of a mutated pod's
+	// annotations, the limit the API server enforces
+	DefaultMaxAnnotationsSize = 256 * 1024
+)
+
+// WithMaxEnvVarSize sets the maximum size, in bytes, of an environment variable injected into a
+// pod, such as the rendered sidecar configs. Defaults to DefaultMaxEnvVarSize.
+func WithMaxEnvVarSize(size int) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.maxEnvVarSize = size
+	}
+}
+
+// WithMaxAnnotationsSize sets the maximum total size, in bytes, of the annotations of a mutated
+// pod. Defaults to DefaultMaxAnnotationsSize.
+func WithMaxAnnotationsSize(size int) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.maxAnnotationsSize = size
+	}
+}
+
+// validateSizeLimits checks that the size limits are positive
+func (a *spiffeEnableWebhook) validateSizeLimits() error {
+	if a.maxEnvVarSize <= 0 {
+		return fmt.Errorf("invalid maximum environment variable size %d: must be positive", a.maxEnvVarSize)
+	}
+	if a.maxAnnotationsSize <= 0 {
+		return fmt.Errorf("invalid maximum annotations size %d: must be positive", a.maxAnnotationsSize)
+	}
+	return nil
+}
+
+// checkSizeLimits returns an error if the environment variables added to the pod, or its
+// annotations, exceed the size limits. Without the check, such pods are rejected by the API
+// server or fail to start with errors that don't point to the injected config.
+func (a *spiffeEnableWebhook) checkSizeLimits(originalPod, pod *corev1.Pod) error {
+	for _, container := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+		original := findContainer(originalPod, container.Name)
+		for _, envVar := range container.Env {
+			if original != nil && slices.ContainsFunc(original.Env, func(e corev1.EnvVar) bool { return e.Name == envVar.Name }) {
+				continue
+			}
+			// NAME=VALUE, NUL-terminated
+			if size := len(envVar.Name) + len(envVar.Value) + 2; size > a.maxEnvVarSize {
+				return fmt.Errorf("environment variable %s injected into container %s is %d bytes, more than the limit of %d bytes; "+
+					"reduce the configuration requested by the pod's annotations", envVar.Name, container.Name, size, a.maxEnvVarSize)
+			}
+		}
+	}
+
+	size := 0
+	for key, value := range pod.Annotations {
+		size += len(key) + len(value)
+	}
+	if size > a.maxAnnotationsSize {
+		return fmt.Errorf("pod annotations are %d bytes after injection, more than the limit of %d bytes; "+
+			"reduce the size of the pod's annotations", size, a.maxAnnotationsSize)
+	}
+	return nil
+}
+
+// findContainer returns the pod's init container or container with the given name, if any
+func findContainer(pod *corev1.Pod, name string) *corev1.Container {
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		if idx := slices.IndexFunc(containers, func(c corev1.Container) bool { return c.Name == name }); idx != -1 {
+			return &containers[idx]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const tracerName = "github.com/cofide/spiffe-enable/internal/webhook"
+
+// Spans of the phases of handling an admission request. The names, and the values of the
+// attributes below, are drawn from fixed sets so that they don't add to the traces' cardinality;
+// in particular, pod names are never recorded.
+const (
+	spanHandle         = "Handle"
+	spanDecode         = "decode"
+	spanDecide         = "decide"
+	spanGenerateConfig = "generate_config"
+)
+
+const (
+	attributeResult = "spiffe_enable.result"
+	attributeReason = "spiffe_enable.reason"
+	attributeModes  = "spiffe_enable.modes"
+	attributeMode   = "spiffe_enable.mode"
+	attributeDryRun = "spiffe_enable.dry_run"
+)
+
+// WithTracerProvider sets the provider of the tracer with which admission requests are traced.
+// Tracing is a no-op by default.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.tracer = provider.Tracer(tracerName)
+	}
+}
+
+func newNoopTracer() trace.Tracer {
+	return noop.NewTracerProvider().Tracer(tracerName)
+}
+
+// annotate records the outcome of an admission request on its span
+func (o *admissionOutcome) annotate(span trace.Span, resp admission.Response) {
+	result, reason := o.classify(resp)
+	span.SetAttributes(
+		attribute.String(attributeResult, result),
+		attribute.StringSlice(attributeModes, o.injectedModes),
+		attribute.Bool(attributeDryRun, o.dryRun),
+	)
+	if reason != "" {
+		span.SetAttributes(attribute.String(attributeReason, reason))
+	}
+	if result == resultDenied && reason == denyReasonError {
+		span.SetStatus(codes.Error, reason)
+	}
+}
+
+// endSpan ends a span, recording err as its status if set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
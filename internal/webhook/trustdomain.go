@@ -0,0 +1,45 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	constants "github.com/cofide/spiffe-enable/internal/const"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// WithAllowedTrustDomains restricts injection to pods in namespaces mapped to one of the given
+// trust domains with the trust-domain namespace annotation. No restriction applies if empty.
+func WithAllowedTrustDomains(trustDomains []string) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.allowedTrustDomains = trustDomains
+	}
+}
+
+// checkTrustDomain returns a reason to deny injection if namespace is mapped to a trust domain that
+// isn't allowed. Namespaces without a mapping are allowed, with a warning.
+func (a *spiffeEnableWebhook) checkTrustDomain(ctx context.Context, namespace string, warnings *admissionWarnings) (string, error) {
+	if len(a.allowedTrustDomains) == 0 {
+		return "", nil
+	}
+
+	ns := &corev1.Namespace{}
+	if err := a.Client.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		return "", fmt.Errorf("error getting namespace %s: %w", namespace, err)
+	}
+
+	trustDomain, ok := ns.Annotations[constants.TrustDomainAnnotation]
+	if !ok {
+		warnings.add("namespace %s has no %s annotation, so its trust domain can't be checked against the allowed trust domains",
+			namespace, constants.TrustDomainAnnotation)
+		return "", nil
+	}
+
+	if !slices.Contains(a.allowedTrustDomains, trustDomain) {
+		return fmt.Sprintf("namespace %s is mapped to trust domain %q, which is not one of the allowed trust domains %v",
+			namespace, trustDomain, a.allowedTrustDomains), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,25 @@
+package webhook
+
+import "fmt"
+
+// admissionWarnings accumulates warnings from all stages of a mutation so that they
+// can be returned together on the admission response
+type admissionWarnings struct {
+	warnings []string
+}
+
+// add records a warning, ignoring duplicates
+func (w *admissionWarnings) add(format string, args ...any) {
+	warning := fmt.Sprintf(format, args...)
+	for _, existing := range w.warnings {
+		if existing == warning {
+			return
+		}
+	}
+	w.warnings = append(w.warnings, warning)
+}
+
+// list returns the accumulated warnings in the order they were added
+func (w *admissionWarnings) list() []string {
+	return w.warnings
+}
@@ -4,228 +4,675 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	constants "github.com/cofide/spiffe-enable/internal/const"
 	"github.com/cofide/spiffe-enable/internal/helper"
 	"github.com/cofide/spiffe-enable/internal/proxy"
 	"github.com/cofide/spiffe-enable/internal/workload"
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
-const annotationValueTrue = "true"
+const (
+	annotationValueTrue = "true"
+	// defaultContainerAnnotation selects the container that kubectl commands use by default
+	defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+	// defaultProxyStartupProbeTimeout is how long app containers wait for Envoy to be ready, if
+	// the startup probe is enabled without a timeout
+	defaultProxyStartupProbeTimeout = time.Minute
+	// defaultProxySocketWaitTimeout is how long the proxy init container waits for the Workload API
+	// socket, if the wait is enabled without a timeout
+	defaultProxySocketWaitTimeout = time.Minute
+	// proxyDNSNdots is the ndots option set on pods when the proxy DNS config is requested
+	proxyDNSNdots = "1"
+)
+
+// envVarNameRegex matches valid (POSIX-style) environment variable names
+var envVarNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
 
 type spiffeEnableWebhook struct {
-	Client  client.Client
-	decoder admission.Decoder
-	Log     logr.Logger
+	Client                    client.Client
+	decoder                   admission.Decoder
+	Log                       logr.Logger
+	renderTimeout             time.Duration
+	nativeSidecarsSupported   bool
+	sleepActionSupported      bool
+	xdsTokenHeader            string
+	xdsToken                  string
+	xdsTokenFile              string
+	skipOwnerKinds            map[string]bool
+	images                    ImageConfig
+	jwksURI                   string
+	envoyBaseConfig           []byte
+	version                   string
+	allowedTrustDomains       []string
+	allowedModes              string
+	denyNameCollisions        bool
+	requireSidecarResources   bool
+	autoInjectMode            string
+	autoInjectImagePrefixes   []string
+	autoInjectServiceAccounts []string
+	maxEnvVarSize             int
+	maxAnnotationsSize        int
+	agentXDSCheck             *agentXDSCheck
+	namespaceDefaults         bool
+	tracer                    trace.Tracer
+	validateTemplates         func() error
+	now                       func() time.Time
 }
 
-var (
-	debugUIImage string
-)
+// Option configures optional behaviour of the webhook
+type Option func(*spiffeEnableWebhook)
 
-func NewSpiffeEnableWebhook(client client.Client, log logr.Logger, decoder admission.Decoder) (*spiffeEnableWebhook, error) {
-	debugUIImage = getEnvWithDefault(constants.EnvVarUIImage, constants.DefaultDebugUIImage)
+// WithNativeSidecarSupport sets whether the cluster supports native sidecars
+// (init containers with restartPolicy Always). Defaults to true.
+func WithNativeSidecarSupport(supported bool) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.nativeSidecarsSupported = supported
+	}
+}
 
-	log.Info(debugUIImage)
+// WithSleepActionSupport sets whether the cluster supports the sleep action for lifecycle hooks,
+// which the spiffe-helper preStop hook uses. Defaults to true.
+func WithSleepActionSupport(supported bool) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.sleepActionSupported = supported
+	}
+}
+
+// WithVersion sets the controller version recorded in the injected-by annotation
+func WithVersion(version string) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.version = version
+	}
+}
 
-	return &spiffeEnableWebhook{
-		Client:  client,
-		Log:     log,
-		decoder: decoder,
-	}, nil
+// WithSkipOwnerKinds skips injection for pods with an owner reference of one of the
+// given kinds, eg Job, whose pods may be prevented from completing by sidecars
+func WithSkipOwnerKinds(kinds []string) Option {
+	return func(w *spiffeEnableWebhook) {
+		w.skipOwnerKinds = make(map[string]bool, len(kinds))
+		for _, kind := range kinds {
+			w.skipOwnerKinds[kind] = true
+		}
+	}
 }
 
-func (a *spiffeEnableWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+func NewSpiffeEnableWebhook(client client.Client, log logr.Logger, decoder admission.Decoder, opts ...Option) (*spiffeEnableWebhook, error) {
+	renderTimeout, err := getDurationEnvWithDefault(constants.EnvVarRenderTimeout, constants.DefaultRenderTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	xdsTokenHeader := getEnvWithDefault(constants.EnvVarXDSTokenHeader, constants.DefaultXDSTokenHeader)
+	if err := proxy.ValidateXDSHeaderKey(xdsTokenHeader); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", constants.EnvVarXDSTokenHeader, err)
+	}
+
+	// The base config is read once, and checked by rendering a config with it, so that an invalid
+	// base config fails at startup rather than on every injection
+	var envoyBaseConfig []byte
+	if baseConfigFile := os.Getenv(constants.EnvVarEnvoyBaseConfigFile); baseConfigFile != "" {
+		envoyBaseConfig, err = os.ReadFile(baseConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", constants.EnvVarEnvoyBaseConfigFile, err)
+		}
+		if _, err := proxy.NewEnvoy(context.Background(), proxy.EnvoyConfigParams{BaseConfig: envoyBaseConfig}); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", constants.EnvVarEnvoyBaseConfigFile, err)
+		}
+	}
+
+	webhook := &spiffeEnableWebhook{
+		Client:                  client,
+		Log:                     log,
+		decoder:                 decoder,
+		renderTimeout:           renderTimeout,
+		nativeSidecarsSupported: true,
+		sleepActionSupported:    true,
+		xdsTokenHeader:          xdsTokenHeader,
+		xdsToken:                os.Getenv(constants.EnvVarXDSToken),
+		xdsTokenFile:            os.Getenv(constants.EnvVarXDSTokenFile),
+		images:                  imageConfigFromEnv(),
+		jwksURI:                 os.Getenv(constants.EnvVarJWKSURI),
+		envoyBaseConfig:         envoyBaseConfig,
+		version:                 "unknown",
+		autoInjectMode:          constants.InjectCSIVolume,
+		maxEnvVarSize:           DefaultMaxEnvVarSize,
+		maxAnnotationsSize:      DefaultMaxAnnotationsSize,
+		agentXDSCheck:           newAgentXDSCheck(),
+		tracer:                  newNoopTracer(),
+		validateTemplates:       proxy.ValidateTemplates,
+		now:                     time.Now,
+	}
+	for _, opt := range opts {
+		opt(webhook)
+	}
+
+	if err := webhook.images.validate(); err != nil {
+		return nil, err
+	}
+	if err := webhook.validateAutoInject(); err != nil {
+		return nil, err
+	}
+	if err := webhook.validateModePolicy(); err != nil {
+		return nil, err
+	}
+	if err := webhook.validateSizeLimits(); err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+func (a *spiffeEnableWebhook) Handle(ctx context.Context, req admission.Request) (resp admission.Response) {
+	// Warnings from all stages are returned together, whatever the outcome. In dry-run mode, pods
+	// that would be rejected are allowed instead, with a warning, so that a preview never blocks them.
+	warnings := &admissionWarnings{}
+	dryRun := false
+	start := time.Now()
+	outcome := &admissionOutcome{}
+	ctx, span := a.tracer.Start(ctx, spanHandle)
+	// The decision phase may end early, with the request
+	decideSpan := trace.Span(noop.Span{})
+	defer func() {
+		outcome.dryRun = dryRun
+		outcome.record(resp, time.Since(start))
+		decideSpan.End()
+		outcome.annotate(span, resp)
+		span.End()
+		if dryRun && !resp.Allowed {
+			a.Log.Info("Dry run, pod would have been rejected", "reason", resp.Result.Message, "request", req.UID)
+			warnings.add("dry run: the pod would have been rejected: %s", resp.Result.Message)
+			resp = admission.Allowed("dry run: the pod would have been rejected")
+		}
+		resp = resp.WithWarnings(warnings.list()...)
+	}()
+
+	// Only pods are mutated. A misconfigured webhook configuration may send other kinds, which
+	// are allowed unchanged rather than rejected so that the webhook can't block unrelated objects.
+	if req.Kind.Group != corev1.GroupName || req.Kind.Kind != "Pod" {
+		a.Log.Info("Ignoring non-pod object", "kind", req.Kind.String(), "request", req.UID)
+		warnings.add("spiffe-enable webhook ignored unexpected %s object; check the webhook configuration", req.Kind.Kind)
+		outcome.skipReason = skipReasonNotPod
+		return admission.Allowed("not a pod")
+	}
+
+	// Only new pods are mutated. Containers of an existing pod can't be added or removed, so
+	// injecting into (or removing injected sidecars from) a running pod on update would either be
+	// rejected or pull components out from under the application. Changes to injection therefore
+	// only take effect for pods created after the change, eg from an updated template.
+	if req.Operation != admissionv1.Create {
+		a.Log.Info("Ignoring non-create operation", "operation", req.Operation, "request", req.UID)
+		outcome.skipReason = skipReasonNotCreate
+		return admission.Allowed(fmt.Sprintf("%s operations are not mutated", req.Operation))
+	}
+
 	pod := &corev1.Pod{}
-	if err := a.decoder.Decode(req, pod); err != nil {
+	_, decodeSpan := a.tracer.Start(ctx, spanDecode)
+	err := a.decoder.Decode(req, pod)
+	endSpan(decodeSpan, err)
+	if err != nil {
 		a.Log.Error(err, "Failed to decode pod", "request", req.UID)
 		return admission.Errored(http.StatusBadRequest, err)
 	}
+	_, decideSpan = a.tracer.Start(ctx, spanDecide)
+	originalPod := pod.DeepCopy()
+	// A mistyped value is rejected rather than ignored, as it would otherwise mutate (or reject) the
+	// pod that was meant to be previewed
+	dryRun, err = parseBoolAnnotation(pod.Annotations, constants.DryRunAnnotation, false)
+	if err != nil {
+		a.Log.Error(err, "Pod rejected due to invalid dry run option", "request", req.UID)
+		return admission.Errored(http.StatusBadRequest, err)
+	}
 
 	logger := a.Log.WithValues("podNamespace", pod.Namespace, "podName", pod.Name, "request", req.UID)
 
-	// Check for a debug annotation
-	debugAnnotationValue, debugAnnotationExists := pod.Annotations[constants.DebugAnnotation]
+	// Skip injection entirely for mirror pods, which the kubelet creates to represent its static
+	// pods. The kubelet runs the static pod from its own manifest, so a mutation would only make the
+	// mirror pod misrepresent it.
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		logger.Info("Skipping injection for mirror pod")
+		warnings.add("spiffe-enable injection skipped for mirror pod; add components to the static pod's manifest instead")
+		outcome.skipReason = skipReasonMirrorPod
+		return admission.Allowed("injection skipped for mirror pods")
+	}
 
-	if debugAnnotationExists && debugAnnotationValue == annotationValueTrue {
-		// Ensure the CSI volume is injected and mounted to containers
-		ensureCSIVolumeAndMount(pod, logger)
-
-		if !workload.ContainerExists(pod.Spec.Containers, constants.DebugUIContainerName) {
-			logger.Info("Adding SPIFFE Enable debug UI container", "containerName", constants.DebugUIContainerName)
-			debugSidecar := corev1.Container{
-				Name:            constants.DebugUIContainerName,
-				Image:           debugUIImage,
-				ImagePullPolicy: corev1.PullAlways,
-				Ports: []corev1.ContainerPort{
-					{
-						ContainerPort: constants.DebugUIPort,
-					},
-				},
-			}
-			pod.Spec.Containers = append(pod.Spec.Containers, debugSidecar)
+	// Skip injection entirely for pods owned by an excluded kind
+	for _, owner := range pod.OwnerReferences {
+		if a.skipOwnerKinds[owner.Kind] {
+			logger.Info("Skipping injection for pod with excluded owner kind", "ownerKind", owner.Kind, "ownerName", owner.Name)
+			outcome.skipReason = skipReasonOwnerKind
+			return admission.Allowed(fmt.Sprintf("injection skipped for pods owned by %s", owner.Kind))
 		}
 	}
 
-	// Check for an inject annotation and process based on the value
-	injectAnnotationValue, injectAnnotationExists := pod.Annotations[constants.InjectAnnotation]
+	// Annotations that a pod doesn't set may be defaulted for its namespace, taking precedence over
+	// auto-injection. Pods created by controllers may not have a namespace set, but the request
+	// always does.
+	defaulted, err := a.applyNamespaceDefaults(ctx, req.Namespace, pod, warnings)
+	if err != nil {
+		logger.Error(err, "Error getting namespace defaults")
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if len(defaulted) > 0 {
+		logger.Info("Applied namespace default annotations", "annotations", defaulted)
+	}
 
-	allowedModes := map[string]bool{
-		constants.InjectAnnotationHelper: true,
-		constants.InjectAnnotationProxy:  true,
-		constants.InjectCSIVolume:        true,
+	// Pods without an inject annotation are injected with the auto-inject mode if they use a matching
+	// image or service account. The annotation is set on the pod, so that the injection is visible,
+	// and so that a pod can opt out by setting it (eg to an empty value). Pods created by controllers
+	// may not have a namespace set, but the request always does.
+	if _, ok := pod.Annotations[constants.InjectAnnotation]; !ok {
+		if reason := a.autoInjectReason(pod, req.Namespace); reason != "" {
+			logger.Info("Auto-injecting pod", "reason", reason, "mode", a.autoInjectMode)
+			if pod.Annotations == nil {
+				pod.Annotations = make(map[string]string)
+			}
+			pod.Annotations[constants.InjectAnnotation] = a.autoInjectMode
+		}
 	}
 
-	var invalidModes []string
+	// Parse the annotations that apply to all of the injected components, and add any extra
+	// environment to the application containers
+	inj, err := a.newInjection(pod, originalPod, logger, warnings)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if err := inj.applyAppEnv(); err != nil {
+		return errorResponse(err)
+	}
 
-	if injectAnnotationExists {
-		toInject := strings.Split(injectAnnotationValue, ",")
+	// Check for a debug annotation
+	debugAnnotationValue, debugAnnotationExists := pod.Annotations[constants.DebugAnnotation]
 
-		// First check that the desired injections are permitted
-		for _, mode := range toInject {
-			trimmedMode := strings.TrimSpace(mode)
-			if trimmedMode == "" {
-				continue
-			}
+	// Check for user containers with the names of containers to be injected, which would otherwise
+	// be mistaken for injected containers and silently prevent injection
+	injectedNames := injectedContainerNames(pod.Annotations[constants.InjectAnnotation],
+		debugAnnotationExists && debugAnnotationValue == annotationValueTrue)
+	if denyReason := a.checkContainerNameCollisions(pod, injectedNames, warnings); denyReason != "" {
+		logger.Info("Pod denied due to container name collision", "reason", denyReason)
+		outcome.denyReason = denyReasonContainerCollision
+		return admission.Denied(denyReason)
+	}
 
-			if _, isValid := allowedModes[trimmedMode]; !isValid {
-				invalidModes = append(invalidModes, trimmedMode)
-			}
+	if debugAnnotationExists && debugAnnotationValue == annotationValueTrue {
+		outcome.injectedModes = append(outcome.injectedModes, injectModeDebug)
+
+		if err := a.injectDebugUI(inj); err != nil {
+			return errorResponse(err)
 		}
+	}
+
+	// Check for an inject annotation and process based on the value
+	injectAnnotationValue, injectAnnotationExists := pod.Annotations[constants.InjectAnnotation]
 
+	if injectAnnotationExists {
+		toInject, duplicateModes, invalidModes := parseInjectModes(injectAnnotationValue)
 		if len(invalidModes) > 0 {
 			err := fmt.Errorf(
 				"invalid mode(s) found in injection list: %v. Allowed modes are: %v",
 				strings.Join(invalidModes, ", "),
-				getKeys(allowedModes),
+				injectModeOrder,
 			)
 			logger.Error(err, "Pod rejected due to invalid injection modes", "providedModes", injectAnnotationValue, "invalidFound", invalidModes)
 			return admission.Errored(http.StatusBadRequest, err)
 		}
+		if len(duplicateModes) > 0 {
+			warnings.add("annotation %s lists mode(s) %v more than once; each mode is injected once",
+				constants.InjectAnnotation, duplicateModes)
+		}
+
+		// The proxy's nftables rules would be applied to the host's network namespace for a pod using
+		// the host network, redirecting the host's traffic, and the proxy's loopback interception
+		// doesn't hold there
+		if pod.Spec.HostNetwork && slices.Contains(toInject, constants.InjectAnnotationProxy) {
+			reason := fmt.Sprintf("the %s component can't be injected into pods using the host network",
+				constants.InjectAnnotationProxy)
+			logger.Info("Pod denied due to proxy injection with host network")
+			outcome.denyReason = denyReasonHostNetwork
+			return admission.Denied(reason)
+		}
+
+		// Pods created by controllers may not have a namespace set, but the request always does
+		denyReason, err := a.checkTrustDomain(ctx, req.Namespace, warnings)
+		if err != nil {
+			logger.Error(err, "Error checking trust domain")
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		if denyReason != "" {
+			logger.Info("Pod denied due to trust domain policy", "reason", denyReason)
+			outcome.denyReason = denyReasonTrustDomain
+			return admission.Denied(denyReason)
+		}
+
+		denyReason, err = a.checkModePolicy(ctx, req.Namespace, toInject)
+		if err != nil {
+			logger.Error(err, "Error checking mode policy")
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		if denyReason != "" {
+			logger.Info("Pod denied due to mode policy", "reason", denyReason)
+			outcome.denyReason = denyReasonModePolicy
+			return admission.Denied(denyReason)
+		}
+
+		if denyReason := a.checkSidecarResources(pod, toInject); denyReason != "" {
+			logger.Info("Pod denied due to missing sidecar resources", "reason", denyReason)
+			outcome.denyReason = denyReasonSidecarResources
+			return admission.Denied(denyReason)
+		}
+
+		decideSpan.End()
 
 		// Now iterate the injections and apply
+		outcome.injectedModes = append(outcome.injectedModes, toInject...)
 		for _, mode := range toInject {
+			var err error
 			switch mode {
 			case constants.InjectCSIVolume:
 				// Ensure the CSI volume is injected and mounted to containers
-				ensureCSIVolumeAndMount(pod, logger)
-
+				ensureCSIVolumeAndMount(pod, inj.wlAPI, logger)
 			case constants.InjectAnnotationProxy:
-				// Ensure the CSI volume is injected and mounted to containers
-				ensureCSIVolumeAndMount(pod, logger)
-
-				// Generate the Envoy configuration
-				configParams := proxy.EnvoyConfigParams{
-					NodeID:          "node",
-					ClusterName:     "cluster",
-					AdminPort:       9901,
-					AgentXDSService: constants.AgentXDSService,
-					AgentXDSPort:    constants.AgentXDSPort,
-				}
-
-				envoy, err := proxy.NewEnvoy(configParams)
-				if err != nil {
-					logger.Error(err, "Error creating proxy config")
-					return admission.Errored(http.StatusInternalServerError, fmt.Errorf("error creating proxy config: %w", err))
-				}
-
-				// Add an emptyDir volume for the Envoy proxy configuration if it doesn't already exist
-				if !workload.VolumeExists(pod, proxy.EnvoyConfigVolumeName) {
-					logger.Info("Adding Envoy config volume", "volumeName", proxy.EnvoyConfigVolumeName)
-					pod.Spec.Volumes = append(pod.Spec.Volumes, envoy.GetConfigVolume())
-				}
-
-				// Add an init container to write out the Envoy config to a file
-				if !workload.InitContainerExists(pod, proxy.EnvoyConfigInitContainerName) {
-					logger.Info("Adding init container to inject Envoy config", "initContainerName", proxy.EnvoyConfigInitContainerName)
-					pod.Spec.InitContainers = append([]corev1.Container{envoy.GetInitContainer()}, pod.Spec.InitContainers...)
-				}
-
-				// Add the Envoy container as a sidecar
-				if !workload.ContainerExists(pod.Spec.Containers, proxy.EnvoySidecarContainerName) {
-					logger.Info("Adding Envoy proxy sidecar container", "containerName", proxy.EnvoySidecarContainerName)
-
-					// Check for a log level annotation
-					logLevel := pod.Annotations[constants.EnvoyLogLevelAnnotation]
-					if logLevel == "" {
-						logLevel = "info"
-					}
-
-					pod.Spec.Containers = append(pod.Spec.Containers, envoy.GetSidecarContainer(logLevel))
-				}
-
+				err = a.injectProxy(ctx, inj)
 			case constants.InjectAnnotationHelper:
-				// Ensure the CSI volume is injected and mounted to containers
-				ensureCSIVolumeAndMount(pod, logger)
-
-				// Inject a spiffe-helper sidecar container
-				logger.Info("Applying 'helper' mode mutations")
-
-				incIntermediateBundle := false
-				incIntermediateValue, incIntermediateExists := pod.Annotations[helper.SPIFFEHelperIncIntermediateAnnotation]
-				if incIntermediateExists && incIntermediateValue == annotationValueTrue {
-					incIntermediateBundle = true
-				}
-
-				// Generate the spiffe-helper configuration
-				configParams := helper.SPIFFEHelperConfigParams{
-					AgentAddress:              constants.SPIFFEWLSocketPath,
-					CertPath:                  constants.SPIFFEEnableCertDirectory,
-					IncludeIntermediateBundle: incIntermediateBundle,
-				}
-
-				spiffeHelper, err := helper.NewSPIFFEHelper(configParams)
-				if err != nil {
-					logger.Error(err, "Error creating spiffe-helper config")
-					return admission.Errored(http.StatusInternalServerError,
-						fmt.Errorf("error creating spiffe-helper config: %w", err))
-				}
-
-				// Add an emptyDir volume for the SPIFFE Helper configuration if it doesn't already exist
-				if !workload.VolumeExists(pod, helper.SPIFFEHelperConfigVolumeName) {
-					logger.Info("Adding spiffe-helper config volume", "volumeName", helper.SPIFFEHelperConfigVolumeName)
-					pod.Spec.Volumes = append(pod.Spec.Volumes, spiffeHelper.GetConfigVolume())
-				}
-
-				// Add an emptyDir volume for the certs managed by SPIFFE Helper
-				if !workload.VolumeExists(pod, constants.SPIFFEEnableCertVolumeName) {
-					logger.Info("Adding spiffe-helper certs volume", "volumeName", constants.SPIFFEEnableCertVolumeName)
-					pod.Spec.Volumes = append(pod.Spec.Volumes, getCertsVolume())
-				}
-
-				if !workload.InitContainerExists(pod, helper.SPIFFEHelperSidecarContainerName) {
-					logger.Info("Adding spiffe-helper sidecar container", "initContainerName", helper.SPIFFEHelperSidecarContainerName)
-					pod.Spec.InitContainers = append([]corev1.Container{spiffeHelper.GetSidecarContainer()}, pod.Spec.InitContainers...)
-				}
-
-				if !workload.InitContainerExists(pod, helper.SPIFFEHelperInitContainerName) {
-					logger.Info("Adding init container to inject spiffe-helper config", "initContainerName", helper.SPIFFEHelperInitContainerName)
-					pod.Spec.InitContainers = append([]corev1.Container{spiffeHelper.GetInitContainer()}, pod.Spec.InitContainers...)
-				}
+				err = a.injectHelper(ctx, inj)
+			}
+			if err != nil {
+				return errorResponse(err)
 			}
 		}
 	}
 
+	checkPodWarnings(pod, warnings)
+
+	// The image pull secrets are only added to pods that have been mutated, ie that have had
+	// components injected
+	if !equality.Semantic.DeepEqual(originalPod, pod) {
+		ensureImagePullSecrets(pod, inj.imagePullSecrets, logger)
+		a.setAuditAnnotations(pod)
+	}
+
+	if err := a.checkSizeLimits(originalPod, pod); err != nil {
+		logger.Error(err, "Pod rejected due to oversized injected configuration")
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
 	marshaledPod, err := json.Marshal(pod)
 	if err != nil {
 		logger.Error(err, "Failed to marshal modified pod")
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
+	logMutatedPod(logger, pod, inj.sensitiveValues)
+
+	// In dry-run mode, the mutations are logged but not made
+	if dryRun {
+		logDryRun(logger, originalPod, pod, inj.sensitiveValues)
+		return admission.Allowed("dry run: the pod was not mutated")
+	}
 
 	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
 }
 
-func getCertsVolume() corev1.Volume {
+// injectModeOrder lists the valid inject modes in the order they are applied, so that the mutated
+// pod doesn't depend on the order in which they are listed in the annotation
+var injectModeOrder = []string{
+	constants.InjectCSIVolume,
+	constants.InjectAnnotationHelper,
+	constants.InjectAnnotationProxy,
+}
+
+// parseInjectModes parses the comma-delimited inject annotation value, returning the valid modes
+// once each in application order, along with any modes that were listed more than once and any
+// invalid modes
+func parseInjectModes(value string) (modes, duplicates, invalid []string) {
+	seen := make(map[string]bool)
+	for _, mode := range strings.Split(value, ",") {
+		mode = strings.TrimSpace(mode)
+		if mode == "" {
+			continue
+		}
+		if !slices.Contains(injectModeOrder, mode) {
+			invalid = append(invalid, mode)
+			continue
+		}
+		if seen[mode] && !slices.Contains(duplicates, mode) {
+			duplicates = append(duplicates, mode)
+		}
+		seen[mode] = true
+	}
+
+	for _, mode := range injectModeOrder {
+		if seen[mode] {
+			modes = append(modes, mode)
+		}
+	}
+	return modes, duplicates, invalid
+}
+
+// setAuditAnnotations records when and by which controller version the pod was mutated. An
+// existing injected-at annotation is kept, so that the record is of the first injection.
+func (a *spiffeEnableWebhook) setAuditAnnotations(pod *corev1.Pod) {
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	if _, ok := pod.Annotations[constants.InjectedAtAnnotation]; ok {
+		return
+	}
+	pod.Annotations[constants.InjectedAtAnnotation] = a.now().UTC().Format(time.RFC3339)
+	pod.Annotations[constants.InjectedByAnnotation] = a.version
+}
+
+// getXDSInitialMetadata returns the headers carrying the xDS authentication token, if one is configured.
+// A token file is read on each call so that a rotated token is picked up.
+func (a *spiffeEnableWebhook) getXDSInitialMetadata() ([]proxy.XDSHeader, error) {
+	token := a.xdsToken
+	if a.xdsTokenFile != "" {
+		tokenBytes, err := os.ReadFile(a.xdsTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read xDS token file: %w", err)
+		}
+		token = strings.TrimSpace(string(tokenBytes))
+	}
+
+	if token == "" {
+		return nil, nil
+	}
+
+	return []proxy.XDSHeader{{Key: a.xdsTokenHeader, Value: token}}, nil
+}
+
+// getJWTAuthn returns the config for validating the JWT-SVIDs of incoming requests, if the pod has
+// requested it with the JWT audiences annotation. The JWKS URI defaults to the webhook's.
+func (a *spiffeEnableWebhook) getJWTAuthn(pod *corev1.Pod) (*proxy.JWTAuthn, error) {
+	audiencesValue, ok := pod.Annotations[constants.ProxyJWTAudiencesAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	forward, err := parseBoolAnnotation(pod.Annotations, constants.ProxyJWTForwardAnnotation, false)
+	if err != nil {
+		return nil, err
+	}
+
+	jwtAuthn := &proxy.JWTAuthn{
+		JWKSURI: a.jwksURI,
+		Forward: forward,
+	}
+	for _, audience := range strings.Split(audiencesValue, ",") {
+		jwtAuthn.Audiences = append(jwtAuthn.Audiences, strings.TrimSpace(audience))
+	}
+	if jwksURI, ok := pod.Annotations[constants.ProxyJWTJWKSURIAnnotation]; ok {
+		jwtAuthn.JWKSURI = jwksURI
+	}
+
+	portValue := pod.Annotations[constants.ProxyJWTAppPortAnnotation]
+	port, err := strconv.ParseUint(portValue, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: must be a port number", constants.ProxyJWTAppPortAnnotation, portValue)
+	}
+	jwtAuthn.AppPort = uint32(port)
+
+	if err := jwtAuthn.Validate(); err != nil {
+		return nil, err
+	}
+	return jwtAuthn, nil
+}
+
+// setInboundPorts sets the ports of the listener that terminates mTLS for incoming connections,
+// if the pod has requested it with the inbound port annotations
+func setInboundPorts(pod *corev1.Pod, params *proxy.EnvoyConfigParams) error {
+	for _, port := range []struct {
+		annotation string
+		target     *uint32
+	}{
+		{constants.ProxyInboundPortAnnotation, &params.InboundPort},
+		{constants.ProxyInboundAppPortAnnotation, &params.ApplicationPort},
+	} {
+		value, ok := pod.Annotations[port.annotation]
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid %s annotation %q: must be a port number", port.annotation, value)
+		}
+		*port.target = uint32(parsed)
+	}
+	return params.ValidateInbound()
+}
+
+// checkPodWarnings adds warnings for a mutated pod's configuration that is likely unintended
+func checkPodWarnings(pod *corev1.Pod, warnings *admissionWarnings) {
+	componentAnnotations := []struct {
+		annotation string
+		component  string
+		container  string
+	}{
+		{constants.ProxySizeAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyResourcesAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyInitExtraCommandsAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyDNSConfigAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyCustomDNSAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyFlavorAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyIPFamilyAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ExcludeOutboundPortsAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ExcludeDestCIDRsAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyStartupProbeAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyStartupProbeTimeoutAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyWaitForSocketAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyWaitForSocketTimeoutAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyJWTAudiencesAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyJWTAppPortAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyJWTJWKSURIAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyJWTForwardAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyInboundPortAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyInboundAppPortAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.ProxyUpstreamsAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.InitNetRawAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.EnvoyLogLevelAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.EnvoyAdminPortAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{constants.EnvoyAdminAddressAnnotation, constants.InjectAnnotationProxy, proxy.EnvoySidecarContainerName},
+		{helper.SPIFFEHelperIncIntermediateAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperIncFederatedAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperConfigFormatAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperCertDirModeAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperCertFileModeAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperKeyFileModeAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperCertSymlinksAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperSVIDFileAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperSVIDKeyFileAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperSVIDBundleFileAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperCmdAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperCmdArgsAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperRenewSignalAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperWaitForCertAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperCertPathsAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperJWTAudiencesAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperHealthChecksAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperPreStopSleepAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperCABundlePathAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+		{helper.SPIFFEHelperResourcesAnnotation, constants.InjectAnnotationHelper, helper.SPIFFEHelperSidecarContainerName},
+	}
+	for _, ca := range componentAnnotations {
+		if _, ok := pod.Annotations[ca.annotation]; ok && !sidecarExists(pod, ca.container) {
+			warnings.add("annotation %s has no effect as the %s component is not injected", ca.annotation, ca.component)
+		}
+	}
+
+	// The config volume and init image pull policy annotations apply to both the proxy and helper components
+	for _, annotation := range []string{constants.ConfigVolumeMemoryAnnotation, constants.InitImagePullPolicyAnnotation} {
+		if _, ok := pod.Annotations[annotation]; ok &&
+			!sidecarExists(pod, proxy.EnvoySidecarContainerName) && !sidecarExists(pod, helper.SPIFFEHelperSidecarContainerName) {
+			warnings.add("annotation %s has no effect as neither the %s nor the %s component is injected",
+				annotation, constants.InjectAnnotationProxy, constants.InjectAnnotationHelper)
+		}
+	}
+
+	for _, vol := range pod.Spec.Volumes {
+		if vol.Name == constants.SPIFFEWLVolume && vol.CSI == nil {
+			warnings.add("volume %s is not a SPIFFE CSI volume; the SPIFFE Workload API may not be available", vol.Name)
+		}
+	}
+}
+
+// useNativeSidecar returns whether a sidecar should be injected as a native sidecar, given
+// the sidecar mode requested for the pod and the default for the sidecar. Native sidecars
+// are never used if the cluster does not support them.
+func (a *spiffeEnableWebhook) useNativeSidecar(sidecarMode string, defaultNative bool) bool {
+	if !a.nativeSidecarsSupported {
+		return false
+	}
+	switch sidecarMode {
+	case constants.SidecarModeNative:
+		return true
+	case constants.SidecarModeRegular:
+		return false
+	default:
+		return defaultNative
+	}
+}
+
+// sidecarExists checks whether a sidecar has already been injected, either as a native
+// sidecar (init container) or as a regular container
+func sidecarExists(pod *corev1.Pod, containerName string) bool {
+	return workload.InitContainerExists(pod, containerName) || workload.ContainerExists(pod.Spec.Containers, containerName)
+}
+
+// findCertVolume returns the volume that the first application container to mount one at the
+// cert directory uses, or the certs volume if none do. Mounts of a subPath aren't reused, as
+// spiffe-helper writes to the root of the volume.
+func findCertVolume(containers []corev1.Container) string {
+	for _, container := range containers {
+		for _, vm := range container.VolumeMounts {
+			if vm.MountPath == constants.SPIFFEEnableCertDirectory && vm.SubPath == "" && vm.SubPathExpr == "" {
+				return vm.Name
+			}
+		}
+	}
+	return constants.SPIFFEEnableCertVolumeName
+}
+
+func getCertsVolume(name string) corev1.Volume {
 	return corev1.Volume{
-		Name: constants.SPIFFEEnableCertVolumeName,
+		Name: name,
 		VolumeSource: corev1.VolumeSource{
 			EmptyDir: &corev1.EmptyDirVolumeSource{
 				Medium: corev1.StorageMediumMemory, // In-memory
@@ -234,32 +681,49 @@ func getCertsVolume() corev1.Volume {
 	}
 }
 
-func getKeys(m map[string]bool) []string {
-	keys := make([]string, 0, len(m))
-	for k := range m {
-		keys = append(keys, k)
-	}
-	return keys
+// workloadAPIInjection sets how the SPIFFE Workload API socket is given to a pod's containers
+type workloadAPIInjection struct {
+	// socketPath is the path of the socket within the CSI volume
+	socketPath string
+	// includeInitContainers also gives the socket to the pod's own init containers
+	includeInitContainers bool
+	// skipContainers are the application containers that don't get the socket
+	skipContainers []string
 }
 
-func ensureCSIVolumeAndMount(pod *corev1.Pod, logger logr.Logger) {
+func ensureCSIVolumeAndMount(pod *corev1.Pod, wlAPI workloadAPIInjection, logger logr.Logger) {
 	// Add a CSI volume to the pod for the SPIFFE Workload API
 	if !workload.VolumeExists(pod, constants.SPIFFEWLVolume) {
 		logger.Info("Adding SPIFFE CSI volume", "volumeName", constants.SPIFFEWLVolume)
 		pod.Spec.Volumes = append(pod.Spec.Volumes, workload.GetSPIFFEVolume())
 	}
 
-	// Process each (standard) container in the pod
+	// Process each (standard) container in the pod, other than those skipped
 	for i := range pod.Spec.Containers {
 		container := &pod.Spec.Containers[i]
+		if slices.Contains(wlAPI.skipContainers, container.Name) {
+			continue
+		}
 		// Add CSI volume mounts
-		ensureCSIVolumeMount(container, workload.GetSPIFFEVolumeMount(), logger)
+		ensureVolumeMount(container, workload.GetSPIFFEVolumeMount(), logger)
 		// Add SPIFFE socket environment variable
-		ensureEnvVar(container, workload.GetSPIFFEEnvVar())
+		ensureEnvVar(container, workload.GetSPIFFEEnvVarForSocket(wlAPI.socketPath))
+	}
+
+	// Optionally process the pod's own init containers, skipping those that are injected
+	if wlAPI.includeInitContainers {
+		for i := range pod.Spec.InitContainers {
+			container := &pod.Spec.InitContainers[i]
+			if slices.Contains(injectedInitContainerNames, container.Name) {
+				continue
+			}
+			ensureVolumeMount(container, workload.GetSPIFFEVolumeMount(), logger)
+			ensureEnvVar(container, workload.GetSPIFFEEnvVarForSocket(wlAPI.socketPath))
+		}
 	}
 }
 
-func ensureCSIVolumeMount(container *corev1.Container, targetMount corev1.VolumeMount, logger logr.Logger) bool {
+func ensureVolumeMount(container *corev1.Container, targetMount corev1.VolumeMount, logger logr.Logger) bool {
 	madeChange := false
 	mountExists := false
 	mountIndex := -1 // Index of the mount if found by name and path
@@ -292,12 +756,319 @@ func ensureCSIVolumeMount(container *corev1.Container, targetMount corev1.Volume
 	return madeChange
 }
 
+// ensureProxyDNSConfig tunes the pod's DNS config for the proxy's DNS redirection. All DNS requests
+// are redirected to Envoy, which answers for the names in its DNS table and forwards the rest to
+// the upstream servers in resolv.conf, so the nameservers are left unchanged. A lower ndots means
+// that names with a dot are looked up as-is first, so that they are answered from Envoy's table
+// rather than after a series of failed lookups through the search domains. An ndots option already
+// set on the pod is kept.
+func ensureProxyDNSConfig(pod *corev1.Pod, logger logr.Logger) {
+	if pod.Spec.DNSConfig == nil {
+		pod.Spec.DNSConfig = &corev1.PodDNSConfig{}
+	}
+	for _, option := range pod.Spec.DNSConfig.Options {
+		if option.Name == "ndots" {
+			return
+		}
+	}
+	logger.Info("Setting pod DNS ndots option for proxy DNS redirection", "ndots", proxyDNSNdots)
+	pod.Spec.DNSConfig.Options = append(pod.Spec.DNSConfig.Options, corev1.PodDNSConfigOption{
+		Name:  "ndots",
+		Value: ptr.To(proxyDNSNdots),
+	})
+}
+
 func ensureEnvVar(container *corev1.Container, envVar corev1.EnvVar) {
 	if !workload.EnvVarExists(container, envVar.Name) {
 		container.Env = append(container.Env, envVar)
 	}
 }
 
+// parseExtraEnv parses a comma-delimited list of KEY=VALUE pairs into environment variables
+func parseExtraEnv(value string) ([]corev1.EnvVar, error) {
+	var envVars []corev1.EnvVar
+	seen := make(map[string]bool)
+
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, envValue, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !found {
+			return nil, fmt.Errorf("invalid extra environment variable %q: expected KEY=VALUE", pair)
+		}
+		if !envVarNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid extra environment variable name %q: must consist of letters, digits and '_', and not start with a digit", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate extra environment variable %q", name)
+		}
+		seen[name] = true
+
+		envVars = append(envVars, corev1.EnvVar{Name: name, Value: envValue})
+	}
+
+	return envVars, nil
+}
+
+// parseImagePullSecrets parses a comma-delimited list of secret names, ignoring duplicates
+func parseImagePullSecrets(value string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || slices.Contains(names, name) {
+			continue
+		}
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid image pull secret name %q: %s", name, strings.Join(errs, "; "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// ensureImagePullSecrets adds the named secrets to the pod's image pull secrets, keeping those
+// already set and skipping any that are already present
+func ensureImagePullSecrets(pod *corev1.Pod, names []string, logger logr.Logger) {
+	for _, name := range names {
+		if slices.ContainsFunc(pod.Spec.ImagePullSecrets, func(ref corev1.LocalObjectReference) bool {
+			return ref.Name == name
+		}) {
+			continue
+		}
+		logger.Info("Adding image pull secret", "secretName", name)
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+	}
+}
+
+// parseExcludeOutboundPorts parses a comma-delimited list of ports, ignoring duplicates
+func parseExcludeOutboundPorts(value string) ([]int, error) {
+	var ports []int
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		port, err := strconv.ParseUint(entry, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("invalid %s annotation: %q. Must be a comma-delimited list of ports between 1 and 65535",
+				constants.ExcludeOutboundPortsAnnotation, value)
+		}
+		if !slices.Contains(ports, int(port)) {
+			ports = append(ports, int(port))
+		}
+	}
+	return ports, nil
+}
+
+// parseExcludeDestCIDRs parses a comma-delimited list of CIDRs, ignoring duplicates
+func parseExcludeDestCIDRs(value string) ([]string, error) {
+	var cidrs []string
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || slices.Contains(cidrs, entry) {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(entry); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %q is not a valid CIDR",
+				constants.ExcludeDestCIDRsAnnotation, entry)
+		}
+		cidrs = append(cidrs, entry)
+	}
+	return cidrs, nil
+}
+
+// Kinds of environment sources in the env-from annotation
+const (
+	envFromKindConfigMap = "configmap"
+	envFromKindSecret    = "secret"
+	envFromOptional      = "optional"
+)
+
+// parseEnvFrom parses a comma-delimited list of KIND:NAME[:optional] entries, where KIND is
+// configmap or secret, into environment sources. Sources marked optional don't prevent the
+// containers from starting if they don't exist.
+func parseEnvFrom(value string) ([]corev1.EnvFromSource, error) {
+	var sources []corev1.EnvFromSource
+	seen := make(map[string]bool)
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != envFromOptional) {
+			return nil, fmt.Errorf("invalid environment source %q: expected KIND:NAME or KIND:NAME:%s", entry, envFromOptional)
+		}
+		kind, name := parts[0], parts[1]
+		if name == "" {
+			return nil, fmt.Errorf("invalid environment source %q: missing name", entry)
+		}
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid environment source name %q: %s", name, strings.Join(errs, "; "))
+		}
+		if seen[kind+":"+name] {
+			return nil, fmt.Errorf("duplicate environment source %q", kind+":"+name)
+		}
+		seen[kind+":"+name] = true
+
+		var optional *bool
+		if len(parts) == 3 {
+			optional = ptr.To(true)
+		}
+		reference := corev1.LocalObjectReference{Name: name}
+
+		switch kind {
+		case envFromKindConfigMap:
+			sources = append(sources, corev1.EnvFromSource{
+				ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: reference, Optional: optional},
+			})
+		case envFromKindSecret:
+			sources = append(sources, corev1.EnvFromSource{
+				SecretRef: &corev1.SecretEnvSource{LocalObjectReference: reference, Optional: optional},
+			})
+		default:
+			return nil, fmt.Errorf("invalid environment source kind %q: must be %s or %s", kind, envFromKindConfigMap, envFromKindSecret)
+		}
+	}
+
+	return sources, nil
+}
+
+// ensureEnvFrom adds an environment source to a container, unless the container already has a
+// source referencing the same ConfigMap or Secret
+func ensureEnvFrom(container *corev1.Container, source corev1.EnvFromSource) {
+	for _, existing := range container.EnvFrom {
+		if source.ConfigMapRef != nil && existing.ConfigMapRef != nil && existing.ConfigMapRef.Name == source.ConfigMapRef.Name {
+			return
+		}
+		if source.SecretRef != nil && existing.SecretRef != nil && existing.SecretRef.Name == source.SecretRef.Name {
+			return
+		}
+	}
+	container.EnvFrom = append(container.EnvFrom, source)
+}
+
+// sidecarPlacer adds sidecars to a pod's containers, either after the existing containers (the
+// default) or before them. Sidecars placed first keep the order in which they are added.
+type sidecarPlacer struct {
+	position string
+	placed   int
+}
+
+func (p *sidecarPlacer) add(pod *corev1.Pod, sidecar corev1.Container) {
+	if p.position != constants.SidecarPositionFirst {
+		pod.Spec.Containers = append(pod.Spec.Containers, sidecar)
+		return
+	}
+
+	// Tools such as kubectl default to the first container, so keep defaulting to the application
+	if p.placed == 0 && len(pod.Spec.Containers) > 0 {
+		if _, ok := pod.Annotations[defaultContainerAnnotation]; !ok {
+			pod.Annotations[defaultContainerAnnotation] = pod.Spec.Containers[0].Name
+		}
+	}
+	pod.Spec.Containers = slices.Insert(pod.Spec.Containers, p.placed, sidecar)
+	p.placed++
+}
+
+// parseCertPaths parses a comma-delimited list of CONTAINER=PATH pairs, giving the path at
+// which the certs are mounted in each of the named application containers
+func parseCertPaths(value string, containers []corev1.Container, certVolume string) (map[string]string, error) {
+	certPaths := make(map[string]string)
+
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, certPath, found := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		certPath = strings.TrimSpace(certPath)
+		if !found {
+			return nil, fmt.Errorf("invalid cert path %q: expected CONTAINER=PATH", pair)
+		}
+		if _, ok := certPaths[name]; ok {
+			return nil, fmt.Errorf("duplicate cert path for container %q", name)
+		}
+
+		idx := slices.IndexFunc(containers, func(c corev1.Container) bool { return c.Name == name })
+		if idx == -1 {
+			return nil, fmt.Errorf("invalid cert path for container %q: no such container", name)
+		}
+		if !path.IsAbs(certPath) || path.Clean(certPath) != certPath || certPath == "/" {
+			return nil, fmt.Errorf("invalid cert path %q for container %q: must be a clean, absolute path other than /", certPath, name)
+		}
+		for _, vm := range containers[idx].VolumeMounts {
+			if vm.MountPath == certPath && vm.Name != certVolume {
+				return nil, fmt.Errorf("invalid cert path %q for container %q: volume %s is already mounted there", certPath, name, vm.Name)
+			}
+		}
+
+		certPaths[name] = certPath
+	}
+
+	return certPaths, nil
+}
+
+// parseTargetContainers parses a comma-delimited list of the application containers that get the
+// Workload API socket, returning the other application containers, which are skipped
+func parseTargetContainers(value string, containers []corev1.Container) ([]string, error) {
+	var targets []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("invalid target containers %q: container names must not be empty", value)
+		}
+		if !workload.ContainerExists(containers, name) {
+			return nil, fmt.Errorf("invalid target container %q: no such container", name)
+		}
+		if slices.Contains(targets, name) {
+			return nil, fmt.Errorf("duplicate target container %q", name)
+		}
+		targets = append(targets, name)
+	}
+
+	var skip []string
+	for _, container := range containers {
+		if !slices.Contains(targets, container.Name) {
+			skip = append(skip, container.Name)
+		}
+	}
+	return skip, nil
+}
+
+// parseCABundlePath validates the path of the file at which the trust bundle is mounted into the
+// application containers, which must not collide with their existing mounts or cert paths
+func parseCABundlePath(value string, containers []corev1.Container, certPaths map[string]string, certVolume string) (string, error) {
+	caBundlePath := strings.TrimSpace(value)
+	if !path.IsAbs(caBundlePath) || path.Clean(caBundlePath) != caBundlePath || caBundlePath == "/" {
+		return "", fmt.Errorf("invalid CA bundle path %q: must be a clean, absolute file path", value)
+	}
+
+	for _, container := range containers {
+		for _, vm := range container.VolumeMounts {
+			if vm.MountPath == caBundlePath && vm.Name != certVolume {
+				return "", fmt.Errorf("invalid CA bundle path %q for container %q: volume %s is already mounted there",
+					caBundlePath, container.Name, vm.Name)
+			}
+		}
+		if certPath, ok := certPaths[container.Name]; ok &&
+			(certPath == caBundlePath || strings.HasPrefix(caBundlePath, certPath+"/")) {
+			return "", fmt.Errorf("invalid CA bundle path %q for container %q: must not be within the cert path %s",
+				caBundlePath, container.Name, certPath)
+		}
+	}
+
+	return caBundlePath, nil
+}
+
 func getEnvWithDefault(variable string, defaultValue string) string {
 	v, ok := os.LookupEnv(variable)
 	if !ok {
@@ -305,3 +1076,18 @@ func getEnvWithDefault(variable string, defaultValue string) string {
 	}
 	return v
 }
+
+func getDurationEnvWithDefault(variable string, defaultValue time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(variable)
+	if !ok {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q for %s: %w", v, variable, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration for %s must be positive, got %s", variable, v)
+	}
+	return d, nil
+}
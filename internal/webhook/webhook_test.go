@@ -1,10 +1,18 @@
 package webhook
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -15,9 +23,17 @@ import (
 	"github.com/cofide/spiffe-enable/internal/helper"
 	"github.com/cofide/spiffe-enable/internal/proxy"
 	"github.com/cofide/spiffe-enable/internal/workload"
+	"github.com/go-logr/logr/funcr"
 	"github.com/go-logr/logr/testr"
+	"github.com/hashicorp/hcl/v2/hclsimple"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -25,7 +41,7 @@ import (
 	jsonpatch "github.com/evanphx/json-patch"
 )
 
-func newTestWebhook(t *testing.T) *spiffeEnableWebhook {
+func newTestWebhook(t *testing.T, opts ...Option) *spiffeEnableWebhook {
 	scheme := runtime.NewScheme()
 	require.NoError(t, corev1.AddToScheme(scheme))
 
@@ -35,7 +51,8 @@ func newTestWebhook(t *testing.T) *spiffeEnableWebhook {
 	webhook, err := NewSpiffeEnableWebhook(
 		fake.NewClientBuilder().WithScheme(scheme).Build(),
 		testr.New(t),
-		decoder)
+		decoder,
+		opts...)
 	require.NoError(t, err)
 
 	return webhook
@@ -46,7 +63,8 @@ func newAdmissionRequest(t *testing.T, pod *corev1.Pod) (admission.Request, []by
 	require.NoError(t, err)
 	return admission.Request{
 		AdmissionRequest: admissionv1.AdmissionRequest{
-			UID: "test-uid",
+			UID:       "test-uid",
+			Operation: admissionv1.Create,
 			Object: runtime.RawExtension{
 				Raw: rawPod,
 			},
@@ -72,13 +90,16 @@ func TestSpiffeEnableWebhook_Handle(t *testing.T) {
 	}
 
 	tests := []struct {
-		name            string
-		podAnnotations  map[string]string
-		initialPod      func() *corev1.Pod
-		expectedAllowed bool
-		expectedPatched bool
-		expectedStatus  *metav1.Status
-		validatePod     func(t *testing.T, mutatedPod *corev1.Pod)
+		name                    string
+		podAnnotations          map[string]string
+		initialPod              func() *corev1.Pod
+		expectedAllowed         bool
+		expectedPatched         bool
+		expectedStatus          *metav1.Status
+		expectedMessageContains []string
+		expectedWarnings        []string
+		webhookOptions          []Option
+		validatePod             func(t *testing.T, mutatedPod *corev1.Pod)
 	}{
 		{
 			name:            "No pod annotations; no injection",
@@ -107,6 +128,8 @@ func TestSpiffeEnableWebhook_Handle(t *testing.T) {
 				assert.NotNil(t, mutatedPod.Spec.Volumes[0].CSI)
 				assert.Equal(t, "csi.spiffe.io", mutatedPod.Spec.Volumes[0].CSI.Driver)
 
+				// No sidecars are injected, whether as regular or native (init) containers
+				assert.Empty(t, mutatedPod.Spec.InitContainers)
 				require.Len(t, mutatedPod.Spec.Containers, 1)
 				appContainer := mutatedPod.Spec.Containers[0]
 				assert.Equal(t, "app-container", appContainer.Name)
@@ -144,6 +167,9 @@ func TestSpiffeEnableWebhook_Handle(t *testing.T) {
 						assert.Equal(t, constants.DefaultDebugUIImage, c.Image)
 						require.Len(t, c.Ports, 1)
 						assert.Equal(t, int32(constants.DebugUIPort), c.Ports[0].ContainerPort)
+						// The UI has the Workload API socket, like the app containers
+						assert.Contains(t, c.VolumeMounts, workload.GetSPIFFEVolumeMount())
+						assert.Contains(t, c.Env, workload.GetSPIFFEEnvVarForSocket(constants.SPIFFEWLSocketPath))
 						break
 					}
 				}
@@ -151,6 +177,68 @@ func TestSpiffeEnableWebhook_Handle(t *testing.T) {
 				assert.Len(t, mutatedPod.Spec.Containers, 2) // app + debug UI
 			},
 		},
+		{
+			name: "spiffe.cofide.io/debug-ui-expose: false",
+			podAnnotations: map[string]string{
+				constants.DebugAnnotation:         annotationValueTrue,
+				constants.DebugUIExposeAnnotation: "false",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.Containers, 2) // app + debug UI
+				debugUI := mutatedPod.Spec.Containers[1]
+				assert.Equal(t, constants.DebugUIContainerName, debugUI.Name)
+				assert.Empty(t, debugUI.Ports)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/debug-ui-expose: invalid",
+			podAnnotations: map[string]string{
+				constants.DebugAnnotation:         annotationValueTrue,
+				constants.DebugUIExposeAnnotation: "no",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.DebugUIExposeAnnotation, `"no"`},
+		},
+		{
+			name: "spiffe.cofide.io/debug-ui-port",
+			podAnnotations: map[string]string{
+				constants.DebugAnnotation:       annotationValueTrue,
+				constants.DebugUIPortAnnotation: "9080",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.Containers, 2) // app + debug UI
+				debugUI := mutatedPod.Spec.Containers[1]
+				assert.Equal(t, constants.DebugUIContainerName, debugUI.Name)
+				require.Len(t, debugUI.Ports, 1)
+				assert.Equal(t, int32(9080), debugUI.Ports[0].ContainerPort)
+				assert.Contains(t, debugUI.Env, corev1.EnvVar{Name: constants.EnvVarUIPort, Value: "9080"})
+			},
+		},
+		{
+			name: "spiffe.cofide.io/debug-ui-port: invalid",
+			podAnnotations: map[string]string{
+				constants.DebugAnnotation:       annotationValueTrue,
+				constants.DebugUIPortAnnotation: "0",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.DebugUIPortAnnotation},
+		},
 		{
 			name:            "spiffe.cofide.io/inject: helper",
 			podAnnotations:  map[string]string{constants.InjectAnnotation: constants.InjectAnnotationHelper},
@@ -217,7 +305,7 @@ func TestSpiffeEnableWebhook_Handle(t *testing.T) {
 						// Check command, env, mounts, security context for init container
 						require.NotNil(t, ic.SecurityContext)
 						require.NotNil(t, ic.SecurityContext.Capabilities)
-						assert.Contains(t, ic.SecurityContext.Capabilities.Add, corev1.Capability("NET_ADMIN"))
+						assert.Equal(t, []corev1.Capability{"NET_ADMIN"}, ic.SecurityContext.Capabilities.Add)
 						assert.Equal(t, ptr.To(int64(0)), ic.SecurityContext.RunAsUser)
 						break
 					}
@@ -264,125 +352,4632 @@ func TestSpiffeEnableWebhook_Handle(t *testing.T) {
 				Code:    http.StatusBadRequest,
 				Message: "invalid mode(s) found in injection list: invalid_mode. Allowed modes are: helper, proxy",
 			},
-			validatePod: nil,
+			// Check parts of the message because allowed modes order might change
+			expectedMessageContains: []string{"invalid mode(s) found", "invalid_mode"},
+			validatePod:             nil,
 		},
 		{
-			name:           "No pod annotation, CSI volume already exists",
-			podAnnotations: map[string]string{},
+			name: "spiffe.cofide.io/dry-run: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationHelper,
+				constants.DryRunAnnotation: "yes",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.DryRunAnnotation, `"yes"`},
+		},
+		{
+			name: "spiffe.cofide.io/extra-env: multiple variables",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:   constants.InjectCSIVolume,
+				constants.ExtraEnvAnnotation: "SPIFFE_TRUST_DOMAIN=example.org, SPIFFE_CERT_DIR=/spiffe-enable",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				appContainer := mutatedPod.Spec.Containers[0]
+				assert.Contains(t, appContainer.Env, corev1.EnvVar{Name: "SPIFFE_TRUST_DOMAIN", Value: "example.org"})
+				assert.Contains(t, appContainer.Env, corev1.EnvVar{Name: "SPIFFE_CERT_DIR", Value: "/spiffe-enable"})
+				assert.Contains(t, appContainer.Env, workload.GetSPIFFEEnvVar())
+				assert.Len(t, appContainer.Env, 3)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/extra-env: existing variables are not duplicated or overridden",
+			podAnnotations: map[string]string{
+				constants.ExtraEnvAnnotation: "SPIFFE_TRUST_DOMAIN=example.org,EXTRA=value",
+			},
 			initialPod: func() *corev1.Pod {
 				p := basePod()
-				p.Spec.Volumes = append(p.Spec.Volumes, workload.GetSPIFFEVolume())
+				p.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "SPIFFE_TRUST_DOMAIN", Value: "original.org"}}
 				return p
 			},
 			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				appContainer := mutatedPod.Spec.Containers[0]
+				assert.Equal(t, []corev1.EnvVar{
+					{Name: "SPIFFE_TRUST_DOMAIN", Value: "original.org"},
+					{Name: "EXTRA", Value: "value"},
+				}, appContainer.Env)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/extra-env: not added to injected sidecars",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:   constants.InjectAnnotationProxy,
+				constants.ExtraEnvAnnotation: "EXTRA=value",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				for _, c := range mutatedPod.Spec.Containers {
+					if c.Name == proxy.EnvoySidecarContainerName {
+						assert.False(t, workload.EnvVarExists(&c, "EXTRA"))
+					} else {
+						assert.True(t, workload.EnvVarExists(&c, "EXTRA"))
+					}
+				}
+			},
+		},
+		{
+			name:            "spiffe.cofide.io/extra-env: invalid identifier",
+			podAnnotations:  map[string]string{constants.ExtraEnvAnnotation: "1INVALID=value"},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"invalid extra environment variable name", "1INVALID"},
+		},
+		{
+			name:            "spiffe.cofide.io/extra-env: missing value separator",
+			podAnnotations:  map[string]string{constants.ExtraEnvAnnotation: "NO_VALUE"},
+			initialPod:      basePod,
+			expectedAllowed: false,
 			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"expected KEY=VALUE"},
+		},
+		{
+			name: "spiffe.cofide.io/env-from",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:  constants.InjectAnnotationProxy,
+				constants.EnvFromAnnotation: "configmap:spiffe-config, secret:spiffe-creds:optional",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
 			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
-				assert.Len(t, mutatedPod.Spec.Volumes, 1, "CSI Volume should not be duplicated")
+				for _, c := range mutatedPod.Spec.Containers {
+					if c.Name == proxy.EnvoySidecarContainerName {
+						assert.Empty(t, c.EnvFrom)
+						continue
+					}
+					assert.Equal(t, []corev1.EnvFromSource{
+						{ConfigMapRef: &corev1.ConfigMapEnvSource{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "spiffe-config"},
+						}},
+						{SecretRef: &corev1.SecretEnvSource{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "spiffe-creds"},
+							Optional:             ptr.To(true),
+						}},
+					}, c.EnvFrom)
+				}
 			},
 		},
 		{
-			name:           "spiffe.cofide.io/inject: csi, CSI volume already exists, unmounted",
-			podAnnotations: map[string]string{constants.InjectAnnotation: constants.InjectCSIVolume},
+			name: "spiffe.cofide.io/env-from: existing sources are not duplicated",
+			podAnnotations: map[string]string{
+				constants.EnvFromAnnotation: "configmap:spiffe-config,secret:spiffe-config",
+			},
 			initialPod: func() *corev1.Pod {
 				p := basePod()
-				p.Spec.Volumes = append(p.Spec.Volumes, workload.GetSPIFFEVolume())
+				p.Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{{
+					Prefix: "APP_",
+					ConfigMapRef: &corev1.ConfigMapEnvSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "spiffe-config"},
+					},
+				}}
 				return p
 			},
 			expectedAllowed: true,
 			expectedPatched: true,
 			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
-				assert.Len(t, mutatedPod.Spec.Volumes, 1, "CSI Volume should not be duplicated")
-
-				// Ensure the CSI volume is mounted into the container
-				require.Len(t, mutatedPod.Spec.Containers, 1)
-				appContainer := mutatedPod.Spec.Containers[0]
-				assert.Equal(t, "app-container", appContainer.Name)
-				require.Len(t, appContainer.VolumeMounts, 1)
-				assert.Equal(t, constants.SPIFFEWLVolume, appContainer.VolumeMounts[0].Name)
-				assert.Equal(t, constants.SPIFFEWLMountPath, appContainer.VolumeMounts[0].MountPath)
-				assert.True(t, appContainer.VolumeMounts[0].ReadOnly)
-
-				// Ensure the environment variable is set
-				foundEnv := false
-				for _, env := range appContainer.Env {
-					if env.Name == constants.SPIFFEWLSocketEnvName {
-						assert.Equal(t, constants.SPIFFEWLSocket, env.Value)
-						foundEnv = true
-						break
+				envFrom := mutatedPod.Spec.Containers[0].EnvFrom
+				require.Len(t, envFrom, 2)
+				assert.Equal(t, "APP_", envFrom[0].Prefix)
+				require.NotNil(t, envFrom[1].SecretRef)
+				assert.Equal(t, "spiffe-config", envFrom[1].SecretRef.Name)
+			},
+		},
+		{
+			name:            "spiffe.cofide.io/env-from: invalid kind",
+			podAnnotations:  map[string]string{constants.EnvFromAnnotation: "volume:spiffe-config"},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"invalid environment source kind", "volume"},
+		},
+		{
+			name:            "spiffe.cofide.io/env-from: missing name",
+			podAnnotations:  map[string]string{constants.EnvFromAnnotation: "secret:"},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"missing name"},
+		},
+		{
+			name:            "spiffe.cofide.io/env-from: invalid optional flag",
+			podAnnotations:  map[string]string{constants.EnvFromAnnotation: "secret:spiffe-creds:maybe"},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"expected KIND:NAME or KIND:NAME:optional"},
+		},
+		{
+			name: "spiffe.cofide.io/helper-config-format: json",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperConfigFormatAnnotation: helper.SPIFFEHelperConfigFormatJSON,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				for _, ic := range mutatedPod.Spec.InitContainers {
+					if ic.Name == helper.SPIFFEHelperInitContainerName {
+						require.Len(t, ic.Env, 1)
+						assert.True(t, json.Valid([]byte(ic.Env[0].Value)), "spiffe-helper config is not JSON")
+						return
 					}
 				}
-				assert.True(t, foundEnv, "SPIFFE_ENDPOINT_SOCKET env var not found")
+				t.Fatal("SPIFFE Helper init container not found")
 			},
 		},
-		// TODO: Add tests for idempotency of helper and proxy components if they already exist.
-		// TODO: Add test for existing CSI volume mount with different ReadOnly (should be updated by ensureCSIVolumeMount)
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			wh := newTestWebhook(t)
-			pod := tt.initialPod()
-			if pod.Annotations == nil && len(tt.podAnnotations) > 0 { // Ensure annotations map exists
-				pod.Annotations = make(map[string]string)
-			}
-			for k, v := range tt.podAnnotations {
-				pod.Annotations[k] = v
-			}
-
-			req, podBytes := newAdmissionRequest(t, pod)
-			resp := wh.Handle(context.Background(), req)
-
-			assert.Equal(t, tt.expectedAllowed, resp.Allowed, "Response Allowed mismatch")
-
-			if !tt.expectedAllowed && tt.expectedStatus != nil {
-				require.NotNil(t, resp.Result)
-				// Check parts of the message because allowed modes order might change
-				assert.Contains(t, resp.Result.Message, "invalid mode(s) found")
-				assert.Contains(t, resp.Result.Message, "invalid_mode")
-				assert.Equal(t, int32(http.StatusBadRequest), resp.Result.Code)
-			}
-
-			if tt.expectedPatched {
-				// Check either resp.Patch or resp.Patches
-				hasPatch := (len(resp.Patch) > 0) || len(resp.Patches) > 0
-				assert.True(t, hasPatch, "Expected patch(es)")
-
-				if tt.validatePod != nil && resp.Allowed {
-					modifiedJSON := podBytes
-					for _, p := range resp.Patches {
-						patchBytes, err := p.MarshalJSON()
-						if err != nil {
-							t.Fatalf("Failed to marshal patch: %v", err)
-						}
-
-						patchArrayBytes := append([]byte("["), patchBytes...)
-						patchArrayBytes = append(patchArrayBytes, []byte("]")...)
-
-						patch, err := jsonpatch.DecodePatch(patchArrayBytes)
-						if err != nil {
-							t.Fatalf("Failed to decode patch: %v", err)
-						}
-
-						modifiedJSON, err = patch.Apply(modifiedJSON)
-						if err != nil {
-							panic(err)
-						}
+		{
+			name: "spiffe.cofide.io/spiffe-helper-include-intermediate-bundle: false",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                   constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperIncIntermediateAnnotation: "false",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				for _, ic := range mutatedPod.Spec.InitContainers {
+					if ic.Name == helper.SPIFFEHelperInitContainerName {
+						require.Len(t, ic.Env, 1)
+						assert.Contains(t, ic.Env[0].Value, "add_intermediates_to_bundle = false")
+						return
 					}
-
-					// Decode the result
-					var modifiedPod corev1.Pod
-					if err := json.Unmarshal(modifiedJSON, &modifiedPod); err != nil {
-						t.Fatalf("Failed to unmarshal modified pod: %v", err)
+				}
+				t.Fatal("SPIFFE Helper init container not found")
+			},
+		},
+		{
+			name: "spiffe.cofide.io/spiffe-helper-include-intermediate-bundle: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                   constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperIncIntermediateAnnotation: "yes",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{helper.SPIFFEHelperIncIntermediateAnnotation, `"yes"`},
+		},
+		{
+			name: "spiffe.cofide.io/helper-health-checks: false",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperHealthChecksAnnotation: "false",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				for _, ic := range mutatedPod.Spec.InitContainers {
+					if ic.Name == helper.SPIFFEHelperInitContainerName {
+						require.Len(t, ic.Env, 1)
+						assert.NotContains(t, ic.Env[0].Value, "health_checks")
+						return
 					}
-
-					// Validate the modified pod matches the expected pod
-					tt.validatePod(t, &modifiedPod)
 				}
-			} else {
-				// Check for no patches when not expected
-				assert.Empty(t, resp.Patch)
-				assert.Empty(t, resp.Patches)
-			}
+				t.Fatal("SPIFFE Helper init container not found")
+			},
+		},
+		{
+			name: "spiffe.cofide.io/helper-health-checks: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperHealthChecksAnnotation: "False",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{helper.SPIFFEHelperHealthChecksAnnotation, `"False"`},
+		},
+		{
+			name: "spiffe.cofide.io/helper-config-format: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperConfigFormatAnnotation: "yaml",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"invalid spiffe-helper config format", "yaml"},
+		},
+		{
+			name: "spiffe.cofide.io/helper-include-federated-domains: false",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperIncFederatedAnnotation: "false",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				for _, ic := range mutatedPod.Spec.InitContainers {
+					if ic.Name == helper.SPIFFEHelperInitContainerName {
+						require.Len(t, ic.Env, 1)
+						var cfg helper.SPIFFEHelperConfig
+						require.NoError(t, hclsimple.Decode("config.hcl", []byte(ic.Env[0].Value), nil, &cfg))
+						assert.False(t, cfg.IncludeFederatedDomains)
+						return
+					}
+				}
+				t.Fatal("SPIFFE Helper init container not found")
+			},
+		},
+		{
+			name: "spiffe.cofide.io/helper-include-federated-domains: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperIncFederatedAnnotation: "no",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{helper.SPIFFEHelperIncFederatedAnnotation, `"no"`},
+		},
+		{
+			name: "spiffe-helper cert file name annotations",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                  constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperSVIDFileAnnotation:       "server.crt",
+				helper.SPIFFEHelperSVIDKeyFileAnnotation:    "server.key",
+				helper.SPIFFEHelperSVIDBundleFileAnnotation: "ca-bundle.pem",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				for _, ic := range mutatedPod.Spec.InitContainers {
+					if ic.Name == helper.SPIFFEHelperInitContainerName {
+						require.Len(t, ic.Env, 1)
+						var cfg helper.SPIFFEHelperConfig
+						require.NoError(t, hclsimple.Decode("config.hcl", []byte(ic.Env[0].Value), nil, &cfg))
+						assert.Equal(t, "server.crt", cfg.SVIDFilename)
+						assert.Equal(t, "server.key", cfg.SVIDKeyFilename)
+						assert.Equal(t, "ca-bundle.pem", cfg.SVIDBundleFilename)
+						return
+					}
+				}
+				t.Fatal("SPIFFE Helper init container not found")
+			},
+		},
+		{
+			name: "spiffe-helper cert file name annotations: duplicate",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:               constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperSVIDKeyFileAnnotation: "tls.crt",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{`"tls.crt"`},
+		},
+		{
+			name: "spiffe-helper renew signal annotations",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:               constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperCmdAnnotation:         "/usr/bin/reloader",
+				helper.SPIFFEHelperCmdArgsAnnotation:     "--pid-file /run/app.pid",
+				helper.SPIFFEHelperRenewSignalAnnotation: "SIGHUP",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				for _, ic := range mutatedPod.Spec.InitContainers {
+					if ic.Name == helper.SPIFFEHelperInitContainerName {
+						require.Len(t, ic.Env, 1)
+						var cfg helper.SPIFFEHelperConfig
+						require.NoError(t, hclsimple.Decode("config.hcl", []byte(ic.Env[0].Value), nil, &cfg))
+						assert.Equal(t, "/usr/bin/reloader", cfg.Cmd)
+						assert.Equal(t, "--pid-file /run/app.pid", cfg.CmdArgs)
+						assert.Equal(t, "SIGHUP", cfg.RenewSignal)
+						return
+					}
+				}
+				t.Fatal("SPIFFE Helper init container not found")
+			},
+		},
+		{
+			name: "spiffe-helper renew signal annotation without command",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:               constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperRenewSignalAnnotation: "SIGHUP",
+			},
+			initialPod:       basePod,
+			expectedAllowed:  true,
+			expectedPatched:  true,
+			expectedWarnings: []string{helper.SPIFFEHelperRenewSignalAnnotation + " has no effect without " + helper.SPIFFEHelperCmdAnnotation},
+		},
+		{
+			name: "spiffe-helper renew signal annotation: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:               constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperCmdAnnotation:         "/usr/bin/reloader",
+				helper.SPIFFEHelperRenewSignalAnnotation: "HUP",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{`"HUP"`},
+		},
+		{
+			name: "spiffe.cofide.io/wait-for-cert",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:               constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperWaitForCertAnnotation: "true",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				// The wait container must run after the native sidecar has started
+				require.Len(t, mutatedPod.Spec.InitContainers, 3)
+				assert.Equal(t, helper.SPIFFEHelperInitContainerName, mutatedPod.Spec.InitContainers[0].Name)
+				assert.Equal(t, helper.SPIFFEHelperSidecarContainerName, mutatedPod.Spec.InitContainers[1].Name)
+				waitContainer := mutatedPod.Spec.InitContainers[2]
+				require.Equal(t, helper.SPIFFEHelperWaitContainerName, waitContainer.Name)
+				assert.Equal(t, []string{"/bin/sh", "-c"}, waitContainer.Command)
+				require.Len(t, waitContainer.Args, 1)
+				assert.Contains(t, waitContainer.Args[0], "until [ -f "+constants.SPIFFEEnableCertDirectory+"/"+helper.SPIFFEHelperSVIDFileName+" ]")
+				assert.Equal(t, []corev1.VolumeMount{{
+					Name:      constants.SPIFFEEnableCertVolumeName,
+					MountPath: constants.SPIFFEEnableCertDirectory,
+					ReadOnly:  true,
+				}}, waitContainer.VolumeMounts)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/wait-for-cert with a regular sidecar",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:               constants.InjectAnnotationHelper,
+				constants.SidecarModeAnnotation:          constants.SidecarModeRegular,
+				helper.SPIFFEHelperWaitForCertAnnotation: "true",
+			},
+			initialPod:       basePod,
+			expectedAllowed:  true,
+			expectedPatched:  true,
+			expectedWarnings: []string{helper.SPIFFEHelperWaitForCertAnnotation + " is ignored"},
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				assert.False(t, workload.InitContainerExists(mutatedPod, helper.SPIFFEHelperWaitContainerName))
+			},
+		},
+		{
+			name: "spiffe.cofide.io/wait-for-cert: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:               constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperWaitForCertAnnotation: "yes",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{helper.SPIFFEHelperWaitForCertAnnotation, `"yes"`},
+		},
+		{
+			name: "spiffe.cofide.io/helper-cert-symlinks",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperCertSymlinksAnnotation: "true",
+				helper.SPIFFEHelperWaitForCertAnnotation:  "true",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				// The publisher is a native sidecar after spiffe-helper, and the wait for the cert
+				// follows it, as the cert is only in the cert directory once published
+				var names []string
+				for _, ic := range mutatedPod.Spec.InitContainers {
+					names = append(names, ic.Name)
+				}
+				assert.Equal(t, []string{
+					helper.SPIFFEHelperInitContainerName,
+					helper.SPIFFEHelperSidecarContainerName,
+					helper.SPIFFEHelperCertPublisherContainerName,
+					helper.SPIFFEHelperWaitContainerName,
+				}, names)
+				assert.Equal(t, ptr.To(corev1.ContainerRestartPolicyAlways), mutatedPod.Spec.InitContainers[2].RestartPolicy)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/helper-cert-symlinks with a regular sidecar",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				constants.SidecarModeAnnotation:           constants.SidecarModeRegular,
+				helper.SPIFFEHelperCertSymlinksAnnotation: "true",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				assert.True(t, workload.ContainerExists(mutatedPod.Spec.Containers, helper.SPIFFEHelperCertPublisherContainerName))
+				assert.False(t, workload.InitContainerExists(mutatedPod, helper.SPIFFEHelperCertPublisherContainerName))
+			},
+		},
+		{
+			name: "spiffe.cofide.io/helper-cert-symlinks: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperCertSymlinksAnnotation: "True",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{helper.SPIFFEHelperCertSymlinksAnnotation, `"True"`},
+		},
+		{
+			name: "spiffe.cofide.io/workload-socket-path",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:             constants.InjectAnnotationHelper,
+				constants.WorkloadSocketPathAnnotation: constants.SPIFFEWLMountPath + "/agent.sock",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				appContainer := mutatedPod.Spec.Containers[0]
+				assert.Contains(t, appContainer.Env, corev1.EnvVar{
+					Name:  constants.SPIFFEWLSocketEnvName,
+					Value: "unix://" + constants.SPIFFEWLMountPath + "/agent.sock",
+				})
+				assert.Contains(t, appContainer.VolumeMounts, workload.GetSPIFFEVolumeMount())
+
+				for _, ic := range mutatedPod.Spec.InitContainers {
+					if ic.Name == helper.SPIFFEHelperInitContainerName {
+						require.Len(t, ic.Env, 1)
+						var cfg helper.SPIFFEHelperConfig
+						require.NoError(t, hclsimple.Decode("config.hcl", []byte(ic.Env[0].Value), nil, &cfg))
+						assert.Equal(t, constants.SPIFFEWLMountPath+"/agent.sock", cfg.AgentAddress)
+						return
+					}
+				}
+				t.Fatal("SPIFFE Helper init container not found")
+			},
+		},
+		{
+			name: "spiffe.cofide.io/workload-socket-path: outside the mount",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:             constants.InjectCSIVolume,
+				constants.WorkloadSocketPathAnnotation: "/run/spire/agent.sock",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.WorkloadSocketPathAnnotation, "/run/spire/agent.sock"},
+		},
+		{
+			name: "spiffe-helper cert permission annotations",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperCertDirModeAnnotation:  "0700",
+				helper.SPIFFEHelperKeyFileModeAnnotation:  "0400",
+				helper.SPIFFEHelperCertFileModeAnnotation: "0640",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				for _, ic := range mutatedPod.Spec.InitContainers {
+					if ic.Name == helper.SPIFFEHelperInitContainerName {
+						require.Len(t, ic.Args, 1)
+						assert.Contains(t, ic.Args[0], "chmod 700 "+constants.SPIFFEEnableCertDirectory)
+						require.Len(t, ic.Env, 1)
+						var cfg helper.SPIFFEHelperConfig
+						require.NoError(t, hclsimple.Decode("config.hcl", []byte(ic.Env[0].Value), nil, &cfg))
+						assert.Equal(t, 0o400, cfg.KeyFileMode)
+						assert.Equal(t, 0o640, cfg.CertFileMode)
+						return
+					}
+				}
+				t.Fatal("SPIFFE Helper init container not found")
+			},
+		},
+		{
+			name: "spiffe-helper cert permission annotation invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:               constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperKeyFileModeAnnotation: "0999",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{helper.SPIFFEHelperKeyFileModeAnnotation, "invalid file mode"},
+		},
+		{
+			name: "spiffe.cofide.io/sidecar-mode: regular",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:      constants.InjectAnnotationHelper + "," + constants.InjectAnnotationProxy,
+				constants.SidecarModeAnnotation: constants.SidecarModeRegular,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.Containers, 3) // app + helper + proxy
+				for _, c := range mutatedPod.Spec.Containers {
+					assert.Nil(t, c.RestartPolicy, "container %s", c.Name)
+				}
+				assert.True(t, workload.ContainerExists(mutatedPod.Spec.Containers, helper.SPIFFEHelperSidecarContainerName))
+				assert.True(t, workload.ContainerExists(mutatedPod.Spec.Containers, proxy.EnvoySidecarContainerName))
+				assert.Len(t, mutatedPod.Spec.InitContainers, 2) // helper-init + proxy-init
+			},
+		},
+		{
+			name: "spiffe.cofide.io/sidecar-mode: native",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:      constants.InjectAnnotationHelper + "," + constants.InjectAnnotationProxy,
+				constants.SidecarModeAnnotation: constants.SidecarModeNative,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				assert.Len(t, mutatedPod.Spec.Containers, 1) // app
+				names := make([]string, 0, len(mutatedPod.Spec.InitContainers))
+				for _, ic := range mutatedPod.Spec.InitContainers {
+					names = append(names, ic.Name)
+					if ic.Name == helper.SPIFFEHelperSidecarContainerName || ic.Name == proxy.EnvoySidecarContainerName {
+						assert.Equal(t, ptr.To(corev1.ContainerRestartPolicyAlways), ic.RestartPolicy, "container %s", ic.Name)
+					} else {
+						assert.Nil(t, ic.RestartPolicy, "container %s", ic.Name)
+					}
+				}
+				// Each native sidecar must start after the init container that writes its config
+				assert.Equal(t, []string{
+					proxy.EnvoyConfigInitContainerName,
+					proxy.EnvoySidecarContainerName,
+					helper.SPIFFEHelperInitContainerName,
+					helper.SPIFFEHelperSidecarContainerName,
+				}, names)
+			},
+		},
+		{
+			name:            "spiffe.cofide.io/sidecar-mode: default without native sidecar support",
+			podAnnotations:  map[string]string{constants.InjectAnnotation: constants.InjectAnnotationHelper},
+			initialPod:      basePod,
+			webhookOptions:  []Option{WithNativeSidecarSupport(false)},
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.Containers, 2) // app + helper
+				assert.Equal(t, helper.SPIFFEHelperSidecarContainerName, mutatedPod.Spec.Containers[1].Name)
+				assert.Nil(t, mutatedPod.Spec.Containers[1].RestartPolicy)
+				assert.Len(t, mutatedPod.Spec.InitContainers, 1) // helper-init
+			},
+		},
+		{
+			name: "spiffe.cofide.io/sidecar-mode: native without native sidecar support",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:      constants.InjectAnnotationHelper,
+				constants.SidecarModeAnnotation: constants.SidecarModeNative,
+			},
+			initialPod:      basePod,
+			webhookOptions:  []Option{WithNativeSidecarSupport(false)},
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"not supported by this cluster"},
+		},
+		{
+			name: "spiffe.cofide.io/sidecar-mode: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:      constants.InjectAnnotationHelper,
+				constants.SidecarModeAnnotation: "sometimes",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"invalid sidecar mode", "sometimes"},
+		},
+		{
+			name: "spiffe.cofide.io/helper-cert-paths: two containers with distinct paths",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:             constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperCertPathsAnnotation: "app-container=/etc/app/certs,other-container=/var/run/certs",
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Name: "other-container", Image: "busybox"})
+				return p
+			},
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				certMounts := map[string]string{}
+				for _, c := range mutatedPod.Spec.Containers {
+					for _, vm := range c.VolumeMounts {
+						if vm.Name == constants.SPIFFEEnableCertVolumeName {
+							assert.True(t, vm.ReadOnly, "container %s", c.Name)
+							certMounts[c.Name] = vm.MountPath
+						}
+					}
+				}
+				assert.Equal(t, map[string]string{
+					"app-container":   "/etc/app/certs",
+					"other-container": "/var/run/certs",
+				}, certMounts)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/helper-cert-paths: unknown container",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:             constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperCertPathsAnnotation: "missing-container=/certs",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"missing-container", "no such container"},
+		},
+		{
+			name: "spiffe.cofide.io/helper-ca-bundle-path",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperCABundlePathAnnotation: "/etc/ssl/certs/spiffe-ca.pem",
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Name: "other-container", Image: "busybox"})
+				return p
+			},
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				expectedMount := corev1.VolumeMount{
+					Name:      constants.SPIFFEEnableCertVolumeName,
+					MountPath: "/etc/ssl/certs/spiffe-ca.pem",
+					SubPath:   helper.SPIFFEHelperSVIDBundleFileName,
+					ReadOnly:  true,
+				}
+				for _, name := range []string{"app-container", "other-container"} {
+					idx := slices.IndexFunc(mutatedPod.Spec.Containers, func(c corev1.Container) bool { return c.Name == name })
+					require.NotEqual(t, -1, idx, "container %s", name)
+					assert.Contains(t, mutatedPod.Spec.Containers[idx].VolumeMounts, expectedMount, "container %s", name)
+				}
+				for _, c := range mutatedPod.Spec.InitContainers {
+					assert.NotContains(t, c.VolumeMounts, expectedMount, "init container %s", c.Name)
+				}
+			},
+		},
+		{
+			name: "spiffe.cofide.io/helper-ca-bundle-path: relative path",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperCABundlePathAnnotation: "certs/ca.pem",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"invalid CA bundle path", "must be a clean, absolute file path"},
+		},
+		{
+			name: "spiffe.cofide.io/helper-ca-bundle-path: existing mount",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperCABundlePathAnnotation: "/etc/ssl/certs/ca.pem",
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.Containers[0].VolumeMounts = append(p.Spec.Containers[0].VolumeMounts,
+					corev1.VolumeMount{Name: "ca", MountPath: "/etc/ssl/certs/ca.pem", SubPath: "ca.pem"})
+				return p
+			},
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"volume ca is already mounted there"},
+		},
+		{
+			name: "spiffe.cofide.io/helper-ca-bundle-path: within cert path",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperCertPathsAnnotation:    "app-container=/etc/app/certs",
+				helper.SPIFFEHelperCABundlePathAnnotation: "/etc/app/certs/ca.pem",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"must not be within the cert path /etc/app/certs"},
+		},
+		{
+			name: "spiffe.cofide.io/init-image-pull-policy",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:              constants.InjectAnnotationProxy + "," + constants.InjectAnnotationHelper,
+				constants.InitImagePullPolicyAnnotation: string(corev1.PullAlways),
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				pullPolicies := map[string]corev1.PullPolicy{}
+				for _, c := range slices.Concat(mutatedPod.Spec.InitContainers, mutatedPod.Spec.Containers) {
+					pullPolicies[c.Name] = c.ImagePullPolicy
+				}
+				assert.Equal(t, corev1.PullAlways, pullPolicies[proxy.EnvoyConfigInitContainerName])
+				assert.Equal(t, corev1.PullAlways, pullPolicies[helper.SPIFFEHelperInitContainerName])
+				// The sidecars keep their own policy
+				assert.Equal(t, corev1.PullIfNotPresent, pullPolicies[proxy.EnvoySidecarContainerName])
+				assert.Equal(t, corev1.PullIfNotPresent, pullPolicies[helper.SPIFFEHelperSidecarContainerName])
+			},
+		},
+		{
+			name: "spiffe.cofide.io/init-image-pull-policy: default",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationProxy + "," + constants.InjectAnnotationHelper,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				for _, c := range mutatedPod.Spec.InitContainers {
+					assert.Equal(t, corev1.PullIfNotPresent, c.ImagePullPolicy, "init container %s", c.Name)
+				}
+			},
+		},
+		{
+			name: "spiffe.cofide.io/init-image-pull-policy: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:              constants.InjectAnnotationProxy,
+				constants.InitImagePullPolicyAnnotation: "always",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"invalid init image pull policy", "always"},
+		},
+		{
+			name: "spiffe.cofide.io/init-image-pull-policy: no proxy or helper",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:              constants.InjectCSIVolume,
+				constants.InitImagePullPolicyAnnotation: string(corev1.PullAlways),
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			expectedWarnings: []string{
+				"annotation " + constants.InitImagePullPolicyAnnotation + " has no effect as neither the proxy nor the helper component is injected",
+			},
+		},
+		{
+			name: "spiffe.cofide.io/image-pull-secrets",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:           constants.InjectAnnotationProxy + "," + constants.InjectAnnotationHelper,
+				constants.ImagePullSecretsAnnotation: "mirror-creds, app-creds, mirror-creds",
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "app-creds"}}
+				return p
+			},
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				// The pod's own secret is kept, and not duplicated
+				assert.Equal(t, []corev1.LocalObjectReference{{Name: "app-creds"}, {Name: "mirror-creds"}},
+					mutatedPod.Spec.ImagePullSecrets)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/image-pull-secrets: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:           constants.InjectAnnotationProxy,
+				constants.ImagePullSecretsAnnotation: "Mirror_Creds",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"invalid image pull secret name", "Mirror_Creds"},
+		},
+		{
+			name: "spiffe.cofide.io/image-pull-secrets: no injection",
+			podAnnotations: map[string]string{
+				constants.ImagePullSecretsAnnotation: "mirror-creds",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: false,
+		},
+		{
+			name: "spiffe.cofide.io/proxy-flavor: istio",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:      constants.InjectAnnotationProxy,
+				constants.ProxyFlavorAnnotation: proxy.ProxyFlavorIstio,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.NotEmpty(t, mutatedPod.Spec.InitContainers)
+				initContainer := mutatedPod.Spec.InitContainers[0]
+				assert.Equal(t, proxy.EnvoyConfigInitContainerName, initContainer.Name)
+				require.Len(t, initContainer.Args, 1)
+				assert.Contains(t, initContainer.Args[0], "tcp dport 15020 return")
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-flavor: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:      constants.InjectAnnotationProxy,
+				constants.ProxyFlavorAnnotation: "linkerd",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ProxyFlavorAnnotation, "linkerd"},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-ip-family: ipv6",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:        constants.InjectAnnotationProxy,
+				constants.ProxyIPFamilyAnnotation: proxy.IPFamilyIPv6,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.NotEmpty(t, mutatedPod.Spec.InitContainers)
+				initContainer := mutatedPod.Spec.InitContainers[0]
+				assert.Equal(t, proxy.EnvoyConfigInitContainerName, initContainer.Name)
+				require.Len(t, initContainer.Args, 1)
+				assert.Contains(t, initContainer.Args[0], "ip6 daddr ::1/128")
+				assert.NotContains(t, initContainer.Args[0], "ip daddr 127.0.0.1/8")
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-ip-family: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:        constants.InjectAnnotationProxy,
+				constants.ProxyIPFamilyAnnotation: "ipv5",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ProxyIPFamilyAnnotation, "ipv5"},
+		},
+		{
+			name: "spiffe.cofide.io/exclude-outbound-ports",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:               constants.InjectAnnotationProxy,
+				constants.ExcludeOutboundPortsAnnotation: "9090, 8125,9090",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.NotEmpty(t, mutatedPod.Spec.InitContainers)
+				initContainer := mutatedPod.Spec.InitContainers[0]
+				assert.Equal(t, proxy.EnvoyConfigInitContainerName, initContainer.Name)
+				require.Len(t, initContainer.Args, 1)
+				assert.Equal(t, 1, strings.Count(initContainer.Args[0], "tcp dport 9090 return"))
+				assert.Contains(t, initContainer.Args[0], "tcp dport 8125 return")
+			},
+		},
+		{
+			name: "spiffe.cofide.io/exclude-outbound-ports: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:               constants.InjectAnnotationProxy,
+				constants.ExcludeOutboundPortsAnnotation: "9090,metrics",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ExcludeOutboundPortsAnnotation, "9090,metrics"},
+		},
+		{
+			name: "spiffe.cofide.io/exclude-dest-cidrs",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:           constants.InjectAnnotationProxy,
+				constants.ExcludeDestCIDRsAnnotation: "10.96.0.0/12, fd00::/8",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.NotEmpty(t, mutatedPod.Spec.InitContainers)
+				initContainer := mutatedPod.Spec.InitContainers[0]
+				assert.Equal(t, proxy.EnvoyConfigInitContainerName, initContainer.Name)
+				require.Len(t, initContainer.Args, 1)
+				assert.Contains(t, initContainer.Args[0], "ip daddr 10.96.0.0/12 return")
+				assert.Contains(t, initContainer.Args[0], "ip6 daddr fd00::/8 return")
+			},
+		},
+		{
+			name: "spiffe.cofide.io/exclude-dest-cidrs: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:           constants.InjectAnnotationProxy,
+				constants.ExcludeDestCIDRsAnnotation: "10.96.0.0/12,10.0.0.1",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ExcludeDestCIDRsAnnotation, "10.0.0.1"},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-init-extra-commands",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                 constants.InjectAnnotationProxy,
+				constants.ProxyInitExtraCommandsAnnotation: "modprobe nf_tables",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			expectedWarnings: []string{
+				constants.ProxyInitExtraCommandsAnnotation + " runs custom commands as root",
+			},
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.NotEmpty(t, mutatedPod.Spec.InitContainers)
+				initContainer := mutatedPod.Spec.InitContainers[0]
+				assert.Equal(t, proxy.EnvoyConfigInitContainerName, initContainer.Name)
+				require.Len(t, initContainer.Args, 1)
+				assert.Contains(t, initContainer.Args[0], "modprobe nf_tables")
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-init-extra-commands: empty",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                 constants.InjectAnnotationProxy,
+				constants.ProxyInitExtraCommandsAnnotation: "  ",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ProxyInitExtraCommandsAnnotation, "must not be empty"},
+		},
+		{
+			name: "spiffe.cofide.io/sidecar-position: first",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:          constants.InjectAnnotationProxy + "," + constants.InjectAnnotationHelper,
+				constants.SidecarModeAnnotation:     constants.SidecarModeRegular,
+				constants.SidecarPositionAnnotation: constants.SidecarPositionFirst,
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Name: "mesh-proxy", Image: "mesh"})
+				return p
+			},
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				var names []string
+				for _, c := range mutatedPod.Spec.Containers {
+					names = append(names, c.Name)
+				}
+				assert.Equal(t, []string{
+					helper.SPIFFEHelperSidecarContainerName, proxy.EnvoySidecarContainerName, "app-container", "mesh-proxy",
+				}, names)
+				assert.Equal(t, "app-container", mutatedPod.Annotations["kubectl.kubernetes.io/default-container"])
+			},
+		},
+		{
+			name: "spiffe.cofide.io/sidecar-position: last",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:          constants.InjectAnnotationProxy + "," + constants.InjectAnnotationHelper,
+				constants.SidecarModeAnnotation:     constants.SidecarModeRegular,
+				constants.SidecarPositionAnnotation: constants.SidecarPositionLast,
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Name: "mesh-proxy", Image: "mesh"})
+				return p
+			},
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				var names []string
+				for _, c := range mutatedPod.Spec.Containers {
+					names = append(names, c.Name)
+				}
+				assert.Equal(t, []string{
+					"app-container", "mesh-proxy", helper.SPIFFEHelperSidecarContainerName, proxy.EnvoySidecarContainerName,
+				}, names)
+				assert.NotContains(t, mutatedPod.Annotations, "kubectl.kubernetes.io/default-container")
+			},
+		},
+		{
+			name: "spiffe.cofide.io/sidecar-position: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:          constants.InjectAnnotationProxy,
+				constants.SidecarPositionAnnotation: "middle",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"invalid sidecar position", "middle"},
+		},
+		{
+			name: "proxy injection with conflicting envoy-config volume",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationProxy,
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.Volumes = []corev1.Volume{
+					{Name: proxy.EnvoyConfigVolumeName, VolumeSource: corev1.VolumeSource{
+						ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app-envoy"}},
+					}},
+				}
+				p.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{{Name: proxy.EnvoyConfigVolumeName, MountPath: "/etc/app-envoy"}}
+				return p
+			},
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				injectedVolumeName := proxy.EnvoyConfigVolumeName + "-1"
+
+				// The user's volume is unchanged and the injected one doesn't collide with it
+				require.Len(t, mutatedPod.Spec.Volumes, 3) // envoy-config, spiffe-workload-api, envoy-config-1
+				assert.NotNil(t, mutatedPod.Spec.Volumes[0].ConfigMap)
+				assert.True(t, workload.VolumeExists(mutatedPod, injectedVolumeName))
+				assert.Equal(t, proxy.EnvoyConfigVolumeName, mutatedPod.Spec.Containers[0].VolumeMounts[0].Name)
+
+				require.Len(t, mutatedPod.Spec.InitContainers, 1)
+				assert.Equal(t, injectedVolumeName, mutatedPod.Spec.InitContainers[0].VolumeMounts[0].Name)
+				require.Len(t, mutatedPod.Spec.Containers, 2)
+				assert.Equal(t, injectedVolumeName, mutatedPod.Spec.Containers[1].VolumeMounts[0].Name)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-dns-config: true",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+				constants.ProxyDNSConfigAnnotation: "true",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				assert.Empty(t, mutatedPod.Spec.DNSPolicy)
+				require.NotNil(t, mutatedPod.Spec.DNSConfig)
+				assert.Empty(t, mutatedPod.Spec.DNSConfig.Nameservers)
+				assert.Equal(t, []corev1.PodDNSConfigOption{{Name: "ndots", Value: ptr.To("1")}}, mutatedPod.Spec.DNSConfig.Options)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-dns-config: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+				constants.ProxyDNSConfigAnnotation: "on",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ProxyDNSConfigAnnotation, `"on"`},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-dns-config: true keeps existing ndots",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+				constants.ProxyDNSConfigAnnotation: "true",
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.DNSConfig = &corev1.PodDNSConfig{Options: []corev1.PodDNSConfigOption{
+					{Name: "ndots", Value: ptr.To("2")},
+					{Name: "edns0"},
+				}}
+				return p
+			},
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.NotNil(t, mutatedPod.Spec.DNSConfig)
+				assert.Equal(t, []corev1.PodDNSConfigOption{
+					{Name: "ndots", Value: ptr.To("2")},
+					{Name: "edns0"},
+				}, mutatedPod.Spec.DNSConfig.Options)
+			},
+		},
+		{
+			name: "proxy injection leaves DNS config alone by default",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationProxy,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				assert.Empty(t, mutatedPod.Spec.DNSPolicy)
+				assert.Nil(t, mutatedPod.Spec.DNSConfig)
+			},
+		},
+		{
+			name: "hostNetwork pod requesting proxy is denied",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationHelper + "," + constants.InjectAnnotationProxy,
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.HostNetwork = true
+				return p
+			},
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusForbidden,
+			},
+			expectedMessageContains: []string{"proxy", "host network"},
+		},
+		{
+			name: "hostNetwork pod requesting helper is allowed",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationHelper,
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.HostNetwork = true
+				return p
+			},
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				assert.True(t, mutatedPod.Spec.HostNetwork)
+				assert.True(t, sidecarExists(mutatedPod, helper.SPIFFEHelperSidecarContainerName))
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-startup-probe: true",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:            constants.InjectAnnotationProxy,
+				constants.ProxyStartupProbeAnnotation: "true",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.Containers, 2) // app, envoy
+				probe := mutatedPod.Spec.Containers[0].StartupProbe
+				require.NotNil(t, probe)
+				require.NotNil(t, probe.HTTPGet)
+				assert.Equal(t, proxy.EnvoyReadinessPath, probe.HTTPGet.Path)
+				assert.Equal(t, int32(proxy.EnvoyReadinessPort), probe.HTTPGet.Port.IntVal)
+				assert.Equal(t, int32(60), probe.FailureThreshold)
+				assert.Nil(t, mutatedPod.Spec.Containers[1].StartupProbe)
+
+				// The Envoy config serves the readiness endpoint on the probed port
+				require.Len(t, mutatedPod.Spec.InitContainers, 1)
+				assert.Contains(t, mutatedPod.Spec.InitContainers[0].Env[0].Value, `"readiness_listener"`)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-startup-probe: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:            constants.InjectAnnotationProxy,
+				constants.ProxyStartupProbeAnnotation: "yes",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ProxyStartupProbeAnnotation, "yes"},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-startup-probe-timeout",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                   constants.InjectAnnotationProxy,
+				constants.ProxyStartupProbeAnnotation:        "true",
+				constants.ProxyStartupProbeTimeoutAnnotation: "30s",
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.Containers = append(p.Spec.Containers, corev1.Container{
+					Name:  "worker",
+					Image: "worker",
+					StartupProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{
+						Exec: &corev1.ExecAction{Command: []string{"true"}},
+					}},
+				})
+				return p
+			},
+			expectedAllowed:  true,
+			expectedPatched:  true,
+			expectedWarnings: []string{"container worker already has a startup probe"},
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.Containers, 3) // app, worker, envoy
+				require.NotNil(t, mutatedPod.Spec.Containers[0].StartupProbe)
+				assert.Equal(t, int32(30), mutatedPod.Spec.Containers[0].StartupProbe.FailureThreshold)
+				// An existing startup probe is kept
+				require.NotNil(t, mutatedPod.Spec.Containers[1].StartupProbe.Exec)
+				assert.Nil(t, mutatedPod.Spec.Containers[1].StartupProbe.HTTPGet)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-startup-probe-timeout: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                   constants.InjectAnnotationProxy,
+				constants.ProxyStartupProbeAnnotation:        "true",
+				constants.ProxyStartupProbeTimeoutAnnotation: "-5s",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ProxyStartupProbeTimeoutAnnotation},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-wait-for-socket",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                    constants.InjectAnnotationProxy,
+				constants.ProxyWaitForSocketAnnotation:        "true",
+				constants.ProxyWaitForSocketTimeoutAnnotation: "2m",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.NotEmpty(t, mutatedPod.Spec.InitContainers)
+				initContainer := mutatedPod.Spec.InitContainers[0]
+				require.Equal(t, proxy.EnvoyConfigInitContainerName, initContainer.Name)
+				require.Len(t, initContainer.Args, 1)
+				assert.Contains(t, initContainer.Args[0], "until [ -S "+constants.SPIFFEWLSocketPath+" ]")
+				assert.Contains(t, initContainer.Args[0], `-ge 120 ]`)
+				assert.Contains(t, initContainer.VolumeMounts, workload.GetSPIFFEVolumeMount())
+			},
+		},
+		{
+			name: "proxy injection doesn't wait for the socket by default",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationProxy,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.NotEmpty(t, mutatedPod.Spec.InitContainers)
+				initContainer := mutatedPod.Spec.InitContainers[0]
+				require.Equal(t, proxy.EnvoyConfigInitContainerName, initContainer.Name)
+				assert.NotContains(t, initContainer.Args[0], "until [ -S")
+				assert.NotContains(t, initContainer.VolumeMounts, workload.GetSPIFFEVolumeMount())
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-wait-for-socket: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:             constants.InjectAnnotationProxy,
+				constants.ProxyWaitForSocketAnnotation: "TRUE",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ProxyWaitForSocketAnnotation, `"TRUE"`},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-wait-for-socket-timeout: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                    constants.InjectAnnotationProxy,
+				constants.ProxyWaitForSocketAnnotation:        "true",
+				constants.ProxyWaitForSocketTimeoutAnnotation: "soon",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ProxyWaitForSocketTimeoutAnnotation},
+		},
+		{
+			name: "spiffe.cofide.io/envoy-admin-port and envoy-admin-address",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:            constants.InjectAnnotationProxy,
+				constants.EnvoyAdminPortAnnotation:    "19901",
+				constants.EnvoyAdminAddressAnnotation: "::1",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.NotEmpty(t, mutatedPod.Spec.InitContainers)
+				initContainer := mutatedPod.Spec.InitContainers[0]
+				require.Equal(t, proxy.EnvoyConfigInitContainerName, initContainer.Name)
+				assert.Contains(t, initContainer.Args[0], "tcp dport 19901 return")
+				assert.NotContains(t, initContainer.Args[0], "tcp dport 9901 return")
+				require.Len(t, initContainer.Env, 1)
+				assert.Contains(t, initContainer.Env[0].Value, `"address": "::1"`)
+				assert.Contains(t, initContainer.Env[0].Value, `"port_value": 19901`)
+
+				// The sidecar drains its listeners through the admin interface before stopping
+				sidecarIndex := slices.IndexFunc(mutatedPod.Spec.Containers, func(c corev1.Container) bool {
+					return c.Name == proxy.EnvoySidecarContainerName
+				})
+				require.GreaterOrEqual(t, sidecarIndex, 0)
+				lifecycle := mutatedPod.Spec.Containers[sidecarIndex].Lifecycle
+				require.NotNil(t, lifecycle)
+				require.NotNil(t, lifecycle.PreStop)
+				require.NotNil(t, lifecycle.PreStop.Exec)
+				assert.Contains(t, strings.Join(lifecycle.PreStop.Exec.Command, " "), "http://[::1]:19901/drain_listeners")
+			},
+		},
+		{
+			name: "spiffe.cofide.io/envoy-admin-port: out of range",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+				constants.EnvoyAdminPortAnnotation: "70000",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.EnvoyAdminPortAnnotation, "between 1 and 65535"},
+		},
+		{
+			name: "spiffe.cofide.io/envoy-admin-port: used by the proxy",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+				constants.EnvoyAdminPortAnnotation: "10000",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.EnvoyAdminPortAnnotation, "already used by the proxy"},
+		},
+		{
+			name: "spiffe.cofide.io/envoy-admin-address: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:            constants.InjectAnnotationProxy,
+				constants.EnvoyAdminAddressAnnotation: "localhost",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.EnvoyAdminAddressAnnotation, "IP address"},
+		},
+		{
+			name: "proxy injection leaves app startup probes alone by default",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationProxy,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				assert.Nil(t, mutatedPod.Spec.Containers[0].StartupProbe)
+				assert.NotContains(t, mutatedPod.Spec.InitContainers[0].Env[0].Value, `"readiness_listener"`)
+			},
+		},
+		{
+			name: "dnsPolicy None is redirected with a warning by default",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationProxy,
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.DNSPolicy = corev1.DNSNone
+				p.Spec.DNSConfig = &corev1.PodDNSConfig{Nameservers: []string{"10.0.0.10"}}
+				return p
+			},
+			expectedAllowed:  true,
+			expectedPatched:  true,
+			expectedWarnings: []string{"pod has dnsPolicy None"},
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.InitContainers, 1)
+				assert.Contains(t, mutatedPod.Spec.InitContainers[0].Args[0], "udp dport 53")
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-custom-dns: skip",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+				constants.ProxyCustomDNSAnnotation: constants.ProxyCustomDNSSkip,
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.DNSPolicy = corev1.DNSNone
+				p.Spec.DNSConfig = &corev1.PodDNSConfig{Nameservers: []string{"10.0.0.10"}}
+				return p
+			},
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.InitContainers, 1)
+				assert.NotContains(t, mutatedPod.Spec.InitContainers[0].Args[0], "dport 53")
+				assert.Equal(t, []string{"10.0.0.10"}, mutatedPod.Spec.DNSConfig.Nameservers)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-custom-dns: skip only applies to dnsPolicy None",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+				constants.ProxyCustomDNSAnnotation: constants.ProxyCustomDNSSkip,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.InitContainers, 1)
+				assert.Contains(t, mutatedPod.Spec.InitContainers[0].Args[0], "udp dport 53")
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-custom-dns: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+				constants.ProxyCustomDNSAnnotation: "ignore",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ProxyCustomDNSAnnotation, "ignore"},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-jwt-forward: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:            constants.InjectAnnotationProxy,
+				constants.ProxyJWTAudiencesAnnotation: "api",
+				constants.ProxyJWTAppPortAnnotation:   "8080",
+				constants.ProxyJWTForwardAnnotation:   "yes",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ProxyJWTForwardAnnotation, `"yes"`},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-jwt-audiences",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:            constants.InjectAnnotationProxy,
+				constants.ProxyJWTAudiencesAnnotation: "spiffe://example.org/api, api",
+				constants.ProxyJWTAppPortAnnotation:   "8080",
+				constants.ProxyJWTJWKSURIAnnotation:   "https://oidc.example.org/keys",
+				constants.ProxyJWTForwardAnnotation:   "true",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.InitContainers, 1)
+				var compacted bytes.Buffer
+				require.NoError(t, json.Compact(&compacted, []byte(mutatedPod.Spec.InitContainers[0].Env[0].Value)))
+				cfg := compacted.String()
+				assert.Contains(t, cfg, `"jwt_authn_listener"`)
+				assert.Contains(t, cfg, `"audiences":["spiffe://example.org/api","api"]`)
+				assert.Contains(t, cfg, `"forward":true`)
+				assert.Contains(t, cfg, `"uri":"https://oidc.example.org/keys"`)
+				assert.Contains(t, cfg, `"address":"127.0.0.1","port_value":8080`)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-jwt-audiences without a JWKS URI",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:            constants.InjectAnnotationProxy,
+				constants.ProxyJWTAudiencesAnnotation: "api",
+				constants.ProxyJWTAppPortAnnotation:   "8080",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"invalid JWKS URI"},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-jwt-app-port: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:            constants.InjectAnnotationProxy,
+				constants.ProxyJWTAudiencesAnnotation: "api",
+				constants.ProxyJWTAppPortAnnotation:   "http",
+				constants.ProxyJWTJWKSURIAnnotation:   "https://oidc.example.org/keys",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ProxyJWTAppPortAnnotation},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-inbound-port",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:              constants.InjectAnnotationProxy,
+				constants.ProxyInboundPortAnnotation:    "15443",
+				constants.ProxyInboundAppPortAnnotation: "8080",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.InitContainers, 1)
+				var compacted bytes.Buffer
+				require.NoError(t, json.Compact(&compacted, []byte(mutatedPod.Spec.InitContainers[0].Env[0].Value)))
+				cfg := compacted.String()
+				assert.Contains(t, cfg, `"inbound_listener"`)
+				assert.Contains(t, cfg, `"port_value":15443`)
+				assert.Contains(t, cfg, "DownstreamTlsContext")
+				assert.Contains(t, cfg, `"address":"127.0.0.1","port_value":8080`)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/init-net-raw",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:     constants.InjectAnnotationProxy,
+				constants.InitNetRawAnnotation: "true",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.InitContainers, 1)
+				initContainer := mutatedPod.Spec.InitContainers[0]
+				require.Equal(t, proxy.EnvoyConfigInitContainerName, initContainer.Name)
+				assert.Equal(t, []corev1.Capability{"NET_ADMIN", "NET_RAW"}, initContainer.SecurityContext.Capabilities.Add)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/init-net-raw: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:     constants.InjectAnnotationProxy,
+				constants.InitNetRawAnnotation: "yes",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.InitNetRawAnnotation, `"yes"`},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-upstreams",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+				constants.ProxyUpstreamsAnnotation: "payments:8443=spiffe://example.org/payments",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.InitContainers, 1)
+				var compacted bytes.Buffer
+				require.NoError(t, json.Compact(&compacted, []byte(mutatedPod.Spec.InitContainers[0].Env[0].Value)))
+				cfg := compacted.String()
+				assert.Contains(t, cfg, `"name":"upstream_payments_8443"`)
+				assert.Contains(t, cfg, "UpstreamTlsContext")
+				assert.Contains(t, cfg, `"matcher":{"exact":"spiffe://example.org/payments"}`)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-upstreams: invalid SPIFFE ID",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+				constants.ProxyUpstreamsAnnotation: "payments:8443=payments",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ProxyUpstreamsAnnotation, `invalid SPIFFE ID "payments"`},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-inbound-port without an app port",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:           constants.InjectAnnotationProxy,
+				constants.ProxyInboundPortAnnotation: "15443",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"invalid application port"},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-inbound-port: used by the proxy",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:              constants.InjectAnnotationProxy,
+				constants.ProxyInboundPortAnnotation:    "15008",
+				constants.ProxyInboundAppPortAnnotation: "8080",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"invalid inbound port 15008"},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-inbound-app-port: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:              constants.InjectAnnotationProxy,
+				constants.ProxyInboundPortAnnotation:    "15443",
+				constants.ProxyInboundAppPortAnnotation: "http",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ProxyInboundAppPortAnnotation},
+		},
+		{
+			name: "spiffe.cofide.io/helper-jwt-audiences",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperJWTAudiencesAnnotation: "api, spiffe://example.org/db",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				for _, ic := range mutatedPod.Spec.InitContainers {
+					if ic.Name == helper.SPIFFEHelperInitContainerName {
+						require.Len(t, ic.Env, 1)
+						var cfg helper.SPIFFEHelperConfig
+						require.NoError(t, hclsimple.Decode("config.hcl", []byte(ic.Env[0].Value), nil, &cfg))
+						require.Len(t, cfg.JWTSVIDs, 2)
+						assert.Equal(t, "api", cfg.JWTSVIDs[0].JWTAudience)
+						assert.Equal(t, "jwt_svid_1.token", cfg.JWTSVIDs[0].JWTSVIDFilename)
+						assert.Equal(t, "spiffe://example.org/db", cfg.JWTSVIDs[1].JWTAudience)
+						assert.Equal(t, "jwt_svid_2.token", cfg.JWTSVIDs[1].JWTSVIDFilename)
+						return
+					}
+				}
+				t.Fatal("SPIFFE Helper init container not found")
+			},
+		},
+		{
+			name: "spiffe.cofide.io/helper-jwt-audiences: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperJWTAudiencesAnnotation: "api,api",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{helper.SPIFFEHelperJWTAudiencesAnnotation, "duplicate audience"},
+		},
+		{
+			name: "spiffe.cofide.io/helper-pre-stop-sleep",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				constants.SidecarModeAnnotation:           constants.SidecarModeRegular,
+				helper.SPIFFEHelperPreStopSleepAnnotation: "10s",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.Containers, 2) // app, spiffe-helper
+				sidecar := mutatedPod.Spec.Containers[1]
+				require.Equal(t, helper.SPIFFEHelperSidecarContainerName, sidecar.Name)
+				require.NotNil(t, sidecar.Lifecycle)
+				require.NotNil(t, sidecar.Lifecycle.PreStop)
+				require.NotNil(t, sidecar.Lifecycle.PreStop.Sleep)
+				assert.Equal(t, int64(10), sidecar.Lifecycle.PreStop.Sleep.Seconds)
+				assert.Nil(t, mutatedPod.Spec.Containers[0].Lifecycle)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/helper-pre-stop-sleep longer than the grace period",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperPreStopSleepAnnotation: "20s",
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.TerminationGracePeriodSeconds = ptr.To(int64(15))
+				return p
+			},
+			expectedAllowed:  true,
+			expectedPatched:  true,
+			expectedWarnings: []string{helper.SPIFFEHelperPreStopSleepAnnotation + " (20s) is not shorter than the pod's termination grace period (15s)"},
+		},
+		{
+			name: "spiffe.cofide.io/helper-pre-stop-sleep: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperPreStopSleepAnnotation: "10",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{helper.SPIFFEHelperPreStopSleepAnnotation},
+		},
+		{
+			name: "spiffe.cofide.io/helper-pre-stop-sleep: sleep action unsupported",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperPreStopSleepAnnotation: "10s",
+			},
+			initialPod:      basePod,
+			webhookOptions:  []Option{WithSleepActionSupport(false)},
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{helper.SPIFFEHelperPreStopSleepAnnotation, "v1.30+"},
+		},
+		{
+			name: "helper has no preStop hook by default",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationHelper,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				for _, container := range slices.Concat(mutatedPod.Spec.InitContainers, mutatedPod.Spec.Containers) {
+					assert.Nil(t, container.Lifecycle, container.Name)
+				}
+			},
+		},
+		{
+			name: "spiffe.cofide.io/config-volume-memory: true",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:             "helper,proxy",
+				constants.ConfigVolumeMemoryAnnotation: "true",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				for _, volume := range mutatedPod.Spec.Volumes {
+					switch volume.Name {
+					case proxy.EnvoyConfigVolumeName, helper.SPIFFEHelperConfigVolumeName, constants.SPIFFEEnableCertVolumeName:
+						require.NotNil(t, volume.EmptyDir, volume.Name)
+						assert.Equal(t, corev1.StorageMediumMemory, volume.EmptyDir.Medium, volume.Name)
+					}
+				}
+				assert.True(t, workload.VolumeExists(mutatedPod, proxy.EnvoyConfigVolumeName))
+				assert.True(t, workload.VolumeExists(mutatedPod, helper.SPIFFEHelperConfigVolumeName))
+			},
+		},
+		{
+			name: "spiffe.cofide.io/config-volume-memory: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:             "helper,proxy",
+				constants.ConfigVolumeMemoryAnnotation: "1",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{constants.ConfigVolumeMemoryAnnotation, `"1"`},
+		},
+		{
+			name: "config volumes use the default medium by default",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation: "helper,proxy",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				for _, volume := range mutatedPod.Spec.Volumes {
+					switch volume.Name {
+					case proxy.EnvoyConfigVolumeName, helper.SPIFFEHelperConfigVolumeName:
+						require.NotNil(t, volume.EmptyDir, volume.Name)
+						assert.Equal(t, corev1.StorageMediumDefault, volume.EmptyDir.Medium, volume.Name)
+					}
+				}
+			},
+		},
+		{
+			name: "spiffe.cofide.io/config-volume-memory without a config volume",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:             constants.InjectCSIVolume,
+				constants.ConfigVolumeMemoryAnnotation: "true",
+			},
+			initialPod:       basePod,
+			expectedAllowed:  true,
+			expectedPatched:  true,
+			expectedWarnings: []string{constants.ConfigVolumeMemoryAnnotation + " has no effect"},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-size: small",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:    constants.InjectAnnotationProxy,
+				constants.ProxySizeAnnotation: proxy.ProxySizeSmall,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.Containers, 2) // app, envoy
+				envoy := mutatedPod.Spec.Containers[1]
+				assert.Equal(t, proxy.EnvoySidecarContainerName, envoy.Name)
+				expected := proxy.ProxySizeProfiles[proxy.ProxySizeSmall]
+				assert.Equal(t, expected.Requests.Cpu().String(), envoy.Resources.Requests.Cpu().String())
+				assert.Equal(t, expected.Limits.Memory().String(), envoy.Resources.Limits.Memory().String())
+				assert.Empty(t, mutatedPod.Spec.Containers[0].Resources.Requests)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-resources overrides proxy-size",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+				constants.ProxySizeAnnotation:      proxy.ProxySizeLarge,
+				constants.ProxyResourcesAnnotation: `{"limits":{"memory":"1Gi"}}`,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.Containers, 2) // app, envoy
+				envoy := mutatedPod.Spec.Containers[1]
+				assert.Empty(t, envoy.Resources.Requests)
+				assert.Equal(t, "1Gi", envoy.Resources.Limits.Memory().String())
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-resources: list format",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+				constants.ProxyResourcesAnnotation: "cpu=100m,memory=64Mi,limits.memory=128Mi",
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				require.Len(t, mutatedPod.Spec.Containers, 2) // app, envoy
+				envoy := mutatedPod.Spec.Containers[1]
+				assert.Equal(t, "100m", envoy.Resources.Requests.Cpu().String())
+				assert.Equal(t, "64Mi", envoy.Resources.Requests.Memory().String())
+				assert.Equal(t, "128Mi", envoy.Resources.Limits.Memory().String())
+			},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-resources: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+				constants.ProxyResourcesAnnotation: "cpu=lots",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"invalid proxy resources", "lots"},
+		},
+		{
+			name: "Default sidecar resources",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationProxy + "," + constants.InjectAnnotationHelper,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				resources := map[string]corev1.ResourceRequirements{}
+				for _, c := range slices.Concat(mutatedPod.Spec.InitContainers, mutatedPod.Spec.Containers) {
+					resources[c.Name] = c.Resources
+				}
+				assert.Equal(t, proxy.DefaultSidecarResources, resources[proxy.EnvoySidecarContainerName])
+				assert.Equal(t, helper.DefaultSidecarResources, resources[helper.SPIFFEHelperSidecarContainerName])
+				assert.Empty(t, resources["app-container"].Requests)
+			},
+		},
+		{
+			name: "spiffe.cofide.io/helper-resources",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:             constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperResourcesAnnotation: `{"requests":{"cpu":"20m"},"limits":{"memory":"64Mi"}}`,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				idx := slices.IndexFunc(mutatedPod.Spec.InitContainers, func(c corev1.Container) bool {
+					return c.Name == helper.SPIFFEHelperSidecarContainerName
+				})
+				require.NotEqual(t, -1, idx)
+				resources := mutatedPod.Spec.InitContainers[idx].Resources
+				assert.Equal(t, "20m", resources.Requests.Cpu().String())
+				assert.Equal(t, "64Mi", resources.Limits.Memory().String())
+			},
+		},
+		{
+			name: "spiffe.cofide.io/helper-resources: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:             constants.InjectAnnotationHelper,
+				helper.SPIFFEHelperResourcesAnnotation: "memory=128Mi,limits.memory=64Mi",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"invalid spiffe-helper resources", "exceeds its limit"},
+		},
+		{
+			name: "spiffe.cofide.io/proxy-size: invalid",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:    constants.InjectAnnotationProxy,
+				constants.ProxySizeAnnotation: "huge",
+			},
+			initialPod:      basePod,
+			expectedAllowed: false,
+			expectedPatched: false,
+			expectedStatus: &metav1.Status{
+				Code: http.StatusBadRequest,
+			},
+			expectedMessageContains: []string{"invalid proxy size", "huge"},
+		},
+		{
+			name: "Warnings from multiple stages on a patched response",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:                constants.InjectCSIVolume,
+				constants.EnvoyLogLevelAnnotation:         "debug",
+				helper.SPIFFEHelperConfigFormatAnnotation: helper.SPIFFEHelperConfigFormatJSON,
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.Volumes = append(p.Spec.Volumes, corev1.Volume{
+					Name:         constants.SPIFFEWLVolume,
+					VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+				})
+				return p
+			},
+			expectedAllowed: true,
+			expectedPatched: true,
+			expectedWarnings: []string{
+				constants.EnvoyLogLevelAnnotation + " has no effect",
+				helper.SPIFFEHelperConfigFormatAnnotation + " has no effect",
+				constants.SPIFFEWLVolume + " is not a SPIFFE CSI volume",
+			},
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				assert.Len(t, mutatedPod.Spec.Volumes, 1)
+			},
+		},
+		{
+			name: "Warnings on an allowed response without patches",
+			podAnnotations: map[string]string{
+				constants.EnvoyLogLevelAnnotation:            "debug",
+				helper.SPIFFEHelperIncIntermediateAnnotation: annotationValueTrue,
+			},
+			initialPod:      basePod,
+			expectedAllowed: true,
+			expectedPatched: false,
+			expectedWarnings: []string{
+				constants.EnvoyLogLevelAnnotation + " has no effect",
+				helper.SPIFFEHelperIncIntermediateAnnotation + " has no effect",
+			},
+		},
+		{
+			name:           "Job-owned pod with skipped owner kind",
+			podAnnotations: map[string]string{constants.InjectAnnotation: constants.InjectAnnotationHelper},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.OwnerReferences = []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: "test-job"}}
+				return p
+			},
+			webhookOptions:  []Option{WithSkipOwnerKinds([]string{"Job"})},
+			expectedAllowed: true,
+			expectedPatched: false,
+		},
+		{
+			name:           "Job-owned pod without skipped owner kinds",
+			podAnnotations: map[string]string{constants.InjectAnnotation: constants.InjectAnnotationHelper},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.OwnerReferences = []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: "test-job"}}
+				return p
+			},
+			webhookOptions:  []Option{WithSkipOwnerKinds([]string{"DaemonSet"})},
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				assert.True(t, sidecarExists(mutatedPod, helper.SPIFFEHelperSidecarContainerName))
+			},
+		},
+		{
+			name: "Mirror pod",
+			podAnnotations: map[string]string{
+				constants.InjectAnnotation:    constants.InjectAnnotationProxy + "," + constants.InjectAnnotationHelper,
+				corev1.MirrorPodAnnotationKey: "6f0d8a5e0b3c4e2a9d1f7c8b5a4e3d2c",
+			},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.OwnerReferences = []metav1.OwnerReference{{APIVersion: "v1", Kind: "Node", Name: "node-1"}}
+				return p
+			},
+			expectedAllowed:  true,
+			expectedPatched:  false,
+			expectedWarnings: []string{"injection skipped for mirror pod"},
+		},
+		{
+			name:           "No pod annotation, CSI volume already exists",
+			podAnnotations: map[string]string{},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.Volumes = append(p.Spec.Volumes, workload.GetSPIFFEVolume())
+				return p
+			},
+			expectedAllowed: true,
+			expectedPatched: false,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				assert.Len(t, mutatedPod.Spec.Volumes, 1, "CSI Volume should not be duplicated")
+			},
+		},
+		{
+			name:           "spiffe.cofide.io/inject: csi, CSI volume already exists, unmounted",
+			podAnnotations: map[string]string{constants.InjectAnnotation: constants.InjectCSIVolume},
+			initialPod: func() *corev1.Pod {
+				p := basePod()
+				p.Spec.Volumes = append(p.Spec.Volumes, workload.GetSPIFFEVolume())
+				return p
+			},
+			expectedAllowed: true,
+			expectedPatched: true,
+			validatePod: func(t *testing.T, mutatedPod *corev1.Pod) {
+				assert.Len(t, mutatedPod.Spec.Volumes, 1, "CSI Volume should not be duplicated")
+
+				// Ensure the CSI volume is mounted into the container
+				require.Len(t, mutatedPod.Spec.Containers, 1)
+				appContainer := mutatedPod.Spec.Containers[0]
+				assert.Equal(t, "app-container", appContainer.Name)
+				require.Len(t, appContainer.VolumeMounts, 1)
+				assert.Equal(t, constants.SPIFFEWLVolume, appContainer.VolumeMounts[0].Name)
+				assert.Equal(t, constants.SPIFFEWLMountPath, appContainer.VolumeMounts[0].MountPath)
+				assert.True(t, appContainer.VolumeMounts[0].ReadOnly)
+
+				// Ensure the environment variable is set
+				foundEnv := false
+				for _, env := range appContainer.Env {
+					if env.Name == constants.SPIFFEWLSocketEnvName {
+						assert.Equal(t, constants.SPIFFEWLSocket, env.Value)
+						foundEnv = true
+						break
+					}
+				}
+				assert.True(t, foundEnv, "SPIFFE_ENDPOINT_SOCKET env var not found")
+			},
+		},
+		// TODO: Add tests for idempotency of helper and proxy components if they already exist.
+		// TODO: Add test for existing CSI volume mount with different ReadOnly (should be updated by ensureVolumeMount)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := newTestWebhook(t, tt.webhookOptions...)
+			pod := tt.initialPod()
+			if pod.Annotations == nil && len(tt.podAnnotations) > 0 { // Ensure annotations map exists
+				pod.Annotations = make(map[string]string)
+			}
+			for k, v := range tt.podAnnotations {
+				pod.Annotations[k] = v
+			}
+
+			req, podBytes := newAdmissionRequest(t, pod)
+			resp := wh.Handle(context.Background(), req)
+
+			assert.Equal(t, tt.expectedAllowed, resp.Allowed, "Response Allowed mismatch")
+
+			require.Len(t, resp.Warnings, len(tt.expectedWarnings), "Response warnings: %v", resp.Warnings)
+			for i, warning := range tt.expectedWarnings {
+				assert.Contains(t, resp.Warnings[i], warning)
+			}
+
+			if !tt.expectedAllowed && tt.expectedStatus != nil {
+				require.NotNil(t, resp.Result)
+				for _, substring := range tt.expectedMessageContains {
+					assert.Contains(t, resp.Result.Message, substring)
+				}
+				assert.Equal(t, tt.expectedStatus.Code, resp.Result.Code)
+			}
+
+			if tt.expectedPatched {
+				// Check either resp.Patch or resp.Patches
+				hasPatch := (len(resp.Patch) > 0) || len(resp.Patches) > 0
+				assert.True(t, hasPatch, "Expected patch(es)")
+
+				if tt.validatePod != nil && resp.Allowed {
+					modifiedJSON := podBytes
+					for _, p := range resp.Patches {
+						patchBytes, err := p.MarshalJSON()
+						if err != nil {
+							t.Fatalf("Failed to marshal patch: %v", err)
+						}
+
+						patchArrayBytes := append([]byte("["), patchBytes...)
+						patchArrayBytes = append(patchArrayBytes, []byte("]")...)
+
+						patch, err := jsonpatch.DecodePatch(patchArrayBytes)
+						if err != nil {
+							t.Fatalf("Failed to decode patch: %v", err)
+						}
+
+						modifiedJSON, err = patch.Apply(modifiedJSON)
+						if err != nil {
+							panic(err)
+						}
+					}
+
+					// Decode the result
+					var modifiedPod corev1.Pod
+					if err := json.Unmarshal(modifiedJSON, &modifiedPod); err != nil {
+						t.Fatalf("Failed to unmarshal modified pod: %v", err)
+					}
+
+					// Validate the modified pod matches the expected pod
+					tt.validatePod(t, &modifiedPod)
+				}
+			} else {
+				// Check for no patches when not expected
+				assert.Empty(t, resp.Patch)
+				assert.Empty(t, resp.Patches)
+			}
+		})
+	}
+}
+
+func TestNewSpiffeEnableWebhook_RenderTimeout(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		wh := newTestWebhook(t)
+		assert.Equal(t, constants.DefaultRenderTimeout, wh.renderTimeout)
+	})
+
+	t.Run("from environment", func(t *testing.T) {
+		t.Setenv(constants.EnvVarRenderTimeout, "500ms")
+		wh := newTestWebhook(t)
+		assert.Equal(t, 500*time.Millisecond, wh.renderTimeout)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Setenv(constants.EnvVarRenderTimeout, "soon")
+		_, err := NewSpiffeEnableWebhook(nil, testr.New(t), nil)
+		require.Error(t, err)
+	})
+}
+
+func TestParseExtraEnv(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    []corev1.EnvVar
+		expectError bool
+	}{
+		{
+			name:  "multiple pairs with whitespace",
+			value: " A=1 , B_2=two ,",
+			expected: []corev1.EnvVar{
+				{Name: "A", Value: "1"},
+				{Name: "B_2", Value: "two"},
+			},
+		},
+		{
+			name:     "value containing '='",
+			value:    "OPTS=a=b",
+			expected: []corev1.EnvVar{{Name: "OPTS", Value: "a=b"}},
+		},
+		{
+			name:     "empty value",
+			value:    "EMPTY=",
+			expected: []corev1.EnvVar{{Name: "EMPTY", Value: ""}},
+		},
+		{
+			name:        "invalid character in name",
+			value:       "MY-VAR=value",
+			expectError: true,
+		},
+		{
+			name:        "empty name",
+			value:       "=value",
+			expectError: true,
+		},
+		{
+			name:        "duplicate name",
+			value:       "A=1,A=2",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envVars, err := parseExtraEnv(tt.value)
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, envVars)
+		})
+	}
+}
+
+func TestParseCertPaths(t *testing.T) {
+	containers := []corev1.Container{
+		{Name: "app-a"},
+		{Name: "app-b", VolumeMounts: []corev1.VolumeMount{{Name: "config", MountPath: "/etc/config"}}},
+	}
+
+	tests := []struct {
+		name        string
+		value       string
+		expected    map[string]string
+		expectError bool
+	}{
+		{
+			name:     "multiple containers with whitespace",
+			value:    " app-a=/etc/a/certs , app-b=/var/run/certs ,",
+			expected: map[string]string{"app-a": "/etc/a/certs", "app-b": "/var/run/certs"},
+		},
+		{
+			name:        "missing path",
+			value:       "app-a",
+			expectError: true,
+		},
+		{
+			name:        "unknown container",
+			value:       "app-c=/certs",
+			expectError: true,
+		},
+		{
+			name:        "duplicate container",
+			value:       "app-a=/a,app-a=/b",
+			expectError: true,
+		},
+		{
+			name:        "relative path",
+			value:       "app-a=certs",
+			expectError: true,
+		},
+		{
+			name:        "unclean path",
+			value:       "app-a=/etc/../certs",
+			expectError: true,
+		},
+		{
+			name:        "root path",
+			value:       "app-a=/",
+			expectError: true,
+		},
+		{
+			name:        "path used by another volume",
+			value:       "app-b=/etc/config",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certPaths, err := parseCertPaths(tt.value, containers, constants.SPIFFEEnableCertVolumeName)
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, certPaths)
+		})
+	}
+}
+
+func TestParseBoolAnnotation(t *testing.T) {
+	const name = "example.com/enabled"
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		defaultValue bool
+		expected     bool
+		expectError  bool
+	}{
+		{name: "unset uses default", defaultValue: true, expected: true},
+		{name: "true", annotations: map[string]string{name: "true"}, expected: true},
+		{name: "false", annotations: map[string]string{name: "false"}, defaultValue: true, expected: false},
+		{name: "invalid", annotations: map[string]string{name: "yes"}, expectError: true},
+		{name: "empty", annotations: map[string]string{name: ""}, defaultValue: true, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := parseBoolAnnotation(tt.annotations, name, tt.defaultValue)
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), name)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestParsePositiveDurationAnnotation(t *testing.T) {
+	const name = "example.com/timeout"
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    time.Duration
+		expectError bool
+	}{
+		{name: "unset uses default", expected: time.Minute},
+		{name: "duration", annotations: map[string]string{name: "30s"}, expected: 30 * time.Second},
+		{name: "zero", annotations: map[string]string{name: "0s"}, expectError: true},
+		{name: "negative", annotations: map[string]string{name: "-1s"}, expectError: true},
+		{name: "no unit", annotations: map[string]string{name: "30"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := parsePositiveDurationAnnotation(tt.annotations, name, time.Minute)
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), name)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestParsePortAnnotation(t *testing.T) {
+	const name = "example.com/port"
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    uint16
+		expectError bool
+	}{
+		{name: "unset", expected: 0},
+		{name: "port", annotations: map[string]string{name: "9090"}, expected: 9090},
+		{name: "highest port", annotations: map[string]string{name: "65535"}, expected: 65535},
+		{name: "zero", annotations: map[string]string{name: "0"}, expectError: true},
+		{name: "too large", annotations: map[string]string{name: "65536"}, expectError: true},
+		{name: "not a number", annotations: map[string]string{name: "http"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := parsePortAnnotation(tt.annotations, name)
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), name)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestSpiffeEnableWebhook_XDSToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("file-token\n"), 0o600))
+
+	tests := []struct {
+		name           string
+		env            map[string]string
+		expectedHeader *proxy.XDSHeader
+		expectError    bool
+	}{
+		{
+			name: "no token",
+		},
+		{
+			name:           "token from environment",
+			env:            map[string]string{constants.EnvVarXDSToken: "env-token"},
+			expectedHeader: &proxy.XDSHeader{Key: "authorization", Value: "env-token"},
+		},
+		{
+			name: "token from file takes precedence, custom header",
+			env: map[string]string{
+				constants.EnvVarXDSToken:       "env-token",
+				constants.EnvVarXDSTokenFile:   tokenFile,
+				constants.EnvVarXDSTokenHeader: "x-cofide-token",
+			},
+			expectedHeader: &proxy.XDSHeader{Key: "x-cofide-token", Value: "file-token"},
+		},
+		{
+			name:        "missing token file",
+			env:         map[string]string{constants.EnvVarXDSTokenFile: filepath.Join(t.TempDir(), "missing")},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			wh := newTestWebhook(t)
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-pod",
+					Namespace:   "default",
+					Annotations: map[string]string{constants.InjectAnnotation: constants.InjectAnnotationProxy},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+			}
+			req, _ := newAdmissionRequest(t, pod)
+			resp := wh.Handle(context.Background(), req)
+
+			if tt.expectError {
+				assert.False(t, resp.Allowed)
+				return
+			}
+			require.True(t, resp.Allowed)
+
+			// The Envoy config is passed to the init container in an environment variable
+			var envoyConfig string
+			for _, p := range resp.Patches {
+				if p.Path != "/spec/initContainers" {
+					continue
+				}
+				initContainers, err := json.Marshal(p.Value)
+				require.NoError(t, err)
+				var containers []corev1.Container
+				require.NoError(t, json.Unmarshal(initContainers, &containers))
+				for _, c := range containers {
+					for _, env := range c.Env {
+						if env.Name == proxy.EnvoyConfigContentEnvVar {
+							envoyConfig = env.Value
+						}
+					}
+				}
+			}
+			require.NotEmpty(t, envoyConfig)
+
+			var cfg struct {
+				DynamicResources struct {
+					ADSConfig struct {
+						GRPCServices []struct {
+							InitialMetadata []map[string]string `json:"initial_metadata"`
+						} `json:"grpc_services"`
+					} `json:"ads_config"`
+				} `json:"dynamic_resources"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(envoyConfig), &cfg))
+			require.Len(t, cfg.DynamicResources.ADSConfig.GRPCServices, 1)
+			initialMetadata := cfg.DynamicResources.ADSConfig.GRPCServices[0].InitialMetadata
+
+			if tt.expectedHeader == nil {
+				assert.Empty(t, initialMetadata)
+				return
+			}
+			assert.Equal(t, []map[string]string{
+				{"key": tt.expectedHeader.Key, "value": tt.expectedHeader.Value},
+			}, initialMetadata)
+		})
+	}
+
+	t.Run("invalid header", func(t *testing.T) {
+		t.Setenv(constants.EnvVarXDSTokenHeader, "Authorization")
+		_, err := NewSpiffeEnableWebhook(nil, testr.New(t), nil)
+		require.Error(t, err)
+	})
+}
+
+func TestSpiffeEnableWebhook_InitImages(t *testing.T) {
+	tests := []struct {
+		name                string
+		env                 map[string]string
+		expectedProxyImage  string
+		expectedHelperImage string
+	}{
+		{
+			name:                "defaults",
+			expectedProxyImage:  helper.InitHelperImage,
+			expectedHelperImage: helper.InitHelperImage,
+		},
+		{
+			name: "configured independently",
+			env: map[string]string{
+				constants.EnvVarProxyInitImage:  "example.com/nft:1.0",
+				constants.EnvVarHelperInitImage: "busybox:1.37",
+			},
+			expectedProxyImage:  "example.com/nft:1.0",
+			expectedHelperImage: "busybox:1.37",
+		},
+		{
+			name: "fallback",
+			env: map[string]string{
+				constants.EnvVarInitFallbackImage: constants.PublicInitFallbackImage,
+			},
+			expectedProxyImage:  helper.InitHelperImage,
+			expectedHelperImage: constants.PublicInitFallbackImage,
+		},
+		{
+			name: "helper image overrides fallback",
+			env: map[string]string{
+				constants.EnvVarHelperInitImage:   "busybox:1.37",
+				constants.EnvVarInitFallbackImage: constants.PublicInitFallbackImage,
+			},
+			expectedProxyImage:  helper.InitHelperImage,
+			expectedHelperImage: "busybox:1.37",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			wh := newTestWebhook(t)
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-pod",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.InjectAnnotation: constants.InjectAnnotationProxy + "," + constants.InjectAnnotationHelper,
+					},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+			}
+			req, rawPod := newAdmissionRequest(t, pod)
+			resp := wh.Handle(context.Background(), req)
+			require.True(t, resp.Allowed)
+
+			patchBytes, err := json.Marshal(resp.Patches)
+			require.NoError(t, err)
+			patch, err := jsonpatch.DecodePatch(patchBytes)
+			require.NoError(t, err)
+			mutatedJSON, err := patch.Apply(rawPod)
+			require.NoError(t, err)
+			var mutated corev1.Pod
+			require.NoError(t, json.Unmarshal(mutatedJSON, &mutated))
+
+			images := map[string]string{}
+			for _, c := range mutated.Spec.InitContainers {
+				images[c.Name] = c.Image
+			}
+			assert.Equal(t, tt.expectedProxyImage, images[proxy.EnvoyConfigInitContainerName])
+			assert.Equal(t, tt.expectedHelperImage, images[helper.SPIFFEHelperInitContainerName])
+		})
+	}
+}
+
+func TestSpiffeEnableWebhook_Images(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		images   ImageConfig
+		expected map[string]string
+	}{
+		{
+			name: "defaults",
+			expected: map[string]string{
+				helper.SPIFFEHelperSidecarContainerName: helper.SPIFFEHelperImage,
+				proxy.EnvoySidecarContainerName:         proxy.IstioImage,
+			},
+		},
+		{
+			name: "environment variables",
+			env: map[string]string{
+				constants.EnvVarHelperImage: "mirror.example.com/spiffe/spiffe-helper:0.10.1",
+				constants.EnvVarProxyImage:  "mirror.example.com/istio/proxyv2:1.26.4",
+			},
+			expected: map[string]string{
+				helper.SPIFFEHelperSidecarContainerName: "mirror.example.com/spiffe/spiffe-helper:0.10.1",
+				proxy.EnvoySidecarContainerName:         "mirror.example.com/istio/proxyv2:1.26.4",
+			},
+		},
+		{
+			name: "options override environment variables",
+			env: map[string]string{
+				constants.EnvVarHelperImage:    "mirror.example.com/spiffe/spiffe-helper:0.10.1",
+				constants.EnvVarProxyInitImage: "mirror.example.com/cofide/spiffe-enable-init:v0.3.0",
+			},
+			images: ImageConfig{
+				Helper:     "registry.internal:5000/spiffe-helper@sha256:" + strings.Repeat("a", 64),
+				HelperInit: "busybox",
+				Proxy:      "registry.internal:5000/envoy:v1",
+			},
+			expected: map[string]string{
+				helper.SPIFFEHelperSidecarContainerName: "registry.internal:5000/spiffe-helper@sha256:" + strings.Repeat("a", 64),
+				helper.SPIFFEHelperInitContainerName:    "busybox",
+				proxy.EnvoySidecarContainerName:         "registry.internal:5000/envoy:v1",
+				proxy.EnvoyConfigInitContainerName:      "mirror.example.com/cofide/spiffe-enable-init:v0.3.0",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			wh := newTestWebhook(t, WithImages(tt.images))
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-pod",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.InjectAnnotation: constants.InjectAnnotationProxy + "," + constants.InjectAnnotationHelper,
+					},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+			}
+			req, rawPod := newAdmissionRequest(t, pod)
+			resp := wh.Handle(context.Background(), req)
+			require.True(t, resp.Allowed)
+
+			patchBytes, err := json.Marshal(resp.Patches)
+			require.NoError(t, err)
+			patch, err := jsonpatch.DecodePatch(patchBytes)
+			require.NoError(t, err)
+			mutatedJSON, err := patch.Apply(rawPod)
+			require.NoError(t, err)
+			var mutated corev1.Pod
+			require.NoError(t, json.Unmarshal(mutatedJSON, &mutated))
+
+			images := map[string]string{}
+			for _, c := range slices.Concat(mutated.Spec.InitContainers, mutated.Spec.Containers) {
+				images[c.Name] = c.Image
+			}
+			for name, image := range tt.expected {
+				assert.Equal(t, image, images[name], "container %s", name)
+			}
+		})
+	}
+}
+
+func TestNewSpiffeEnableWebhook_Images(t *testing.T) {
+	for _, image := range []string{"Registry.example.com/UPPER:v1", "example.com/app:", "example.com/app@sha256:abc", "with space"} {
+		t.Run(image, func(t *testing.T) {
+			_, err := NewSpiffeEnableWebhook(nil, testr.New(t), nil, WithImages(ImageConfig{Proxy: image}))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid proxy image")
+		})
+	}
+
+	t.Run("environment variable", func(t *testing.T) {
+		t.Setenv(constants.EnvVarHelperImage, "example.com/app::v1")
+		_, err := NewSpiffeEnableWebhook(nil, testr.New(t), nil)
+		require.Error(t, err)
+	})
+}
+
+func TestSpiffeEnableWebhook_DebugUIImage(t *testing.T) {
+	t.Setenv(constants.EnvVarUIImage, "mirror.example.com/cofide/spiffe-enable-ui:v0.3.0")
+	wh := newTestWebhook(t)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-pod",
+			Namespace:   "default",
+			Annotations: map[string]string{constants.DebugAnnotation: annotationValueTrue},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+	}
+	req, rawPod := newAdmissionRequest(t, pod)
+	resp := wh.Handle(context.Background(), req)
+	require.True(t, resp.Allowed)
+
+	patchBytes, err := json.Marshal(resp.Patches)
+	require.NoError(t, err)
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+	require.NoError(t, err)
+	mutatedJSON, err := patch.Apply(rawPod)
+	require.NoError(t, err)
+	var mutated corev1.Pod
+	require.NoError(t, json.Unmarshal(mutatedJSON, &mutated))
+
+	require.Len(t, mutated.Spec.Containers, 2)
+	assert.Equal(t, constants.DebugUIContainerName, mutated.Spec.Containers[1].Name)
+	assert.Equal(t, "mirror.example.com/cofide/spiffe-enable-ui:v0.3.0", mutated.Spec.Containers[1].Image)
+}
+
+func TestSpiffeEnableWebhook_NonPod(t *testing.T) {
+	wh := newTestWebhook(t)
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-config",
+			Namespace:   "default",
+			Annotations: map[string]string{constants.InjectAnnotation: constants.InjectAnnotationProxy},
+		},
+		Data: map[string]string{"key": "value"},
+	}
+	raw, err := json.Marshal(configMap)
+	require.NoError(t, err)
+
+	resp := wh.Handle(context.Background(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			UID:    "test-uid",
+			Object: runtime.RawExtension{Raw: raw},
+			Kind:   metav1.GroupVersionKind{Kind: "ConfigMap", Version: "v1"},
+		},
+	})
+
+	assert.True(t, resp.Allowed)
+	assert.Empty(t, resp.Patches)
+	require.Len(t, resp.Warnings, 1)
+	assert.Contains(t, resp.Warnings[0], "ConfigMap")
+}
+
+func TestSpiffeEnableWebhook_AuditAnnotations(t *testing.T) {
+	injectedAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// mutate runs the webhook on pod and returns the resulting pod
+	mutate := func(t *testing.T, wh *spiffeEnableWebhook, pod *corev1.Pod) *corev1.Pod {
+		t.Helper()
+		req, rawPod := newAdmissionRequest(t, pod)
+		resp := wh.Handle(context.Background(), req)
+		require.True(t, resp.Allowed)
+
+		patchBytes, err := json.Marshal(resp.Patches)
+		require.NoError(t, err)
+		patch, err := jsonpatch.DecodePatch(patchBytes)
+		require.NoError(t, err)
+		mutatedJSON, err := patch.Apply(rawPod)
+		require.NoError(t, err)
+		var mutated corev1.Pod
+		require.NoError(t, json.Unmarshal(mutatedJSON, &mutated))
+		return &mutated
+	}
+
+	newPod := func(annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default", Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+		}
+	}
+
+	wh := newTestWebhook(t, WithVersion("v1.2.3"))
+	wh.now = func() time.Time { return injectedAt }
+
+	t.Run("set on injected pods", func(t *testing.T) {
+		mutated := mutate(t, wh, newPod(map[string]string{constants.InjectAnnotation: constants.InjectAnnotationHelper}))
+		assert.Equal(t, "2025-06-01T12:00:00Z", mutated.Annotations[constants.InjectedAtAnnotation])
+		assert.Equal(t, "v1.2.3", mutated.Annotations[constants.InjectedByAnnotation])
+	})
+
+	t.Run("absent on skipped pods", func(t *testing.T) {
+		mutated := mutate(t, wh, newPod(nil))
+		assert.NotContains(t, mutated.Annotations, constants.InjectedAtAnnotation)
+		assert.NotContains(t, mutated.Annotations, constants.InjectedByAnnotation)
+	})
+
+	t.Run("unchanged on reinvocation", func(t *testing.T) {
+		injected := mutate(t, wh, newPod(map[string]string{constants.InjectAnnotation: constants.InjectAnnotationHelper}))
+
+		wh.now = func() time.Time { return injectedAt.Add(time.Hour) }
+		t.Cleanup(func() { wh.now = func() time.Time { return injectedAt } })
+
+		req, _ := newAdmissionRequest(t, injected)
+		resp := wh.Handle(context.Background(), req)
+		require.True(t, resp.Allowed)
+		assert.Empty(t, resp.Patches)
+	})
+}
+
+func TestSpiffeEnableWebhook_Update(t *testing.T) {
+	wh := newTestWebhook(t)
+
+	// A running pod whose injection annotation has been changed, eg to remove the helper
+	oldPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-pod",
+			Namespace:   "default",
+			Annotations: map[string]string{constants.InjectAnnotation: constants.InjectAnnotationHelper},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+	}
+	newPod := oldPod.DeepCopy()
+	newPod.Annotations[constants.InjectAnnotation] = constants.InjectAnnotationProxy
+
+	req, _ := newAdmissionRequest(t, newPod)
+	req.Operation = admissionv1.Update
+	rawOldPod, err := json.Marshal(oldPod)
+	require.NoError(t, err)
+	req.OldObject = runtime.RawExtension{Raw: rawOldPod}
+
+	resp := wh.Handle(context.Background(), req)
+	assert.True(t, resp.Allowed)
+	assert.Empty(t, resp.Patches, "containers of a running pod must never be mutated")
+}
+
+func TestSpiffeEnableWebhook_AllowedTrustDomains(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	namespace := func(name, trustDomain string) *corev1.Namespace {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if trustDomain != "" {
+			ns.Annotations = map[string]string{constants.TrustDomainAnnotation: trustDomain}
+		}
+		return ns
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		namespace("allowed", "example.org"),
+		namespace("denied", "other.org"),
+		namespace("unmapped", ""),
+	).Build()
+
+	tests := []struct {
+		name                string
+		allowedTrustDomains []string
+		namespace           string
+		expectedAllowed     bool
+		expectedPatched     bool
+		expectedWarning     string
+	}{
+		{
+			name:            "no policy",
+			namespace:       "denied",
+			expectedAllowed: true,
+			expectedPatched: true,
+		},
+		{
+			name:                "allowed trust domain",
+			allowedTrustDomains: []string{"example.org", "example.com"},
+			namespace:           "allowed",
+			expectedAllowed:     true,
+			expectedPatched:     true,
+		},
+		{
+			name:                "denied trust domain",
+			allowedTrustDomains: []string{"example.org"},
+			namespace:           "denied",
+			expectedAllowed:     false,
+		},
+		{
+			name:                "unmapped namespace",
+			allowedTrustDomains: []string{"example.org"},
+			namespace:           "unmapped",
+			expectedAllowed:     true,
+			expectedPatched:     true,
+			expectedWarning:     "namespace unmapped has no " + constants.TrustDomainAnnotation,
+		},
+		{
+			name:                "missing namespace",
+			allowedTrustDomains: []string{"example.org"},
+			namespace:           "missing",
+			expectedAllowed:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh, err := NewSpiffeEnableWebhook(k8sClient, testr.New(t), admission.NewDecoder(scheme),
+				WithAllowedTrustDomains(tt.allowedTrustDomains))
+			require.NoError(t, err)
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-pod",
+					Annotations: map[string]string{constants.InjectAnnotation: constants.InjectCSIVolume},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+			}
+			req, _ := newAdmissionRequest(t, pod)
+			req.Namespace = tt.namespace
+
+			resp := wh.Handle(context.Background(), req)
+			assert.Equal(t, tt.expectedAllowed, resp.Allowed, "result: %v", resp.Result)
+			assert.Equal(t, tt.expectedPatched, len(resp.Patches) > 0)
+			if !tt.expectedAllowed && tt.namespace == "denied" {
+				require.NotNil(t, resp.Result)
+				assert.Equal(t, int32(http.StatusForbidden), resp.Result.Code)
+				assert.Contains(t, resp.Result.Message, "other.org")
+			}
+			if tt.expectedWarning != "" {
+				require.Len(t, resp.Warnings, 1)
+				assert.Contains(t, resp.Warnings[0], tt.expectedWarning)
+			}
+		})
+	}
+}
+
+func TestSpiffeEnableWebhook_ModePolicy(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	namespace := func(name, allowedModes string) *corev1.Namespace {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if allowedModes != "" {
+			ns.Annotations = map[string]string{constants.AllowedModesAnnotation: allowedModes}
+		}
+		return ns
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		namespace("default-policy", ""),
+		namespace("proxy-enabled", "csi,proxy"),
+		namespace("locked", allowedModesNone),
+		namespace("invalid", "mesh"),
+	).Build()
+
+	tests := []struct {
+		name            string
+		allowedModes    string
+		namespace       string
+		inject          string
+		expectedAllowed bool
+		expectedMessage string
+	}{
+		{
+			name:            "no policy",
+			namespace:       "missing",
+			inject:          constants.InjectAnnotationProxy,
+			expectedAllowed: true,
+		},
+		{
+			name:            "controller default allows mode",
+			allowedModes:    "csi,helper",
+			namespace:       "default-policy",
+			inject:          constants.InjectAnnotationHelper,
+			expectedAllowed: true,
+		},
+		{
+			name:            "controller default forbids proxy",
+			allowedModes:    "csi,helper",
+			namespace:       "default-policy",
+			inject:          constants.InjectAnnotationHelper + "," + constants.InjectAnnotationProxy,
+			expectedMessage: "mode(s) [proxy] are not allowed in namespace default-policy, which allows [csi helper] as set by the controller default",
+		},
+		{
+			name:            "namespace re-enables proxy",
+			allowedModes:    "csi,helper",
+			namespace:       "proxy-enabled",
+			inject:          constants.InjectAnnotationProxy,
+			expectedAllowed: true,
+		},
+		{
+			name:            "namespace annotation replaces the controller default",
+			allowedModes:    "csi,helper",
+			namespace:       "proxy-enabled",
+			inject:          constants.InjectAnnotationHelper,
+			expectedMessage: "mode(s) [helper] are not allowed in namespace proxy-enabled",
+		},
+		{
+			name:            "namespace opts out of all modes",
+			allowedModes:    "csi,helper,proxy",
+			namespace:       "locked",
+			inject:          constants.InjectCSIVolume,
+			expectedMessage: "which allows [] as set by the " + constants.AllowedModesAnnotation + " annotation of namespace locked",
+		},
+		{
+			name:            "controller default allows no modes",
+			allowedModes:    allowedModesNone,
+			namespace:       "default-policy",
+			inject:          constants.InjectCSIVolume,
+			expectedMessage: "mode(s) [csi] are not allowed",
+		},
+		{
+			name:            "invalid namespace annotation",
+			allowedModes:    "csi",
+			namespace:       "invalid",
+			inject:          constants.InjectCSIVolume,
+			expectedMessage: "namespace invalid has an invalid " + constants.AllowedModesAnnotation + " annotation",
+		},
+		{
+			name:         "missing namespace",
+			allowedModes: "csi",
+			namespace:    "missing",
+			inject:       constants.InjectCSIVolume,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh, err := NewSpiffeEnableWebhook(k8sClient, testr.New(t), admission.NewDecoder(scheme),
+				WithAllowedModes(tt.allowedModes))
+			require.NoError(t, err)
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-pod",
+					Annotations: map[string]string{constants.InjectAnnotation: tt.inject},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+			}
+			req, _ := newAdmissionRequest(t, pod)
+			req.Namespace = tt.namespace
+
+			resp := wh.Handle(context.Background(), req)
+			assert.Equal(t, tt.expectedAllowed, resp.Allowed, "result: %v", resp.Result)
+			if tt.expectedAllowed {
+				assert.NotEmpty(t, resp.Patches)
+				return
+			}
+			require.NotNil(t, resp.Result)
+			if tt.expectedMessage != "" {
+				assert.Equal(t, int32(http.StatusForbidden), resp.Result.Code)
+				assert.Contains(t, resp.Result.Message, tt.expectedMessage)
+			}
+		})
+	}
+}
+
+func TestNewSpiffeEnableWebhook_ModePolicy(t *testing.T) {
+	for _, modes := range []string{"csi,helper,proxy", "proxy", allowedModesNone} {
+		_, err := NewSpiffeEnableWebhook(nil, testr.New(t), nil, WithAllowedModes(modes))
+		assert.NoError(t, err, modes)
+	}
+	for _, modes := range []string{"mesh", "csi,mesh", ","} {
+		_, err := NewSpiffeEnableWebhook(nil, testr.New(t), nil, WithAllowedModes(modes))
+		assert.Error(t, err, modes)
+	}
+}
+
+func TestSpiffeEnableWebhook_ContainerNameCollisions(t *testing.T) {
+	tests := []struct {
+		name            string
+		deny            bool
+		annotations     map[string]string
+		expectedAllowed bool
+		expectedWarning bool
+	}{
+		{
+			name:            "user container collides",
+			annotations:     map[string]string{constants.InjectAnnotation: constants.InjectAnnotationHelper},
+			expectedAllowed: true,
+			expectedWarning: true,
+		},
+		{
+			name:            "user container collides, denied",
+			deny:            true,
+			annotations:     map[string]string{constants.InjectAnnotation: constants.InjectAnnotationHelper},
+			expectedAllowed: false,
+		},
+		{
+			name: "previously injected container",
+			deny: true,
+			annotations: map[string]string{
+				constants.InjectAnnotation:     constants.InjectAnnotationHelper,
+				constants.InjectedByAnnotation: "v0.1.0",
+			},
+			expectedAllowed: true,
+		},
+		{
+			name:            "component not requested",
+			deny:            true,
+			annotations:     map[string]string{constants.InjectAnnotation: constants.InjectAnnotationProxy},
+			expectedAllowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := newTestWebhook(t, WithDenyContainerNameCollisions(tt.deny))
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-pod",
+					Namespace:   "default",
+					Annotations: tt.annotations,
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{
+					{Name: "app-container", Image: "nginx"},
+					{Name: helper.SPIFFEHelperSidecarContainerName, Image: "example.org/my-helper"},
+				}},
+			}
+			req, _ := newAdmissionRequest(t, pod)
+
+			resp := wh.Handle(context.Background(), req)
+			assert.Equal(t, tt.expectedAllowed, resp.Allowed)
+			if !tt.expectedAllowed {
+				assert.Contains(t, resp.Result.Message, helper.SPIFFEHelperSidecarContainerName)
+			}
+
+			var collisionWarnings []string
+			for _, warning := range resp.Warnings {
+				if strings.Contains(warning, "same name as injected containers") {
+					collisionWarnings = append(collisionWarnings, warning)
+				}
+			}
+			if tt.expectedWarning {
+				require.Len(t, collisionWarnings, 1)
+				assert.Contains(t, collisionWarnings[0], helper.SPIFFEHelperSidecarContainerName)
+			} else {
+				assert.Empty(t, collisionWarnings)
+			}
+		})
+	}
+}
+
+func TestSpiffeEnableWebhook_RequireSidecarResources(t *testing.T) {
+	tests := []struct {
+		name            string
+		require         bool
+		annotations     map[string]string
+		expectedAllowed bool
+		expectedMissing []string
+	}{
+		{
+			name:            "not required",
+			annotations:     map[string]string{constants.InjectAnnotation: "helper,proxy"},
+			expectedAllowed: true,
+		},
+		{
+			name:            "required and missing",
+			require:         true,
+			annotations:     map[string]string{constants.InjectAnnotation: "helper,proxy"},
+			expectedAllowed: false,
+			expectedMissing: []string{helper.SPIFFEHelperResourcesAnnotation, constants.ProxyResourcesAnnotation},
+		},
+		{
+			name:    "required and missing for one sidecar",
+			require: true,
+			annotations: map[string]string{
+				constants.InjectAnnotation:             "helper,proxy",
+				helper.SPIFFEHelperResourcesAnnotation: "cpu=10m,memory=32Mi",
+			},
+			expectedAllowed: false,
+			expectedMissing: []string{constants.ProxySizeAnnotation},
+		},
+		{
+			name:    "required and provided",
+			require: true,
+			annotations: map[string]string{
+				constants.InjectAnnotation:             "helper,proxy",
+				helper.SPIFFEHelperResourcesAnnotation: "cpu=10m,memory=32Mi",
+				constants.ProxySizeAnnotation:          "small",
+			},
+			expectedAllowed: true,
+		},
+		{
+			name:    "required and provided explicitly",
+			require: true,
+			annotations: map[string]string{
+				constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+				constants.ProxyResourcesAnnotation: "{}",
+			},
+			expectedAllowed: true,
+		},
+		{
+			name:            "required without sidecars",
+			require:         true,
+			annotations:     map[string]string{constants.InjectAnnotation: constants.InjectCSIVolume},
+			expectedAllowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := newTestWebhook(t, WithRequireSidecarResources(tt.require))
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-pod",
+					Namespace:   "default",
+					Annotations: tt.annotations,
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+			}
+			req, _ := newAdmissionRequest(t, pod)
+
+			resp := wh.Handle(context.Background(), req)
+			assert.Equal(t, tt.expectedAllowed, resp.Allowed)
+			if tt.expectedAllowed {
+				assert.NotEmpty(t, resp.Patches)
+				return
+			}
+			require.NotNil(t, resp.Result)
+			assert.Equal(t, int32(http.StatusForbidden), resp.Result.Code)
+			for _, annotation := range tt.expectedMissing {
+				assert.Contains(t, resp.Result.Message, annotation)
+			}
+			assert.Empty(t, resp.Patches)
+		})
+	}
+}
+
+func TestParseInjectModes(t *testing.T) {
+	tests := []struct {
+		name               string
+		value              string
+		expectedModes      []string
+		expectedDuplicates []string
+		expectedInvalid    []string
+	}{
+		{
+			name:          "single mode",
+			value:         "proxy",
+			expectedModes: []string{constants.InjectAnnotationProxy},
+		},
+		{
+			name:          "out of order",
+			value:         "proxy, helper,csi",
+			expectedModes: []string{constants.InjectCSIVolume, constants.InjectAnnotationHelper, constants.InjectAnnotationProxy},
+		},
+		{
+			name:               "duplicates",
+			value:              "helper,helper,proxy,helper, proxy",
+			expectedModes:      []string{constants.InjectAnnotationHelper, constants.InjectAnnotationProxy},
+			expectedDuplicates: []string{constants.InjectAnnotationHelper, constants.InjectAnnotationProxy},
+		},
+		{
+			name:            "invalid",
+			value:           "helper,sidecar,,",
+			expectedModes:   []string{constants.InjectAnnotationHelper},
+			expectedInvalid: []string{"sidecar"},
+		},
+		{
+			name:  "empty",
+			value: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modes, duplicates, invalid := parseInjectModes(tt.value)
+			assert.Equal(t, tt.expectedModes, modes)
+			assert.Equal(t, tt.expectedDuplicates, duplicates)
+			assert.Equal(t, tt.expectedInvalid, invalid)
+		})
+	}
+}
+
+func TestSpiffeEnableWebhook_InjectOrder(t *testing.T) {
+	wh := newTestWebhook(t)
+
+	// mutate runs the webhook on a pod with the given inject annotation and returns the mutated pod
+	mutate := func(t *testing.T, inject string) (*corev1.Pod, []string) {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-pod",
+				Namespace:   "default",
+				Annotations: map[string]string{constants.InjectAnnotation: inject},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+		}
+		req, rawPod := newAdmissionRequest(t, pod)
+		wh.now = func() time.Time { return time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC) }
+
+		resp := wh.Handle(context.Background(), req)
+		require.True(t, resp.Allowed)
+
+		patchBytes, err := json.Marshal(resp.Patches)
+		require.NoError(t, err)
+		patch, err := jsonpatch.DecodePatch(patchBytes)
+		require.NoError(t, err)
+		mutatedRaw, err := patch.Apply(rawPod)
+		require.NoError(t, err)
+
+		mutatedPod := &corev1.Pod{}
+		require.NoError(t, json.Unmarshal(mutatedRaw, mutatedPod))
+		// The annotation itself differs between the pods
+		delete(mutatedPod.Annotations, constants.InjectAnnotation)
+		return mutatedPod, resp.Warnings
+	}
+
+	expected, warnings := mutate(t, "helper,proxy")
+	assert.Empty(t, warnings)
+
+	t.Run("out of order", func(t *testing.T) {
+		actual, warnings := mutate(t, "proxy,helper")
+		assert.Equal(t, expected, actual)
+		assert.Empty(t, warnings)
+	})
+
+	t.Run("duplicates", func(t *testing.T) {
+		actual, warnings := mutate(t, "proxy,helper,helper,proxy")
+		assert.Equal(t, expected, actual)
+		require.Len(t, warnings, 1)
+		assert.Contains(t, warnings[0], "more than once")
+	})
+}
+
+func TestSpiffeEnableWebhook_InitContainers(t *testing.T) {
+	wh := newTestWebhook(t)
+
+	newPod := func(annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default", Annotations: annotations},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "migrate", Image: "migrate"}},
+				Containers:     []corev1.Container{{Name: "app-container", Image: "nginx"}},
+			},
+		}
+	}
+
+	mutate := func(t *testing.T, pod *corev1.Pod) *corev1.Pod {
+		t.Helper()
+		req, rawPod := newAdmissionRequest(t, pod)
+		resp := wh.Handle(context.Background(), req)
+		require.True(t, resp.Allowed)
+
+		patchBytes, err := json.Marshal(resp.Patches)
+		require.NoError(t, err)
+		patch, err := jsonpatch.DecodePatch(patchBytes)
+		require.NoError(t, err)
+		mutatedJSON, err := patch.Apply(rawPod)
+		require.NoError(t, err)
+		var mutated corev1.Pod
+		require.NoError(t, json.Unmarshal(mutatedJSON, &mutated))
+		return &mutated
+	}
+
+	// hasSocket returns whether the named init container has the Workload API socket
+	hasSocket := func(t *testing.T, pod *corev1.Pod, name string) bool {
+		t.Helper()
+		for _, container := range pod.Spec.InitContainers {
+			if container.Name != name {
+				continue
+			}
+			hasMount := slices.ContainsFunc(container.VolumeMounts, func(mount corev1.VolumeMount) bool {
+				return mount.Name == constants.SPIFFEWLVolume
+			})
+			hasEnv := slices.ContainsFunc(container.Env, func(env corev1.EnvVar) bool {
+				return env.Name == constants.SPIFFEWLSocketEnvName
+			})
+			assert.Equal(t, hasMount, hasEnv)
+			return hasMount && hasEnv
+		}
+		require.Failf(t, "init container not found", "%s", name)
+		return false
+	}
+
+	t.Run("not injected by default", func(t *testing.T) {
+		mutated := mutate(t, newPod(map[string]string{constants.InjectAnnotation: constants.InjectCSIVolume}))
+		assert.False(t, hasSocket(t, mutated, "migrate"))
+	})
+
+	t.Run("injected when requested", func(t *testing.T) {
+		mutated := mutate(t, newPod(map[string]string{
+			constants.InjectAnnotation:               constants.InjectCSIVolume,
+			constants.InjectInitContainersAnnotation: "true",
+		}))
+		assert.True(t, hasSocket(t, mutated, "migrate"))
+	})
+
+	t.Run("injected init containers are skipped", func(t *testing.T) {
+		mutated := mutate(t, newPod(map[string]string{
+			constants.InjectAnnotation:               constants.InjectAnnotationHelper + "," + constants.InjectAnnotationProxy,
+			constants.InjectInitContainersAnnotation: "true",
+		}))
+		assert.True(t, hasSocket(t, mutated, "migrate"))
+		assert.False(t, hasSocket(t, mutated, helper.SPIFFEHelperInitContainerName))
+		assert.False(t, hasSocket(t, mutated, proxy.EnvoyConfigInitContainerName))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		req, _ := newAdmissionRequest(t, newPod(map[string]string{
+			constants.InjectAnnotation:               constants.InjectCSIVolume,
+			constants.InjectInitContainersAnnotation: "yes",
+		}))
+		resp := wh.Handle(context.Background(), req)
+		assert.False(t, resp.Allowed)
+		require.NotNil(t, resp.Result)
+		assert.Equal(t, int32(http.StatusBadRequest), resp.Result.Code)
+		assert.Contains(t, resp.Result.Message, constants.InjectInitContainersAnnotation)
+	})
+}
+
+func TestSpiffeEnableWebhook_TargetContainers(t *testing.T) {
+	wh := newTestWebhook(t)
+
+	newPod := func(annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default", Annotations: annotations},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{Name: "app-container", Image: "nginx"},
+					{Name: "log-agent", Image: "fluent-bit"},
+					{Name: "metrics-agent", Image: "statsd"},
+				},
+			},
+		}
+	}
+
+	handle := func(t *testing.T, pod *corev1.Pod) (admission.Response, *corev1.Pod) {
+		t.Helper()
+		req, rawPod := newAdmissionRequest(t, pod)
+		resp := wh.Handle(context.Background(), req)
+		if !resp.Allowed {
+			return resp, nil
+		}
+
+		patchBytes, err := json.Marshal(resp.Patches)
+		require.NoError(t, err)
+		patch, err := jsonpatch.DecodePatch(patchBytes)
+		require.NoError(t, err)
+		mutatedJSON, err := patch.Apply(rawPod)
+		require.NoError(t, err)
+		var mutated corev1.Pod
+		require.NoError(t, json.Unmarshal(mutatedJSON, &mutated))
+		return resp, &mutated
+	}
+
+	// hasSocket returns whether the named container has the Workload API socket
+	hasSocket := func(t *testing.T, pod *corev1.Pod, name string) bool {
+		t.Helper()
+		for _, container := range pod.Spec.Containers {
+			if container.Name == name {
+				hasMount := slices.Contains(container.VolumeMounts, workload.GetSPIFFEVolumeMount())
+				hasEnv := workload.EnvVarExists(&container, constants.SPIFFEWLSocketEnvName)
+				assert.Equal(t, hasMount, hasEnv)
+				return hasMount && hasEnv
+			}
+		}
+		require.Failf(t, "container not found", "%s", name)
+		return false
+	}
+
+	t.Run("all containers by default", func(t *testing.T) {
+		_, mutated := handle(t, newPod(map[string]string{constants.InjectAnnotation: constants.InjectCSIVolume}))
+		require.NotNil(t, mutated)
+		for _, name := range []string{"app-container", "log-agent", "metrics-agent"} {
+			assert.True(t, hasSocket(t, mutated, name), name)
+		}
+	})
+
+	t.Run("only the target container", func(t *testing.T) {
+		_, mutated := handle(t, newPod(map[string]string{
+			constants.InjectAnnotation:           constants.InjectCSIVolume,
+			constants.TargetContainersAnnotation: "app-container",
+		}))
+		require.NotNil(t, mutated)
+		assert.True(t, hasSocket(t, mutated, "app-container"))
+		assert.False(t, hasSocket(t, mutated, "log-agent"))
+		assert.False(t, hasSocket(t, mutated, "metrics-agent"))
+		assert.Empty(t, mutated.Spec.Containers[1].VolumeMounts)
+		assert.Empty(t, mutated.Spec.Containers[2].Env)
+	})
+
+	t.Run("extra env and env sources only for the target containers", func(t *testing.T) {
+		_, mutated := handle(t, newPod(map[string]string{
+			constants.InjectAnnotation:           constants.InjectCSIVolume,
+			constants.TargetContainersAnnotation: "app-container",
+			constants.ExtraEnvAnnotation:         "EXTRA=value",
+			constants.EnvFromAnnotation:          "configmap:spiffe-config",
+		}))
+		require.NotNil(t, mutated)
+		assert.True(t, workload.EnvVarExists(&mutated.Spec.Containers[0], "EXTRA"))
+		assert.Len(t, mutated.Spec.Containers[0].EnvFrom, 1)
+		for _, c := range mutated.Spec.Containers[1:] {
+			assert.False(t, workload.EnvVarExists(&c, "EXTRA"), c.Name)
+			assert.Empty(t, c.EnvFrom, c.Name)
+		}
+	})
+
+	t.Run("injected sidecars keep the socket", func(t *testing.T) {
+		_, mutated := handle(t, newPod(map[string]string{
+			constants.InjectAnnotation:           constants.InjectAnnotationProxy,
+			constants.SidecarModeAnnotation:      constants.SidecarModeRegular,
+			constants.TargetContainersAnnotation: "app-container,metrics-agent",
+		}))
+		require.NotNil(t, mutated)
+		assert.True(t, hasSocket(t, mutated, "app-container"))
+		assert.False(t, hasSocket(t, mutated, "log-agent"))
+		assert.True(t, hasSocket(t, mutated, "metrics-agent"))
+		envoy := mutated.Spec.Containers[slices.IndexFunc(mutated.Spec.Containers, func(c corev1.Container) bool {
+			return c.Name == proxy.EnvoySidecarContainerName
+		})]
+		assert.Contains(t, envoy.VolumeMounts, workload.GetSPIFFEVolumeMount())
+	})
+
+	for name, value := range map[string]string{
+		"unknown container":   "app-container,missing",
+		"duplicate container": "app-container,app-container",
+		"empty container":     "app-container,",
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp, _ := handle(t, newPod(map[string]string{
+				constants.InjectAnnotation:           constants.InjectCSIVolume,
+				constants.TargetContainersAnnotation: value,
+			}))
+			assert.False(t, resp.Allowed)
+			assert.Equal(t, int32(http.StatusBadRequest), resp.Result.Code)
+		})
+	}
+}
+
+func TestSpiffeEnableWebhook_ExistingCertVolume(t *testing.T) {
+	wh := newTestWebhook(t)
+
+	newPod := func(mount corev1.VolumeMount) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-pod",
+				Namespace:   "default",
+				Annotations: map[string]string{constants.InjectAnnotation: constants.InjectAnnotationHelper},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app-container", Image: "nginx", VolumeMounts: []corev1.VolumeMount{mount}}},
+				Volumes: []corev1.Volume{{
+					Name:         "app-certs",
+					VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+				}},
+			},
+		}
+	}
+
+	mutate := func(t *testing.T, pod *corev1.Pod) *corev1.Pod {
+		t.Helper()
+		req, rawPod := newAdmissionRequest(t, pod)
+		resp := wh.Handle(context.Background(), req)
+		require.True(t, resp.Allowed)
+
+		patchBytes, err := json.Marshal(resp.Patches)
+		require.NoError(t, err)
+		patch, err := jsonpatch.DecodePatch(patchBytes)
+		require.NoError(t, err)
+		mutatedJSON, err := patch.Apply(rawPod)
+		require.NoError(t, err)
+		var mutated corev1.Pod
+		require.NoError(t, json.Unmarshal(mutatedJSON, &mutated))
+		return &mutated
+	}
+
+	// certVolume returns the volume the named init container mounts at the cert directory
+	certVolume := func(t *testing.T, pod *corev1.Pod, name string) string {
+		t.Helper()
+		for _, container := range pod.Spec.InitContainers {
+			if container.Name != name {
+				continue
+			}
+			for _, mount := range container.VolumeMounts {
+				if mount.MountPath == constants.SPIFFEEnableCertDirectory {
+					return mount.Name
+				}
+			}
+		}
+		require.Failf(t, "cert directory mount not found", "%s", name)
+		return ""
+	}
+
+	t.Run("reused", func(t *testing.T) {
+		mutated := mutate(t, newPod(corev1.VolumeMount{Name: "app-certs", MountPath: constants.SPIFFEEnableCertDirectory}))
+		assert.Equal(t, "app-certs", certVolume(t, mutated, helper.SPIFFEHelperSidecarContainerName))
+		assert.Equal(t, "app-certs", certVolume(t, mutated, helper.SPIFFEHelperInitContainerName))
+		assert.False(t, workload.VolumeExists(mutated, constants.SPIFFEEnableCertVolumeName))
+		assert.NotContains(t, mutated.Spec.Containers[0].VolumeMounts,
+			corev1.VolumeMount{Name: constants.SPIFFEEnableCertVolumeName, MountPath: constants.SPIFFEEnableCertDirectory})
+	})
+
+	t.Run("subPath not reused", func(t *testing.T) {
+		mutated := mutate(t, newPod(corev1.VolumeMount{
+			Name: "app-certs", MountPath: constants.SPIFFEEnableCertDirectory, SubPath: "certs",
+		}))
+		assert.Equal(t, constants.SPIFFEEnableCertVolumeName, certVolume(t, mutated, helper.SPIFFEHelperSidecarContainerName))
+		assert.True(t, workload.VolumeExists(mutated, constants.SPIFFEEnableCertVolumeName))
+	})
+
+	t.Run("reused by cert paths", func(t *testing.T) {
+		pod := newPod(corev1.VolumeMount{Name: "app-certs", MountPath: constants.SPIFFEEnableCertDirectory})
+		pod.Annotations[helper.SPIFFEHelperCertPathsAnnotation] = "app-container=/etc/certs"
+		mutated := mutate(t, pod)
+		assert.Contains(t, mutated.Spec.Containers[0].VolumeMounts,
+			corev1.VolumeMount{Name: "app-certs", MountPath: "/etc/certs", ReadOnly: true})
+	})
+}
+
+func TestSpiffeEnableWebhook_AutoInject(t *testing.T) {
+	tests := []struct {
+		name               string
+		prefixes           []string
+		serviceAccounts    []string
+		mode               string
+		annotations        map[string]string
+		initImage          string
+		image              string
+		serviceAccountName string
+		expectedInject     string
+		expectPatched      bool
+	}{
+		{
+			name:           "matching image",
+			prefixes:       []string{"registry.example.com/"},
+			image:          "registry.example.com/app:v1",
+			expectedInject: constants.InjectCSIVolume,
+			expectPatched:  true,
+		},
+		{
+			name:           "matching init container image",
+			prefixes:       []string{"other.example.com/", "registry.example.com/"},
+			mode:           "csi,helper",
+			initImage:      "registry.example.com/migrate:v1",
+			image:          "nginx",
+			expectedInject: "csi,helper",
+			expectPatched:  true,
+		},
+		{
+			name:     "non-matching image",
+			prefixes: []string{"registry.example.com/"},
+			image:    "registry.example.org/app:v1",
+		},
+		{
+			name:  "disabled",
+			image: "registry.example.com/app:v1",
+		},
+		{
+			name:           "explicit annotation takes precedence",
+			prefixes:       []string{"registry.example.com/"},
+			annotations:    map[string]string{constants.InjectAnnotation: ""},
+			image:          "registry.example.com/app:v1",
+			expectedInject: "",
+		},
+		{
+			name:               "matching service account",
+			serviceAccounts:    []string{"other/mesh-enabled", "default/mesh-enabled"},
+			mode:               "csi,helper",
+			image:              "nginx",
+			serviceAccountName: "mesh-enabled",
+			expectedInject:     "csi,helper",
+			expectPatched:      true,
+		},
+		{
+			name:            "matching default service account",
+			serviceAccounts: []string{"default/default"},
+			image:           "nginx",
+			expectedInject:  constants.InjectCSIVolume,
+			expectPatched:   true,
+		},
+		{
+			name:               "service account in another namespace",
+			serviceAccounts:    []string{"other/mesh-enabled"},
+			image:              "nginx",
+			serviceAccountName: "mesh-enabled",
+		},
+		{
+			name:               "non-matching service account",
+			serviceAccounts:    []string{"default/mesh-enabled"},
+			image:              "nginx",
+			serviceAccountName: "app",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{WithAutoInjectImagePrefixes(tt.prefixes), WithAutoInjectServiceAccounts(tt.serviceAccounts)}
+			if tt.mode != "" {
+				opts = append(opts, WithAutoInjectMode(tt.mode))
+			}
+			wh := newTestWebhook(t, opts...)
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-pod",
+					Namespace:   "default",
+					Annotations: tt.annotations,
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: tt.serviceAccountName,
+					Containers:         []corev1.Container{{Name: "app-container", Image: tt.image}},
+				},
+			}
+			if tt.initImage != "" {
+				pod.Spec.InitContainers = []corev1.Container{{Name: "init", Image: tt.initImage}}
+			}
+			req, rawPod := newAdmissionRequest(t, pod)
+			req.Namespace = pod.Namespace
+
+			resp := wh.Handle(context.Background(), req)
+			require.True(t, resp.Allowed)
+			if !tt.expectPatched {
+				assert.Empty(t, resp.Patches)
+				return
+			}
+			require.NotEmpty(t, resp.Patches)
+
+			patchBytes, err := json.Marshal(resp.Patches)
+			require.NoError(t, err)
+			patch, err := jsonpatch.DecodePatch(patchBytes)
+			require.NoError(t, err)
+			mutatedRaw, err := patch.Apply(rawPod)
+			require.NoError(t, err)
+			mutatedPod := &corev1.Pod{}
+			require.NoError(t, json.Unmarshal(mutatedRaw, mutatedPod))
+
+			assert.Equal(t, tt.expectedInject, mutatedPod.Annotations[constants.InjectAnnotation])
+			assert.True(t, workload.VolumeExists(mutatedPod, constants.SPIFFEWLVolume))
+		})
+	}
+}
+
+func TestNewSpiffeEnableWebhook_AutoInject(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{
+			name: "empty mode",
+			opts: []Option{WithAutoInjectImagePrefixes([]string{"registry.example.com/"}), WithAutoInjectMode("")},
+		},
+		{
+			name: "invalid mode",
+			opts: []Option{WithAutoInjectImagePrefixes([]string{"registry.example.com/"}), WithAutoInjectMode("csi,sidecar")},
+		},
+		{
+			name: "invalid mode for service accounts",
+			opts: []Option{WithAutoInjectServiceAccounts([]string{"default/app"}), WithAutoInjectMode("sidecar")},
+		},
+		{
+			name: "service account without namespace",
+			opts: []Option{WithAutoInjectServiceAccounts([]string{"app"})},
+		},
+		{
+			name: "service account with empty name",
+			opts: []Option{WithAutoInjectServiceAccounts([]string{"default/"})},
+		},
+		{
+			name: "service account with extra separator",
+			opts: []Option{WithAutoInjectServiceAccounts([]string{"default/app/extra"})},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSpiffeEnableWebhook(nil, testr.New(t), nil, tt.opts...)
+			assert.Error(t, err)
+		})
+	}
+
+	// The mode isn't used, so isn't checked, without auto-injection
+	_, err := NewSpiffeEnableWebhook(nil, testr.New(t), nil, WithAutoInjectMode(""))
+	assert.NoError(t, err)
+}
+
+func TestSpiffeEnableWebhook_SizeLimits(t *testing.T) {
+	largeValue := strings.Repeat("x", 200*1024)
+
+	tests := []struct {
+		name                    string
+		opts                    []Option
+		annotations             map[string]string
+		env                     []corev1.EnvVar
+		expectedAllowed         bool
+		expectedMessageContains []string
+	}{
+		{
+			name: "proxy config within the default limits",
+			annotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationProxy,
+			},
+			expectedAllowed: true,
+		},
+		{
+			name: "proxy config over the env var limit",
+			opts: []Option{WithMaxEnvVarSize(1024)},
+			annotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationProxy,
+			},
+			expectedMessageContains: []string{
+				proxy.EnvoyConfigContentEnvVar, proxy.EnvoyConfigInitContainerName, "more than the limit of 1024 bytes",
+			},
+		},
+		{
+			name: "large extra env var over the default limit",
+			annotations: map[string]string{
+				constants.InjectAnnotation:   constants.InjectCSIVolume,
+				constants.ExtraEnvAnnotation: "LARGE=" + largeValue,
+			},
+			expectedMessageContains: []string{"LARGE", "app-container", "more than the limit of 131072 bytes"},
+		},
+		{
+			name: "existing large env var is not checked",
+			annotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectCSIVolume,
+			},
+			env:             []corev1.EnvVar{{Name: "LARGE", Value: largeValue}},
+			expectedAllowed: true,
+		},
+		{
+			name: "annotations over the limit",
+			opts: []Option{WithMaxAnnotationsSize(1024)},
+			annotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectCSIVolume,
+				"example.com/large":        strings.Repeat("x", 1000),
+			},
+			expectedMessageContains: []string{"pod annotations are", "more than the limit of 1024 bytes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := newTestWebhook(t, tt.opts...)
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default", Annotations: tt.annotations},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app-container", Image: "nginx", Env: tt.env}},
+				},
+			}
+			req, _ := newAdmissionRequest(t, pod)
+
+			resp := wh.Handle(context.Background(), req)
+			assert.Equal(t, tt.expectedAllowed, resp.Allowed)
+			if tt.expectedAllowed {
+				return
+			}
+			require.NotNil(t, resp.Result)
+			assert.Equal(t, int32(http.StatusBadRequest), resp.Result.Code)
+			for _, substr := range tt.expectedMessageContains {
+				assert.Contains(t, resp.Result.Message, substr)
+			}
+		})
+	}
+}
+
+func TestNewSpiffeEnableWebhook_SizeLimits(t *testing.T) {
+	_, err := NewSpiffeEnableWebhook(nil, testr.New(t), nil, WithMaxEnvVarSize(0))
+	assert.Error(t, err)
+	_, err = NewSpiffeEnableWebhook(nil, testr.New(t), nil, WithMaxAnnotationsSize(-1))
+	assert.Error(t, err)
+}
+
+func TestSpiffeEnableWebhook_DebugLog(t *testing.T) {
+	t.Setenv(constants.EnvVarXDSToken, "xds-secret-token")
+
+	for _, verbosity := range []int{0, debugLogLevel} {
+		t.Run(fmt.Sprintf("verbosity=%d", verbosity), func(t *testing.T) {
+			var logs []string
+			logger := funcr.New(func(prefix, args string) {
+				logs = append(logs, args)
+			}, funcr.Options{Verbosity: verbosity})
+
+			scheme := runtime.NewScheme()
+			require.NoError(t, corev1.AddToScheme(scheme))
+			wh, err := NewSpiffeEnableWebhook(fake.NewClientBuilder().WithScheme(scheme).Build(), logger, admission.NewDecoder(scheme))
+			require.NoError(t, err)
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-pod",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.InjectAnnotation:   constants.InjectAnnotationProxy + "," + constants.InjectAnnotationHelper,
+						constants.ExtraEnvAnnotation: "EXTRA_API_KEY=extra-secret-key",
+					},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:  "app-container",
+					Image: "nginx",
+					Env:   []corev1.EnvVar{{Name: "DB_PASSWORD", Value: "db-secret-password"}, {Name: "LOG_LEVEL", Value: "info"}},
+				}}},
+			}
+			req, _ := newAdmissionRequest(t, pod)
+			resp := wh.Handle(context.Background(), req)
+			require.True(t, resp.Allowed)
+
+			var podLog string
+			for _, line := range logs {
+				if strings.Contains(line, `"msg"="Mutated pod"`) {
+					podLog = line
+				}
+			}
+			if verbosity < debugLogLevel {
+				assert.Empty(t, podLog)
+				return
+			}
+			require.NotEmpty(t, podLog)
+
+			for _, name := range []string{
+				proxy.EnvoySidecarContainerName, proxy.EnvoyConfigInitContainerName,
+				helper.SPIFFEHelperSidecarContainerName, helper.SPIFFEHelperInitContainerName,
+			} {
+				assert.Contains(t, podLog, name)
+			}
+			assert.Contains(t, podLog, "LOG_LEVEL")
+			assert.Contains(t, podLog, redactedValue)
+			for _, secret := range []string{"xds-secret-token", "extra-secret-key", "db-secret-password"} {
+				assert.NotContains(t, podLog, secret)
+			}
+		})
+	}
+}
+
+func TestSpiffeEnableWebhook_DryRun(t *testing.T) {
+	t.Setenv(constants.EnvVarXDSToken, "xds-secret-token")
+
+	tests := []struct {
+		name             string
+		annotations      map[string]string
+		expectedLog      string
+		expectedWarnings []string
+		validateLog      func(t *testing.T, log string)
+	}{
+		{
+			name: "injection",
+			annotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationProxy + "," + constants.InjectAnnotationHelper,
+				constants.DryRunAnnotation: "true",
+			},
+			expectedLog: `"msg"="Dry run, pod not mutated"`,
+			validateLog: func(t *testing.T, log string) {
+				for _, name := range []string{
+					proxy.EnvoySidecarContainerName, proxy.EnvoyConfigInitContainerName,
+					helper.SPIFFEHelperSidecarContainerName, helper.SPIFFEHelperInitContainerName,
+				} {
+					assert.Contains(t, log, name)
+				}
+				assert.Contains(t, log, redactedValue)
+				assert.NotContains(t, log, "xds-secret-token")
+			},
+		},
+		{
+			name: "rejected pod",
+			annotations: map[string]string{
+				constants.InjectAnnotation: "invalid",
+				constants.DryRunAnnotation: "true",
+			},
+			expectedLog:      `"msg"="Dry run, pod would have been rejected"`,
+			expectedWarnings: []string{"dry run: the pod would have been rejected"},
+			validateLog: func(t *testing.T, log string) {
+				assert.Contains(t, log, "invalid mode(s) found in injection list")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs []string
+			logger := funcr.New(func(prefix, args string) {
+				logs = append(logs, args)
+			}, funcr.Options{})
+
+			scheme := runtime.NewScheme()
+			require.NoError(t, corev1.AddToScheme(scheme))
+			wh, err := NewSpiffeEnableWebhook(fake.NewClientBuilder().WithScheme(scheme).Build(), logger, admission.NewDecoder(scheme))
+			require.NoError(t, err)
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-pod",
+					Namespace:   "default",
+					Annotations: tt.annotations,
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+			}
+			req, _ := newAdmissionRequest(t, pod)
+			resp := wh.Handle(context.Background(), req)
+
+			require.True(t, resp.Allowed)
+			assert.Empty(t, resp.Patches)
+			assert.Nil(t, resp.PatchType)
+			for _, warning := range tt.expectedWarnings {
+				assert.True(t, slices.ContainsFunc(resp.Warnings, func(w string) bool {
+					return strings.Contains(w, warning)
+				}), "expected warning containing %q in %v", warning, resp.Warnings)
+			}
+
+			var dryRunLog string
+			for _, line := range logs {
+				if strings.Contains(line, tt.expectedLog) {
+					dryRunLog = line
+				}
+			}
+			require.NotEmpty(t, dryRunLog)
+			tt.validateLog(t, dryRunLog)
+		})
+	}
+}
+
+func TestSpiffeEnableWebhook_Metrics(t *testing.T) {
+	webhook := newTestWebhook(t, WithSkipOwnerKinds([]string{"Job"}))
+
+	handle := func(annotations map[string]string, modify func(*corev1.Pod)) admission.Response {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default", Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+		}
+		if modify != nil {
+			modify(pod)
+		}
+		req, _ := newAdmissionRequest(t, pod)
+		return webhook.Handle(context.Background(), req)
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		modify      func(*corev1.Pod)
+		allowed     bool
+		counters    []prometheus.Counter
+	}{
+		{
+			name: "inject",
+			annotations: map[string]string{
+				constants.InjectAnnotation: constants.InjectAnnotationProxy + "," + constants.InjectAnnotationHelper,
+			},
+			allowed: true,
+			counters: []prometheus.Counter{
+				injectionsTotal.WithLabelValues(constants.InjectAnnotationProxy),
+				injectionsTotal.WithLabelValues(constants.InjectAnnotationHelper),
+			},
+		},
+		{
+			name:     "allow without injection",
+			allowed:  true,
+			counters: []prometheus.Counter{skippedTotal.WithLabelValues(skipReasonNotRequested)},
+		},
+		{
+			name:        "skip excluded owner",
+			annotations: map[string]string{constants.InjectAnnotation: constants.InjectAnnotationProxy},
+			modify: func(pod *corev1.Pod) {
+				pod.OwnerReferences = []metav1.OwnerReference{{Kind: "Job", Name: "job"}}
+			},
+			allowed:  true,
+			counters: []prometheus.Counter{skippedTotal.WithLabelValues(skipReasonOwnerKind)},
+		},
+		{
+			name:        "deny host network",
+			annotations: map[string]string{constants.InjectAnnotation: constants.InjectAnnotationProxy},
+			modify: func(pod *corev1.Pod) {
+				pod.Spec.HostNetwork = true
+			},
+			counters: []prometheus.Counter{deniedTotal.WithLabelValues(denyReasonHostNetwork)},
+		},
+		{
+			name:        "reject invalid annotation",
+			annotations: map[string]string{constants.InjectAnnotation: "invalid"},
+			counters:    []prometheus.Counter{deniedTotal.WithLabelValues(denyReasonInvalidRequest)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := make([]float64, len(tt.counters))
+			for i, counter := range tt.counters {
+				before[i] = testutil.ToFloat64(counter)
+			}
+			histogramBefore := histogramSampleCount(t)
+
+			resp := handle(tt.annotations, tt.modify)
+			assert.Equal(t, tt.allowed, resp.Allowed)
+
+			for i, counter := range tt.counters {
+				assert.Equal(t, before[i]+1, testutil.ToFloat64(counter), "counter %d", i)
+			}
+			assert.Equal(t, histogramBefore+1, histogramSampleCount(t))
+		})
+	}
+}
+
+func histogramSampleCount(t *testing.T) uint64 {
+	metric := &dto.Metric{}
+	require.NoError(t, handleDuration.Write(metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestSpiffeEnableWebhook_AgentXDSCheck(t *testing.T) {
+	checkedAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	newPod := func(mode string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-pod",
+				Namespace:   "default",
+				Annotations: map[string]string{constants.InjectAnnotation: mode},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+		}
+	}
+
+	// newWebhook returns a webhook that resolves only the given hosts, and a count of the lookups
+	newWebhook := func(t *testing.T, enabled bool, resolvable ...string) (*spiffeEnableWebhook, *int) {
+		wh := newTestWebhook(t, WithAgentXDSCheck(enabled))
+		wh.now = func() time.Time { return checkedAt }
+		lookups := 0
+		wh.agentXDSCheck.lookupHost = func(_ context.Context, host string) ([]string, error) {
+			lookups++
+			if slices.Contains(resolvable, host) {
+				return []string{"10.0.0.1"}, nil
+			}
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return wh, &lookups
+	}
+
+	handle := func(t *testing.T, wh *spiffeEnableWebhook, mode string) admission.Response {
+		req, _ := newAdmissionRequest(t, newPod(mode))
+		resp := wh.Handle(context.Background(), req)
+		require.True(t, resp.Allowed)
+		require.NotEmpty(t, resp.Patches)
+		return resp
+	}
+
+	t.Run("resolvable", func(t *testing.T) {
+		wh, lookups := newWebhook(t, true, constants.AgentXDSService)
+		resp := handle(t, wh, constants.InjectAnnotationProxy)
+		assert.Empty(t, resp.Warnings)
+		assert.Equal(t, 1, *lookups)
+	})
+
+	t.Run("unresolvable", func(t *testing.T) {
+		wh, lookups := newWebhook(t, true)
+		resp := handle(t, wh, constants.InjectAnnotationProxy)
+		require.Len(t, resp.Warnings, 1)
+		assert.Contains(t, resp.Warnings[0], constants.AgentXDSService)
+		assert.Contains(t, resp.Warnings[0], "doesn't resolve")
+
+		// The result is cached, so the warning is repeated without another lookup
+		resp = handle(t, wh, constants.InjectAnnotationProxy)
+		require.Len(t, resp.Warnings, 1)
+		assert.Equal(t, 1, *lookups)
+
+		// Until it expires
+		wh.now = func() time.Time { return checkedAt.Add(agentXDSCheckTTL) }
+		handle(t, wh, constants.InjectAnnotationProxy)
+		assert.Equal(t, 2, *lookups)
+	})
+
+	t.Run("not injecting the proxy", func(t *testing.T) {
+		wh, lookups := newWebhook(t, true)
+		resp := handle(t, wh, constants.InjectAnnotationHelper)
+		assert.Empty(t, resp.Warnings)
+		assert.Zero(t, *lookups)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		wh, lookups := newWebhook(t, false)
+		resp := handle(t, wh, constants.InjectAnnotationProxy)
+		assert.Empty(t, resp.Warnings)
+		assert.Zero(t, *lookups)
+	})
+}
+
+func TestSpiffeEnableWebhook_EnvoyBaseConfig(t *testing.T) {
+	baseConfigFile := filepath.Join(t.TempDir(), "base.yaml")
+	require.NoError(t, os.WriteFile(baseConfigFile, []byte("overload_manager:\n  refresh_interval: 0.25s\n"), 0o600))
+	t.Setenv(constants.EnvVarEnvoyBaseConfigFile, baseConfigFile)
+	wh := newTestWebhook(t)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-pod",
+			Namespace:   "default",
+			Annotations: map[string]string{constants.InjectAnnotation: constants.InjectAnnotationProxy},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+	}
+	req, rawPod := newAdmissionRequest(t, pod)
+	resp := wh.Handle(context.Background(), req)
+	require.True(t, resp.Allowed)
+
+	patchBytes, err := json.Marshal(resp.Patches)
+	require.NoError(t, err)
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+	require.NoError(t, err)
+	mutatedRaw, err := patch.Apply(rawPod)
+	require.NoError(t, err)
+	mutatedPod := &corev1.Pod{}
+	require.NoError(t, json.Unmarshal(mutatedRaw, mutatedPod))
+
+	idx := slices.IndexFunc(mutatedPod.Spec.InitContainers, func(c corev1.Container) bool {
+		return c.Name == proxy.EnvoyConfigInitContainerName
+	})
+	require.NotEqual(t, -1, idx)
+	var cfg map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(mutatedPod.Spec.InitContainers[idx].Env[0].Value), &cfg))
+	assert.Contains(t, cfg, "overload_manager")
+	assert.Contains(t, cfg, "dynamic_resources")
+
+	t.Run("invalid base config", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(baseConfigFile, []byte("- not an object"), 0o600))
+		_, err := NewSpiffeEnableWebhook(nil, testr.New(t), nil)
+		require.Error(t, err)
+	})
+
+	t.Run("missing base config", func(t *testing.T) {
+		t.Setenv(constants.EnvVarEnvoyBaseConfigFile, filepath.Join(t.TempDir(), "missing"))
+		_, err := NewSpiffeEnableWebhook(nil, testr.New(t), nil)
+		require.Error(t, err)
+	})
+}
+
+func TestSpiffeEnableWebhook_DNSProxy(t *testing.T) {
+	wh := newTestWebhook(t)
+
+	// dnsListenerPorts returns the ports of the DNS proxy listeners in the mutated pod's Envoy config
+	dnsListenerPorts := func(t *testing.T, pod *corev1.Pod) []float64 {
+		req, rawPod := newAdmissionRequest(t, pod)
+		resp := wh.Handle(context.Background(), req)
+		require.True(t, resp.Allowed)
+
+		patchBytes, err := json.Marshal(resp.Patches)
+		require.NoError(t, err)
+		patch, err := jsonpatch.DecodePatch(patchBytes)
+		require.NoError(t, err)
+		mutatedRaw, err := patch.Apply(rawPod)
+		require.NoError(t, err)
+		mutatedPod := &corev1.Pod{}
+		require.NoError(t, json.Unmarshal(mutatedRaw, mutatedPod))
+
+		idx := slices.IndexFunc(mutatedPod.Spec.InitContainers, func(c corev1.Container) bool {
+			return c.Name == proxy.EnvoyConfigInitContainerName
+		})
+		require.NotEqual(t, -1, idx)
+		var cfg struct {
+			StaticResources struct {
+				Listeners []struct {
+					Name    string `json:"name"`
+					Address struct {
+						SocketAddress map[string]interface{} `json:"socket_address"`
+					} `json:"address"`
+				} `json:"listeners"`
+			} `json:"static_resources"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(mutatedPod.Spec.InitContainers[idx].Env[0].Value), &cfg))
+
+		var ports []float64
+		for _, listener := range cfg.StaticResources.Listeners {
+			if strings.HasPrefix(listener.Name, "dns_") {
+				ports = append(ports, listener.Address.SocketAddress["port_value"].(float64))
+			}
+		}
+		return ports
+	}
+
+	newPod := func(annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default", Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+		}
+	}
+
+	t.Run("listener on the DNS proxy port by default", func(t *testing.T) {
+		ports := dnsListenerPorts(t, newPod(map[string]string{constants.InjectAnnotation: constants.InjectAnnotationProxy}))
+		assert.Equal(t, []float64{proxy.DNSProxyPort}, ports)
+	})
+
+	t.Run("no listener without DNS redirection", func(t *testing.T) {
+		pod := newPod(map[string]string{
+			constants.InjectAnnotation:         constants.InjectAnnotationProxy,
+			constants.ProxyCustomDNSAnnotation: constants.ProxyCustomDNSSkip,
+		})
+		pod.Spec.DNSPolicy = corev1.DNSNone
+		pod.Spec.DNSConfig = &corev1.PodDNSConfig{Nameservers: []string{"10.0.0.10"}}
+		assert.Empty(t, dnsListenerPorts(t, pod))
+	})
+}
+
+func TestSpiffeEnableWebhook_ReadyCheck(t *testing.T) {
+	tests := []struct {
+		name          string
+		modify        func(w *spiffeEnableWebhook)
+		expectedError string
+	}{
+		{
+			name:   "valid templates and images",
+			modify: func(*spiffeEnableWebhook) {},
+		},
+		{
+			name: "invalid template",
+			modify: func(w *spiffeEnableWebhook) {
+				w.validateTemplates = func() error { return fmt.Errorf("unexpected EOF") }
+			},
+			expectedError: "invalid templates: unexpected EOF",
+		},
+		{
+			name: "invalid image",
+			modify: func(w *spiffeEnableWebhook) {
+				w.images.Proxy = "Invalid Image"
+			},
+			expectedError: `invalid proxy image "Invalid Image"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhook := newTestWebhook(t)
+			tt.modify(webhook)
+
+			err := webhook.ReadyCheck(&http.Request{})
+			if tt.expectedError == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedError)
+		})
+	}
+}
+
+func TestSpiffeEnableWebhook_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	wh := newTestWebhook(t, WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))))
+
+	handle := func(t *testing.T, annotations map[string]string) map[string]tracetest.SpanStub {
+		t.Helper()
+		exporter.Reset()
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default", Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+		}
+		req, _ := newAdmissionRequest(t, pod)
+		wh.Handle(context.Background(), req)
+
+		spans := map[string]tracetest.SpanStub{}
+		for _, span := range exporter.GetSpans() {
+			// Pod names would make the spans unbounded in cardinality
+			assert.NotContains(t, span.Name, pod.Name)
+			for _, attr := range span.Attributes {
+				assert.NotContains(t, attr.Value.Emit(), pod.Name)
+			}
+			spans[span.Name+"/"+attributeValue(span, attributeMode)] = span
+		}
+		return spans
+	}
+
+	t.Run("injection", func(t *testing.T) {
+		spans := handle(t, map[string]string{
+			constants.InjectAnnotation: constants.InjectAnnotationHelper + "," + constants.InjectAnnotationProxy,
+		})
+		require.Len(t, spans, 5)
+
+		root := spans[spanHandle+"/"]
+		assert.Equal(t, resultInjected, attributeValue(root, attributeResult))
+		assert.Equal(t, "false", attributeValue(root, attributeDryRun))
+		assert.Contains(t, attributeValue(root, attributeModes), constants.InjectAnnotationHelper)
+		assert.Contains(t, attributeValue(root, attributeModes), constants.InjectAnnotationProxy)
+
+		for _, name := range []string{
+			spanDecode + "/",
+			spanDecide + "/",
+			spanGenerateConfig + "/" + constants.InjectAnnotationHelper,
+			spanGenerateConfig + "/" + constants.InjectAnnotationProxy,
+		} {
+			span, ok := spans[name]
+			require.True(t, ok, "missing span %s", name)
+			assert.Equal(t, root.SpanContext.SpanID(), span.Parent.SpanID(), name)
+			assert.Equal(t, codes.Unset, span.Status.Code, name)
+		}
+	})
+
+	t.Run("rejection", func(t *testing.T) {
+		spans := handle(t, map[string]string{constants.InjectAnnotation: "invalid"})
+		require.Len(t, spans, 3)
+
+		root := spans[spanHandle+"/"]
+		assert.Equal(t, resultDenied, attributeValue(root, attributeResult))
+		assert.Equal(t, denyReasonInvalidRequest, attributeValue(root, attributeReason))
+	})
+
+	t.Run("no-op by default", func(t *testing.T) {
+		_, span := newTestWebhook(t).tracer.Start(context.Background(), spanHandle)
+		assert.False(t, span.IsRecording())
+	})
+}
+
+// attributeValue returns the value of a span's attribute, or an empty string if it isn't set
+func attributeValue(span tracetest.SpanStub, key string) string {
+	for _, attr := range span.Attributes {
+		if string(attr.Key) == key {
+			return attr.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestSpiffeEnableWebhook_NamespaceDefaults(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	defaults := func(namespace string, data map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: constants.NamespaceDefaultsConfigMap, Namespace: namespace},
+			Data:       data,
+		}
+	}
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		defaults("csi-enabled", map[string]string{"inject": constants.InjectCSIVolume}),
+		defaults("unknown-key", map[string]string{"inject": constants.InjectCSIVolume, "enabled": "true", "annotations": ""}),
+	).Build()
+
+	tests := []struct {
+		name               string
+		disabled           bool
+		namespace          string
+		annotations        map[string]string
+		expectedInject     *string
+		expectedVolumeName string
+		expectedWarnings   []string
+	}{
+		{
+			name:      "no ConfigMap",
+			namespace: "no-defaults",
+		},
+		{
+			name:               "ConfigMap enables injection",
+			namespace:          "csi-enabled",
+			expectedInject:     ptr.To(constants.InjectCSIVolume),
+			expectedVolumeName: constants.SPIFFEWLVolume,
+		},
+		{
+			name:               "pod annotation overrides ConfigMap",
+			namespace:          "csi-enabled",
+			annotations:        map[string]string{constants.InjectAnnotation: constants.InjectAnnotationHelper},
+			expectedInject:     ptr.To(constants.InjectAnnotationHelper),
+			expectedVolumeName: constants.SPIFFEEnableCertVolumeName,
+		},
+		{
+			name:           "empty pod annotation opts out",
+			namespace:      "csi-enabled",
+			annotations:    map[string]string{constants.InjectAnnotation: ""},
+			expectedInject: ptr.To(""),
+		},
+		{
+			name:               "unknown key",
+			namespace:          "unknown-key",
+			expectedInject:     ptr.To(constants.InjectCSIVolume),
+			expectedVolumeName: constants.SPIFFEWLVolume,
+			// The warnings are in the order of the keys
+			expectedWarnings: []string{`unknown key "annotations"`, `unknown key "enabled"`},
+		},
+		{
+			name:      "disabled",
+			disabled:  true,
+			namespace: "csi-enabled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh, err := NewSpiffeEnableWebhook(k8sClient, testr.New(t), admission.NewDecoder(scheme),
+				WithNamespaceDefaults(!tt.disabled))
+			require.NoError(t, err)
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Annotations: tt.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app-container", Image: "nginx"}}},
+			}
+			req, rawPod := newAdmissionRequest(t, pod)
+			req.Namespace = tt.namespace
+
+			resp := wh.Handle(context.Background(), req)
+			require.True(t, resp.Allowed, "result: %v", resp.Result)
+			require.Len(t, resp.Warnings, len(tt.expectedWarnings))
+			for i, expected := range tt.expectedWarnings {
+				assert.Contains(t, resp.Warnings[i], expected)
+			}
+			if tt.expectedVolumeName == "" {
+				assert.Empty(t, resp.Patches)
+				return
+			}
+
+			patchBytes, err := json.Marshal(resp.Patches)
+			require.NoError(t, err)
+			patch, err := jsonpatch.DecodePatch(patchBytes)
+			require.NoError(t, err)
+			mutatedJSON, err := patch.Apply(rawPod)
+			require.NoError(t, err)
+			var mutated corev1.Pod
+			require.NoError(t, json.Unmarshal(mutatedJSON, &mutated))
+
+			require.NotNil(t, tt.expectedInject)
+			assert.Equal(t, *tt.expectedInject, mutated.Annotations[constants.InjectAnnotation])
+			assert.True(t, workload.VolumeExists(&mutated, tt.expectedVolumeName))
 		})
 	}
 }
@@ -0,0 +1,69 @@
+package workload
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ParseResources parses container resources, either as a JSON-encoded ResourceRequirements or as a
+// comma-delimited list of RESOURCE=QUANTITY pairs, eg cpu=100m,memory=64Mi,limits.memory=128Mi.
+// Only cpu and memory can be set in the list form: bare names and names prefixed with requests.
+// set requests, and names prefixed with limits. set limits.
+func ParseResources(value string) (corev1.ResourceRequirements, error) {
+	var resources corev1.ResourceRequirements
+	if strings.HasPrefix(strings.TrimSpace(value), "{") {
+		if err := json.Unmarshal([]byte(value), &resources); err != nil {
+			return corev1.ResourceRequirements{}, fmt.Errorf("invalid resources %q: %w", value, err)
+		}
+	} else {
+		for _, pair := range strings.Split(value, ",") {
+			pair = strings.TrimSpace(pair)
+			if pair == "" {
+				continue
+			}
+
+			key, quantityValue, found := strings.Cut(pair, "=")
+			if !found {
+				return corev1.ResourceRequirements{}, fmt.Errorf("invalid resource %q: expected RESOURCE=QUANTITY", pair)
+			}
+			key = strings.TrimSpace(key)
+
+			list := &resources.Requests
+			name := key
+			if requestName, ok := strings.CutPrefix(key, "requests."); ok {
+				name = requestName
+			} else if limitName, ok := strings.CutPrefix(key, "limits."); ok {
+				list = &resources.Limits
+				name = limitName
+			}
+			if name != string(corev1.ResourceCPU) && name != string(corev1.ResourceMemory) {
+				return corev1.ResourceRequirements{}, fmt.Errorf("invalid resource %q: must be cpu or memory, with an optional requests or limits prefix", key)
+			}
+
+			quantity, err := resource.ParseQuantity(strings.TrimSpace(quantityValue))
+			if err != nil {
+				return corev1.ResourceRequirements{}, fmt.Errorf("invalid quantity %q for resource %q: %w", quantityValue, key, err)
+			}
+			if *list == nil {
+				*list = corev1.ResourceList{}
+			}
+			if _, ok := (*list)[corev1.ResourceName(name)]; ok {
+				return corev1.ResourceRequirements{}, fmt.Errorf("duplicate resource %q", key)
+			}
+			(*list)[corev1.ResourceName(name)] = quantity
+		}
+	}
+
+	// The API server would reject the pod, so reject the resources with a clearer message
+	for name, request := range resources.Requests {
+		if limit, ok := resources.Limits[name]; ok && request.Cmp(limit) > 0 {
+			return corev1.ResourceRequirements{}, fmt.Errorf("invalid resources: %s request %s exceeds its limit %s",
+				name, request.String(), limit.String())
+		}
+	}
+	return resources, nil
+}
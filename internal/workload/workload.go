@@ -1,6 +1,11 @@
 package workload
 
 import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
 	constants "github.com/cofide/spiffe-enable/internal/const"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/utils/ptr"
@@ -39,6 +44,30 @@ func GetSPIFFEEnvVar() corev1.EnvVar {
 	return spiffeWLEnvVar
 }
 
+// GetSPIFFEEnvVarForSocket returns the SPIFFE socket environment variable for the Workload API
+// socket at socketPath, rather than the default path
+func GetSPIFFEEnvVarForSocket(socketPath string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name:  constants.SPIFFEWLSocketEnvName,
+		Value: "unix://" + socketPath,
+	}
+}
+
+// ValidateSocketPath checks the path of a SPIFFE Workload API socket, which must be a clean,
+// absolute path within the CSI volume's mount. The path is used in the proxy init container's
+// commands, so it is limited to letters, digits, '.', '_', '-' and '/'.
+func ValidateSocketPath(socketPath string) error {
+	if path.Clean(socketPath) != socketPath || !strings.HasPrefix(socketPath, constants.SPIFFEWLMountPath+"/") {
+		return fmt.Errorf("invalid socket path %q: must be a clean, absolute path within %s", socketPath, constants.SPIFFEWLMountPath)
+	}
+	if !socketPathRegexp.MatchString(socketPath) {
+		return fmt.Errorf("invalid socket path %q: must consist of letters, digits, '.', '_', '-' and '/'", socketPath)
+	}
+	return nil
+}
+
+var socketPathRegexp = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
 // Helper function to check if a volume already exists
 func VolumeExists(pod *corev1.Pod, volumeName string) bool {
 	for _, vol := range pod.Spec.Volumes {
@@ -49,6 +78,16 @@ func VolumeExists(pod *corev1.Pod, volumeName string) bool {
 	return false
 }
 
+// UniqueVolumeName returns volumeName if the pod has no volume of that name, otherwise the
+// first of volumeName-1, volumeName-2, ... that isn't used
+func UniqueVolumeName(pod *corev1.Pod, volumeName string) string {
+	name := volumeName
+	for i := 1; VolumeExists(pod, name); i++ {
+		name = fmt.Sprintf("%s-%d", volumeName, i)
+	}
+	return name
+}
+
 // Helper function to check if a container already exists
 func ContainerExists(containers []corev1.Container, containerName string) bool {
 	for _, container := range containers {
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+const contentTypeJSON = "application/json"
+
+// CertificatesResponse is the workload's certificates as served by the JSON API
+type CertificatesResponse struct {
+	SVIDs                 []Certificate `json:"svids"`
+	Bundles               []Certificate `json:"bundles"`
+	FederatedTrustDomains []string      `json:"federatedTrustDomains"`
+}
+
+// loadCertificatesResponse loads the workload's certificates, flagging those that expire within
+// expiryWarnThreshold, and returns errNoSVID if it has no SVIDs yet
+func loadCertificatesResponse(
+	ctx context.Context, client workloadClient, expiryWarnThreshold time.Duration,
+) (*CertificatesResponse, error) {
+	svidCerts, err := loadSVIDCertificates(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	if len(svidCerts) == 0 {
+		return nil, errNoSVID
+	}
+
+	caCerts, federatedTDs, err := loadCACertificates(ctx, client, svidCerts[0].TrustDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	setExpiry(svidCerts, now, expiryWarnThreshold)
+	setExpiry(caCerts, now, expiryWarnThreshold)
+
+	// Empty lists are encoded as [] rather than null
+	return &CertificatesResponse{
+		SVIDs:                 svidCerts,
+		Bundles:               append([]Certificate{}, caCerts...),
+		FederatedTrustDomains: append([]string{}, federatedTDs...),
+	}, nil
+}
+
+// certificatesHandler serves the workload's X509-SVIDs, trust bundle certificates and federated
+// trust domains as JSON, for tooling that inspects them programmatically. SVIDs rotate, so
+// responses are not cached.
+func certificatesHandler(client workloadClient, expiryWarnThreshold time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reqCtx, reqCancel := context.WithTimeout(r.Context(), apiTimeout)
+		defer reqCancel()
+
+		resp, err := loadCertificatesResponse(reqCtx, client, expiryWarnThreshold)
+		if errors.Is(err, errNoSVID) {
+			http.Error(w, messageNoSVID, http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			log.Printf("Error loading certificates: %v", err)
+			http.Error(w, "Error loading certificates", http.StatusInternalServerError)
+			return
+		}
+
+		body, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("Error marshaling certificates: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.Header().Set("Cache-Control", "no-store")
+		if _, err := w.Write(body); err != nil {
+			log.Printf("Error writing certificates: %v", err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificatesHandler(t *testing.T) {
+	client := newFakeWorkloadClient(t, "spiffe://example.org/workload")
+	ca := client.svids[0].Certificates[1]
+	client.bundles.Add(x509bundle.FromX509Authorities(
+		spiffeid.RequireTrustDomainFromString("federated.org"), []*x509.Certificate{ca}))
+
+	rec := httptest.NewRecorder()
+	certificatesHandler(client, defaultExpiryWarnThreshold)(rec, httptest.NewRequest(http.MethodGet, "/api/certificates", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, contentTypeJSON, rec.Header().Get("Content-Type"))
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+	var shape map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &shape))
+	assert.ElementsMatch(t, []string{"svids", "bundles", "federatedTrustDomains"}, slices.Collect(maps.Keys(shape)))
+
+	var resp CertificatesResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.SVIDs, 1)
+	assert.Equal(t, "spiffe://example.org/workload", resp.SVIDs[0].Name)
+	assert.Equal(t, "example.org", resp.SVIDs[0].TrustDomain)
+	leaf := client.svids[0].Certificates[0]
+	assert.Equal(t, leaf.NotBefore.UTC().Format(time.RFC3339), resp.SVIDs[0].NotBefore)
+	assert.Equal(t, leaf.NotAfter.UTC().Format(time.RFC3339), resp.SVIDs[0].NotAfter)
+	assert.NotEmpty(t, resp.SVIDs[0].TimeToExpiry)
+	assert.Equal(t, []string{"federated.org"}, resp.FederatedTrustDomains)
+
+	require.Len(t, resp.Bundles, 2)
+	for _, bundle := range resp.Bundles {
+		assert.Equal(t, base64.StdEncoding.EncodeToString(ca.Raw), bundle.Certificate)
+		assert.Equal(t, ca.NotAfter.UTC().Format(time.RFC3339), bundle.NotAfter)
+	}
+	assert.ElementsMatch(t, []string{"example.org", "federated.org"},
+		[]string{resp.Bundles[0].Name, resp.Bundles[1].Name})
+}
+
+func TestCertificatesHandler_Unavailable(t *testing.T) {
+	tests := []struct {
+		name           string
+		client         *fakeWorkloadClient
+		expectedStatus int
+	}{
+		{
+			name:           "no SVID yet",
+			client:         &fakeWorkloadClient{},
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "Workload API unavailable",
+			client:         &fakeWorkloadClient{err: errors.New("connection refused")},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			certificatesHandler(tt.client, defaultExpiryWarnThreshold)(rec, httptest.NewRequest(http.MethodGet, "/api/certificates", nil))
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// The defaults for the --fetch-attempts, --fetch-backoff and --fail-closed flags, so that they can
+// be set on an injected UI container
+const (
+	envVarFetchAttempts = "SPIFFE_ENABLE_UI_FETCH_ATTEMPTS"
+	envVarFetchBackoff  = "SPIFFE_ENABLE_UI_FETCH_BACKOFF"
+	envVarFailClosed    = "SPIFFE_ENABLE_UI_FAIL_CLOSED"
+)
+
+// The defaults used if no fetch policy is configured. The agent is often briefly unavailable as a
+// pod starts, so a few quick retries ride out the common case without stalling the page for long.
+const (
+	defaultFetchAttempts = 3
+	defaultFetchBackoff  = 500 * time.Millisecond
+)
+
+// fetchPolicy is how the dashboard handles an unavailable Workload API. Fetches are attempted up
+// to attempts times, waiting backoff before the first retry and doubling it for each one after.
+// If every attempt fails, the dashboard is rendered without the workload's SVIDs (fail open),
+// unless failClosed is set, in which case only an error is returned.
+type fetchPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	failClosed bool
+}
+
+// parseFetchPolicy parses the fetch policy flags, using the defaults for any that are empty
+func parseFetchPolicy(attempts, backoff, failClosed string) (fetchPolicy, error) {
+	policy := fetchPolicy{attempts: defaultFetchAttempts, backoff: defaultFetchBackoff}
+
+	if attempts != "" {
+		n, err := strconv.Atoi(attempts)
+		if err != nil {
+			return fetchPolicy{}, fmt.Errorf("invalid fetch attempts %q: %w", attempts, err)
+		}
+		if n < 1 {
+			return fetchPolicy{}, fmt.Errorf("invalid fetch attempts %q: must be at least 1", attempts)
+		}
+		policy.attempts = n
+	}
+
+	if backoff != "" {
+		d, err := time.ParseDuration(backoff)
+		if err != nil {
+			return fetchPolicy{}, fmt.Errorf("invalid fetch backoff %q: %w", backoff, err)
+		}
+		if d < 0 {
+			return fetchPolicy{}, fmt.Errorf("invalid fetch backoff %q: must not be negative", backoff)
+		}
+		policy.backoff = d
+	}
+
+	if failClosed != "" {
+		b, err := strconv.ParseBool(failClosed)
+		if err != nil {
+			return fetchPolicy{}, fmt.Errorf("invalid fail closed setting %q: %w", failClosed, err)
+		}
+		policy.failClosed = b
+	}
+
+	return policy, nil
+}
+
+// retry calls fetch until it succeeds, the attempts are used up, or ctx is done, returning the
+// last error
+func (p fetchPolicy) retry(ctx context.Context, fetch func() error) error {
+	backoff := p.backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fetch(); err == nil || attempt >= p.attempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
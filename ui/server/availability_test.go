@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFetchPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		attempts    string
+		backoff     string
+		failClosed  string
+		expected    fetchPolicy
+		expectError bool
+	}{
+		{
+			name:     "defaults",
+			expected: fetchPolicy{attempts: defaultFetchAttempts, backoff: defaultFetchBackoff},
+		},
+		{
+			name:       "configured",
+			attempts:   "5",
+			backoff:    "1s",
+			failClosed: "true",
+			expected:   fetchPolicy{attempts: 5, backoff: time.Second, failClosed: true},
+		},
+		{
+			name:     "single attempt without backoff",
+			attempts: "1",
+			backoff:  "0s",
+			expected: fetchPolicy{attempts: 1},
+		},
+		{name: "zero attempts", attempts: "0", expectError: true},
+		{name: "invalid attempts", attempts: "many", expectError: true},
+		{name: "negative backoff", backoff: "-1s", expectError: true},
+		{name: "invalid backoff", backoff: "soon", expectError: true},
+		{name: "invalid fail closed", failClosed: "maybe", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := parseFetchPolicy(tt.attempts, tt.backoff, tt.failClosed)
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, policy)
+		})
+	}
+}
+
+func TestFetchPolicy_Retry(t *testing.T) {
+	errUnavailable := errors.New("unavailable")
+
+	tests := []struct {
+		name             string
+		failures         int
+		expectedCalls    int
+		expectedErrorNil bool
+	}{
+		{name: "succeeds first time", failures: 0, expectedCalls: 1, expectedErrorNil: true},
+		{name: "succeeds on retry", failures: 2, expectedCalls: 3, expectedErrorNil: true},
+		{name: "attempts used up", failures: 5, expectedCalls: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := fetchPolicy{attempts: 3, backoff: time.Millisecond}
+			calls := 0
+			err := policy.retry(context.Background(), func() error {
+				calls++
+				if calls <= tt.failures {
+					return errUnavailable
+				}
+				return nil
+			})
+			assert.Equal(t, tt.expectedCalls, calls)
+			if tt.expectedErrorNil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, errUnavailable)
+			}
+		})
+	}
+}
+
+func TestFetchPolicy_Retry_ContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	policy := fetchPolicy{attempts: 3, backoff: time.Hour}
+	calls := 0
+	err := policy.retry(ctx, func() error {
+		calls++
+		return errors.New("unavailable")
+	})
+	require.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// errNoX509Context is returned by the cache until the watch stream delivers the first X.509
+// context, or fails
+var errNoX509Context = errors.New("waiting for the first X.509 context from the Workload API")
+
+// x509ContextCache holds the latest X.509 context from the watch stream, so that pages are served
+// immediately, and with up-to-date SVIDs, rather than with a fetch per request. It implements
+// workloadClient, serving the cached context in place of a client.
+type x509ContextCache struct {
+	mu          sync.Mutex
+	svids       []*x509svid.SVID
+	bundles     *x509bundle.Set
+	err         error
+	subscribers map[chan struct{}]struct{}
+}
+
+func newX509ContextCache() *x509ContextCache {
+	return &x509ContextCache{
+		err:         errNoX509Context,
+		subscribers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// OnX509ContextUpdate caches the update and notifies the subscribers
+func (c *x509ContextCache) OnX509ContextUpdate(x509Context *workloadapi.X509Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.svids = x509Context.SVIDs
+	c.bundles = x509Context.Bundles
+	c.err = nil
+	for ch := range c.subscribers {
+		// A subscriber that hasn't handled the last update will read the latest context anyway
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// OnX509ContextWatchError records the error if no context has been received yet. Once one has, it
+// is served until the next update, as the client retries the stream.
+func (c *x509ContextCache) OnX509ContextWatchError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.svids == nil && c.bundles == nil {
+		c.err = err
+	}
+}
+
+func (c *x509ContextCache) FetchX509SVIDs(_ context.Context) ([]*x509svid.SVID, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.svids, c.err
+}
+
+func (c *x509ContextCache) FetchX509Bundles(_ context.Context) (*x509bundle.Set, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bundles, c.err
+}
+
+// Close is a no-op, as the client that the cache is watching is closed by its owner
+func (c *x509ContextCache) Close() error {
+	return nil
+}
+
+// subscribe returns a channel that receives a value whenever the context is updated, and a
+// function that stops the subscription
+func (c *x509ContextCache) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.subscribers, ch)
+	}
+}
+
+// x509ContextWatchers fans out a single watch stream to several watchers
+type x509ContextWatchers []workloadapi.X509ContextWatcher
+
+func (w x509ContextWatchers) OnX509ContextUpdate(x509Context *workloadapi.X509Context) {
+	for _, watcher := range w {
+		watcher.OnX509ContextUpdate(x509Context)
+	}
+}
+
+func (w x509ContextWatchers) OnX509ContextWatchError(err error) {
+	for _, watcher := range w {
+		watcher.OnX509ContextWatchError(err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeX509ContextSource streams the X.509 contexts sent on its channel to a watcher
+type fakeX509ContextSource struct {
+	updates chan *workloadapi.X509Context
+}
+
+func (f *fakeX509ContextSource) WatchX509Context(ctx context.Context, watcher workloadapi.X509ContextWatcher) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case update := <-f.updates:
+			watcher.OnX509ContextUpdate(update)
+		}
+	}
+}
+
+// newFakeX509Context returns an X.509 context with a single SVID for spiffeID
+func newFakeX509Context(t *testing.T, spiffeID string) *workloadapi.X509Context {
+	t.Helper()
+	client := newFakeWorkloadClient(t, spiffeID)
+	return &workloadapi.X509Context{SVIDs: client.svids, Bundles: client.bundles}
+}
+
+// cachedSPIFFEID returns the ID of the cache's first SVID, or the error fetching it
+func cachedSPIFFEID(cache *x509ContextCache) (string, error) {
+	svids, err := cache.FetchX509SVIDs(context.Background())
+	if err != nil {
+		return "", err
+	}
+	if len(svids) == 0 {
+		return "", nil
+	}
+	return svids[0].ID.String(), nil
+}
+
+func TestX509ContextCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := &fakeX509ContextSource{updates: make(chan *workloadapi.X509Context)}
+	cache := newX509ContextCache()
+	updates, unsubscribe := cache.subscribe()
+	defer unsubscribe()
+
+	_, err := cachedSPIFFEID(cache)
+	require.ErrorIs(t, err, errNoX509Context)
+
+	errUnavailable := errors.New("connection refused")
+	cache.OnX509ContextWatchError(errUnavailable)
+	_, err = cachedSPIFFEID(cache)
+	require.ErrorIs(t, err, errUnavailable)
+
+	go func() {
+		_ = source.WatchX509Context(ctx, cache)
+	}()
+
+	for _, id := range []string{"spiffe://example.org/workload", "spiffe://example.org/rotated"} {
+		source.updates <- newFakeX509Context(t, id)
+
+		select {
+		case <-updates:
+		case <-time.After(time.Second):
+			require.FailNow(t, "subscriber was not notified of the update")
+		}
+
+		cached, err := cachedSPIFFEID(cache)
+		require.NoError(t, err)
+		assert.Equal(t, id, cached)
+
+		bundles, err := cache.FetchX509Bundles(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, bundles.Len())
+	}
+
+	// Once a context has been received, it is served through errors on the watch stream
+	cache.OnX509ContextWatchError(errUnavailable)
+	cached, err := cachedSPIFFEID(cache)
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://example.org/rotated", cached)
+}
+
+func TestEventsHandler(t *testing.T) {
+	cache := newX509ContextCache()
+	server := httptest.NewServer(eventsHandler(cache, defaultExpiryWarnThreshold))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, contentTypeEventStream, resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() (string, string) {
+		t.Helper()
+		var event string
+		var data []string
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				return event, strings.Join(data, "\n")
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = append(data, strings.TrimPrefix(line, "data: "))
+			}
+		}
+	}
+
+	cache.OnX509ContextUpdate(newFakeX509Context(t, "spiffe://example.org/workload"))
+	event, data := readEvent()
+	assert.Equal(t, eventUpdate, event)
+	var certs CertificatesResponse
+	require.NoError(t, json.Unmarshal([]byte(data), &certs))
+	require.Len(t, certs.SVIDs, 1)
+	assert.Equal(t, "spiffe://example.org/workload", certs.SVIDs[0].Name)
+
+	cache.OnX509ContextUpdate(&workloadapi.X509Context{})
+	event, data = readEvent()
+	assert.Equal(t, eventUnavailable, event)
+	assert.Equal(t, errNoSVID.Error(), data)
+}
+
+func TestWriteEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{
+			name:     "single line",
+			data:     `{"svids":[]}`,
+			expected: "event: unavailable\ndata: {\"svids\":[]}\n\n",
+		},
+		{
+			name:     "multiple lines",
+			data:     "connection error:\ndial unix: no such file",
+			expected: "event: unavailable\ndata: connection error:\ndata: dial unix: no such file\n\n",
+		},
+		{
+			name:     "carriage returns",
+			data:     "first\r\nsecond\rthird",
+			expected: "event: unavailable\ndata: first\ndata: second\ndata: third\n\n",
+		},
+		{
+			name:     "empty",
+			expected: "event: unavailable\ndata: \n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			require.NoError(t, writeEvent(&b, eventUnavailable, tt.data))
+			assert.Equal(t, tt.expected, b.String())
+		})
+	}
+}
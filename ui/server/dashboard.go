@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Messages shown in place of the workload summary when its SVIDs can't be displayed
+const (
+	messageNoSVID      = "No SVID available yet"
+	messageUnavailable = "Workload API unavailable"
+)
+
+// errNoSVID is returned when the Workload API is reachable but has not yet issued the workload an
+// SVID, which is usual while a pod's registration entry propagates to the agent
+var errNoSVID = errors.New("no SVID available yet")
+
+// dashboard serves the dashboard page for the workload's primary Workload API endpoint
+type dashboard struct {
+	client              workloadClient
+	endpoints           []endpointClient
+	tmpl                *template.Template
+	idMatcher           *idMatcher
+	expiryWarnThreshold time.Duration
+	policy              fetchPolicy
+	liveUpdates         bool
+}
+
+// loadDashboardTemplate parses the embedded dashboard template
+func loadDashboardTemplate() (*template.Template, error) {
+	tmplBytes, err := fs.ReadFile(tmplAssets, "templates/dashboard.tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template file: %w", err)
+	}
+
+	tmpl, err := template.New("dashboard").Parse(string(tmplBytes))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template: %w", err)
+	}
+	return tmpl, nil
+}
+
+func (d *dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reqCtx, reqCancel := context.WithTimeout(r.Context(), apiTimeout)
+	defer reqCancel()
+
+	// Get SVID certificates, retrying while the Workload API is unavailable or has no SVID for
+	// the workload
+	var svidCerts []Certificate
+	err := d.policy.retry(reqCtx, func() error {
+		var err error
+		svidCerts, err = loadSVIDCertificates(reqCtx, d.client)
+		if err == nil && len(svidCerts) == 0 {
+			err = errNoSVID
+		}
+		return err
+	})
+	if err != nil {
+		d.serveUnavailable(reqCtx, w, err)
+		return
+	}
+
+	caCerts, federatedTDs, err := loadCACertificates(reqCtx, d.client, svidCerts[0].TrustDomain)
+	if err != nil {
+		log.Printf("Error loading CA certificates: %v", err)
+		http.Error(w, "Error loading certificates", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	setExpiry(svidCerts, now, d.expiryWarnThreshold)
+	setExpiry(caCerts, now, d.expiryWarnThreshold)
+
+	svidCertsJSON, err := json.Marshal(svidCerts)
+	if err != nil {
+		log.Printf("Error marshaling SVID certificates: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	caCertsJSON, err := json.Marshal(caCerts)
+	if err != nil {
+		log.Printf("Error marshaling CA certificates: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Prepare data for template
+	data := PageData{
+		SpiffeID:              svidCerts[0].Name,
+		TrustDomain:           svidCerts[0].TrustDomain,
+		FederatedTrustDomains: federatedTDs,
+		SVIDCertificates:      template.JS(svidCertsJSON),
+		CACertificates:        template.JS(caCertsJSON),
+		SVIDs:                 svidCerts,
+		IDCheck:               d.idMatcher.check(svidCerts[0].Name),
+		ExpiryWarnings:        expiryWarnings(svidCerts, caCerts),
+		LiveUpdates:           d.liveUpdates,
+	}
+
+	if groups := groupByTrustDomain(svidCerts, caCerts); len(groups) > 1 {
+		data.TrustDomains = groups
+	}
+
+	if len(d.endpoints) > 1 {
+		data.Endpoints = loadEndpointSVIDs(reqCtx, d.endpoints)
+	}
+
+	d.execute(w, http.StatusOK, data)
+}
+
+// serveUnavailable responds with 503 when the workload's SVIDs couldn't be loaded. If the policy
+// fails open, the dashboard is rendered without them, so that any other Workload API endpoints can
+// still be inspected.
+func (d *dashboard) serveUnavailable(ctx context.Context, w http.ResponseWriter, err error) {
+	message := messageUnavailable
+	if errors.Is(err, errNoSVID) {
+		message = messageNoSVID
+	} else {
+		log.Printf("Error loading SVID certificates: %v", err)
+	}
+
+	if d.policy.failClosed {
+		http.Error(w, message, http.StatusServiceUnavailable)
+		return
+	}
+
+	if !errors.Is(err, errNoSVID) {
+		message = fmt.Sprintf("%s (%v)", message, err)
+	}
+	data := PageData{
+		Unavailable:      message,
+		SVIDCertificates: template.JS("[]"),
+		CACertificates:   template.JS("[]"),
+		LiveUpdates:      d.liveUpdates,
+	}
+	if len(d.endpoints) > 1 {
+		data.Endpoints = loadEndpointSVIDs(ctx, d.endpoints)
+	}
+
+	d.execute(w, http.StatusServiceUnavailable, data)
+}
+
+// execute renders the template, buffering it so that an error can still be reported with its own
+// status
+func (d *dashboard) execute(w http.ResponseWriter, status int, data PageData) {
+	var buf bytes.Buffer
+	if err := d.tmpl.Execute(&buf, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Printf("Error writing dashboard: %v", err)
+	}
+}
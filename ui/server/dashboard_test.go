@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyWorkloadClient fails to fetch SVIDs a number of times before deferring to its client
+type flakyWorkloadClient struct {
+	*fakeWorkloadClient
+	failures int
+	calls    int
+}
+
+func (f *flakyWorkloadClient) FetchX509SVIDs(ctx context.Context) ([]*x509svid.SVID, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, errors.New("connection refused")
+	}
+	return f.fakeWorkloadClient.FetchX509SVIDs(ctx)
+}
+
+func TestDashboard(t *testing.T) {
+	tmpl, err := loadDashboardTemplate()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name                string
+		client              workloadClient
+		failClosed          bool
+		expectedStatus      int
+		expectedContentType string
+		expectedContains    []string
+		expectedExcludes    []string
+	}{
+		{
+			name:             "SVID available",
+			client:           newFakeWorkloadClient(t, "spiffe://example.org/workload"),
+			expectedStatus:   http.StatusOK,
+			expectedContains: []string{"spiffe://example.org/workload", "SVID Validity", "(expires in "},
+			expectedExcludes: []string{messageNoSVID, messageUnavailable},
+		},
+		{
+			name: "SVID available after transient failures",
+			client: &flakyWorkloadClient{
+				fakeWorkloadClient: newFakeWorkloadClient(t, "spiffe://example.org/workload"),
+				failures:           2,
+			},
+			expectedStatus:   http.StatusOK,
+			expectedContains: []string{"spiffe://example.org/workload"},
+		},
+		{
+			name:             "no SVID yet",
+			client:           &fakeWorkloadClient{},
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedContains: []string{messageNoSVID, "const svidCertsRawJSON = []"},
+		},
+		{
+			// An empty slice is rendered as the full page, with no certificates rather than null
+			name:                "empty SVID slice",
+			client:              &fakeWorkloadClient{svids: []*x509svid.SVID{}},
+			expectedStatus:      http.StatusServiceUnavailable,
+			expectedContentType: "text/html; charset=utf-8",
+			expectedContains: []string{
+				"<html", messageNoSVID, "const svidCertsRawJSON = []", "const caCertsRawJSON = []",
+			},
+			expectedExcludes: []string{"SVID Validity", "null", messageUnavailable},
+		},
+		{
+			name:             "Workload API unavailable",
+			client:           &fakeWorkloadClient{err: errors.New("connection refused")},
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedContains: []string{messageUnavailable, "connection refused", "const svidCertsRawJSON = []"},
+		},
+		{
+			name:                "no SVID yet, fail closed",
+			client:              &fakeWorkloadClient{},
+			failClosed:          true,
+			expectedStatus:      http.StatusServiceUnavailable,
+			expectedContentType: "text/plain; charset=utf-8",
+			expectedContains:    []string{messageNoSVID},
+			expectedExcludes:    []string{"<html"},
+		},
+		{
+			name:             "Workload API unavailable, fail closed",
+			client:           &fakeWorkloadClient{err: errors.New("connection refused")},
+			failClosed:       true,
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedContains: []string{messageUnavailable},
+			expectedExcludes: []string{"<html", "connection refused"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &dashboard{
+				client: tt.client,
+				tmpl:   tmpl,
+				policy: fetchPolicy{attempts: 3, backoff: time.Millisecond, failClosed: tt.failClosed},
+			}
+
+			rec := httptest.NewRecorder()
+			require.NotPanics(t, func() {
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			})
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expectedContentType != "" {
+				assert.Equal(t, tt.expectedContentType, rec.Header().Get("Content-Type"))
+			}
+			for _, s := range tt.expectedContains {
+				assert.Contains(t, rec.Body.String(), s)
+			}
+			for _, s := range tt.expectedExcludes {
+				assert.NotContains(t, rec.Body.String(), s)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	encodingPEM = ".pem"
+	encodingDER = ".der"
+
+	contentTypePEM = "application/x-pem-file"
+	contentTypeDER = "application/pkix-cert"
+)
+
+// certLoader loads the list of certificates that a download handler indexes into
+type certLoader func(ctx context.Context, client workloadClient) ([]Certificate, error)
+
+// loadBundleCertificates returns the X.509 trust bundle certificates across all trust domains
+func loadBundleCertificates(ctx context.Context, client workloadClient) ([]Certificate, error) {
+	certs, _, err := loadCACertificates(ctx, client, "")
+	return certs, err
+}
+
+// certDownloadHandler serves a single certificate from the list returned by load,
+// selected by the index in the request path (eg /cert/0.pem or /bundle/1.der).
+// PEM downloads include the full chain; DER downloads contain the leaf certificate only.
+func certDownloadHandler(client workloadClient, kind string, load certLoader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		file := r.PathValue("file")
+		ext := path.Ext(file)
+		if ext != encodingPEM && ext != encodingDER {
+			http.NotFound(w, r)
+			return
+		}
+
+		index, err := strconv.Atoi(strings.TrimSuffix(file, ext))
+		if err != nil || index < 0 {
+			http.Error(w, "Invalid certificate index", http.StatusBadRequest)
+			return
+		}
+
+		reqCtx, reqCancel := context.WithTimeout(r.Context(), apiTimeout)
+		defer reqCancel()
+
+		certs, err := load(reqCtx, client)
+		if err != nil {
+			log.Printf("Error loading %s certificates: %v", kind, err)
+			http.Error(w, "Error loading certificates", http.StatusInternalServerError)
+			return
+		}
+
+		if index >= len(certs) {
+			http.NotFound(w, r)
+			return
+		}
+
+		chain, err := decodeCertificateChain(certs[index])
+		if err != nil {
+			log.Printf("Error decoding %s certificate %d: %v", kind, index, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		var body []byte
+		contentType := contentTypeDER
+		if ext == encodingPEM {
+			contentType = contentTypePEM
+			for _, c := range chain {
+				body = append(body, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+			}
+		} else {
+			body = chain[0].Raw
+		}
+
+		filename := fmt.Sprintf("%s-%d%s", kind, index, ext)
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		if _, err := w.Write(body); err != nil {
+			log.Printf("Error writing %s certificate %d: %v", kind, index, err)
+		}
+	}
+}
+
+// decodeCertificateChain parses the base64 DER-encoded certificate(s) held by a Certificate
+func decodeCertificateChain(cert Certificate) ([]*x509.Certificate, error) {
+	der, err := base64.StdEncoding.DecodeString(cert.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode certificate: %w", err)
+	}
+
+	chain, err := x509.ParseCertificates(der)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse certificate: %w", err)
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+
+	return chain, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeWorkloadClient struct {
+	svids   []*x509svid.SVID
+	bundles *x509bundle.Set
+	err     error
+}
+
+func (f *fakeWorkloadClient) FetchX509SVIDs(_ context.Context) ([]*x509svid.SVID, error) {
+	return f.svids, f.err
+}
+
+func (f *fakeWorkloadClient) FetchX509Bundles(_ context.Context) (*x509bundle.Set, error) {
+	return f.bundles, f.err
+}
+
+func (f *fakeWorkloadClient) Close() error {
+	return nil
+}
+
+// newFakeWorkloadClient returns a client serving a single SVID for spiffeID, signed by a CA
+// that is also returned as the trust bundle for the SVID's trust domain
+func newFakeWorkloadClient(t *testing.T, spiffeID string) *fakeWorkloadClient {
+	t.Helper()
+
+	id := spiffeid.RequireFromString(spiffeID)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		URIs:                  []*url.URL{id.TrustDomain().ID().URL()},
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		URIs:         []*url.URL{id.URL()},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey)
+	require.NoError(t, err)
+	leafCert, err := x509.ParseCertificate(leafDER)
+	require.NoError(t, err)
+
+	return &fakeWorkloadClient{
+		svids: []*x509svid.SVID{{
+			ID:           id,
+			Certificates: []*x509.Certificate{leafCert, caCert},
+			PrivateKey:   leafKey,
+		}},
+		bundles: x509bundle.NewSet(x509bundle.FromX509Authorities(id.TrustDomain(), []*x509.Certificate{caCert})),
+	}
+}
+
+func TestCertDownloadHandler(t *testing.T) {
+	client := newFakeWorkloadClient(t, "spiffe://example.org/workload")
+	leaf := client.svids[0].Certificates[0]
+	ca := client.svids[0].Certificates[1]
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /cert/{file}", certDownloadHandler(client, "svid", loadSVIDCertificates))
+	mux.HandleFunc("GET /bundle/{file}", certDownloadHandler(client, "bundle", loadBundleCertificates))
+
+	tests := []struct {
+		name                string
+		path                string
+		expectedStatus      int
+		expectedContentType string
+		expectedFilename    string
+		expectedCerts       []*x509.Certificate
+	}{
+		{
+			name:                "SVID as PEM",
+			path:                "/cert/0.pem",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: contentTypePEM,
+			expectedFilename:    "svid-0.pem",
+			expectedCerts:       []*x509.Certificate{leaf, ca},
+		},
+		{
+			name:                "SVID as DER",
+			path:                "/cert/0.der",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: contentTypeDER,
+			expectedFilename:    "svid-0.der",
+			expectedCerts:       []*x509.Certificate{leaf},
+		},
+		{
+			name:                "bundle cert as PEM",
+			path:                "/bundle/0.pem",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: contentTypePEM,
+			expectedFilename:    "bundle-0.pem",
+			expectedCerts:       []*x509.Certificate{ca},
+		},
+		{
+			name:                "bundle cert as DER",
+			path:                "/bundle/0.der",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: contentTypeDER,
+			expectedFilename:    "bundle-0.der",
+			expectedCerts:       []*x509.Certificate{ca},
+		},
+		{
+			name:           "SVID out of range PEM",
+			path:           "/cert/1.pem",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "SVID out of range DER",
+			path:           "/cert/1.der",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "bundle cert out of range",
+			path:           "/bundle/5.pem",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "negative index",
+			path:           "/cert/-1.pem",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "non-numeric index",
+			path:           "/cert/abc.der",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unsupported encoding",
+			path:           "/cert/0.txt",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			require.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, tt.expectedContentType, rec.Header().Get("Content-Type"))
+			assert.Contains(t, rec.Header().Get("Content-Disposition"), tt.expectedFilename)
+
+			var got []*x509.Certificate
+			if tt.expectedContentType == contentTypePEM {
+				rest := rec.Body.Bytes()
+				for {
+					var block *pem.Block
+					block, rest = pem.Decode(rest)
+					if block == nil {
+						break
+					}
+					assert.Equal(t, "CERTIFICATE", block.Type)
+					cert, err := x509.ParseCertificate(block.Bytes)
+					require.NoError(t, err)
+					got = append(got, cert)
+				}
+			} else {
+				cert, err := x509.ParseCertificate(rec.Body.Bytes())
+				require.NoError(t, err)
+				got = append(got, cert)
+			}
+
+			require.Len(t, got, len(tt.expectedCerts))
+			for i := range tt.expectedCerts {
+				assert.True(t, tt.expectedCerts[i].Equal(got[i]))
+			}
+		})
+	}
+}
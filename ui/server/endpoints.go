@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/spiffe/go-spiffe/v2/logger"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// envVarEndpoints is a comma-delimited list of additional Workload API endpoints to
+// query, eg to compare identities across federated trust domains
+const envVarEndpoints = "SPIFFE_ENABLE_UI_ENDPOINTS"
+
+// clientFactory creates a Workload API client for an endpoint address
+type clientFactory func(ctx context.Context, address string) (workloadClient, error)
+
+// endpointClient is a Workload API client for an endpoint, or the error creating it
+type endpointClient struct {
+	address string
+	client  workloadClient
+	err     error
+}
+
+// EndpointSVIDs holds the SVIDs fetched from a Workload API endpoint, or the reason the endpoint is unreachable
+type EndpointSVIDs struct {
+	Address string
+	SVIDs   []Certificate
+	Error   string
+}
+
+func newWorkloadAPIClient(ctx context.Context, address string) (workloadClient, error) {
+	return workloadapi.New(ctx, workloadapi.WithAddr(address), workloadapi.WithLogger(logger.Std))
+}
+
+// parseEndpoints returns the primary endpoint followed by any additional endpoints, without duplicates
+func parseEndpoints(primary, additional string) []string {
+	endpoints := []string{primary}
+	seen := map[string]bool{primary: true}
+
+	for _, endpoint := range strings.Split(additional, ",") {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint == "" || seen[endpoint] {
+			continue
+		}
+		seen[endpoint] = true
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpoints
+}
+
+// newEndpointClients creates a client for each endpoint, recording rather than
+// failing on errors so that the remaining endpoints can still be used
+func newEndpointClients(ctx context.Context, addresses []string, factory clientFactory) []endpointClient {
+	clients := make([]endpointClient, 0, len(addresses))
+	for _, address := range addresses {
+		client, err := factory(ctx, address)
+		clients = append(clients, endpointClient{address: address, client: client, err: err})
+	}
+	return clients
+}
+
+// loadEndpointSVIDs fetches the SVIDs from each endpoint concurrently. Results are in the
+// same order as the endpoints, with unreachable endpoints reporting an error.
+func loadEndpointSVIDs(ctx context.Context, endpoints []endpointClient) []EndpointSVIDs {
+	results := make([]EndpointSVIDs, len(endpoints))
+
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		results[i].Address = endpoint.address
+		if endpoint.err != nil {
+			results[i].Error = endpoint.err.Error()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			svids, err := loadSVIDCertificates(ctx, endpoint.client)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].SVIDs = svids
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEndpoints(t *testing.T) {
+	assert.Equal(t, []string{"unix:///local.sock"}, parseEndpoints("unix:///local.sock", ""))
+	assert.Equal(t,
+		[]string{"unix:///local.sock", "unix:///remote.sock", "tcp://10.0.0.1:8081"},
+		parseEndpoints("unix:///local.sock", " unix:///remote.sock,,unix:///local.sock, tcp://10.0.0.1:8081,unix:///remote.sock"),
+	)
+}
+
+func TestLoadEndpointSVIDs(t *testing.T) {
+	local := newFakeWorkloadClient(t, "spiffe://local.org/workload")
+	remote := newFakeWorkloadClient(t, "spiffe://remote.org/workload")
+	failing := &fakeWorkloadClient{err: errors.New("connection refused")}
+
+	fakeClients := map[string]workloadClient{
+		"unix:///local.sock":   local,
+		"unix:///remote.sock":  remote,
+		"unix:///failing.sock": failing,
+	}
+	factory := func(_ context.Context, address string) (workloadClient, error) {
+		client, ok := fakeClients[address]
+		if !ok {
+			return nil, fmt.Errorf("invalid address %q", address)
+		}
+		return client, nil
+	}
+
+	addresses := parseEndpoints("unix:///local.sock", "unix:///remote.sock,unix:///failing.sock,invalid")
+	endpoints := newEndpointClients(context.Background(), addresses, factory)
+	require.Len(t, endpoints, 4)
+
+	results := loadEndpointSVIDs(context.Background(), endpoints)
+	require.Len(t, results, 4)
+
+	// Results are in endpoint order, with reachable endpoints' SVIDs side by side
+	assert.Equal(t, "unix:///local.sock", results[0].Address)
+	assert.Empty(t, results[0].Error)
+	require.Len(t, results[0].SVIDs, 1)
+	assert.Equal(t, "spiffe://local.org/workload", results[0].SVIDs[0].Name)
+	assert.Equal(t, "local.org", results[0].SVIDs[0].TrustDomain)
+
+	assert.Equal(t, "unix:///remote.sock", results[1].Address)
+	assert.Empty(t, results[1].Error)
+	require.Len(t, results[1].SVIDs, 1)
+	assert.Equal(t, "spiffe://remote.org/workload", results[1].SVIDs[0].Name)
+
+	// Partial failures are reported per endpoint
+	assert.Equal(t, "unix:///failing.sock", results[2].Address)
+	assert.Contains(t, results[2].Error, "connection refused")
+	assert.Empty(t, results[2].SVIDs)
+
+	assert.Equal(t, "invalid", results[3].Address)
+	assert.Contains(t, results[3].Error, "invalid address")
+	assert.Empty(t, results[3].SVIDs)
+}
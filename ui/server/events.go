@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const contentTypeEventStream = "text/event-stream"
+
+// Server-Sent Events sent by the events handler
+const (
+	// eventUpdate carries the workload's certificates as JSON, in the same form as /api/certificates
+	eventUpdate = "update"
+	// eventUnavailable carries the reason the workload's certificates can't be loaded
+	eventUnavailable = "unavailable"
+)
+
+// eventsHandler streams a Server-Sent Event each time the cached X.509 context is updated, eg on
+// SVID rotation, so that the dashboard can refresh itself
+func eventsHandler(cache *x509ContextCache, expiryWarnThreshold time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		updates, unsubscribe := cache.subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", contentTypeEventStream)
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			log.Printf("Error starting event stream: %v", err)
+			return
+		}
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-updates:
+			}
+
+			event, data := eventUpdate, ""
+			resp, err := loadCertificatesResponse(r.Context(), cache, expiryWarnThreshold)
+			if err == nil {
+				var body []byte
+				body, err = json.Marshal(resp)
+				data = string(body)
+			}
+			if err != nil {
+				event, data = eventUnavailable, err.Error()
+			}
+
+			if err := writeEvent(w, event, data); err != nil {
+				log.Printf("Error writing event: %v", err)
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				log.Printf("Error flushing event: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes a Server-Sent Event. Each line of data is written as its own data field, which
+// the client joins with newlines, as a newline within a field would end it.
+func writeEvent(w io.Writer, event, data string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "event: %s\n", event)
+	data = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(data)
+	for line := range strings.SplitSeq(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// envVarExpiryWarnThreshold is the default for the --expiry-warn-threshold flag, so that it can be
+// set on an injected UI container
+const envVarExpiryWarnThreshold = "SPIFFE_ENABLE_UI_EXPIRY_WARN_THRESHOLD"
+
+// defaultExpiryWarnThreshold is used if no threshold is configured
+const defaultExpiryWarnThreshold = time.Hour
+
+// parseExpiryWarnThreshold parses a threshold duration, using the default if value is empty
+func parseExpiryWarnThreshold(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultExpiryWarnThreshold, nil
+	}
+
+	threshold, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid expiry warning threshold %q: %w", value, err)
+	}
+	if threshold < 0 {
+		return 0, fmt.Errorf("invalid expiry warning threshold %q: must not be negative", value)
+	}
+	return threshold, nil
+}
+
+// setValidity records the validity window of cert on c. For an SVID, cert is its leaf certificate.
+func setValidity(c *Certificate, cert *x509.Certificate) {
+	c.NotBefore = cert.NotBefore.UTC().Format(time.RFC3339)
+	c.NotAfter = cert.NotAfter.UTC().Format(time.RFC3339)
+	c.notAfter = cert.NotAfter
+}
+
+// setExpiry records the time to expiry of each certificate, and flags those that expire within
+// threshold of now, or have already expired. Certificates without a validity window are left
+// without expiry details.
+func setExpiry(certs []Certificate, now time.Time, threshold time.Duration) {
+	for i := range certs {
+		notAfter := certs[i].notAfter
+		if notAfter.IsZero() {
+			continue
+		}
+
+		timeToExpiry := notAfter.Sub(now)
+		certs[i].TimeToExpiry = timeToExpiry.Round(time.Second).String()
+		certs[i].Expired = !now.Before(notAfter)
+		certs[i].Warning = !certs[i].Expired && timeToExpiry <= threshold
+	}
+}
+
+// expiryWarnings returns the certificates that are expired or within the warning threshold
+func expiryWarnings(certs ...[]Certificate) []Certificate {
+	var warnings []Certificate
+	for _, c := range certs {
+		for _, cert := range c {
+			if cert.Warning || cert.Expired {
+				warnings = append(warnings, cert)
+			}
+		}
+	}
+	return warnings
+}
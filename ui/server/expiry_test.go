@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newExpiringCertificate returns a self-signed Certificate that expires at notAfter
+func newExpiringCertificate(t *testing.T, name string, notAfter time.Time) Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	c := Certificate{Name: name, Certificate: base64.StdEncoding.EncodeToString(der)}
+	setValidity(&c, cert)
+	return c
+}
+
+func TestSetExpiry(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	threshold := 2 * time.Hour
+
+	tests := []struct {
+		name                 string
+		notAfter             time.Time
+		expectedTimeToExpiry string
+		expectedWarning      bool
+		expectedExpired      bool
+	}{
+		{
+			name:                 "above threshold",
+			notAfter:             now.Add(3 * time.Hour),
+			expectedTimeToExpiry: "3h0m0s",
+		},
+		{
+			name:                 "within threshold",
+			notAfter:             now.Add(90 * time.Minute),
+			expectedTimeToExpiry: "1h30m0s",
+			expectedWarning:      true,
+		},
+		{
+			name:                 "past expiry",
+			notAfter:             now.Add(-time.Minute),
+			expectedTimeToExpiry: "-1m0s",
+			expectedExpired:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certs := []Certificate{newExpiringCertificate(t, "spiffe://example.org/app", tt.notAfter)}
+			setExpiry(certs, now, threshold)
+
+			assert.Equal(t, tt.notAfter.Add(-24*time.Hour).Format(time.RFC3339), certs[0].NotBefore)
+			assert.Equal(t, tt.notAfter.Format(time.RFC3339), certs[0].NotAfter)
+			assert.Equal(t, tt.expectedTimeToExpiry, certs[0].TimeToExpiry)
+			assert.Equal(t, tt.expectedWarning, certs[0].Warning)
+			assert.Equal(t, tt.expectedExpired, certs[0].Expired)
+
+			if tt.expectedWarning || tt.expectedExpired {
+				assert.Equal(t, certs, expiryWarnings(certs))
+			} else {
+				assert.Empty(t, expiryWarnings(certs))
+			}
+		})
+	}
+
+	t.Run("no validity", func(t *testing.T) {
+		certs := []Certificate{
+			{Name: "unknown", Certificate: "not base64!"},
+			newExpiringCertificate(t, "spiffe://example.org/app", now.Add(3*time.Hour)),
+		}
+		setExpiry(certs, now, threshold)
+
+		// A certificate without a validity window is left without expiry details, without
+		// affecting the others
+		assert.Equal(t, Certificate{Name: "unknown", Certificate: "not base64!"}, certs[0])
+		assert.Equal(t, "3h0m0s", certs[1].TimeToExpiry)
+	})
+}
+
+func TestParseExpiryWarnThreshold(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    time.Duration
+		expectError bool
+	}{
+		{value: "", expected: defaultExpiryWarnThreshold},
+		{value: "30m", expected: 30 * time.Minute},
+		{value: "0s", expected: 0},
+		{value: "-1h", expectError: true},
+		{value: "soon", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			threshold, err := parseExpiryWarnThreshold(tt.value)
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, threshold)
+		})
+	}
+}
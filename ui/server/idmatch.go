@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// envVarExpectedIDPattern is the default for the --expected-id-pattern flag, so that it can be
+// set on an injected UI container
+const envVarExpectedIDPattern = "SPIFFE_ENABLE_UI_EXPECTED_ID_PATTERN"
+
+// IDCheck is the result of comparing the workload's SPIFFE ID with the expected pattern
+type IDCheck struct {
+	Pattern string
+	Matches bool
+}
+
+// idMatcher checks SPIFFE IDs against an expected pattern, a regular expression that must match
+// the whole ID, eg spiffe://example\.org/ns/[^/]+/sa/[^/]+
+type idMatcher struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// newIDMatcher compiles pattern. An empty pattern returns a nil matcher, which checks nothing.
+func newIDMatcher(pattern string) (*idMatcher, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expected SPIFFE ID pattern %q: %w", pattern, err)
+	}
+	return &idMatcher{pattern: pattern, re: re}, nil
+}
+
+// check compares id with the expected pattern, returning nil if there is no pattern
+func (m *idMatcher) check(id string) *IDCheck {
+	if m == nil {
+		return nil
+	}
+	return &IDCheck{Pattern: m.pattern, Matches: m.re.MatchString(id)}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIDMatcher(t *testing.T) {
+	t.Run("no pattern", func(t *testing.T) {
+		matcher, err := newIDMatcher("")
+		require.NoError(t, err)
+		assert.Nil(t, matcher.check("spiffe://example.org/ns/default/sa/app"))
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		_, err := newIDMatcher("spiffe://example.org/(")
+		require.Error(t, err)
+	})
+
+	matcher, err := newIDMatcher(`spiffe://example\.org/ns/default/sa/[^/]+`)
+	require.NoError(t, err)
+
+	tests := []struct {
+		id      string
+		matches bool
+	}{
+		{id: "spiffe://example.org/ns/default/sa/app", matches: true},
+		{id: "spiffe://example.org/ns/other/sa/app", matches: false},
+		{id: "spiffe://other.org/ns/default/sa/app", matches: false},
+		// The pattern must match the whole ID
+		{id: "spiffe://example.org/ns/default/sa/app/extra", matches: false},
+		{id: "prefix-spiffe://example.org/ns/default/sa/app", matches: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			result := matcher.check(tt.id)
+			require.NotNil(t, result)
+			assert.Equal(t, tt.matches, result.Matches)
+			assert.Equal(t, `spiffe://example\.org/ns/default/sa/[^/]+`, result.Pattern)
+		})
+	}
+}
@@ -4,10 +4,9 @@ import (
 	"context"
 	"embed"
 	"encoding/base64"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"html/template"
-	"io"
 	"io/fs"
 	"log"
 	"log/slog"
@@ -15,13 +14,19 @@ import (
 	"os"
 	"time"
 
-	"github.com/spiffe/go-spiffe/v2/logger"
-	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
 )
 
 const (
 	apiTimeout          = 30 * time.Second
 	defaultSpiffeSocket = "unix:///spiffe-workload-api/spire-agent.sock"
+	defaultPort         = "8080"
+	// envVarPort is the default for the --port flag, so that it can be set on an injected UI
+	// container
+	envVarPort = "SPIFFE_ENABLE_UI_PORT"
 )
 
 var (
@@ -38,6 +43,25 @@ type Certificate struct {
 	Name        string `json:"name"`
 	TrustDomain string `json:"td"`
 	Certificate string `json:"certificate"`
+	// NotBefore and NotAfter are the validity window of the certificate, or of an SVID's leaf
+	// certificate, set with setValidity as it is loaded
+	NotBefore string `json:"notBefore,omitempty"`
+	NotAfter  string `json:"notAfter,omitempty"`
+	// The time to expiry, and whether the certificate is expired or close to expiry, are set
+	// relative to the time of the request with setExpiry
+	TimeToExpiry string `json:"timeToExpiry,omitempty"`
+	Warning      bool   `json:"warning"`
+	Expired      bool   `json:"expired"`
+
+	// notAfter is the expiry that the time to expiry is calculated from
+	notAfter time.Time
+}
+
+// workloadClient is the subset of the Workload API client used by the UI
+type workloadClient interface {
+	FetchX509SVIDs(ctx context.Context) ([]*x509svid.SVID, error)
+	FetchX509Bundles(ctx context.Context) (*x509bundle.Set, error)
+	Close() error
 }
 
 type PageData struct {
@@ -46,6 +70,20 @@ type PageData struct {
 	FederatedTrustDomains []string
 	SVIDCertificates      template.JS
 	CACertificates        template.JS
+	// SVIDs are the workload's SVIDs, with their validity windows
+	SVIDs []Certificate
+	// Endpoints is only populated when multiple Workload API endpoints are configured
+	Endpoints []EndpointSVIDs
+	// IDCheck is only populated when an expected SPIFFE ID pattern is configured
+	IDCheck *IDCheck
+	// ExpiryWarnings are the certificates that are expired or close to expiry
+	ExpiryWarnings []Certificate
+	// TrustDomains is only populated when the workload has SVIDs in more than one trust domain
+	TrustDomains []TrustDomainSVIDs
+	// LiveUpdates is set if the dashboard can subscribe to /events to refresh on updates
+	LiveUpdates bool
+	// Unavailable is set, in place of the workload's details, when it has no SVIDs to display
+	Unavailable string
 }
 
 func init() {
@@ -59,45 +97,62 @@ func init() {
 }
 
 func main() {
-	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
-	defer cancel()
+	expectedIDPattern := flag.String("expected-id-pattern", os.Getenv(envVarExpectedIDPattern),
+		"A regular expression that the workload's SPIFFE ID is expected to match in full. Mismatches are flagged in the UI.")
+	expiryWarnThresholdFlag := flag.String("expiry-warn-threshold", os.Getenv(envVarExpiryWarnThreshold),
+		"Flag certificates that expire within this duration (eg 2h). Defaults to 1h.")
+	port := flag.String("port", os.Getenv(envVarPort), "The port to serve the UI on. Defaults to "+defaultPort+".")
+	fetchAttempts := flag.String("fetch-attempts", os.Getenv(envVarFetchAttempts),
+		"The number of times to try fetching the workload's SVIDs before treating the Workload API as unavailable. Defaults to 3.")
+	fetchBackoff := flag.String("fetch-backoff", os.Getenv(envVarFetchBackoff),
+		"How long to wait before retrying a failed fetch, doubling for each further retry. Defaults to 500ms.")
+	failClosed := flag.String("fail-closed", os.Getenv(envVarFailClosed),
+		"Respond with only an error, rather than a dashboard without the workload's SVIDs, if none are available.")
+	flag.Parse()
+	if *port == "" {
+		*port = defaultPort
+	}
 
-	client, err := workloadapi.New(ctx, workloadapi.WithAddr(spiffeSocket), workloadapi.WithLogger(logger.Std))
+	expiryWarnThreshold, err := parseExpiryWarnThreshold(*expiryWarnThresholdFlag)
 	if err != nil {
-		log.Fatalf("Unable to create workload API client: %v", err)
+		log.Fatal(err)
 	}
-	defer func() {
-		if err := client.Close(); err != nil {
-			log.Printf("Error closing workload API client: %v", err)
-		}
-	}()
 
-	subTmplFS, err := fs.Sub(tmplAssets, "templates")
+	policy, err := parseFetchPolicy(*fetchAttempts, *fetchBackoff, *failClosed)
 	if err != nil {
-		log.Fatalf("Failed to create sub-filesystem: %v", err)
+		log.Fatal(err)
 	}
 
-	// Load the dashboard template
-	tmplFile, err := subTmplFS.Open("dashboard.tmpl")
+	idMatcher, err := newIDMatcher(*expectedIDPattern)
 	if err != nil {
-		log.Fatalf("Failed to open template file: %v", err)
+		log.Fatal(err)
 	}
-	defer func() {
-		if err := tmplFile.Close(); err != nil {
-			log.Printf("Error closing template file: %v", err)
-		}
-	}()
 
-	// Read the content of the template file
-	tmplBytes, err := io.ReadAll(tmplFile)
-	if err != nil {
-		log.Fatalf("Failed to read template file: %v", err)
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+
+	endpoints := newEndpointClients(ctx, parseEndpoints(spiffeSocket, os.Getenv(envVarEndpoints)), newWorkloadAPIClient)
+	if endpoints[0].err != nil {
+		log.Fatalf("Unable to create workload API client: %v", endpoints[0].err)
 	}
+	for _, endpoint := range endpoints {
+		if endpoint.err != nil {
+			log.Printf("Unable to create workload API client for %s: %v", endpoint.address, endpoint.err)
+			continue
+		}
+		defer func() {
+			if err := endpoint.client.Close(); err != nil {
+				log.Printf("Error closing workload API client for %s: %v", endpoint.address, err)
+			}
+		}()
+	}
+
+	// The primary endpoint is the workload's own Workload API
+	client := endpoints[0].client
 
-	// Create a new template and parse its content
-	tmpl, err := template.New("dashboard").Parse(string(tmplBytes))
+	tmpl, err := loadDashboardTemplate()
 	if err != nil {
-		log.Fatalf("Error parsing template: %v", err)
+		log.Fatal(err)
 	}
 
 	// Create a sub-filesystem for the embedded UI assets
@@ -112,62 +167,50 @@ func main() {
 	// Serve static files
 	http.Handle("/static/", http.StripPrefix("/static/", fileServer))
 
-	// Serve the dashboard
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		reqCtx, reqCancel := context.WithTimeout(r.Context(), apiTimeout)
-		defer reqCancel()
-
-		// Get SVID certificates
-		svidCerts, err := loadSVIDCertificates(reqCtx, client)
-		if err != nil {
-			log.Printf("Error loading SVID certificates: %v", err)
-			http.Error(w, "Error loading certificates", http.StatusInternalServerError)
-			return
-		}
-
-		caCerts, federatedTDs, err := loadCACertificates(reqCtx, client, svidCerts[0].TrustDomain)
-		if err != nil {
-			log.Printf("Error loading CA certificates: %v", err)
-			http.Error(w, "Error loading certificates", http.StatusInternalServerError)
-			return
+	// Track SVID rotations and cache the latest X.509 context through the watch stream, if the
+	// client supports it, serving the cached context in place of fetching it per request
+	registry := prometheus.NewRegistry()
+	var cache *x509ContextCache
+	if watcher, ok := client.(x509ContextWatcher); ok {
+		tracker := newRotationTracker(time.Now)
+		if err := tracker.register(registry); err != nil {
+			log.Fatalf("Failed to register rotation metrics: %v", err)
 		}
+		cache = newX509ContextCache()
+		go func() {
+			if err := watcher.WatchX509Context(context.Background(), x509ContextWatchers{tracker, cache}); err != nil {
+				log.Printf("Stopped watching X.509 context: %v", err)
+			}
+		}()
 
-		svidCertsJSON, err := json.Marshal(svidCerts)
-		if err != nil {
-			log.Printf("Error marshaling SVID certificates: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+		client = cache
+		http.HandleFunc("GET /events", eventsHandler(cache, expiryWarnThreshold))
+	}
+	http.Handle("GET /metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
 
-		caCertsJSON, err := json.Marshal(caCerts)
-		if err != nil {
-			log.Printf("Error marshaling CA certificates: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+	// Serve individual certificates as PEM or DER downloads
+	http.HandleFunc("GET /cert/{file}", certDownloadHandler(client, "svid", loadSVIDCertificates))
+	http.HandleFunc("GET /bundle/{file}", certDownloadHandler(client, "bundle", loadBundleCertificates))
 
-		// Prepare data for template
-		data := PageData{
-			SpiffeID:              svidCerts[0].Name,
-			TrustDomain:           svidCerts[0].TrustDomain,
-			FederatedTrustDomains: federatedTDs,
-			SVIDCertificates:      template.JS(svidCertsJSON),
-			CACertificates:        template.JS(caCertsJSON),
-		}
+	// Serve the certificates as JSON for programmatic inspection
+	http.HandleFunc("GET /api/certificates", certificatesHandler(client, expiryWarnThreshold))
 
-		// Execute template with data
-		if err := tmpl.Execute(w, data); err != nil {
-			log.Printf("Error executing template: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+	// Serve the dashboard
+	http.Handle("/", &dashboard{
+		client:              client,
+		endpoints:           endpoints,
+		tmpl:                tmpl,
+		idMatcher:           idMatcher,
+		expiryWarnThreshold: expiryWarnThreshold,
+		policy:              policy,
+		liveUpdates:         cache != nil,
 	})
 
-	log.Println("Server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Printf("Server starting on :%s", *port)
+	log.Fatal(http.ListenAndServe(":"+*port, nil))
 }
 
-func loadSVIDCertificates(ctx context.Context, client *workloadapi.Client) ([]Certificate, error) {
+func loadSVIDCertificates(ctx context.Context, client workloadClient) ([]Certificate, error) {
 	certificates := []Certificate{}
 
 	svids, err := client.FetchX509SVIDs(ctx)
@@ -186,6 +229,7 @@ func loadSVIDCertificates(ctx context.Context, client *workloadapi.Client) ([]Ce
 			TrustDomain: s.ID.TrustDomain().Name(),
 			Certificate: base64.StdEncoding.EncodeToString(cert),
 		}
+		setValidity(&c, s.Certificates[0])
 		certificates = append(certificates, c)
 	}
 
@@ -193,7 +237,7 @@ func loadSVIDCertificates(ctx context.Context, client *workloadapi.Client) ([]Ce
 }
 
 func loadCACertificates(
-	ctx context.Context, client *workloadapi.Client, ownTrustDomainID string,
+	ctx context.Context, client workloadClient, ownTrustDomainID string,
 ) ([]Certificate, []string, error) {
 	var certificates []Certificate
 	var uniqueTrustDomainIDs []string
@@ -223,6 +267,7 @@ func loadCACertificates(
 				Name:        trustDomainID,
 				Certificate: base64.StdEncoding.EncodeToString(c.Raw),
 			}
+			setValidity(&cert, c)
 			certificates = append(certificates, cert)
 		}
 	}
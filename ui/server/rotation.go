@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// x509ContextWatcher is implemented by Workload API clients that can stream X.509 context updates
+type x509ContextWatcher interface {
+	WatchX509Context(ctx context.Context, watcher workloadapi.X509ContextWatcher) error
+}
+
+// rotationTracker observes the workload's default X509-SVID through the watch stream, counting
+// rotations and recording when the SVID last rotated
+type rotationTracker struct {
+	mu           sync.Mutex
+	now          func() time.Time
+	serial       *big.Int
+	lastRotation time.Time
+	rotations    prometheus.Counter
+}
+
+func newRotationTracker(now func() time.Time) *rotationTracker {
+	return &rotationTracker{
+		now: now,
+		rotations: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "spiffe_enable_ui_svid_rotations_total",
+			Help: "Number of X509-SVID rotations observed through the Workload API watch stream.",
+		}),
+	}
+}
+
+// register adds the rotation metrics to the registry
+func (r *rotationTracker) register(registry prometheus.Registerer) error {
+	if err := registry.Register(r.rotations); err != nil {
+		return err
+	}
+	return registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "spiffe_enable_ui_svid_seconds_since_last_rotation",
+		Help: "Seconds since the X509-SVID last rotated, or since the initial SVID was issued. " +
+			"Zero until the first SVID is received.",
+	}, r.secondsSinceLastRotation))
+}
+
+// OnX509ContextUpdate records a rotation if the default SVID's leaf certificate has changed. The
+// first SVID received sets the baseline, with its issue time as the last rotation.
+func (r *rotationTracker) OnX509ContextUpdate(x509Context *workloadapi.X509Context) {
+	if len(x509Context.SVIDs) == 0 {
+		return
+	}
+	svid := x509Context.DefaultSVID()
+	if len(svid.Certificates) == 0 {
+		return
+	}
+	leaf := svid.Certificates[0]
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case r.serial == nil:
+		r.lastRotation = leaf.NotBefore
+	case r.serial.Cmp(leaf.SerialNumber) != 0:
+		r.lastRotation = r.now()
+		r.rotations.Inc()
+	}
+	r.serial = leaf.SerialNumber
+}
+
+// OnX509ContextWatchError logs errors from the watch stream, which the client retries
+func (r *rotationTracker) OnX509ContextWatchError(err error) {
+	log.Printf("Error watching X.509 context: %v", err)
+}
+
+func (r *rotationTracker) secondsSinceLastRotation() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.lastRotation.IsZero() {
+		return 0
+	}
+	return r.now().Sub(r.lastRotation).Seconds()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotationTracker(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	issued := start.Add(-10 * time.Minute)
+
+	// update is an SVID received from the watch stream after the given offset from start
+	type update struct {
+		after  time.Duration
+		serial int64
+	}
+
+	tests := []struct {
+		name                     string
+		updates                  []update
+		checkAfter               time.Duration
+		expectedRotations        float64
+		expectedSecondsSinceLast float64
+	}{
+		{
+			name:                     "no updates",
+			checkAfter:               time.Minute,
+			expectedRotations:        0,
+			expectedSecondsSinceLast: 0,
+		},
+		{
+			name:                     "initial SVID is not a rotation",
+			updates:                  []update{{after: 0, serial: 1}},
+			checkAfter:               time.Minute,
+			expectedRotations:        0,
+			expectedSecondsSinceLast: (11 * time.Minute).Seconds(),
+		},
+		{
+			name:                     "unchanged SVID is not a rotation",
+			updates:                  []update{{after: 0, serial: 1}, {after: time.Minute, serial: 1}},
+			checkAfter:               2 * time.Minute,
+			expectedRotations:        0,
+			expectedSecondsSinceLast: (12 * time.Minute).Seconds(),
+		},
+		{
+			name: "rotations are counted",
+			updates: []update{
+				{after: 0, serial: 1},
+				{after: 30 * time.Minute, serial: 2},
+				{after: 40 * time.Minute, serial: 2},
+				{after: time.Hour, serial: 3},
+			},
+			checkAfter:               time.Hour + 5*time.Minute,
+			expectedRotations:        2,
+			expectedSecondsSinceLast: (5 * time.Minute).Seconds(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			tracker := newRotationTracker(func() time.Time { return now })
+			registry := prometheus.NewRegistry()
+			require.NoError(t, tracker.register(registry))
+
+			for _, u := range tt.updates {
+				now = start.Add(u.after)
+				tracker.OnX509ContextUpdate(newX509Context(u.serial, issued))
+			}
+			now = start.Add(tt.checkAfter)
+
+			assert.Equal(t, tt.expectedRotations, testutil.ToFloat64(tracker.rotations))
+			assert.Equal(t, tt.expectedSecondsSinceLast, tracker.secondsSinceLastRotation())
+			count, err := testutil.GatherAndCount(registry)
+			require.NoError(t, err)
+			assert.Equal(t, 2, count)
+		})
+	}
+}
+
+func TestRotationTracker_IgnoresEmptyContext(t *testing.T) {
+	tracker := newRotationTracker(time.Now)
+
+	tracker.OnX509ContextUpdate(&workloadapi.X509Context{})
+
+	assert.Zero(t, testutil.ToFloat64(tracker.rotations))
+	assert.Zero(t, tracker.secondsSinceLastRotation())
+}
+
+// newX509Context returns an X.509 context with a default SVID whose leaf certificate has the
+// given serial number and issue time
+func newX509Context(serial int64, notBefore time.Time) *workloadapi.X509Context {
+	return &workloadapi.X509Context{
+		SVIDs: []*x509svid.SVID{{
+			ID: spiffeid.RequireFromString("spiffe://example.org/workload"),
+			Certificates: []*x509.Certificate{{
+				SerialNumber: big.NewInt(serial),
+				NotBefore:    notBefore,
+			}},
+		}},
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+// TrustDomainSVIDs holds the SVIDs in a trust domain, along with the trust domain's bundle
+type TrustDomainSVIDs struct {
+	TrustDomain string
+	SVIDs       []Certificate
+	// Bundle is empty if the Workload API returned no bundle for the trust domain
+	Bundle []Certificate
+}
+
+// groupByTrustDomain groups the SVIDs by trust domain, in the order in which each trust domain's
+// first SVID appears, pairing each group with the trust domain's bundle certificates. Bundles of
+// trust domains without SVIDs (ie federated trust domains) are not included.
+func groupByTrustDomain(svidCerts, caCerts []Certificate) []TrustDomainSVIDs {
+	var groups []TrustDomainSVIDs
+	index := make(map[string]int)
+
+	for _, svid := range svidCerts {
+		i, ok := index[svid.TrustDomain]
+		if !ok {
+			i = len(groups)
+			index[svid.TrustDomain] = i
+			groups = append(groups, TrustDomainSVIDs{TrustDomain: svid.TrustDomain})
+		}
+		groups[i].SVIDs = append(groups[i].SVIDs, svid)
+	}
+
+	// Bundle certificates are named by their trust domain
+	for _, ca := range caCerts {
+		if i, ok := index[ca.Name]; ok {
+			groups[i].Bundle = append(groups[i].Bundle, ca)
+		}
+	}
+
+	return groups
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupByTrustDomain(t *testing.T) {
+	svid := func(id, td string) Certificate {
+		return Certificate{Name: id, TrustDomain: td, Certificate: id}
+	}
+	ca := func(td, cert string) Certificate {
+		return Certificate{Name: td, Certificate: cert}
+	}
+
+	tests := []struct {
+		name     string
+		svids    []Certificate
+		cas      []Certificate
+		expected []TrustDomainSVIDs
+	}{
+		{
+			name:  "single trust domain",
+			svids: []Certificate{svid("spiffe://example.org/app", "example.org")},
+			cas:   []Certificate{ca("example.org", "ca-1"), ca("federated.org", "ca-2")},
+			expected: []TrustDomainSVIDs{
+				{
+					TrustDomain: "example.org",
+					SVIDs:       []Certificate{svid("spiffe://example.org/app", "example.org")},
+					Bundle:      []Certificate{ca("example.org", "ca-1")},
+				},
+			},
+		},
+		{
+			name: "multiple trust domains",
+			svids: []Certificate{
+				svid("spiffe://example.org/app", "example.org"),
+				svid("spiffe://other.org/app", "other.org"),
+				svid("spiffe://example.org/admin", "example.org"),
+			},
+			cas: []Certificate{
+				ca("other.org", "ca-other"),
+				ca("example.org", "ca-1"),
+				ca("federated.org", "ca-federated"),
+				ca("example.org", "ca-2"),
+			},
+			expected: []TrustDomainSVIDs{
+				{
+					TrustDomain: "example.org",
+					SVIDs: []Certificate{
+						svid("spiffe://example.org/app", "example.org"),
+						svid("spiffe://example.org/admin", "example.org"),
+					},
+					Bundle: []Certificate{ca("example.org", "ca-1"), ca("example.org", "ca-2")},
+				},
+				{
+					TrustDomain: "other.org",
+					SVIDs:       []Certificate{svid("spiffe://other.org/app", "other.org")},
+					Bundle:      []Certificate{ca("other.org", "ca-other")},
+				},
+			},
+		},
+		{
+			name: "trust domain without a bundle",
+			svids: []Certificate{
+				svid("spiffe://example.org/app", "example.org"),
+				svid("spiffe://other.org/app", "other.org"),
+			},
+			cas: []Certificate{ca("example.org", "ca-1")},
+			expected: []TrustDomainSVIDs{
+				{
+					TrustDomain: "example.org",
+					SVIDs:       []Certificate{svid("spiffe://example.org/app", "example.org")},
+					Bundle:      []Certificate{ca("example.org", "ca-1")},
+				},
+				{
+					TrustDomain: "other.org",
+					SVIDs:       []Certificate{svid("spiffe://other.org/app", "other.org")},
+				},
+			},
+		},
+		{
+			name: "no SVIDs",
+			cas:  []Certificate{ca("example.org", "ca-1")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, groupByTrustDomain(tt.svids, tt.cas))
+		})
+	}
+}